@@ -10,6 +10,7 @@ require (
 	github.com/gorilla/mux v1.8.1
 	github.com/jackc/pgx/v5 v5.9.1
 	github.com/justinas/alice v1.2.0
+	github.com/klauspost/compress v1.18.0
 	github.com/stretchr/testify v1.11.1
 	go.uber.org/zap v1.27.1
 )
@@ -0,0 +1,38 @@
+package random
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+)
+
+// ID returns a random ID of 32 hexadecimal characters, as given to
+// requests, batches, queries, cursors and jobs.
+func ID() (string, error) {
+	b, err := read(16)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// UUID returns a random (version 4) UUID.
+func UUID() (string, error) {
+	b, err := read(16)
+	if err != nil {
+		return "", err
+	}
+
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+func read(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return nil, fmt.Errorf("unable to read random bytes: %w", err)
+	}
+	return b, nil
+}
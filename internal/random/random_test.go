@@ -0,0 +1,32 @@
+package random
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestID(t *testing.T) {
+	t.Parallel()
+
+	id, err := ID()
+	require.NoError(t, err)
+	assert.Regexp(t, `^[0-9a-f]{32}$`, id)
+
+	other, err := ID()
+	require.NoError(t, err)
+	assert.NotEqual(t, id, other)
+}
+
+func TestUUID(t *testing.T) {
+	t.Parallel()
+
+	id, err := UUID()
+	require.NoError(t, err)
+	assert.Regexp(t, `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`, id)
+
+	other, err := UUID()
+	require.NoError(t, err)
+	assert.NotEqual(t, id, other)
+}
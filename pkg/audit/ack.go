@@ -0,0 +1,183 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/app-sre/gabi/internal/random"
+	"github.com/app-sre/gabi/pkg/env/splunk"
+	"go.uber.org/zap"
+)
+
+// Acknowledgments are only sent to clients that identify themselves
+// with the same channel when sending events and checking for them.
+const splunkChannelHeader = "X-Splunk-Request-Channel"
+
+var ErrAckTimeout = errors.New("event has not been acknowledged in time")
+
+// ackTracker holds the acknowledgments that Splunk has yet to send, all
+// of which are checked for at once.
+type ackTracker struct {
+	channel string
+	pending map[int64]*pendingAck
+	polling bool
+	mu      sync.Mutex
+}
+
+// pendingAck is an event that has been sent, but not indexed yet, and
+// whether someone is waiting for it to be.
+type pendingAck struct {
+	sent   time.Time
+	done   chan error
+	waited bool
+}
+
+// acknowledged reports whether events have to be acknowledged.
+func (d *SplunkAudit) acknowledged() bool {
+	return d.SplunkEnv.Ack == splunk.AckTrack || d.SplunkEnv.Ack == splunk.AckWait
+}
+
+// acknowledge keeps track of the acknowledgment of an event that has
+// been sent, and waits for it, if required to.
+func (d *SplunkAudit) acknowledge(ctx context.Context, ackID int64) error {
+	if !d.acknowledged() {
+		return nil
+	}
+
+	wait := d.SplunkEnv.Ack == splunk.AckWait
+	done := d.track(ackID, wait)
+	if !wait {
+		return nil
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("unable to confirm Splunk indexed audit: %w", err)
+		}
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("unable to confirm Splunk indexed audit: %w", ctx.Err())
+	}
+}
+
+// track adds the acknowledgment to those checked for, which starts
+// checking unless it is already under way.
+func (d *SplunkAudit) track(ackID int64, waited bool) <-chan error {
+	ack := &pendingAck{sent: time.Now(), done: make(chan error, 1), waited: waited}
+
+	d.acks.mu.Lock()
+	defer d.acks.mu.Unlock()
+
+	if d.acks.pending == nil {
+		d.acks.pending = make(map[int64]*pendingAck)
+	}
+	d.acks.pending[ackID] = ack
+
+	if !d.acks.polling {
+		d.acks.polling = true
+		go d.poll()
+	}
+
+	return ack.done
+}
+
+// Pending returns the number of events that Splunk has yet to
+// acknowledge as indexed.
+func (d *SplunkAudit) Pending() int {
+	d.acks.mu.Lock()
+	defer d.acks.mu.Unlock()
+	return len(d.acks.pending)
+}
+
+// poll checks for acknowledgments until there are none left to check
+// for, giving up on those that have not been sent in time.
+func (d *SplunkAudit) poll() {
+	interval := d.SplunkEnv.AckInterval
+	if interval <= 0 {
+		interval = splunk.DefaultAckInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		d.acks.mu.Lock()
+		ids := make([]int64, 0, len(d.acks.pending))
+		for id := range d.acks.pending {
+			ids = append(ids, id)
+		}
+		if len(ids) == 0 {
+			d.acks.polling = false
+		}
+		d.acks.mu.Unlock()
+
+		if len(ids) == 0 {
+			return
+		}
+		slices.Sort(ids)
+
+		acks, err := d.check(ids)
+		if err != nil {
+			d.logf(zap.WarnLevel, "Unable to check Splunk acknowledgments: %s", err)
+		}
+
+		now := time.Now()
+
+		d.acks.mu.Lock()
+		for _, id := range ids {
+			ack, ok := d.acks.pending[id]
+			if !ok {
+				continue
+			}
+			switch {
+			case acks[strconv.FormatInt(id, 10)]:
+				ack.done <- nil
+			case now.Sub(ack.sent) >= d.SplunkEnv.AckTimeout:
+				if !ack.waited {
+					d.logf(zap.ErrorLevel, "Splunk has not acknowledged indexing audit within %s (acknowledgment ID: %d)",
+						d.SplunkEnv.AckTimeout, id)
+				}
+				ack.done <- ErrAckTimeout
+			default:
+				continue
+			}
+			delete(d.acks.pending, id)
+		}
+		d.acks.mu.Unlock()
+	}
+}
+
+// check asks Splunk which of the given events have been indexed.
+func (d *SplunkAudit) check(ids []int64) (map[string]bool, error) {
+	content, err := json.Marshal(struct {
+		Acks []int64 `json:"acks"`
+	}{ids})
+	if err != nil {
+		return nil, fmt.Errorf("unable to marshal Splunk acknowledgments: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	resp, err := d.request(ctx, "/services/collector/ack", content)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Acks, nil
+}
+
+// newChannelID returns a random UUID, which is what Splunk expects
+// a channel to be identified with.
+func newChannelID() string {
+	// Reading random bytes does not fail, as the program is crashed
+	// when the system cannot provide them.
+	id, _ := random.UUID()
+	return id
+}
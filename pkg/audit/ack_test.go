@@ -0,0 +1,193 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/app-sre/gabi/internal/test"
+	"github.com/app-sre/gabi/pkg/env/splunk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeHEC is an HEC endpoint with indexer acknowledgment either enabled
+// or not, indexing events straight away unless told otherwise.
+type fakeHEC struct {
+	ack      bool
+	index    bool
+	next     int64
+	channels map[string]bool
+	indexed  map[int64]bool
+	mu       sync.Mutex
+}
+
+func newFakeHEC(ack, index bool) *fakeHEC {
+	return &fakeHEC{ack: ack, index: index, channels: make(map[string]bool), indexed: make(map[int64]bool)}
+}
+
+func (f *fakeHEC) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	channel := r.Header.Get(splunkChannelHeader)
+	if f.ack && channel == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, `{"text":"Data channel is missing","code":10}`)
+		return
+	}
+
+	switch r.URL.Path {
+	case "/services/collector/event":
+		if !f.ack {
+			fmt.Fprintln(w, `{"text":"Success","code":0}`)
+			return
+		}
+		id := f.next
+		f.next++
+		f.channels[channel] = true
+		f.indexed[id] = f.index
+		fmt.Fprintf(w, `{"text":"Success","code":0,"ackId":%d}`+"\n", id)
+	case "/services/collector/ack":
+		if !f.ack {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintln(w, `{"text":"ACK is disabled","code":14}`)
+			return
+		}
+		var request struct {
+			Acks []int64 `json:"acks"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&request)
+
+		acks := make(map[string]bool)
+		for _, id := range request.Acks {
+			acks[strconv.FormatInt(id, 10)] = f.channels[channel] && f.indexed[id]
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"acks": acks})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestSplunkAuditAck(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		description string
+		mode        splunk.AckMode
+		ack         bool
+		index       bool
+		error       bool
+		message     string
+		log         string
+	}{
+		{
+			"event acknowledged while waiting for it",
+			splunk.AckWait,
+			true,
+			true,
+			false,
+			``,
+			``,
+		},
+		{
+			"event not acknowledged in time while waiting for it",
+			splunk.AckWait,
+			true,
+			false,
+			true,
+			`unable to confirm Splunk indexed audit: event has not been acknowledged in time`,
+			``,
+		},
+		{
+			"event acknowledged while tracking it",
+			splunk.AckTrack,
+			true,
+			true,
+			false,
+			``,
+			``,
+		},
+		{
+			"event not acknowledged in time while tracking it",
+			splunk.AckTrack,
+			true,
+			false,
+			false,
+			``,
+			`Splunk has not acknowledged indexing audit within 50ms (acknowledgment ID: 0)`,
+		},
+		{
+			"event not acknowledged with acknowledgment disabled for token",
+			splunk.AckWait,
+			false,
+			true,
+			true,
+			`no acknowledgment ID returned`,
+			``,
+		},
+		{
+			"event sent without acknowledgment",
+			splunk.AckNone,
+			false,
+			true,
+			false,
+			``,
+			``,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			var output bytes.Buffer
+
+			hec := newFakeHEC(tc.ack, tc.index)
+
+			server := httptest.NewServer(hec)
+			defer server.Close()
+
+			s := NewSplunkAudit(&splunk.Env{
+				Endpoint:    server.URL,
+				Ack:         tc.mode,
+				AckTimeout:  50 * time.Millisecond,
+				AckInterval: 5 * time.Millisecond,
+			}, WithHTTPClient(server.Client()), WithLogger(test.DummyLogger(&output).Sugar()))
+
+			err := s.Write(context.TODO(), &QueryData{Query: "select 1;", User: "test", Timestamp: time.Now().Unix()})
+
+			if tc.error {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.message)
+			} else {
+				require.NoError(t, err)
+			}
+
+			require.Eventually(t, func() bool {
+				return s.Pending() == 0
+			}, 5*time.Second, time.Millisecond)
+
+			if tc.log != "" {
+				assert.Contains(t, output.String(), tc.log)
+			}
+		})
+	}
+}
+
+func TestNewChannelID(t *testing.T) {
+	t.Parallel()
+
+	a, b := newChannelID(), newChannelID()
+
+	assert.Regexp(t, `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`, a)
+	assert.NotEqual(t, a, b)
+}
@@ -1,13 +1,65 @@
 package audit
 
-import "context"
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// Outcomes of executing a query.
+const (
+	StatusSuccess   = "success"
+	StatusSQLError  = "sql_error"
+	StatusTimeout   = "timeout"
+	StatusCancelled = "cancelled"
+	StatusError     = "error"
+)
 
 type QueryData struct {
 	Query     string
+	Args      []json.RawMessage
+	BatchID   string
+	Statement int
+	DryRun    bool
+	Explain   bool
+	Analyze   bool
+	Cancel    string
+	// Cursor is the cursor a page of a result is fetched from, as the
+	// query has been audited when the cursor was opened.
+	Cursor    string
 	User      string
 	Namespace string
 	Pod       string
 	Timestamp int64
+	// RequestID is the same for every event audited for a request, so
+	// that the result can be told apart from that of other requests.
+	RequestID string
+	Result    *ResultData
+}
+
+// ResultData describes what a query returned. It is only set for
+// the audit event that is emitted after the query has been executed.
+type ResultData struct {
+	Rows         int64
+	Truncated    bool
+	RowLimit     int64
+	ByteLimit    int64
+	Digest       string
+	Bytes        int64
+	CacheHit     bool
+	RowsAffected *int64
+	LastInsertID *int64
+	// NextCursor is the cursor the rest of the result can be fetched
+	// from, so that fetching its pages can be matched to the query.
+	NextCursor string
+	// Status is the outcome of executing the query, and ErrorClass the
+	// kind of error it failed with, which is the SQLSTATE class for
+	// errors reported by the database. Code is the status code of the
+	// response, and Duration how long executing the query took.
+	Status     string
+	ErrorClass string
+	Code       int
+	Duration   time.Duration
 }
 
 type Audit interface {
@@ -0,0 +1,106 @@
+package audit
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+type CircuitState string
+
+const (
+	CircuitClosed   CircuitState = "closed"
+	CircuitOpen     CircuitState = "open"
+	CircuitHalfOpen CircuitState = "half-open"
+)
+
+// Breaker is implemented by audit writers that stop writing for a while
+// once their destination has been failing, instead of waiting on it.
+type Breaker interface {
+	Circuit() CircuitState
+}
+
+// circuitBreaker opens once threshold writes in a row have failed, so
+// that writes fail straight away until cooldown has passed. A single
+// write is then let through, which closes the breaker if it succeeds,
+// and opens it again otherwise. A threshold of zero means the breaker
+// never opens.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	state     CircuitState
+	opened    time.Time
+	trial     bool
+	mu        sync.Mutex
+}
+
+// Allow reports whether a write can go ahead.
+func (b *circuitBreaker) Allow(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.current() {
+	case CircuitOpen:
+		if now.Sub(b.opened) < b.cooldown {
+			return false
+		}
+		b.state, b.trial = CircuitHalfOpen, true
+		return true
+	case CircuitHalfOpen:
+		if b.trial {
+			return false
+		}
+		b.trial = true
+		return true
+	default:
+		return true
+	}
+}
+
+// Done records how a write that has been allowed went, and returns the
+// state of the breaker along with whether it has changed.
+func (b *circuitBreaker) Done(now time.Time, err error) (CircuitState, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	previous := b.current()
+	b.trial = false
+
+	if err == nil {
+		b.failures = 0
+		b.state = CircuitClosed
+		return b.state, previous != b.state
+	}
+
+	b.failures++
+	if previous == CircuitHalfOpen || (b.threshold > 0 && b.failures >= b.threshold) {
+		b.state, b.opened = CircuitOpen, now
+		return b.state, previous != b.state
+	}
+	return previous, false
+}
+
+// Abandon records that a write that has been allowed was given up on,
+// which says nothing about whether writes can succeed.
+func (b *circuitBreaker) Abandon() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trial = false
+}
+
+// State returns the state the breaker is in.
+func (b *circuitBreaker) State() CircuitState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.current()
+}
+
+func (b *circuitBreaker) current() CircuitState {
+	if b.state == "" {
+		return CircuitClosed
+	}
+	return b.state
+}
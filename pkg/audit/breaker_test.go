@@ -0,0 +1,123 @@
+package audit
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	t.Parallel()
+
+	type step struct {
+		elapsed time.Duration
+		allowed bool
+		err     error
+		state   CircuitState
+	}
+
+	cases := []struct {
+		description string
+		threshold   int
+		steps       []step
+	}{
+		{
+			"breaker closed after successful writes",
+			2,
+			[]step{
+				{0, true, nil, CircuitClosed},
+				{0, true, nil, CircuitClosed},
+			},
+		},
+		{
+			"breaker opened after failed writes in a row",
+			2,
+			[]step{
+				{0, true, errors.New("test"), CircuitClosed},
+				{0, true, errors.New("test"), CircuitOpen},
+				{0, false, nil, CircuitOpen},
+			},
+		},
+		{
+			"breaker not opened after failed writes not in a row",
+			2,
+			[]step{
+				{0, true, errors.New("test"), CircuitClosed},
+				{0, true, nil, CircuitClosed},
+				{0, true, errors.New("test"), CircuitClosed},
+			},
+		},
+		{
+			"breaker closed after successful write once cooled down",
+			1,
+			[]step{
+				{0, true, errors.New("test"), CircuitOpen},
+				{30 * time.Second, false, nil, CircuitOpen},
+				{time.Minute, true, nil, CircuitClosed},
+			},
+		},
+		{
+			"breaker opened again after failed write once cooled down",
+			1,
+			[]step{
+				{0, true, errors.New("test"), CircuitOpen},
+				{time.Minute, true, errors.New("test"), CircuitOpen},
+				{30 * time.Second, false, nil, CircuitOpen},
+			},
+		},
+		{
+			"breaker never opened without threshold",
+			0,
+			[]step{
+				{0, true, errors.New("test"), CircuitClosed},
+				{0, true, errors.New("test"), CircuitClosed},
+				{0, true, errors.New("test"), CircuitClosed},
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+			b := &circuitBreaker{threshold: tc.threshold, cooldown: time.Minute}
+
+			for i, step := range tc.steps {
+				now = now.Add(step.elapsed)
+
+				allowed := b.Allow(now)
+				assert.Equal(t, step.allowed, allowed, "step %d", i)
+				if allowed {
+					b.Done(now, step.err)
+				}
+				assert.Equal(t, step.state, b.State(), "step %d", i)
+			}
+		})
+	}
+}
+
+func TestCircuitBreakerHalfOpen(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	b := &circuitBreaker{threshold: 1, cooldown: time.Minute}
+	b.Allow(now)
+	b.Done(now, errors.New("test"))
+
+	now = now.Add(time.Minute)
+
+	// Only a single write is let through once cooled down.
+	assert.True(t, b.Allow(now))
+	assert.Equal(t, CircuitHalfOpen, b.State())
+	assert.False(t, b.Allow(now))
+
+	// Giving up on the write lets another one through.
+	b.Abandon()
+	assert.True(t, b.Allow(now))
+}
@@ -17,10 +17,64 @@ func NewLoggerAudit(logger *zap.SugaredLogger) *ConsoleAudit {
 }
 
 func (d *ConsoleAudit) Write(_ context.Context, q *QueryData) error {
-	d.Logger.Infow("AUDIT",
+	fields := []any{
 		"Query", q.Query,
 		"User", q.User,
 		"Timestamp", q.Timestamp,
-	)
+	}
+	if q.RequestID != "" {
+		fields = append(fields, "RequestID", q.RequestID)
+	}
+	if len(q.Args) > 0 {
+		fields = append(fields, "Args", q.Args)
+	}
+	if q.DryRun {
+		fields = append(fields, "DryRun", q.DryRun)
+	}
+	if q.Explain {
+		fields = append(fields, "Explain", q.Explain, "Analyze", q.Analyze)
+	}
+	if q.Cancel != "" {
+		fields = append(fields, "Cancel", q.Cancel)
+	}
+	if q.Cursor != "" {
+		fields = append(fields, "Cursor", q.Cursor)
+	}
+	if q.BatchID != "" {
+		fields = append(fields, "BatchID", q.BatchID, "Statement", q.Statement)
+	}
+	if r := q.Result; r != nil {
+		fields = append(fields,
+			"Rows", r.Rows,
+			"Truncated", r.Truncated,
+			"RowLimit", r.RowLimit,
+			"ByteLimit", r.ByteLimit,
+		)
+		if r.Digest != "" {
+			fields = append(fields, "Digest", r.Digest, "Bytes", r.Bytes)
+		}
+		if r.CacheHit {
+			fields = append(fields, "CacheHit", r.CacheHit)
+		}
+		if r.RowsAffected != nil {
+			fields = append(fields, "RowsAffected", *r.RowsAffected)
+		}
+		if r.LastInsertID != nil {
+			fields = append(fields, "LastInsertID", *r.LastInsertID)
+		}
+		if r.NextCursor != "" {
+			fields = append(fields, "NextCursor", r.NextCursor)
+		}
+		if r.Status != "" {
+			fields = append(fields, "Status", r.Status, "Code", r.Code, "Duration", r.Duration.String())
+			if r.Digest == "" {
+				fields = append(fields, "Bytes", r.Bytes)
+			}
+		}
+		if r.ErrorClass != "" {
+			fields = append(fields, "ErrorClass", r.ErrorClass)
+		}
+	}
+	d.Logger.Infow("AUDIT", fields...)
 	return nil
 }
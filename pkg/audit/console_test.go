@@ -3,6 +3,7 @@ package audit
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"io"
 	"regexp"
 	"testing"
@@ -41,6 +42,56 @@ func TestLoggingAuditWrite(t *testing.T) {
 			QueryData{Query: "", User: "test", Timestamp: time.Now().Unix()},
 			regexp.MustCompile(`AUDIT\s{"Query": "", "User": "test", "Timestamp": \d{10}}`),
 		},
+		{
+			"query data with arguments set",
+			QueryData{Query: "select $1, $2;", Args: []json.RawMessage{json.RawMessage(`1`), json.RawMessage(`"test"`)}, User: "test", Timestamp: 1672531200},
+			regexp.MustCompile(`AUDIT\s{"Query": "select \$1, \$2;", "User": "test", "Timestamp": 1672531200, "Args": \[1,"test"\]}`),
+		},
+		{
+			"query data with batch set",
+			QueryData{Query: "select 1;", BatchID: "test", Statement: 2, User: "test", Timestamp: 1672531200},
+			regexp.MustCompile(`AUDIT\s{"Query": "select 1;", "User": "test", "Timestamp": 1672531200, "BatchID": "test", "Statement": 2}`),
+		},
+		{
+			"query data with result set",
+			QueryData{Query: "select 1;", User: "test", Timestamp: 1672531200, Result: &ResultData{Rows: 10, Truncated: true, RowLimit: 10}},
+			regexp.MustCompile(`AUDIT\s{"Query": "select 1;", "User": "test", "Timestamp": 1672531200, "Rows": 10, "Truncated": true, "RowLimit": 10, "ByteLimit": 0}`),
+		},
+		{
+			"query data with result digest set",
+			QueryData{Query: "select 1;", User: "test", Timestamp: 1672531200, Result: &ResultData{Rows: 1, Digest: "test", Bytes: 42}},
+			regexp.MustCompile(`AUDIT\s{"Query": "select 1;", "User": "test", "Timestamp": 1672531200, "Rows": 1, "Truncated": false, "RowLimit": 0, "ByteLimit": 0, "Digest": "test", "Bytes": 42}`),
+		},
+		{
+			"query data with result served from cache",
+			QueryData{Query: "select 1;", User: "test", Timestamp: 1672531200, Result: &ResultData{Rows: 1, Digest: "test", Bytes: 42, CacheHit: true}},
+			regexp.MustCompile(`AUDIT\s{"Query": "select 1;", "User": "test", "Timestamp": 1672531200, "Rows": 1, "Truncated": false, "RowLimit": 0, "ByteLimit": 0, "Digest": "test", "Bytes": 42, "CacheHit": true}`),
+		},
+		{
+			"query data with rows affected set",
+			QueryData{Query: "delete from test;", User: "test", Timestamp: 1672531200, Result: &ResultData{RowsAffected: func() *int64 { n := int64(2); return &n }()}},
+			regexp.MustCompile(`AUDIT\s{"Query": "delete from test;", "User": "test", "Timestamp": 1672531200, "Rows": 0, "Truncated": false, "RowLimit": 0, "ByteLimit": 0, "RowsAffected": 2}`),
+		},
+		{
+			"query data with request ID and outcome set",
+			QueryData{Query: "selec 1;", User: "test", Timestamp: 1672531200, RequestID: "test", Result: &ResultData{Bytes: 42, Status: StatusSQLError, ErrorClass: "42", Code: 400, Duration: time.Second}},
+			regexp.MustCompile(`AUDIT\s{"Query": "selec 1;", "User": "test", "Timestamp": 1672531200, "RequestID": "test", "Rows": 0, "Truncated": false, "RowLimit": 0, "ByteLimit": 0, "Status": "sql_error", "Code": 400, "Duration": "1s", "Bytes": 42, "ErrorClass": "42"}`),
+		},
+		{
+			"query data with cursor set",
+			QueryData{Cursor: "test", User: "test", Timestamp: 1672531200, Result: &ResultData{Rows: 1, Truncated: true, NextCursor: "test"}},
+			regexp.MustCompile(`AUDIT\s{"Query": "", "User": "test", "Timestamp": 1672531200, "Cursor": "test", "Rows": 1, "Truncated": true, "RowLimit": 0, "ByteLimit": 0, "NextCursor": "test"}`),
+		},
+		{
+			"query data with dry run set",
+			QueryData{Query: "delete from test;", DryRun: true, User: "test", Timestamp: 1672531200},
+			regexp.MustCompile(`AUDIT\s{"Query": "delete from test;", "User": "test", "Timestamp": 1672531200, "DryRun": true}`),
+		},
+		{
+			"query data with cancellation set",
+			QueryData{Query: "select pg_sleep(10);", Cancel: "test", User: "test", Timestamp: 1672531200},
+			regexp.MustCompile(`AUDIT\s{"Query": "select pg_sleep\(10\);", "User": "test", "Timestamp": 1672531200, "Cancel": "test"}`),
+		},
 		{
 			"invalid query data with nothing set",
 			QueryData{},
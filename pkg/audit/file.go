@@ -0,0 +1,50 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// FileAudit appends events to a file, one JSON object per line, each
+// flushed to disk before the write returns.
+type FileAudit struct {
+	file *os.File
+	mu   sync.Mutex
+}
+
+var _ Audit = (*FileAudit)(nil)
+
+func NewFileAudit(path string) (*FileAudit, error) {
+	f, err := os.OpenFile(filepath.Clean(path), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open audit file: %w", err)
+	}
+	return &FileAudit{file: f}, nil
+}
+
+func (d *FileAudit) Write(_ context.Context, q *QueryData) error {
+	b, err := json.Marshal(q)
+	if err != nil {
+		return fmt.Errorf("unable to marshal audit: %w", err)
+	}
+	b = append(b, '\n')
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, err := d.file.Write(b); err != nil {
+		return fmt.Errorf("unable to write to audit file: %w", err)
+	}
+	if err := d.file.Sync(); err != nil {
+		return fmt.Errorf("unable to flush audit file: %w", err)
+	}
+	return nil
+}
+
+func (d *FileAudit) Close() error {
+	return d.file.Close()
+}
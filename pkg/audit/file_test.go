@@ -0,0 +1,51 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileAuditWrite(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	// Events are appended to those already in the file.
+	require.NoError(t, os.WriteFile(path, []byte(`{"Query":"select 0;"}`+"\n"), 0o600))
+
+	d, err := NewFileAudit(path)
+	require.NoError(t, err)
+
+	require.NoError(t, d.Write(context.TODO(), &QueryData{Query: "select 1;", User: "test", Timestamp: 1672531200}))
+	require.NoError(t, d.Write(context.TODO(), &QueryData{Query: "select 2;", User: "test", Timestamp: 1672531200, Result: &ResultData{Rows: 1, Status: StatusSuccess}}))
+	require.NoError(t, d.Close())
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	lines := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+	require.Len(t, lines, 3)
+
+	var q QueryData
+	require.NoError(t, json.Unmarshal([]byte(lines[2]), &q))
+	assert.Equal(t, "select 2;", q.Query)
+	assert.Equal(t, "test", q.User)
+	require.NotNil(t, q.Result)
+	assert.Equal(t, StatusSuccess, q.Result.Status)
+}
+
+func TestNewFileAuditError(t *testing.T) {
+	t.Parallel()
+
+	_, err := NewFileAudit(filepath.Join(t.TempDir(), "missing", "audit.log"))
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unable to open audit file")
+}
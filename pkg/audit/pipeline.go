@@ -0,0 +1,76 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Sink is somewhere audit events are written to, and whether the
+// request being audited fails when an event cannot be written there.
+type Sink struct {
+	Name     string
+	Audit    Audit
+	Required bool
+}
+
+// Pipeline writes audit events to every sink at once, within a shared
+// deadline. Failing to write to a sink that is not required is only
+// logged.
+type Pipeline struct {
+	sinks   []Sink
+	timeout time.Duration
+	logger  *zap.SugaredLogger
+}
+
+var _ Audit = (*Pipeline)(nil)
+
+func NewPipeline(sinks []Sink, timeout time.Duration, logger *zap.SugaredLogger) *Pipeline {
+	return &Pipeline{sinks: sinks, timeout: timeout, logger: logger}
+}
+
+// Sinks returns the sinks events are written to.
+func (p *Pipeline) Sinks() []Sink {
+	return p.sinks
+}
+
+func (p *Pipeline) Write(ctx context.Context, q *QueryData) error {
+	if p.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.timeout)
+		defer cancel()
+	}
+
+	errs := make([]error, len(p.sinks))
+
+	var wg sync.WaitGroup
+	for i, sink := range p.sinks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = sink.Audit.Write(ctx, q)
+		}()
+	}
+	wg.Wait()
+
+	var failed []error
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+		sink := p.sinks[i]
+		if !sink.Required {
+			if p.logger != nil {
+				p.logger.Warnf("Unable to write audit to %s: %s", sink.Name, err)
+			}
+			continue
+		}
+		failed = append(failed, fmt.Errorf("unable to write audit to %s: %w", sink.Name, err))
+	}
+
+	return errors.Join(failed...)
+}
@@ -0,0 +1,100 @@
+package audit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// blockingSink only returns once the deadline for writing has passed.
+type blockingSink struct{}
+
+func (blockingSink) Write(ctx context.Context, _ *QueryData) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestPipelineWrite(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		description string
+		required    int
+		optional    int
+		error       bool
+		want        string
+	}{
+		{
+			"events written to every sink",
+			0,
+			0,
+			false,
+			``,
+		},
+		{
+			"best-effort sink fails to write event",
+			0,
+			1,
+			false,
+			``,
+		},
+		{
+			"required sink fails to write event",
+			1,
+			0,
+			true,
+			`unable to write audit to required: test`,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			required := &dummySink{fail: tc.required}
+			optional := &dummySink{fail: tc.optional}
+
+			p := NewPipeline([]Sink{
+				{Name: "required", Audit: required, Required: true},
+				{Name: "optional", Audit: optional},
+			}, time.Second, nil)
+
+			err := p.Write(context.TODO(), &QueryData{Query: "select 1;", User: "test"})
+
+			if tc.error {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.want)
+				assert.Empty(t, required.Queries())
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, []string{"select 1;"}, required.Queries())
+			}
+			assert.Equal(t, tc.optional == 0, len(optional.Queries()) == 1)
+		})
+	}
+}
+
+func TestPipelineWriteTimeout(t *testing.T) {
+	t.Parallel()
+
+	timeout := 50 * time.Millisecond
+
+	p := NewPipeline([]Sink{
+		{Name: "first", Audit: blockingSink{}, Required: true},
+		{Name: "second", Audit: blockingSink{}},
+		{Name: "third", Audit: &dummySink{}},
+	}, timeout, nil)
+
+	start := time.Now()
+	err := p.Write(context.TODO(), &QueryData{Query: "select 1;", User: "test"})
+
+	// Sinks are written to at once, so the deadline is shared.
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Contains(t, err.Error(), "unable to write audit to first")
+	assert.NotContains(t, err.Error(), "second")
+	assert.Less(t, time.Since(start), 2*timeout)
+}
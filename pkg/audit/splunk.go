@@ -5,14 +5,18 @@ import (
 	"context"
 	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"math/rand/v2"
 	"net"
 	"net/http"
 	"time"
 
 	"github.com/app-sre/gabi/pkg/env/splunk"
 	"github.com/app-sre/gabi/pkg/version"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
 const (
@@ -21,21 +25,86 @@ const (
 
 	connectTimeout = 5 * time.Second
 	requestTimeout = 30 * time.Second
+
+	maxRetryBackoff = 5 * time.Second
+)
+
+// HEC codes reporting that Splunk is unable to take the event for now,
+// rather than that there is something wrong with it.
+const (
+	splunkCodeInternalError = 8
+	splunkCodeServerBusy    = 9
 )
 
 type SplunkAudit struct {
 	SplunkEnv *splunk.Env
 
-	client *http.Client
+	client  *http.Client
+	logger  *zap.SugaredLogger
+	breaker circuitBreaker
+	acks    ackTracker
+}
+
+var (
+	_ Audit   = (*SplunkAudit)(nil)
+	_ Breaker = (*SplunkAudit)(nil)
+)
+
+// transientError is an error that might not happen again should the
+// event be sent once more.
+type transientError struct {
+	err error
 }
 
-var _ Audit = (*SplunkAudit)(nil)
+func (e *transientError) Error() string {
+	return e.err.Error()
+}
+
+func (e *transientError) Unwrap() error {
+	return e.err
+}
+
+// hecResponse is what HEC endpoints respond with, where AckID is only
+// set when sending an event, and Acks when checking acknowledgments.
+type hecResponse struct {
+	Code  int             `json:"code"`
+	Text  string          `json:"text"`
+	AckID *int64          `json:"ackId"`
+	Acks  map[string]bool `json:"acks"`
+}
 
 type SplunkEventData struct {
-	Query     string `json:"query"`
-	User      string `json:"user"`
-	Namespace string `json:"namespace"`
-	Pod       string `json:"pod"`
+	Query     string            `json:"query"`
+	Args      []json.RawMessage `json:"args,omitempty"`
+	BatchID   string            `json:"batch_id,omitempty"`
+	Statement int               `json:"statement,omitempty"`
+	DryRun    bool              `json:"dry_run,omitempty"`
+	Explain   bool              `json:"explain,omitempty"`
+	Analyze   bool              `json:"analyze,omitempty"`
+	Cancel    string            `json:"cancel,omitempty"`
+	Cursor    string            `json:"cursor,omitempty"`
+	User      string            `json:"user"`
+	Namespace string            `json:"namespace"`
+	Pod       string            `json:"pod"`
+	RequestID string            `json:"request_id,omitempty"`
+	Result    *SplunkResultData `json:"result,omitempty"`
+}
+
+type SplunkResultData struct {
+	Rows         int64  `json:"rows"`
+	Truncated    bool   `json:"truncated"`
+	RowLimit     int64  `json:"row_limit"`
+	ByteLimit    int64  `json:"byte_limit"`
+	Digest       string `json:"digest,omitempty"`
+	Bytes        int64  `json:"bytes,omitempty"`
+	CacheHit     bool   `json:"cache_hit,omitempty"`
+	RowsAffected *int64 `json:"rows_affected,omitempty"`
+	LastInsertID *int64 `json:"last_insert_id,omitempty"`
+	NextCursor   string `json:"next_cursor,omitempty"`
+	Status       string `json:"status,omitempty"`
+	ErrorClass   string `json:"error_class,omitempty"`
+	Code         int    `json:"code,omitempty"`
+	DurationMS   int64  `json:"duration_ms,omitempty"`
 }
 
 type SplunkQueryData struct {
@@ -55,6 +124,12 @@ func WithHTTPClient(client *http.Client) Option {
 	}
 }
 
+func WithLogger(logger *zap.SugaredLogger) Option {
+	return func(s *SplunkAudit) {
+		s.logger = logger
+	}
+}
+
 func NewSplunkAudit(splunk *splunk.Env, options ...Option) *SplunkAudit {
 	s := &SplunkAudit{SplunkEnv: splunk}
 
@@ -73,6 +148,10 @@ func NewSplunkAudit(splunk *splunk.Env, options ...Option) *SplunkAudit {
 		option(s)
 	}
 
+	s.breaker.threshold = s.SplunkEnv.BreakerThreshold
+	s.breaker.cooldown = s.SplunkEnv.BreakerCooldown
+	s.acks.channel = newChannelID()
+
 	return s
 }
 
@@ -91,9 +170,36 @@ func (d *SplunkAudit) Write(ctx context.Context, q *QueryData) error {
 
 	query.Event = &SplunkEventData{
 		Query:     q.Query,
+		Args:      q.Args,
+		BatchID:   q.BatchID,
+		Statement: q.Statement,
+		DryRun:    q.DryRun,
+		Explain:   q.Explain,
+		Analyze:   q.Analyze,
+		Cancel:    q.Cancel,
+		Cursor:    q.Cursor,
 		User:      q.User,
 		Namespace: d.SplunkEnv.Namespace,
 		Pod:       d.SplunkEnv.Pod,
+		RequestID: q.RequestID,
+	}
+	if r := q.Result; r != nil {
+		query.Event.Result = &SplunkResultData{
+			Rows:         r.Rows,
+			Truncated:    r.Truncated,
+			RowLimit:     r.RowLimit,
+			ByteLimit:    r.ByteLimit,
+			Digest:       r.Digest,
+			Bytes:        r.Bytes,
+			CacheHit:     r.CacheHit,
+			RowsAffected: r.RowsAffected,
+			LastInsertID: r.LastInsertID,
+			NextCursor:   r.NextCursor,
+			Status:       r.Status,
+			ErrorClass:   r.ErrorClass,
+			Code:         r.Code,
+			DurationMS:   r.Duration.Milliseconds(),
+		}
 	}
 
 	content, err := json.Marshal(query)
@@ -101,43 +207,179 @@ func (d *SplunkAudit) Write(ctx context.Context, q *QueryData) error {
 		return fmt.Errorf("unable to marshal Splunk audit: %w", err)
 	}
 
-	url := fmt.Sprintf("%s/services/collector/event", d.SplunkEnv.Endpoint)
+	if !d.breaker.Allow(time.Now()) {
+		return fmt.Errorf("unable to send request to Splunk: %w", ErrCircuitOpen)
+	}
+
+	ackID, err := d.send(ctx, content)
+	if err == nil {
+		err = d.acknowledge(ctx, ackID)
+	}
+	d.done(err, ctx.Err() != nil)
 
-	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	return err
+}
+
+// Circuit returns the state of the circuit breaker, which is open while
+// Splunk is deemed to be unavailable.
+func (d *SplunkAudit) Circuit() CircuitState {
+	return d.breaker.State()
+}
+
+// send sends the event, trying again after a jittered exponential
+// backoff for as long as the error is transient, the number of retries
+// allows it, and the deadline of the context leaves enough time. The
+// request being audited has no deadline yet, hence sending the event,
+// including every retry, has one of its own.
+func (d *SplunkAudit) send(ctx context.Context, content []byte) (int64, error) {
+	timeout := d.SplunkEnv.RetryTimeout
+	if timeout <= 0 {
+		timeout = splunk.DefaultRetryTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewBuffer(content))
+	backoff := d.SplunkEnv.RetryBackoff
+
+	for attempt := 0; ; attempt++ {
+		ackID, err := d.post(ctx, content)
+
+		var transient *transientError
+		if err == nil || !errors.As(err, &transient) || attempt >= d.SplunkEnv.Retries || ctx.Err() != nil {
+			return ackID, err
+		}
+
+		wait := time.Duration(0)
+		if backoff > 0 {
+			wait = rand.N(min(backoff<<attempt, maxRetryBackoff))
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			return 0, err
+		}
+		d.logf(zap.WarnLevel, "Unable to send audit to Splunk, retrying in %s (%d of %d): %s",
+			wait.Round(time.Millisecond), attempt+1, d.SplunkEnv.Retries, err)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return 0, err
+		}
+	}
+}
+
+// post sends the event once, and returns the ID of the acknowledgment
+// that Splunk sends once it has been indexed, if requested.
+func (d *SplunkAudit) post(ctx context.Context, content []byte) (int64, error) {
+	resp, err := d.request(ctx, "/services/collector/event", content)
+	if err != nil {
+		return 0, err
+	}
+	if !d.acknowledged() {
+		return 0, nil
+	}
+	if resp.AckID == nil {
+		return 0, errors.New("unable to write to Splunk: no acknowledgment ID returned, indexer acknowledgment might be disabled")
+	}
+
+	return *resp.AckID, nil
+}
+
+// request sends content to the given HEC endpoint, and returns the
+// response, unless it reports an error.
+func (d *SplunkAudit) request(ctx context.Context, path string, content []byte) (*hecResponse, error) {
+	url := fmt.Sprintf("%s%s", d.SplunkEnv.Endpoint, path)
+
+	reqCtx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(reqCtx, http.MethodPost, url, bytes.NewBuffer(content))
 	if err != nil {
-		return fmt.Errorf("unable to create request to Splunk: %w", err)
+		return nil, fmt.Errorf("unable to create request to Splunk: %w", err)
 	}
 	req.Header.Set("Accept", "application/json")
 	req.Header.Set("Authorization", fmt.Sprintf("Splunk %s", d.SplunkEnv.Token))
 	req.Header.Set("Content-Type", "application/json; charset=utf-8")
 	req.Header.Set("User-Agent", fmt.Sprintf("GABI/%s", version.Version()))
+	if d.acknowledged() {
+		req.Header.Set(splunkChannelHeader, d.acks.channel)
+	}
 
 	resp, err := d.client.Do(req)
 	if err != nil {
-		return fmt.Errorf("unable to send request to Splunk: %w", err)
+		return nil, &transientError{fmt.Errorf("unable to send request to Splunk: %w", err)}
 	}
 	defer func() { _ = resp.Body.Close() }()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return fmt.Errorf("unable to read Splunk response body: %w", err)
+		return nil, &transientError{fmt.Errorf("unable to read Splunk response body: %w", err)}
 	}
 
-	splunk := struct {
-		Code int    `json:"code"`
-		Text string `json:"text"`
-	}{}
+	var splunk hecResponse
 
 	err = json.Unmarshal(body, &splunk)
 	if err != nil {
-		return fmt.Errorf("unable to unmarshal Splunk response: %w", err)
+		err = fmt.Errorf("unable to unmarshal Splunk response: %w", err)
+		if retryableStatus(resp.StatusCode) {
+			return nil, &transientError{err}
+		}
+		return nil, err
 	}
 	if splunk.Code > 0 {
-		return fmt.Errorf("unable to write to Splunk: %s (%d)", splunk.Text, splunk.Code)
+		err = fmt.Errorf("unable to write to Splunk: %s (%d)", splunk.Text, splunk.Code)
+		if splunk.Code == splunkCodeInternalError || splunk.Code == splunkCodeServerBusy || retryableStatus(resp.StatusCode) {
+			return nil, &transientError{err}
+		}
+		return nil, err
+	}
+
+	return &splunk, nil
+}
+
+// done records how sending the event went, unless the caller gave up on
+// it, or it was rejected, neither of which is a sign of Splunk being
+// unavailable, and logs the circuit breaker changing state.
+func (d *SplunkAudit) done(err error, abandoned bool) {
+	if err != nil && (abandoned || !unavailableError(err)) {
+		d.breaker.Abandon()
+		return
 	}
 
-	return nil
+	state, changed := d.breaker.Done(time.Now(), err)
+	if !changed {
+		return
+	}
+	switch state {
+	case CircuitOpen:
+		d.logf(zap.ErrorLevel, "Splunk circuit breaker is open, audit will fail for %s: %s", d.SplunkEnv.BreakerCooldown, err)
+	case CircuitClosed:
+		d.logf(zap.InfoLevel, "Splunk circuit breaker is closed")
+	}
+}
+
+func (d *SplunkAudit) logf(level zapcore.Level, template string, args ...any) {
+	if d.logger != nil {
+		d.logger.Logf(level, template, args...)
+	}
+}
+
+// unavailableError reports whether the error is a sign of Splunk being
+// unavailable, as opposed to rejecting the event, such as when the token
+// is invalid, which would not open the circuit breaker for everyone.
+func unavailableError(err error) bool {
+	var transient *transientError
+	return errors.As(err, &transient) || errors.Is(err, ErrAckTimeout)
+}
+
+// Splunk, or a proxy in front of it, being overloaded or unavailable.
+func retryableStatus(code int) bool {
+	switch code {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
 }
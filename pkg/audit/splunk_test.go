@@ -3,11 +3,13 @@ package audit
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"regexp"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -205,6 +207,105 @@ func TestSplunkAduitWrite(t *testing.T) {
 			``,
 			regexp.MustCompile(`{"query":"select 1;","user":"test","namespace":"test","pod":"test"},(.*),"time":1672531200`),
 		},
+		{
+			"valid query with arguments set",
+			QueryData{Query: "select $1;", Args: []json.RawMessage{json.RawMessage(`{"a":1}`)}, User: "test", Timestamp: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC).Unix()},
+			func() *http.Header {
+				return &http.Header{
+					"Accept":          []string{"application/json"},
+					"Accept-Encoding": []string{"gzip"},
+					"Authorization":   []string{"Splunk test123"},
+					"Content-Type":    []string{"application/json; charset=utf-8"},
+					"User-Agent":      []string{fmt.Sprintf("GABI/%s", version.Version())},
+				}
+			},
+			func(s *httptest.Server) *splunk.Env {
+				return &splunk.Env{
+					Endpoint:  s.URL,
+					Token:     "test123",
+					Host:      "test",
+					Namespace: "test",
+					Pod:       "test",
+				}
+			},
+			func(b *bytes.Buffer, h *http.Header) func(w http.ResponseWriter, r *http.Request) {
+				return func(w http.ResponseWriter, r *http.Request) {
+					_, _ = io.Copy(b, r.Body)
+					*h = r.Header
+					h.Del("Content-Length")
+					fmt.Fprintln(w, `{"Code":0,"Text":""}`)
+				}
+			},
+			false,
+			``,
+			regexp.MustCompile(`{"query":"select \$1;","args":\[{"a":1}\],"user":"test","namespace":"test","pod":"test"},(.*),"time":1672531200`),
+		},
+		{
+			"valid query with result set",
+			QueryData{Query: "select 1;", User: "test", Timestamp: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC).Unix(), Result: &ResultData{Rows: 2, Truncated: true, RowLimit: 2, Digest: "test", Bytes: 42}},
+			func() *http.Header {
+				return &http.Header{
+					"Accept":          []string{"application/json"},
+					"Accept-Encoding": []string{"gzip"},
+					"Authorization":   []string{"Splunk test123"},
+					"Content-Type":    []string{"application/json; charset=utf-8"},
+					"User-Agent":      []string{fmt.Sprintf("GABI/%s", version.Version())},
+				}
+			},
+			func(s *httptest.Server) *splunk.Env {
+				return &splunk.Env{
+					Endpoint:  s.URL,
+					Token:     "test123",
+					Host:      "test",
+					Namespace: "test",
+					Pod:       "test",
+				}
+			},
+			func(b *bytes.Buffer, h *http.Header) func(w http.ResponseWriter, r *http.Request) {
+				return func(w http.ResponseWriter, r *http.Request) {
+					_, _ = io.Copy(b, r.Body)
+					*h = r.Header
+					h.Del("Content-Length")
+					fmt.Fprintln(w, `{"Code":0,"Text":""}`)
+				}
+			},
+			false,
+			``,
+			regexp.MustCompile(`{"query":"select 1;","user":"test","namespace":"test","pod":"test","result":{"rows":2,"truncated":true,"row_limit":2,"byte_limit":0,"digest":"test","bytes":42}},(.*),"time":1672531200`),
+		},
+		{
+			"valid query with outcome set",
+			QueryData{Query: "select 1;", User: "test", Timestamp: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC).Unix(), RequestID: "test", Result: &ResultData{Rows: 1, Bytes: 42, Status: StatusSuccess, Code: 200, Duration: 1500 * time.Millisecond}},
+			func() *http.Header {
+				return &http.Header{
+					"Accept":          []string{"application/json"},
+					"Accept-Encoding": []string{"gzip"},
+					"Authorization":   []string{"Splunk test123"},
+					"Content-Type":    []string{"application/json; charset=utf-8"},
+					"User-Agent":      []string{fmt.Sprintf("GABI/%s", version.Version())},
+				}
+			},
+			func(s *httptest.Server) *splunk.Env {
+				return &splunk.Env{
+					Endpoint:  s.URL,
+					Token:     "test123",
+					Host:      "test",
+					Namespace: "test",
+					Pod:       "test",
+				}
+			},
+			func(b *bytes.Buffer, h *http.Header) func(w http.ResponseWriter, r *http.Request) {
+				return func(w http.ResponseWriter, r *http.Request) {
+					_, _ = io.Copy(b, r.Body)
+					*h = r.Header
+					h.Del("Content-Length")
+					fmt.Fprintln(w, `{"Code":0,"Text":""}`)
+				}
+			},
+			false,
+			``,
+			regexp.MustCompile(`{"query":"select 1;","user":"test","namespace":"test","pod":"test","request_id":"test","result":{"rows":1,"truncated":false,"row_limit":0,"byte_limit":0,"bytes":42,"status":"success","code":200,"duration_ms":1500}},(.*),"time":1672531200`),
+		},
 		{
 			"valid query with no SQL statements provided",
 			QueryData{Query: "", User: "test", Timestamp: time.Now().Unix()},
@@ -466,3 +567,235 @@ func TestSplunkAduitWrite(t *testing.T) {
 		})
 	}
 }
+
+func TestSplunkAuditWriteRetry(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		description string
+		retries     int
+		responses   []func(w http.ResponseWriter)
+		attempts    int
+		error       bool
+		message     string
+	}{
+		{
+			"event sent after Splunk being unavailable",
+			2,
+			[]func(w http.ResponseWriter){
+				func(w http.ResponseWriter) {
+					w.WriteHeader(http.StatusServiceUnavailable)
+				},
+				func(w http.ResponseWriter) {
+					fmt.Fprintln(w, `{"Code":0,"Text":""}`)
+				},
+			},
+			2,
+			false,
+			``,
+		},
+		{
+			"event sent after Splunk being busy",
+			2,
+			[]func(w http.ResponseWriter){
+				func(w http.ResponseWriter) {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					fmt.Fprintln(w, `{"Code":9,"Text":"Server is busy"}`)
+				},
+				func(w http.ResponseWriter) {
+					fmt.Fprintln(w, `{"Code":0,"Text":""}`)
+				},
+			},
+			2,
+			false,
+			``,
+		},
+		{
+			"event not sent after retries run out",
+			2,
+			[]func(w http.ResponseWriter){
+				func(w http.ResponseWriter) {
+					w.WriteHeader(http.StatusBadGateway)
+				},
+			},
+			3,
+			true,
+			`unable to unmarshal Splunk response`,
+		},
+		{
+			"event not sent again after Splunk rejecting it",
+			2,
+			[]func(w http.ResponseWriter){
+				func(w http.ResponseWriter) {
+					w.WriteHeader(http.StatusForbidden)
+					fmt.Fprintln(w, `{"Code":4,"Text":"Invalid token"}`)
+				},
+			},
+			1,
+			true,
+			`unable to write to Splunk: Invalid token (4)`,
+		},
+		{
+			"event not sent again without retries",
+			0,
+			[]func(w http.ResponseWriter){
+				func(w http.ResponseWriter) {
+					w.WriteHeader(http.StatusServiceUnavailable)
+				},
+			},
+			1,
+			true,
+			`unable to unmarshal Splunk response`,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			var attempts atomic.Int32
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := int(attempts.Add(1))
+				tc.responses[min(n, len(tc.responses))-1](w)
+			}))
+			defer server.Close()
+
+			s := NewSplunkAudit(&splunk.Env{
+				Endpoint:     server.URL,
+				Retries:      tc.retries,
+				RetryBackoff: time.Millisecond,
+			}, WithHTTPClient(server.Client()))
+
+			err := s.Write(context.TODO(), &QueryData{Query: "select 1;", User: "test", Timestamp: time.Now().Unix()})
+
+			assert.Equal(t, tc.attempts, int(attempts.Load()))
+			if tc.error {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.message)
+			} else {
+				require.NoError(t, err)
+			}
+			assert.Equal(t, CircuitClosed, s.Circuit())
+		})
+	}
+}
+
+func TestSplunkAuditCircuitBreaker(t *testing.T) {
+	t.Parallel()
+
+	var (
+		attempts  atomic.Int32
+		available atomic.Bool
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		if !available.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, `{"Code":0,"Text":""}`)
+	}))
+	defer server.Close()
+
+	s := NewSplunkAudit(&splunk.Env{
+		Endpoint:         server.URL,
+		BreakerThreshold: 2,
+		BreakerCooldown:  50 * time.Millisecond,
+	}, WithHTTPClient(server.Client()))
+
+	write := func() error {
+		return s.Write(context.TODO(), &QueryData{Query: "select 1;", User: "test", Timestamp: time.Now().Unix()})
+	}
+
+	require.Error(t, write())
+	assert.Equal(t, CircuitClosed, s.Circuit())
+	require.Error(t, write())
+	assert.Equal(t, CircuitOpen, s.Circuit())
+
+	err := write()
+	require.ErrorIs(t, err, ErrCircuitOpen)
+	assert.Equal(t, 2, int(attempts.Load()))
+
+	available.Store(true)
+	time.Sleep(60 * time.Millisecond)
+
+	require.NoError(t, write())
+	assert.Equal(t, CircuitClosed, s.Circuit())
+	assert.Equal(t, 3, int(attempts.Load()))
+}
+
+func TestSplunkAuditCircuitBreakerRejected(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprintln(w, `{"Code":4,"Text":"Invalid token"}`)
+	}))
+	defer server.Close()
+
+	s := NewSplunkAudit(&splunk.Env{
+		Endpoint:         server.URL,
+		BreakerThreshold: 1,
+		BreakerCooldown:  time.Minute,
+	}, WithHTTPClient(server.Client()))
+
+	// Events being rejected says nothing about Splunk being available.
+	for i := 0; i < 3; i++ {
+		err := s.Write(context.TODO(), &QueryData{Query: "select 1;", User: "test", Timestamp: time.Now().Unix()})
+		require.Error(t, err)
+		require.NotErrorIs(t, err, ErrCircuitOpen)
+		assert.Equal(t, CircuitClosed, s.Circuit())
+	}
+}
+
+func TestSplunkAuditWriteRetryTimeout(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	s := NewSplunkAudit(&splunk.Env{
+		Endpoint:     server.URL,
+		Retries:      100,
+		RetryBackoff: time.Millisecond,
+		RetryTimeout: 100 * time.Millisecond,
+	}, WithHTTPClient(server.Client()))
+
+	// The request being audited has no deadline of its own.
+	start := time.Now()
+	err := s.Write(context.TODO(), &QueryData{Query: "select 1;", User: "test", Timestamp: time.Now().Unix()})
+
+	require.Error(t, err)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestSplunkAuditWriteCanceled(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	s := NewSplunkAudit(&splunk.Env{
+		Endpoint:         server.URL,
+		Retries:          2,
+		RetryBackoff:     time.Second,
+		BreakerThreshold: 1,
+		BreakerCooldown:  time.Minute,
+	}, WithHTTPClient(server.Client()))
+
+	ctx, cancel := context.WithTimeout(context.TODO(), 10*time.Millisecond)
+	defer cancel()
+
+	err := s.Write(ctx, &QueryData{Query: "select 1;", User: "test", Timestamp: time.Now().Unix()})
+
+	require.Error(t, err)
+	assert.NotErrorIs(t, err, ErrCircuitOpen)
+}
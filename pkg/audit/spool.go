@@ -0,0 +1,648 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	spoolSegmentSuffix = ".spool"
+	spoolProgressFile  = "progress.json"
+	spoolRejectedFile  = "rejected.jsonl"
+
+	// Segments are only removed once every event in them has been
+	// sent, and a new one is started once the current one is full.
+	spoolSegmentSize = 16 << 20
+
+	// Bounds the time spent sending a single event, including retries.
+	spoolForwardTimeout = time.Minute
+
+	// The time waited before sending an event again after failing to.
+	spoolRetryInterval = 5 * time.Second
+)
+
+var ErrSpoolFull = errors.New("audit spool is full")
+
+// SpoolStats describes the events that have not been sent yet.
+type SpoolStats struct {
+	Events int
+	Bytes  int64
+	Oldest time.Time
+}
+
+// Spooler is implemented by audit writers that keep events on disk
+// until they have been sent.
+type Spooler interface {
+	Spool() SpoolStats
+}
+
+// spoolPosition is where the next event to be sent starts.
+type spoolPosition struct {
+	Segment uint64 `json:"segment"`
+	Offset  int64  `json:"offset"`
+}
+
+// SpoolAudit writes events to an append-only spool on disk, flushed
+// before Write returns, from which they are sent to the sink in order
+// by Run. Having been sent is recorded on disk too, so that sending
+// resumes where it stopped after a restart, which means an event can
+// be sent more than once, but is never lost.
+type SpoolAudit struct {
+	dir         string
+	max         int64
+	segmentSize int64
+	retry       time.Duration
+	sink        Audit
+	logger      *zap.SugaredLogger
+	notify      chan struct{}
+
+	// Guarded by mu, as both Write and Run use them.
+	file    *os.File
+	segment uint64
+	size    int64
+	bytes   int64
+	events  int
+	oldest  int64
+	mu      sync.Mutex
+
+	// Only used by Run.
+	pos     spoolPosition
+	reader  *os.File
+	buf     *bufio.Reader
+	partial []byte
+}
+
+var (
+	_ Audit   = (*SpoolAudit)(nil)
+	_ Breaker = (*SpoolAudit)(nil)
+	_ Spooler = (*SpoolAudit)(nil)
+)
+
+// NewSpoolAudit returns an audit writer that spools events in dir,
+// which can hold at most max bytes of events not sent yet, before they
+// are sent to sink.
+// Events left in the spool before a restart are sent first.
+func NewSpoolAudit(sink Audit, dir string, max int64, logger *zap.SugaredLogger) (*SpoolAudit, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("unable to create audit spool directory: %w", err)
+	}
+
+	s := &SpoolAudit{
+		dir:         dir,
+		max:         max,
+		segmentSize: spoolSegmentSize,
+		retry:       spoolRetryInterval,
+		sink:        sink,
+		logger:      logger,
+		notify:      make(chan struct{}, 1),
+	}
+	if err := s.recover(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Write adds the event to the spool, and only returns once it has been
+// flushed to disk. It fails once the spool is full of events not sent
+// yet.
+func (s *SpoolAudit) Write(_ context.Context, q *QueryData) error {
+	b, err := json.Marshal(q)
+	if err != nil {
+		return fmt.Errorf("unable to marshal audit: %w", err)
+	}
+	b = append(b, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := int64(len(b))
+	if s.bytes+n > s.max {
+		return fmt.Errorf("unable to write to audit spool: %w", ErrSpoolFull)
+	}
+
+	// Once every event has been sent, the segment is only kept for as
+	// long as it does not grow larger than the spool.
+	if s.size > 0 && (s.size+n > s.segmentSize || s.events == 0 && s.size+n > s.max) {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+
+	if _, err := s.file.Write(b); err != nil {
+		_ = s.file.Truncate(s.size)
+		return fmt.Errorf("unable to write to audit spool: %w", err)
+	}
+	if err := s.file.Sync(); err != nil {
+		_ = s.file.Truncate(s.size)
+		return fmt.Errorf("unable to flush audit spool: %w", err)
+	}
+
+	s.size += n
+	s.bytes += n
+	if s.events == 0 {
+		s.oldest = q.Timestamp
+	}
+	s.events++
+
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// Run sends the events in the spool to the sink, one at a time and in
+// the order they were written, until the context is cancelled. Events
+// that could not be sent are sent again until they are, unless the
+// sink rejected them, in which case they are set aside in a file of
+// their own, so that they do not hold back the events after them.
+func (s *SpoolAudit) Run(ctx context.Context) {
+	defer s.closeReader()
+
+	var failing bool
+
+	for {
+		q, next, err := s.next()
+		if err != nil {
+			s.logf(zap.ErrorLevel, "Unable to read audit spool: %s", err)
+			if !wait(ctx, time.After(s.retry)) {
+				return
+			}
+			continue
+		}
+		if q == nil {
+			if !wait(ctx, s.notify) {
+				return
+			}
+			continue
+		}
+
+		for {
+			sctx, cancel := context.WithTimeout(ctx, spoolForwardTimeout)
+			err = s.sink.Write(sctx, q)
+			cancel()
+			if err == nil {
+				break
+			}
+			if ctx.Err() != nil {
+				return
+			}
+
+			// Setting the event aside can fail too, which is retried.
+			if rejectedError(err) {
+				rerr := s.reject(q)
+				if rerr == nil {
+					s.logf(zap.ErrorLevel, "Spooled audit rejected, moved it to %s: %s",
+						filepath.Join(s.dir, spoolRejectedFile), err)
+					break
+				}
+				err = rerr
+			}
+
+			failing = true
+			stats := s.Spool()
+			s.logf(zap.WarnLevel, "Unable to send spooled audit, retrying in %s (%d events pending, oldest from %s ago): %s",
+				s.retry, stats.Events, time.Since(stats.Oldest).Round(time.Second), err)
+
+			if !wait(ctx, time.After(s.retry)) {
+				return
+			}
+		}
+
+		if err := s.advance(next); err != nil {
+			s.logf(zap.ErrorLevel, "Unable to record audit spool progress: %s", err)
+		}
+
+		if failing && s.Spool().Events == 0 {
+			failing = false
+			s.logf(zap.InfoLevel, "Sent all spooled audit events")
+		}
+	}
+}
+
+// reject appends the event to the events the sink rejected, and only
+// returns once it has been flushed to disk.
+func (s *SpoolAudit) reject(q *QueryData) error {
+	b, err := json.Marshal(q)
+	if err != nil {
+		return fmt.Errorf("unable to marshal audit: %w", err)
+	}
+	b = append(b, '\n')
+
+	f, err := os.OpenFile(filepath.Join(s.dir, spoolRejectedFile), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("unable to open rejected audit: %w", err)
+	}
+	_, err = f.Write(b)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("unable to write rejected audit: %w", err)
+	}
+	return nil
+}
+
+// Spool returns what is left to be sent.
+func (s *SpoolAudit) Spool() SpoolStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := SpoolStats{Events: s.events, Bytes: s.bytes}
+	if s.events > 0 {
+		stats.Oldest = time.Unix(s.oldest, 0)
+	}
+	return stats
+}
+
+// Circuit returns the state of the circuit breaker of the sink, if it
+// has one, which is open while events cannot be sent.
+func (s *SpoolAudit) Circuit() CircuitState {
+	if b, ok := s.sink.(Breaker); ok {
+		return b.Circuit()
+	}
+	return CircuitClosed
+}
+
+// Close closes the segment being written to, after which nothing can
+// be written to the spool anymore.
+func (s *SpoolAudit) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
+
+// recover picks up where sending stopped before a restart, dropping
+// whatever was written last if flushing it had not completed, and
+// starts a new segment.
+func (s *SpoolAudit) recover() error {
+	segments, err := s.segments()
+	if err != nil {
+		return err
+	}
+
+	b, err := os.ReadFile(filepath.Join(s.dir, spoolProgressFile))
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		if len(segments) > 0 {
+			s.pos = spoolPosition{Segment: segments[0]}
+		}
+	case err != nil:
+		return fmt.Errorf("unable to read audit spool progress: %w", err)
+	default:
+		if err := json.Unmarshal(b, &s.pos); err != nil {
+			return fmt.Errorf("unable to unmarshal audit spool progress: %w", err)
+		}
+	}
+
+	for i, segment := range segments {
+		path := s.segmentPath(segment)
+
+		// Sent before the restart, but not removed yet.
+		if segment < s.pos.Segment {
+			_ = os.Remove(path)
+			continue
+		}
+
+		if i == len(segments)-1 {
+			if err := repairSegment(path); err != nil {
+				return err
+			}
+		}
+
+		offset := int64(0)
+		if segment == s.pos.Segment {
+			offset = s.pos.Offset
+		}
+		size, events, oldest, err := scanSegment(path, offset)
+		if err != nil {
+			return err
+		}
+
+		s.bytes += size
+		if s.events == 0 {
+			s.oldest = oldest
+		}
+		s.events += events
+		s.segment = segment
+	}
+
+	s.segment++
+	if s.segment <= s.pos.Segment {
+		s.segment = s.pos.Segment + 1
+	}
+	return s.open()
+}
+
+// rotate closes the segment being written to, and starts a new one.
+func (s *SpoolAudit) rotate() error {
+	if err := s.file.Close(); err != nil {
+		return fmt.Errorf("unable to close audit spool segment: %w", err)
+	}
+	s.segment++
+	return s.open()
+}
+
+func (s *SpoolAudit) open() error {
+	f, err := os.OpenFile(s.segmentPath(s.segment), os.O_WRONLY|os.O_CREATE|os.O_EXCL|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("unable to create audit spool segment: %w", err)
+	}
+	if err := syncDir(s.dir); err != nil {
+		_ = f.Close()
+		return err
+	}
+	s.file, s.size = f, 0
+	return nil
+}
+
+// next returns the next event to be sent, along with where the one
+// after it starts, or nothing if every event has been sent. Segments
+// that have been sent completely are removed.
+func (s *SpoolAudit) next() (*QueryData, spoolPosition, error) {
+	for {
+		// Nothing is written to a segment once another has been
+		// started, so having read all of it means it has been sent.
+		s.mu.Lock()
+		complete := s.pos.Segment < s.segment
+		s.mu.Unlock()
+
+		if s.reader == nil {
+			f, err := os.Open(s.segmentPath(s.pos.Segment))
+			if errors.Is(err, os.ErrNotExist) && complete {
+				s.pos = spoolPosition{Segment: s.pos.Segment + 1}
+				continue
+			}
+			if err != nil {
+				return nil, s.pos, fmt.Errorf("unable to open audit spool segment: %w", err)
+			}
+			if _, err := f.Seek(s.pos.Offset, io.SeekStart); err != nil {
+				_ = f.Close()
+				return nil, s.pos, fmt.Errorf("unable to seek audit spool segment: %w", err)
+			}
+			s.reader, s.buf, s.partial = f, bufio.NewReader(f), nil
+		}
+
+		line, err := s.buf.ReadBytes('\n')
+		s.partial = append(s.partial, line...)
+
+		switch {
+		case err == nil:
+			line, s.partial = s.partial, nil
+			next := spoolPosition{Segment: s.pos.Segment, Offset: s.pos.Offset + int64(len(line))}
+
+			var q QueryData
+			if err := json.Unmarshal(line, &q); err != nil {
+				s.logf(zap.ErrorLevel, "Unable to unmarshal spooled audit, skipping it: %s", err)
+				if err := s.advance(next); err != nil {
+					return nil, s.pos, err
+				}
+				continue
+			}
+
+			s.mu.Lock()
+			s.oldest = q.Timestamp
+			s.mu.Unlock()
+
+			return &q, next, nil
+		case !errors.Is(err, io.EOF):
+			return nil, s.pos, fmt.Errorf("unable to read audit spool segment: %w", err)
+		case !complete:
+			return nil, s.pos, nil
+		}
+
+		if len(s.partial) > 0 {
+			s.logf(zap.ErrorLevel, "Unable to read spooled audit, skipping it: incomplete event")
+		}
+		if err := s.remove(); err != nil {
+			return nil, s.pos, err
+		}
+	}
+}
+
+// advance records that the event before the given position has been
+// sent.
+func (s *SpoolAudit) advance(pos spoolPosition) error {
+	sent := pos.Offset - s.pos.Offset
+	s.pos = pos
+
+	s.mu.Lock()
+	s.bytes -= sent
+	if s.events > 0 {
+		s.events--
+	}
+	s.mu.Unlock()
+
+	return s.persist()
+}
+
+// remove removes the segment that has been sent completely, and moves
+// on to the next one.
+func (s *SpoolAudit) remove() error {
+	path := s.reader.Name()
+	s.closeReader()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("unable to access audit spool segment: %w", err)
+	}
+	// Whatever could not be read as an event is dropped along with it.
+	skipped := info.Size() - s.pos.Offset
+
+	s.pos = spoolPosition{Segment: s.pos.Segment + 1}
+	if err := s.persist(); err != nil {
+		return err
+	}
+
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("unable to remove audit spool segment: %w", err)
+	}
+
+	s.mu.Lock()
+	s.bytes -= skipped
+	s.mu.Unlock()
+
+	return nil
+}
+
+// persist records where sending resumes from after a restart. Losing
+// it only means events are sent again, hence the directory is not
+// flushed.
+func (s *SpoolAudit) persist() error {
+	b, err := json.Marshal(&s.pos)
+	if err != nil {
+		return fmt.Errorf("unable to marshal audit spool progress: %w", err)
+	}
+
+	path := filepath.Join(s.dir, spoolProgressFile)
+
+	f, err := os.CreateTemp(s.dir, spoolProgressFile+".*")
+	if err != nil {
+		return fmt.Errorf("unable to create audit spool progress: %w", err)
+	}
+	defer func() { _ = os.Remove(f.Name()) }()
+
+	_, err = f.Write(b)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to write audit spool progress: %w", err)
+	}
+	return nil
+}
+
+func (s *SpoolAudit) closeReader() {
+	if s.reader != nil {
+		_ = s.reader.Close()
+		s.reader, s.buf, s.partial = nil, nil, nil
+	}
+}
+
+// segments returns the segments in the order they were written.
+func (s *SpoolAudit) segments() ([]uint64, error) {
+	files, err := filepath.Glob(filepath.Join(s.dir, "*"+spoolSegmentSuffix))
+	if err != nil {
+		return nil, fmt.Errorf("unable to list audit spool segments: %w", err)
+	}
+
+	segments := make([]uint64, 0, len(files))
+	for _, file := range files {
+		n, err := strconv.ParseUint(strings.TrimSuffix(filepath.Base(file), spoolSegmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		segments = append(segments, n)
+	}
+	slices.Sort(segments)
+
+	return segments, nil
+}
+
+func (s *SpoolAudit) segmentPath(segment uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d%s", segment, spoolSegmentSuffix))
+}
+
+func (s *SpoolAudit) logf(level zapcore.Level, template string, args ...any) {
+	if s.logger != nil {
+		s.logger.Logf(level, template, args...)
+	}
+}
+
+// repairSegment drops an event at the end of the segment that was only
+// partially written, which has not been audited.
+func repairSegment(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("unable to read audit spool segment: %w", err)
+	}
+
+	n := bytes.LastIndexByte(b, '\n') + 1
+	if n == len(b) {
+		return nil
+	}
+	if err := os.Truncate(path, int64(n)); err != nil {
+		return fmt.Errorf("unable to truncate audit spool segment: %w", err)
+	}
+	return nil
+}
+
+// scanSegment returns the size of the segment, and the number of events
+// in it, from the given offset on, along with the time of the first
+// one.
+func scanSegment(path string, offset int64) (int64, int, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("unable to open audit spool segment: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	info, err := f.Stat()
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("unable to access audit spool segment: %w", err)
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return 0, 0, 0, fmt.Errorf("unable to seek audit spool segment: %w", err)
+	}
+
+	var (
+		events int
+		oldest int64
+	)
+
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return 0, 0, 0, fmt.Errorf("unable to read audit spool segment: %w", err)
+		}
+		if events == 0 {
+			var q QueryData
+			if json.Unmarshal(line, &q) == nil {
+				oldest = q.Timestamp
+			}
+		}
+		events++
+	}
+
+	return info.Size() - offset, events, oldest, nil
+}
+
+// rejectedError reports whether the sink rejected the event, which no
+// number of retries would change, as opposed to being unavailable, the
+// way the circuit breaker of Splunk tells the two apart.
+func rejectedError(err error) bool {
+	return !unavailableError(err) && !errors.Is(err, ErrCircuitOpen) && !errors.Is(err, context.DeadlineExceeded)
+}
+
+// wait reports whether c has been received from before the context was
+// cancelled.
+func wait[T any](ctx context.Context, c <-chan T) bool {
+	select {
+	case <-c:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("unable to open audit spool directory: %w", err)
+	}
+	defer func() { _ = d.Close() }()
+
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("unable to flush audit spool directory: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,333 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// dummySink records the events written to it, failing the given
+// number of writes first, as if unavailable, and then rejecting the
+// given number of events.
+type dummySink struct {
+	fail    int
+	reject  int
+	queries []string
+	mu      sync.Mutex
+}
+
+func (d *dummySink) Write(_ context.Context, q *QueryData) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.fail > 0 {
+		d.fail--
+		return &transientError{errors.New("test")}
+	}
+	if d.reject > 0 {
+		d.reject--
+		return errors.New("test")
+	}
+	d.queries = append(d.queries, q.Query)
+	return nil
+}
+
+func (d *dummySink) Queries() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string(nil), d.queries...)
+}
+
+func TestSpoolAudit(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		description string
+		events      int
+		segmentSize int64
+		fail        int
+	}{
+		{
+			"events sent in order",
+			3,
+			spoolSegmentSize,
+			0,
+		},
+		{
+			"events sent in order across segments",
+			10,
+			128,
+			0,
+		},
+		{
+			"events sent in order after failing to send them",
+			3,
+			spoolSegmentSize,
+			2,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+			sink := &dummySink{fail: tc.fail}
+
+			s, err := NewSpoolAudit(sink, dir, 1<<20, nil)
+			require.NoError(t, err)
+			defer func() { _ = s.Close() }()
+
+			s.segmentSize, s.retry = tc.segmentSize, time.Millisecond
+
+			var expected []string
+			for i := 0; i < tc.events; i++ {
+				q := fmt.Sprintf("select %d;", i)
+				require.NoError(t, s.Write(context.TODO(), &QueryData{Query: q, User: "test", Timestamp: time.Now().Unix()}))
+				expected = append(expected, q)
+			}
+
+			stats := s.Spool()
+			assert.Equal(t, tc.events, stats.Events)
+			assert.Positive(t, stats.Bytes)
+			assert.False(t, stats.Oldest.IsZero())
+
+			ctx, cancel := context.WithCancel(context.TODO())
+			done := make(chan struct{})
+			go func() {
+				s.Run(ctx)
+				close(done)
+			}()
+
+			require.Eventually(t, func() bool {
+				return len(sink.Queries()) == tc.events
+			}, 5*time.Second, time.Millisecond)
+
+			cancel()
+			<-done
+
+			assert.Equal(t, expected, sink.Queries())
+			assert.Equal(t, 0, s.Spool().Events)
+
+			// Only the segment being written to is left.
+			segments, err := filepath.Glob(filepath.Join(dir, "*"+spoolSegmentSuffix))
+			require.NoError(t, err)
+			assert.Len(t, segments, 1)
+		})
+	}
+}
+
+func TestSpoolAuditRejected(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	sink := &dummySink{fail: 1, reject: 1}
+
+	s, err := NewSpoolAudit(sink, dir, 1<<20, nil)
+	require.NoError(t, err)
+	defer func() { _ = s.Close() }()
+
+	s.retry = time.Millisecond
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, s.Write(context.TODO(), &QueryData{Query: fmt.Sprintf("select %d;", i), User: "test", Timestamp: time.Now().Unix()}))
+	}
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	done := make(chan struct{})
+	go func() {
+		s.Run(ctx)
+		close(done)
+	}()
+
+	// The first event is sent again once Splunk is available, only to
+	// be rejected, which does not hold back the others.
+	require.Eventually(t, func() bool {
+		return len(sink.Queries()) == 2
+	}, 5*time.Second, time.Millisecond)
+
+	cancel()
+	<-done
+
+	assert.Equal(t, []string{"select 1;", "select 2;"}, sink.Queries())
+	assert.Equal(t, 0, s.Spool().Events)
+
+	b, err := os.ReadFile(filepath.Join(dir, spoolRejectedFile))
+	require.NoError(t, err)
+	assert.Regexp(t, `^{"Query":"select 0;"[^\n]*"User":"test"[^\n]*}\n$`, string(b))
+}
+
+func TestSpoolAuditRestart(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	s, err := NewSpoolAudit(&dummySink{}, dir, 1<<20, nil)
+	require.NoError(t, err)
+	s.segmentSize = 128
+
+	for i := 0; i < 5; i++ {
+		require.NoError(t, s.Write(context.TODO(), &QueryData{Query: fmt.Sprintf("select %d;", i), User: "test", Timestamp: int64(i + 1)}))
+	}
+
+	// Send the first two events only.
+	for i := 0; i < 2; i++ {
+		q, next, err := s.next()
+		require.NoError(t, err)
+		require.NotNil(t, q)
+		require.NoError(t, s.advance(next))
+	}
+	s.closeReader()
+	require.NoError(t, s.Close())
+
+	// An event that was being written as the process stopped.
+	segments, err := s.segments()
+	require.NoError(t, err)
+	f, err := os.OpenFile(s.segmentPath(segments[len(segments)-1]), os.O_WRONLY|os.O_APPEND, 0o600)
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"Query":"select`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	sink := &dummySink{}
+
+	s, err = NewSpoolAudit(sink, dir, 1<<20, nil)
+	require.NoError(t, err)
+	defer func() { _ = s.Close() }()
+
+	stats := s.Spool()
+	assert.Equal(t, 3, stats.Events)
+	assert.Equal(t, time.Unix(3, 0), stats.Oldest)
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	done := make(chan struct{})
+	go func() {
+		s.Run(ctx)
+		close(done)
+	}()
+
+	require.Eventually(t, func() bool {
+		return len(sink.Queries()) == 3
+	}, 5*time.Second, time.Millisecond)
+
+	cancel()
+	<-done
+
+	assert.Equal(t, []string{"select 2;", "select 3;", "select 4;"}, sink.Queries())
+	assert.Equal(t, 0, s.Spool().Events)
+}
+
+func TestSpoolAuditFull(t *testing.T) {
+	t.Parallel()
+
+	s, err := NewSpoolAudit(&dummySink{}, t.TempDir(), 256, nil)
+	require.NoError(t, err)
+	defer func() { _ = s.Close() }()
+
+	q := &QueryData{Query: "select 1;", User: "test", Timestamp: time.Now().Unix()}
+
+	require.NoError(t, s.Write(context.TODO(), q))
+
+	err = s.Write(context.TODO(), q)
+	require.ErrorIs(t, err, ErrSpoolFull)
+	assert.Equal(t, 1, s.Spool().Events)
+}
+
+func TestSpoolAuditFullDrained(t *testing.T) {
+	t.Parallel()
+
+	sink := &dummySink{}
+
+	s, err := NewSpoolAudit(sink, t.TempDir(), 512, nil)
+	require.NoError(t, err)
+	defer func() { _ = s.Close() }()
+
+	s.retry = time.Millisecond
+
+	// Run is stopped before the spool directory is removed.
+	ctx, cancel := context.WithCancel(context.TODO())
+	var done chan struct{}
+	defer func() {
+		cancel()
+		if done != nil {
+			<-done
+		}
+	}()
+
+	// Events can be written again once those filling the spool have
+	// been sent, even though the segment is smaller than usual.
+	var sent int
+	for round := 0; round < 3; round++ {
+		var written int
+		for {
+			q := &QueryData{Query: fmt.Sprintf("select %d;", sent+written), User: "test", Timestamp: time.Now().Unix()}
+			if err := s.Write(context.TODO(), q); err != nil {
+				require.ErrorIs(t, err, ErrSpoolFull)
+				break
+			}
+			written++
+		}
+		require.Positive(t, written)
+
+		if round == 0 {
+			done = make(chan struct{})
+			go func() {
+				s.Run(ctx)
+				close(done)
+			}()
+		}
+
+		sent += written
+		require.Eventually(t, func() bool {
+			return len(sink.Queries()) == sent
+		}, 5*time.Second, time.Millisecond)
+		require.Eventually(t, func() bool {
+			return s.Spool().Events == 0
+		}, 5*time.Second, time.Millisecond)
+
+		assert.Zero(t, s.Spool().Bytes)
+	}
+}
+
+func TestSpoolAuditCircuit(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		description string
+		sink        Audit
+		want        CircuitState
+	}{
+		{
+			"sink with circuit breaker open",
+			&SplunkAudit{breaker: circuitBreaker{state: CircuitOpen}},
+			CircuitOpen,
+		},
+		{
+			"sink without circuit breaker",
+			&dummySink{},
+			CircuitClosed,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			s, err := NewSpoolAudit(tc.sink, t.TempDir(), 1<<20, nil)
+			require.NoError(t, err)
+			defer func() { _ = s.Close() }()
+
+			assert.Equal(t, tc.want, s.Circuit())
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package cache
+
+import (
+	"container/list"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/app-sre/gabi/pkg/audit"
+)
+
+// Entry is a response kept in the cache, along with what was recorded
+// about the result it carries, so that it can be audited again.
+type Entry struct {
+	Header http.Header
+	Body   []byte
+	Result audit.ResultData
+
+	key     string
+	size    int64
+	expires time.Time
+}
+
+// Store keeps responses in memory for a short time, up to a total size,
+// evicting those used least recently first to make room.
+type Store struct {
+	ttl     time.Duration
+	max     int64
+	size    int64
+	entries map[string]*list.Element
+	order   *list.List
+	now     func() time.Time
+	mu      sync.Mutex
+}
+
+// NewStore returns a store for responses that expire after ttl, and
+// whose total size is at most max bytes.
+func NewStore(ttl time.Duration, max int64) *Store {
+	return &Store{
+		ttl:     ttl,
+		max:     max,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+		now:     time.Now,
+	}
+}
+
+// Len returns the number of responses kept, including those that have
+// expired but have not been evicted yet.
+func (s *Store) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.entries)
+}
+
+// Size returns the total size of the responses kept.
+func (s *Store) Size() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size
+}
+
+// Max returns the largest size a response can have to be kept.
+func (s *Store) Max() int64 {
+	return s.max
+}
+
+// Get returns the response kept under the given key, unless it has
+// expired. The entry must not be modified.
+func (s *Store) Get(key string) (*Entry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	elem, ok := s.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	e := elem.Value.(*Entry)
+	if !s.now().Before(e.expires) {
+		s.remove(elem)
+		return nil, false
+	}
+	s.order.MoveToFront(elem)
+
+	return e, true
+}
+
+// Set keeps the response under the given key, replacing any kept
+// already, and reports whether it was small enough to be kept.
+func (s *Store) Set(key string, e *Entry) bool {
+	size := entrySize(key, e)
+	if size > s.max {
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if elem, ok := s.entries[key]; ok {
+		s.remove(elem)
+	}
+
+	now := s.now()
+
+	// Expired responses go first, then those used least recently.
+	for elem := s.order.Back(); elem != nil; {
+		prev := elem.Prev()
+		if !now.Before(elem.Value.(*Entry).expires) {
+			s.remove(elem)
+		}
+		elem = prev
+	}
+	for s.size+size > s.max {
+		s.remove(s.order.Back())
+	}
+
+	e.key, e.size, e.expires = key, size, now.Add(s.ttl)
+	s.entries[key] = s.order.PushFront(e)
+	s.size += size
+
+	return true
+}
+
+func (s *Store) remove(elem *list.Element) {
+	e := s.order.Remove(elem).(*Entry)
+	delete(s.entries, e.key)
+	s.size -= e.size
+}
+
+// The size of a response is approximated by the size of its content.
+func entrySize(key string, e *Entry) int64 {
+	size := int64(len(key) + len(e.Body))
+	for name, values := range e.Header {
+		size += int64(len(name))
+		for _, value := range values {
+			size += int64(len(value))
+		}
+	}
+	return size
+}
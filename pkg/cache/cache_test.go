@@ -0,0 +1,181 @@
+package cache
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/app-sre/gabi/pkg/audit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewStore(t *testing.T) {
+	t.Parallel()
+
+	actual := NewStore(time.Minute, 1024)
+
+	require.NotNil(t, actual)
+	assert.IsType(t, &Store{}, actual)
+	assert.Equal(t, 0, actual.Len())
+	assert.Equal(t, int64(0), actual.Size())
+	assert.Equal(t, int64(1024), actual.Max())
+}
+
+func TestGet(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		description string
+		key         string
+		elapsed     time.Duration
+		found       bool
+	}{
+		{
+			"response found",
+			"test",
+			0,
+			true,
+		},
+		{
+			"response not found with unknown key",
+			"unknown",
+			0,
+			false,
+		},
+		{
+			"response not found once expired",
+			"test",
+			time.Minute,
+			false,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+			s := NewStore(time.Minute, 1024)
+			s.now = func() time.Time { return now }
+
+			given := &Entry{
+				Header: http.Header{"Content-Type": {"text/csv"}},
+				Body:   []byte("test"),
+				Result: audit.ResultData{Rows: 1},
+			}
+			require.True(t, s.Set("test", given))
+
+			now = now.Add(tc.elapsed)
+
+			actual, ok := s.Get(tc.key)
+
+			assert.Equal(t, tc.found, ok)
+			if !tc.found {
+				assert.Nil(t, actual)
+				return
+			}
+			assert.Equal(t, given.Body, actual.Body)
+			assert.Equal(t, given.Header, actual.Header)
+			assert.Equal(t, given.Result, actual.Result)
+		})
+	}
+}
+
+func TestSet(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		description string
+		given       []string
+		get         []string
+		want        []string
+		kept        bool
+	}{
+		{
+			"responses kept within size",
+			[]string{"a", "b"},
+			nil,
+			[]string{"a", "b"},
+			true,
+		},
+		{
+			"response used least recently evicted",
+			[]string{"a", "b", "c"},
+			nil,
+			[]string{"b", "c"},
+			true,
+		},
+		{
+			"response used recently not evicted",
+			[]string{"a", "b", "c"},
+			[]string{"a"},
+			[]string{"a", "c"},
+			true,
+		},
+		{
+			"response replaced",
+			[]string{"a", "a"},
+			nil,
+			[]string{"a"},
+			true,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			// Room for two responses, each being 10 bytes along with its key.
+			s := NewStore(time.Minute, 20)
+
+			for i, key := range tc.given {
+				// Getting happens before the last response is kept.
+				if i == len(tc.given)-1 {
+					for _, key := range tc.get {
+						_, _ = s.Get(key)
+					}
+				}
+				assert.Equal(t, tc.kept, s.Set(key, &Entry{Body: []byte(strings.Repeat(key, 9))}))
+			}
+
+			assert.Equal(t, len(tc.want), s.Len())
+			assert.Equal(t, int64(len(tc.want)*10), s.Size())
+			for _, key := range tc.want {
+				_, ok := s.Get(key)
+				assert.True(t, ok, key)
+			}
+		})
+	}
+}
+
+func TestSetTooLarge(t *testing.T) {
+	t.Parallel()
+
+	s := NewStore(time.Minute, 10)
+
+	assert.False(t, s.Set("test", &Entry{Body: []byte("test123")}))
+	assert.Equal(t, 0, s.Len())
+	assert.Equal(t, int64(0), s.Size())
+}
+
+func TestSetExpired(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	s := NewStore(time.Minute, 1024)
+	s.now = func() time.Time { return now }
+
+	require.True(t, s.Set("a", &Entry{Body: []byte("test")}))
+	now = now.Add(time.Minute)
+	require.True(t, s.Set("b", &Entry{Body: []byte("test")}))
+
+	// The expired response is evicted to make room.
+	assert.Equal(t, 1, s.Len())
+	assert.Equal(t, int64(5), s.Size())
+}
@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"context"
 	"database/sql"
 	"encoding/base64"
 	"fmt"
@@ -17,11 +18,17 @@ import (
 
 	gabi "github.com/app-sre/gabi/pkg"
 	"github.com/app-sre/gabi/pkg/audit"
+	"github.com/app-sre/gabi/pkg/cache"
+	"github.com/app-sre/gabi/pkg/cursor"
+	auditenv "github.com/app-sre/gabi/pkg/env/audit"
 	"github.com/app-sre/gabi/pkg/env/db"
+	"github.com/app-sre/gabi/pkg/env/query"
 	"github.com/app-sre/gabi/pkg/env/splunk"
 	"github.com/app-sre/gabi/pkg/env/user"
 	"github.com/app-sre/gabi/pkg/handlers"
+	"github.com/app-sre/gabi/pkg/job"
 	"github.com/app-sre/gabi/pkg/middleware"
+	"github.com/app-sre/gabi/pkg/running"
 	"github.com/app-sre/gabi/pkg/version"
 )
 
@@ -38,6 +45,7 @@ func Run(logger *zap.SugaredLogger) error {
 	date := usere.Expiration.Format(user.ExpiryDateLayout)
 	logger.Infof("Production: %t, expired: %t (expiration date: %s)", gabi.Production(), expiry, date)
 	logger.Debugf("Authorized users: %v", usere.Users)
+	logger.Debugf("Authorized admins: %v", usere.Admins)
 
 	dbe := db.NewDBEnv()
 	err = dbe.Populate()
@@ -52,21 +60,101 @@ func Run(logger *zap.SugaredLogger) error {
 	}
 	logger.Debugf("Connected to database host: %s (port: %d)", dbe.Host, dbe.Port)
 
-	se := splunk.NewSplunkEnv()
-	err = se.Populate()
+	qe := query.NewQueryEnv()
+	err = qe.Populate()
 	if err != nil {
-		return fmt.Errorf("unable to configure Splunk: %w", err)
+		return fmt.Errorf("unable to configure query limits: %w", err)
 	}
-	logger.Infof("Sending audit to Splunk endpoint: %s", se.Endpoint)
+	logger.Infof("Query limits: %d rows, %d bytes (0 means unlimited)", qe.MaxRows, qe.MaxBytes)
+	logger.Infof("Request limits: %d bytes, query of %d bytes", qe.MaxRequestBytes, qe.MaxQueryLength)
+	logger.Infof("Cursors: idle timeout %s, limit %d", qe.CursorTTL, qe.MaxCursors)
+
+	jobs, err := job.NewStore(qe.JobSpoolDir, qe.JobTimeout, qe.JobTTL, qe.MaxJobs, qe.MaxQueued)
+	if err != nil {
+		return fmt.Errorf("unable to configure jobs: %w", err)
+	}
+	logger.Infof("Jobs: timeout %s, retention %s, limit %d running and %d queued per user, results of %d bytes", qe.JobTimeout, qe.JobTTL, qe.MaxJobs, qe.MaxQueued, qe.MaxJobBytes)
+	logger.Infof("Compression: gzip and zstd for responses of at least %d bytes", qe.CompressionMinSize)
+
+	// Results can only be cached when they cannot change as a result
+	// of running queries through this instance.
+	var results *cache.Store
+	switch {
+	case qe.CacheTTL > 0 && dbe.AllowWrite:
+		logger.Infof("Result cache: disabled, as write access is allowed")
+	case qe.CacheTTL > 0:
+		results = cache.NewStore(qe.CacheTTL, qe.CacheMaxBytes)
+		logger.Infof("Result cache: retention %s, limit %d bytes", qe.CacheTTL, qe.CacheMaxBytes)
+	}
+
+	ae := auditenv.NewAuditEnv()
+	err = ae.Populate()
+	if err != nil {
+		return fmt.Errorf("unable to configure audit: %w", err)
+	}
+
+	sinks := make([]audit.Sink, 0, len(ae.Sinks))
+	for _, sink := range ae.Sinks {
+		var a audit.Audit
+
+		switch sink.Type {
+		case auditenv.SinkConsole:
+			a = audit.NewLoggerAudit(logger)
+		case auditenv.SinkFile:
+			file, err := audit.NewFileAudit(ae.File)
+			if err != nil {
+				return fmt.Errorf("unable to configure audit file: %w", err)
+			}
+			defer func() { _ = file.Close() }()
+
+			logger.Infof("Writing audit to file: %s", ae.File)
+			a = file
+		case auditenv.SinkSplunk:
+			se := splunk.NewSplunkEnv()
+			err = se.Populate()
+			if err != nil {
+				return fmt.Errorf("unable to configure Splunk: %w", err)
+			}
+			logger.Infof("Sending audit to Splunk endpoint: %s", se.Endpoint)
+			logger.Infof("Splunk retries: %d (backoff %s, timeout %s), circuit breaker: %d failures (cooldown %s)",
+				se.Retries, se.RetryBackoff, se.RetryTimeout, se.BreakerThreshold, se.BreakerCooldown)
+			logger.Infof("Splunk indexer acknowledgment: %s (timeout %s, checked every %s)", se.Ack, se.AckTimeout, se.AckInterval)
+
+			a = audit.NewSplunkAudit(se, audit.WithLogger(logger))
+
+			// Spooling lets queries proceed while Splunk is unavailable,
+			// as events are sent once it is available again.
+			if se.SpoolDir != "" {
+				spool, err := audit.NewSpoolAudit(a, se.SpoolDir, se.SpoolMaxBytes, logger)
+				if err != nil {
+					return fmt.Errorf("unable to configure audit spool: %w", err)
+				}
+				defer func() { _ = spool.Close() }()
+				go spool.Run(context.Background())
+
+				stats := spool.Spool()
+				logger.Infof("Audit spool: %s (limit %d bytes), %d events pending", se.SpoolDir, se.SpoolMaxBytes, stats.Events)
+				a = spool
+			}
+		}
+
+		sinks = append(sinks, audit.Sink{Name: string(sink.Type), Audit: a, Required: sink.Required})
+		logger.Infof("Audit sink: %s (required: %t)", sink.Type, sink.Required)
+	}
+	logger.Infof("Audit timeout: %s", ae.Timeout)
 
 	cfg := &gabi.Config{
-		DB:          db,
-		DBEnv:       dbe,
-		UserEnv:     usere,
-		LoggerAudit: audit.NewLoggerAudit(logger),
-		SplunkAudit: audit.NewSplunkAudit(se),
-		Logger:      logger,
-		Encoder:     base64.StdEncoding,
+		DB:       db,
+		DBEnv:    dbe,
+		QueryEnv: qe,
+		UserEnv:  usere,
+		Audit:    audit.NewPipeline(sinks, ae.Timeout, logger),
+		Cursors:  cursor.NewStore(qe.CursorTTL, qe.MaxCursors),
+		Queries:  running.NewRegistry(),
+		Jobs:     jobs,
+		Cache:    results,
+		Logger:   logger,
+		Encoder:  base64.StdEncoding,
 	}
 	defer cfg.DB.Close()
 	timeout := gabi.RequestTimeout()
@@ -84,14 +172,68 @@ func Run(logger *zap.SugaredLogger) error {
 		alice.Constructor(middleware.Recovery(cfg)),
 		alice.Constructor(middleware.Authorization(cfg)),
 		alice.Constructor(middleware.Expiration(cfg)),
+		alice.Constructor(middleware.Validation(cfg)),
 		alice.Constructor(middleware.Audit(cfg)),
+		alice.Constructor(middleware.Compression(qe.CompressionMinSize)),
 		alice.Constructor(middleware.Timeout(timeout)),
 	)
 	queryHandler := queryChain.Then(handlers.Query(cfg))
 
+	batchChain := alice.New(
+		alice.Constructor(middleware.Recovery(cfg)),
+		alice.Constructor(middleware.Authorization(cfg)),
+		alice.Constructor(middleware.Expiration(cfg)),
+		alice.Constructor(middleware.ValidationBatch(cfg)),
+		alice.Constructor(middleware.AuditBatch(cfg)),
+		alice.Constructor(middleware.Compression(qe.CompressionMinSize)),
+		alice.Constructor(middleware.Timeout(timeout)),
+	)
+	batchHandler := batchChain.Then(handlers.Batch(cfg))
+
+	explainChain := alice.New(
+		alice.Constructor(middleware.Recovery(cfg)),
+		alice.Constructor(middleware.Authorization(cfg)),
+		alice.Constructor(middleware.Expiration(cfg)),
+		alice.Constructor(middleware.ValidationExplain(cfg)),
+		alice.Constructor(middleware.AuditExplain(cfg)),
+		alice.Constructor(middleware.Compression(qe.CompressionMinSize)),
+		alice.Constructor(middleware.Timeout(timeout)),
+	)
+	explainHandler := explainChain.Then(handlers.Explain(cfg))
+
+	cancelChain := alice.New(
+		alice.Constructor(middleware.Recovery(cfg)),
+		alice.Constructor(middleware.Authorization(cfg)),
+		alice.Constructor(middleware.Expiration(cfg)),
+		alice.Constructor(middleware.AuditCancel(cfg)),
+		alice.Constructor(middleware.Timeout(timeout)),
+	)
+	cancelHandler := cancelChain.Then(handlers.Cancel(cfg))
+
+	// Submitting a job is audited like running the query.
+	jobHandler := queryChain.Then(handlers.SubmitJob(cfg))
+
+	statusChain := alice.New(
+		alice.Constructor(middleware.Recovery(cfg)),
+		alice.Constructor(middleware.Authorization(cfg)),
+		alice.Constructor(middleware.Expiration(cfg)),
+	)
+	activeHandler := statusChain.Then(handlers.ActiveQueries(cfg))
+	jobStatusHandler := statusChain.Then(handlers.GetJob(cfg))
+	jobResultHandler := statusChain.Append(
+		alice.Constructor(middleware.Compression(qe.CompressionMinSize)),
+	).Then(handlers.GetJobResult(cfg))
+
 	r := mux.NewRouter()
 	r.Handle("/healthcheck", logHandler(healthLogOutput, handlers.Healthcheck(cfg))).Methods("GET")
 	r.Handle("/query", logHandler(defaultLogOutput, queryHandler)).Methods("POST")
+	r.Handle("/query/batch", logHandler(defaultLogOutput, batchHandler)).Methods("POST")
+	r.Handle("/query/{id}", logHandler(defaultLogOutput, cancelHandler)).Methods("DELETE")
+	r.Handle("/queries/active", logHandler(defaultLogOutput, activeHandler)).Methods("GET")
+	r.Handle("/jobs", logHandler(defaultLogOutput, jobHandler)).Methods("POST")
+	r.Handle("/jobs/{id}", logHandler(defaultLogOutput, jobStatusHandler)).Methods("GET")
+	r.Handle("/jobs/{id}/result", logHandler(defaultLogOutput, jobResultHandler)).Methods("GET")
+	r.Handle("/explain", logHandler(defaultLogOutput, explainHandler)).Methods("POST")
 	r.Handle("/dbname", logHandler(defaultLogOutput, handlers.GetCurrentDBName(cfg))).Methods("GET")
 	r.Handle("/dbname/switch", logHandler(defaultLogOutput, handlers.SwitchDBName(cfg))).Methods("POST")
 
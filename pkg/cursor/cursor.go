@@ -0,0 +1,333 @@
+package cursor
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/app-sre/gabi/internal/random"
+)
+
+// A cursor declared in the database is the only one on its connection.
+// Being able to scroll it allows moving back over the rows that have
+// been fetched, but have not been sent.
+const (
+	cursorName    = "gabi_cursor"
+	cursorDeclare = "DECLARE " + cursorName + " SCROLL CURSOR FOR "
+	cursorFetch   = "FETCH FORWARD %d FROM " + cursorName
+	cursorMove    = "MOVE BACKWARD %d FROM " + cursorName
+)
+
+var (
+	ErrNotFound     = errors.New("cursor not found")
+	ErrLimitReached = errors.New("cursor limit reached")
+	ErrBusy         = errors.New("cursor is in use")
+)
+
+// Cursor holds the rows of a query open across requests, using its own
+// connection and transaction, so that the result can be fetched a page
+// at a time. A cursor is held by a single request at a time.
+type Cursor struct {
+	ID      string
+	User    string
+	Rows    *sql.Rows
+	Columns []*sql.ColumnType
+
+	// Pending is set when the current row has been read from the
+	// database, but has not been sent to the client yet.
+	Pending bool
+
+	// PageSize is the page size the previous page was read with.
+	PageSize int64
+
+	declared bool
+
+	store  *Store
+	conn   *sql.Conn
+	tx     *sql.Tx
+	cancel context.CancelFunc
+	stop   func() bool
+	timer  *time.Timer
+	closed bool
+	mu     sync.Mutex
+}
+
+type Store struct {
+	ttl     time.Duration
+	max     int
+	cursors map[string]*Cursor
+	mu      sync.Mutex
+}
+
+// NewStore returns a store for cursors that expire once idle for longer
+// than ttl. A max of zero means there is no limit on open cursors.
+func NewStore(ttl time.Duration, max int) *Store {
+	return &Store{
+		ttl:     ttl,
+		max:     max,
+		cursors: make(map[string]*Cursor),
+	}
+}
+
+// Len returns the number of open cursors.
+func (s *Store) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.cursors)
+}
+
+// Open runs the query with the given arguments in a read-only
+// transaction on a dedicated connection and returns a cursor over its
+// rows, held by the caller until released. The setup statement, when
+// set, is run first to configure the session. Should the context be
+// cancelled while the cursor is held, the cursor is closed.
+func (s *Store) Open(ctx context.Context, db *sql.DB, user, setup, query string, args ...any) (*Cursor, error) {
+	return s.open(ctx, user, func(c *Cursor, cursorCtx context.Context) error {
+		return c.query(ctx, cursorCtx, db, setup, query, args)
+	})
+}
+
+// Declare is like Open, except that the query is declared as a cursor
+// in the database, whose rows are only fetched a page at a time, which
+// is only supported by PostgreSQL.
+func (s *Store) Declare(ctx context.Context, db *sql.DB, user, query string, args ...any) (*Cursor, error) {
+	return s.open(ctx, user, func(c *Cursor, cursorCtx context.Context) error {
+		return c.declare(ctx, cursorCtx, db, query, args)
+	})
+}
+
+func (s *Store) open(ctx context.Context, user string, open func(*Cursor, context.Context) error) (*Cursor, error) {
+	id, err := random.ID()
+	if err != nil {
+		return nil, fmt.Errorf("unable to generate cursor ID: %w", err)
+	}
+
+	c := &Cursor{ID: id, User: user, store: s}
+	c.mu.Lock()
+
+	s.mu.Lock()
+	if s.max > 0 && len(s.cursors) >= s.max {
+		s.mu.Unlock()
+		return nil, ErrLimitReached
+	}
+	s.cursors[id] = c
+	s.mu.Unlock()
+
+	// The transaction and its rows have to outlive the request.
+	cursorCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	c.cancel = cancel
+	c.stop = context.AfterFunc(ctx, cancel)
+
+	if err := open(c, cursorCtx); err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Acquire returns the cursor with the given ID, provided it has been
+// opened by the same user, and holds it until released.
+func (s *Store) Acquire(ctx context.Context, id, user string) (*Cursor, error) {
+	s.mu.Lock()
+	c, ok := s.cursors[id]
+	s.mu.Unlock()
+
+	// Do not disclose cursors that belong to other users.
+	if !ok || c.User != user {
+		return nil, ErrNotFound
+	}
+	if !c.mu.TryLock() {
+		return nil, ErrBusy
+	}
+	if c.closed {
+		c.mu.Unlock()
+		return nil, ErrNotFound
+	}
+
+	c.timer.Stop()
+	c.stop = context.AfterFunc(ctx, c.cancel)
+
+	return c, nil
+}
+
+// Release makes the cursor available for the next page, and starts
+// the idle timer after which it expires.
+func (c *Cursor) Release() {
+	defer c.mu.Unlock()
+
+	if !c.stop() {
+		// The context the cursor was held with has been cancelled.
+		c.close()
+		return
+	}
+	if c.timer == nil {
+		c.timer = time.AfterFunc(c.store.ttl, c.expire)
+		return
+	}
+	c.timer.Reset(c.store.ttl)
+}
+
+// Fetch returns the rows of the next page, which is made of at most n
+// rows, along with the row that tells whether there are more. The rows
+// of a declared cursor are fetched from the database for every page,
+// once the setup statement, if any, has been run, so that each page is
+// bounded by the request fetching it. Otherwise, the rows of the query
+// are carried on with.
+func (c *Cursor) Fetch(ctx context.Context, setup string, n int64) (*sql.Rows, error) {
+	if !c.declared {
+		return c.Rows, nil
+	}
+
+	if setup != "" {
+		if _, err := c.tx.ExecContext(ctx, setup); err != nil {
+			return nil, err
+		}
+	}
+
+	rows, err := c.tx.QueryContext(ctx, fmt.Sprintf(cursorFetch, n+1))
+	if err != nil {
+		return nil, err
+	}
+	c.Rows = rows
+
+	if c.Columns == nil {
+		columns, err := rows.ColumnTypes()
+		if err != nil {
+			return nil, fmt.Errorf("unable to process database columns: %w", err)
+		}
+		c.Columns = columns
+	}
+
+	return rows, nil
+}
+
+// Keep leaves the rows of the page that have not been sent, starting
+// with the current row when pending, to the next page.
+func (c *Cursor) Keep(ctx context.Context, pending bool) error {
+	if !c.declared {
+		c.Pending = pending
+		return nil
+	}
+
+	var unsent int64
+	if pending {
+		unsent++
+	}
+	for c.Rows.Next() {
+		unsent++
+	}
+	if err := c.Rows.Err(); err != nil {
+		return err
+	}
+	if err := c.Rows.Close(); err != nil {
+		return err
+	}
+	c.Rows = nil
+
+	if unsent == 0 {
+		return nil
+	}
+	_, err := c.tx.ExecContext(ctx, fmt.Sprintf(cursorMove, unsent))
+	return err
+}
+
+// Close ends the transaction and returns the connection to the pool.
+// It must only be called while the cursor is held.
+func (c *Cursor) Close() {
+	defer c.mu.Unlock()
+
+	c.stop()
+	c.close()
+}
+
+func (c *Cursor) begin(ctx, cursorCtx context.Context, db *sql.DB) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	c.conn = conn
+
+	tx, err := conn.BeginTx(cursorCtx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	c.tx = tx
+
+	return nil
+}
+
+func (c *Cursor) query(ctx, cursorCtx context.Context, db *sql.DB, setup, query string, args []any) error {
+	if err := c.begin(ctx, cursorCtx, db); err != nil {
+		return err
+	}
+	tx := c.tx
+
+	if setup != "" {
+		if _, err := tx.ExecContext(cursorCtx, setup); err != nil {
+			return err
+		}
+	}
+
+	rows, err := tx.QueryContext(cursorCtx, query, args...)
+	if err != nil {
+		return err
+	}
+	c.Rows = rows
+
+	columns, err := rows.ColumnTypes()
+	if err != nil {
+		return fmt.Errorf("unable to process database columns: %w", err)
+	}
+	c.Columns = columns
+
+	return nil
+}
+
+func (c *Cursor) declare(ctx, cursorCtx context.Context, db *sql.DB, query string, args []any) error {
+	if err := c.begin(ctx, cursorCtx, db); err != nil {
+		return err
+	}
+	c.declared = true
+
+	_, err := c.tx.ExecContext(cursorCtx, cursorDeclare+query, args...)
+	return err
+}
+
+func (c *Cursor) expire() {
+	if !c.mu.TryLock() {
+		// Being used, and the timer will be restarted once released.
+		return
+	}
+	defer c.mu.Unlock()
+
+	c.close()
+}
+
+func (c *Cursor) close() {
+	if c.closed {
+		return
+	}
+	c.closed = true
+
+	c.store.mu.Lock()
+	delete(c.store.cursors, c.ID)
+	c.store.mu.Unlock()
+
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+	if c.Rows != nil {
+		_ = c.Rows.Close()
+	}
+	if c.tx != nil {
+		_ = c.tx.Rollback()
+	}
+	c.cancel()
+	if c.conn != nil {
+		_ = c.conn.Close()
+	}
+}
@@ -0,0 +1,230 @@
+package cursor
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewStore(t *testing.T) {
+	t.Parallel()
+
+	actual := NewStore(time.Minute, 1)
+
+	require.NotNil(t, actual)
+	assert.IsType(t, &Store{}, actual)
+	assert.Equal(t, 0, actual.Len())
+}
+
+func TestOpen(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		description string
+		mock        func(sqlmock.Sqlmock)
+		max         int
+		open        int
+		error       error
+	}{
+		{
+			"cursor opened",
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(`select 1;`).WillReturnRows(sqlmock.NewRows([]string{"?column?"}).AddRow("1"))
+				mock.ExpectRollback()
+			},
+			1,
+			0,
+			nil,
+		},
+		{
+			"cursor not opened due to database error",
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(`select 1;`).WillReturnError(errors.New("test"))
+				mock.ExpectRollback()
+			},
+			1,
+			0,
+			errors.New("test"),
+		},
+		{
+			"cursor not opened due to cursor limit",
+			func(mock sqlmock.Sqlmock) {
+				// No-op.
+			},
+			1,
+			1,
+			ErrLimitReached,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			db, mock, _ := sqlmock.New()
+			defer func() { _ = db.Close() }()
+
+			tc.mock(mock)
+
+			s := NewStore(time.Minute, tc.max)
+			for i := 0; i < tc.open; i++ {
+				s.cursors[string(rune(i))] = &Cursor{}
+			}
+
+			c, err := s.Open(context.TODO(), db, "test", "", "select 1;")
+
+			if tc.error != nil {
+				require.Error(t, err)
+				assert.Equal(t, tc.error.Error(), err.Error())
+				assert.Equal(t, tc.open, s.Len())
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, "test", c.User)
+				assert.Len(t, c.ID, 32)
+				assert.Len(t, c.Columns, 1)
+				assert.Equal(t, 1, s.Len())
+
+				c.Close()
+				assert.Equal(t, 0, s.Len())
+			}
+
+			require.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestDeclare(t *testing.T) {
+	t.Parallel()
+
+	db, mock, _ := sqlmock.New()
+	defer func() { _ = db.Close() }()
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`DECLARE gabi_cursor SCROLL CURSOR FOR select id from test;`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`SET LOCAL statement_timeout = 1000`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`FETCH FORWARD 3 FROM gabi_cursor`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("1").AddRow("2").AddRow("3"))
+	mock.ExpectExec(`MOVE BACKWARD 2 FROM gabi_cursor`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`FETCH FORWARD 3 FROM gabi_cursor`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("2").AddRow("3"))
+	mock.ExpectRollback()
+
+	s := NewStore(time.Minute, 0)
+
+	c, err := s.Declare(context.TODO(), db, "test", "select id from test;")
+	require.NoError(t, err)
+	assert.Nil(t, c.Rows)
+
+	rows, err := c.Fetch(context.TODO(), "SET LOCAL statement_timeout = 1000", 2)
+	require.NoError(t, err)
+	assert.Len(t, c.Columns, 1)
+	require.True(t, rows.Next())
+	require.True(t, rows.Next())
+
+	// The current row is pending, and the one after it was not read.
+	require.NoError(t, c.Keep(context.TODO(), true))
+	assert.False(t, c.Pending)
+	c.Release()
+
+	c, err = s.Acquire(context.TODO(), c.ID, "test")
+	require.NoError(t, err)
+
+	rows, err = c.Fetch(context.TODO(), "", 2)
+	require.NoError(t, err)
+	require.True(t, rows.Next())
+
+	c.Close()
+
+	assert.Equal(t, 0, s.Len())
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAcquire(t *testing.T) {
+	t.Parallel()
+
+	db, mock, _ := sqlmock.New()
+	defer func() { _ = db.Close() }()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`select 1;`).WillReturnRows(sqlmock.NewRows([]string{"?column?"}).AddRow("1"))
+	mock.ExpectRollback()
+
+	s := NewStore(time.Minute, 0)
+
+	c, err := s.Open(context.TODO(), db, "test", "", "select 1;")
+	require.NoError(t, err)
+
+	_, err = s.Acquire(context.TODO(), c.ID, "test")
+	assert.ErrorIs(t, err, ErrBusy)
+
+	c.Release()
+
+	_, err = s.Acquire(context.TODO(), c.ID, "other")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	_, err = s.Acquire(context.TODO(), "unknown", "test")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	actual, err := s.Acquire(context.TODO(), c.ID, "test")
+	require.NoError(t, err)
+	assert.Same(t, c, actual)
+
+	actual.Close()
+
+	_, err = s.Acquire(context.TODO(), c.ID, "test")
+	assert.ErrorIs(t, err, ErrNotFound)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestExpire(t *testing.T) {
+	t.Parallel()
+
+	db, mock, _ := sqlmock.New()
+	defer func() { _ = db.Close() }()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`select 1;`).WillReturnRows(sqlmock.NewRows([]string{"?column?"}).AddRow("1"))
+	mock.ExpectRollback()
+
+	s := NewStore(10*time.Millisecond, 0)
+
+	c, err := s.Open(context.TODO(), db, "test", "", "select 1;")
+	require.NoError(t, err)
+	c.Release()
+
+	assert.Eventually(t, func() bool {
+		return s.Len() == 0
+	}, time.Second, 5*time.Millisecond)
+
+	_, err = s.Acquire(context.TODO(), c.ID, "test")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestReleaseWithCancelledContext(t *testing.T) {
+	t.Parallel()
+
+	db, mock, _ := sqlmock.New()
+	defer func() { _ = db.Close() }()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`select 1;`).WillReturnRows(sqlmock.NewRows([]string{"?column?"}).AddRow("1"))
+	mock.ExpectRollback()
+
+	s := NewStore(time.Minute, 0)
+
+	ctx, cancel := context.WithCancel(context.TODO())
+
+	c, err := s.Open(ctx, db, "test", "", "select 1;")
+	require.NoError(t, err)
+
+	cancel()
+	c.Release()
+
+	assert.Equal(t, 0, s.Len())
+}
@@ -0,0 +1,114 @@
+package audit
+
+import (
+	"os"
+	"strings"
+	"time"
+
+	"github.com/app-sre/gabi/pkg/env"
+)
+
+const (
+	// Sinks audit events are written to unless set otherwise.
+	DefaultSinks = "console,splunk:required"
+
+	DefaultTimeout = 30 * time.Second
+)
+
+// SinkType is where audit events are written to.
+type SinkType string
+
+const (
+	// Events are logged.
+	SinkConsole SinkType = "console"
+	// Events are appended to a file, one JSON object per line.
+	SinkFile SinkType = "file"
+	// Events are sent to Splunk.
+	SinkSplunk SinkType = "splunk"
+)
+
+// requiredSuffix marks a sink that events have to be written to for
+// the request being audited to proceed.
+const requiredSuffix = ":required"
+
+type Sink struct {
+	Type     SinkType
+	Required bool
+}
+
+type Env struct {
+	// Sinks are where audit events are written to, at once and within
+	// Timeout. At least one of them has to be required.
+	Sinks   []Sink
+	Timeout time.Duration
+
+	// File is where the file sink appends events to.
+	File string
+}
+
+func NewAuditEnv() *Env {
+	return &Env{}
+}
+
+func (a *Env) Populate() error {
+	sinks := os.Getenv("AUDIT_SINKS")
+	if sinks == "" {
+		sinks = DefaultSinks
+	}
+
+	a.Sinks = nil
+	required := false
+	for _, entry := range strings.Split(sinks, ",") {
+		s := strings.TrimSpace(entry)
+		if s == "" {
+			continue
+		}
+
+		var sink Sink
+		if t, ok := strings.CutSuffix(s, requiredSuffix); ok {
+			sink.Required, s = true, t
+		}
+
+		switch SinkType(s) {
+		case SinkConsole, SinkFile, SinkSplunk:
+			sink.Type = SinkType(s)
+		default:
+			return &env.TypeError{Name: "AUDIT_SINKS"}
+		}
+		if a.Has(sink.Type) {
+			return &env.TypeError{Name: "AUDIT_SINKS"}
+		}
+
+		a.Sinks = append(a.Sinks, sink)
+		required = required || sink.Required
+	}
+	if !required {
+		return &env.TypeError{Name: "AUDIT_SINKS"}
+	}
+
+	a.Timeout = DefaultTimeout
+	if timeout := os.Getenv("AUDIT_TIMEOUT"); timeout != "" {
+		d, err := time.ParseDuration(timeout)
+		if err != nil || d <= 0 {
+			return &env.TypeError{Name: "AUDIT_TIMEOUT"}
+		}
+		a.Timeout = d
+	}
+
+	a.File = os.Getenv("AUDIT_FILE")
+	if a.Has(SinkFile) && a.File == "" {
+		return &env.Error{Name: "AUDIT_FILE"}
+	}
+
+	return nil
+}
+
+// Has reports whether events are written to the given type of sink.
+func (a *Env) Has(t SinkType) bool {
+	for _, sink := range a.Sinks {
+		if sink.Type == t {
+			return true
+		}
+	}
+	return false
+}
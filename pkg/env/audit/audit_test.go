@@ -0,0 +1,127 @@
+package audit
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewAuditEnv(t *testing.T) {
+	t.Parallel()
+
+	actual := NewAuditEnv()
+
+	require.NotNil(t, actual)
+	assert.IsType(t, &Env{}, actual)
+}
+
+func TestPopulate(t *testing.T) {
+	cases := []struct {
+		description string
+		given       func()
+		expected    *Env
+		error       bool
+		want        string
+	}{
+		{
+			"no environment variables set",
+			func() {
+				// No-op.
+			},
+			&Env{Sinks: []Sink{{SinkConsole, false}, {SinkSplunk, true}}, Timeout: DefaultTimeout},
+			false,
+			``,
+		},
+		{
+			"all environment variables set",
+			func() {
+				t.Setenv("AUDIT_SINKS", "console, file:required")
+				t.Setenv("AUDIT_TIMEOUT", "10s")
+				t.Setenv("AUDIT_FILE", "/var/log/gabi/audit.log")
+			},
+			&Env{Sinks: []Sink{{SinkConsole, false}, {SinkFile, true}}, Timeout: 10 * time.Second, File: "/var/log/gabi/audit.log"},
+			false,
+			``,
+		},
+		{
+			"every sink required",
+			func() {
+				t.Setenv("AUDIT_SINKS", "splunk:required,console:required")
+			},
+			&Env{Sinks: []Sink{{SinkSplunk, true}, {SinkConsole, true}}, Timeout: DefaultTimeout},
+			false,
+			``,
+		},
+		{
+			"invalid AUDIT_SINKS environment variable with unknown sink",
+			func() {
+				t.Setenv("AUDIT_SINKS", "console,syslog:required")
+			},
+			&Env{Sinks: []Sink{{SinkConsole, false}}},
+			true,
+			`unable to convert environment variable: AUDIT_SINKS`,
+		},
+		{
+			"invalid AUDIT_SINKS environment variable with duplicate sink",
+			func() {
+				t.Setenv("AUDIT_SINKS", "splunk:required,splunk")
+			},
+			&Env{Sinks: []Sink{{SinkSplunk, true}}},
+			true,
+			`unable to convert environment variable: AUDIT_SINKS`,
+		},
+		{
+			"invalid AUDIT_SINKS environment variable without required sink",
+			func() {
+				t.Setenv("AUDIT_SINKS", "console,splunk")
+			},
+			&Env{Sinks: []Sink{{SinkConsole, false}, {SinkSplunk, false}}},
+			true,
+			`unable to convert environment variable: AUDIT_SINKS`,
+		},
+		{
+			"invalid AUDIT_TIMEOUT environment variable",
+			func() {
+				t.Setenv("AUDIT_TIMEOUT", "test")
+			},
+			&Env{Sinks: []Sink{{SinkConsole, false}, {SinkSplunk, true}}, Timeout: DefaultTimeout},
+			true,
+			`unable to convert environment variable: AUDIT_TIMEOUT`,
+		},
+		{
+			"missing required AUDIT_FILE environment variable",
+			func() {
+				t.Setenv("AUDIT_SINKS", "file:required")
+			},
+			&Env{Sinks: []Sink{{SinkFile, true}}, Timeout: DefaultTimeout},
+			true,
+			`unable to access environment variable: AUDIT_FILE`,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Cleanup(func() {
+				os.Clearenv()
+			})
+
+			tc.given()
+
+			actual := &Env{}
+			err := actual.Populate()
+
+			if tc.error {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.want)
+			} else {
+				require.NoError(t, err)
+			}
+
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
@@ -1,5 +1,12 @@
 package db
 
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+)
+
 const (
 	driverMySQL      = "mysql"
 	driverPostgreSQL = "pgx"
@@ -9,8 +16,53 @@ const (
 
 	driverMySQLFormat      = `%s:%s@tcp(%s:%d)/%s`
 	driverPostgreSQLFormat = `postgres://%s:%s@%s:%d/%s`
+
+	// MySQL only reports the plan of an analysed query as text.
+	driverMySQLExplain             = `EXPLAIN FORMAT=JSON `
+	driverMySQLExplainAnalyze      = `EXPLAIN ANALYZE `
+	driverPostgreSQLExplain        = `EXPLAIN (FORMAT JSON) `
+	driverPostgreSQLExplainAnalyze = `EXPLAIN (ANALYZE, FORMAT JSON) `
+
+	// Unlike PostgreSQL, MySQL has no setting scoped to a transaction,
+	// so the setting has to be made every time a connection is used.
+	driverMySQLStatementTimeout        = `SET SESSION max_execution_time = %d`
+	driverMySQLStatementTimeoutDefault = `SET SESSION max_execution_time = DEFAULT`
+	driverPostgreSQLStatementTimeout   = `SET LOCAL statement_timeout = %d`
+
+	driverMySQLBackendID      = `SELECT CONNECTION_ID()`
+	driverPostgreSQLBackendID = `SELECT pg_backend_pid()`
+
+	driverMySQLCancelQuery      = `KILL QUERY %d`
+	driverPostgreSQLCancelQuery = `SELECT pg_cancel_backend(%d)`
 )
 
+// MySQL commits the transaction before running these statements, and
+// some of them after too, which no rollback undoes. Statements are told
+// apart by their first keyword, and only one statement is run at once.
+var driverMySQLImplicitCommit = map[string]struct{}{
+	"ALTER":     {},
+	"ANALYZE":   {},
+	"BEGIN":     {},
+	"CACHE":     {},
+	"COMMIT":    {},
+	"CREATE":    {},
+	"DROP":      {},
+	"FLUSH":     {},
+	"GRANT":     {},
+	"INSTALL":   {},
+	"LOAD":      {},
+	"LOCK":      {},
+	"OPTIMIZE":  {},
+	"RENAME":    {},
+	"REPAIR":    {},
+	"RESET":     {},
+	"REVOKE":    {},
+	"START":     {},
+	"TRUNCATE":  {},
+	"UNINSTALL": {},
+	"UNLOCK":    {},
+}
+
 type DriverType string
 
 func (t DriverType) String() string {
@@ -39,6 +91,88 @@ func (t DriverType) Format() string {
 	}
 }
 
+// Explain returns the prefix that turns a query into a request for its
+// plan, which is also executed when it is to be analysed.
+func (t DriverType) Explain(analyze bool) string {
+	switch t.driver() {
+	case driverMySQL:
+		if analyze {
+			return driverMySQLExplainAnalyze
+		}
+		return driverMySQLExplain
+	case driverPostgreSQL:
+		if analyze {
+			return driverPostgreSQLExplainAnalyze
+		}
+		return driverPostgreSQLExplain
+	default:
+		return ""
+	}
+}
+
+// StatementTimeout returns the statement that makes the database stop
+// statements that run for longer than the timeout, where a timeout of
+// zero leaves the default of the database in place.
+func (t DriverType) StatementTimeout(timeout time.Duration) string {
+	switch t.driver() {
+	case driverMySQL:
+		if timeout == 0 {
+			return driverMySQLStatementTimeoutDefault
+		}
+		return fmt.Sprintf(driverMySQLStatementTimeout, timeout.Milliseconds())
+	case driverPostgreSQL:
+		if timeout == 0 {
+			return ""
+		}
+		return fmt.Sprintf(driverPostgreSQLStatementTimeout, timeout.Milliseconds())
+	default:
+		return ""
+	}
+}
+
+// BackendID returns the query that reports the ID the database uses
+// for the connection it is run on.
+func (t DriverType) BackendID() string {
+	switch t.driver() {
+	case driverMySQL:
+		return driverMySQLBackendID
+	case driverPostgreSQL:
+		return driverPostgreSQLBackendID
+	default:
+		return ""
+	}
+}
+
+// CancelQuery returns the statement that stops the query running on
+// the connection with the given ID, leaving the connection open.
+func (t DriverType) CancelQuery(id int64) string {
+	switch t.driver() {
+	case driverMySQL:
+		return fmt.Sprintf(driverMySQLCancelQuery, id)
+	case driverPostgreSQL:
+		return fmt.Sprintf(driverPostgreSQLCancelQuery, id)
+	default:
+		return ""
+	}
+}
+
+// CommitsImplicitly reports whether the database commits the
+// transaction the query is run in, so that rolling it back afterwards
+// does not undo it.
+func (t DriverType) CommitsImplicitly(query string) bool {
+	if t.driver() != driverMySQL {
+		return false
+	}
+	_, ok := driverMySQLImplicitCommit[strings.ToUpper(firstKeyword(query))]
+	return ok
+}
+
+// DeclaresCursors reports whether the database can declare a cursor
+// for a query, whose rows are then fetched a page at a time.
+func (t DriverType) DeclaresCursors() bool {
+	return t.driver() == driverPostgreSQL
+}
+
 func (t DriverType) IsValid() bool {
 	types := map[string]interface{}{
 		"mysql":      struct{}{},
@@ -63,3 +197,37 @@ func (t DriverType) driver() DriverType {
 
 	return t
 }
+
+// firstKeyword returns the first word of the query, skipping comments,
+// other than those MySQL runs the content of, such as /*!80000 ... */.
+func firstKeyword(query string) string {
+	for {
+		query = strings.TrimLeftFunc(query, unicode.IsSpace)
+		switch {
+		case strings.HasPrefix(query, "/*!"):
+			query = strings.TrimLeft(query[3:], "0123456789")
+		case strings.HasPrefix(query, "/*"):
+			end := strings.Index(query, "*/")
+			if end < 0 {
+				return ""
+			}
+			query = query[end+2:]
+		case strings.HasPrefix(query, "--"), strings.HasPrefix(query, "#"):
+			end := strings.IndexByte(query, '\n')
+			if end < 0 {
+				return ""
+			}
+			query = query[end+1:]
+		case strings.HasPrefix(query, "("):
+			query = query[1:]
+		default:
+			end := strings.IndexFunc(query, func(r rune) bool {
+				return !unicode.IsLetter(r) && r != '_'
+			})
+			if end < 0 {
+				return query
+			}
+			return query[:end]
+		}
+	}
+}
@@ -2,6 +2,7 @@ package db
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -16,6 +17,11 @@ func TestDriveType(t *testing.T) {
 		want        string
 		port        int
 		format      string
+		explain     string
+		analyze     string
+		timeout     string
+		backend     string
+		cancel      string
 		valid       bool
 	}{
 		{
@@ -24,6 +30,11 @@ func TestDriveType(t *testing.T) {
 			"mysql",
 			3306,
 			`%s:%s@tcp(%s:%d)/%s`,
+			`EXPLAIN FORMAT=JSON `,
+			`EXPLAIN ANALYZE `,
+			`SET SESSION max_execution_time = 1500`,
+			`SELECT CONNECTION_ID()`,
+			`KILL QUERY 42`,
 			true,
 		},
 		{
@@ -32,6 +43,11 @@ func TestDriveType(t *testing.T) {
 			"pgx",
 			5432,
 			`postgres://%s:%s@%s:%d/%s`,
+			`EXPLAIN (FORMAT JSON) `,
+			`EXPLAIN (ANALYZE, FORMAT JSON) `,
+			`SET LOCAL statement_timeout = 1500`,
+			`SELECT pg_backend_pid()`,
+			`SELECT pg_cancel_backend(42)`,
 			true,
 		},
 		{
@@ -40,6 +56,11 @@ func TestDriveType(t *testing.T) {
 			"pgx",
 			5432,
 			`postgres://%s:%s@%s:%d/%s`,
+			`EXPLAIN (FORMAT JSON) `,
+			`EXPLAIN (ANALYZE, FORMAT JSON) `,
+			`SET LOCAL statement_timeout = 1500`,
+			`SELECT pg_backend_pid()`,
+			`SELECT pg_cancel_backend(42)`,
 			true,
 		},
 		{
@@ -48,6 +69,11 @@ func TestDriveType(t *testing.T) {
 			"pgx",
 			5432,
 			`postgres://%s:%s@%s:%d/%s`,
+			`EXPLAIN (FORMAT JSON) `,
+			`EXPLAIN (ANALYZE, FORMAT JSON) `,
+			`SET LOCAL statement_timeout = 1500`,
+			`SELECT pg_backend_pid()`,
+			`SELECT pg_cancel_backend(42)`,
 			true,
 		},
 		{
@@ -56,6 +82,11 @@ func TestDriveType(t *testing.T) {
 			"",
 			0,
 			``,
+			``,
+			``,
+			``,
+			``,
+			``,
 			false,
 		},
 		{
@@ -64,6 +95,11 @@ func TestDriveType(t *testing.T) {
 			"",
 			0,
 			``,
+			``,
+			``,
+			``,
+			``,
+			``,
 			false,
 		},
 	}
@@ -79,7 +115,75 @@ func TestDriveType(t *testing.T) {
 
 			assert.Equal(t, tc.port, actual.Port())
 			assert.Equal(t, tc.format, actual.Format())
+			assert.Equal(t, tc.explain, actual.Explain(false))
+			assert.Equal(t, tc.analyze, actual.Explain(true))
+			assert.Equal(t, tc.timeout, actual.StatementTimeout(1500*time.Millisecond))
+			assert.Equal(t, tc.backend, actual.BackendID())
+			assert.Equal(t, tc.cancel, actual.CancelQuery(42))
+			if tc.valid && actual.String() == "mysql" {
+				assert.Equal(t, "SET SESSION max_execution_time = DEFAULT", actual.StatementTimeout(0))
+			} else {
+				assert.Empty(t, actual.StatementTimeout(0))
+			}
+			assert.Equal(t, tc.want == "pgx", actual.DeclaresCursors())
 			assert.Equal(t, tc.valid, actual.IsValid())
 		})
 	}
 }
+
+func TestCommitsImplicitly(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		description string
+		driver      DriverType
+		query       string
+		want        bool
+	}{
+		{
+			"MySQL statement committing implicitly",
+			"mysql",
+			"CREATE TABLE test (id int);",
+			true,
+		},
+		{
+			"MySQL statement committing implicitly in lower case after comments",
+			"mysql",
+			"-- test\n /* test */ truncate test;",
+			true,
+		},
+		{
+			"MySQL statement committing implicitly within a comment that is run",
+			"mysql",
+			"/*!50000 DROP TABLE test */;",
+			true,
+		},
+		{
+			"MySQL statement not committing implicitly",
+			"mysql",
+			"DELETE FROM test;",
+			false,
+		},
+		{
+			"MySQL statement not committing implicitly despite a comment",
+			"mysql",
+			"/* DROP TABLE test */ SELECT 1;",
+			false,
+		},
+		{
+			"PostgreSQL statement rolled back",
+			"pgx",
+			"DROP TABLE test;",
+			false,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.want, tc.driver.CommitsImplicitly(tc.query))
+		})
+	}
+}
@@ -0,0 +1,206 @@
+package query
+
+import (
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/app-sre/gabi/pkg/env"
+)
+
+const (
+	DefaultCursorTTL  = 5 * time.Minute
+	DefaultMaxCursors = 10
+
+	DefaultJobTimeout = 1 * time.Hour
+	DefaultJobTTL     = 1 * time.Hour
+	DefaultMaxJobs    = 2
+	DefaultMaxQueued  = 10
+
+	DefaultMaxJobBytes = 1024 * 1024 * 1024
+
+	DefaultCompressionMinSize = 1024
+
+	DefaultCacheMaxBytes = 64 * 1024 * 1024
+
+	DefaultMaxRequestBytes = 1024 * 1024
+	DefaultMaxQueryLength  = 256 * 1024
+)
+
+type Env struct {
+	MaxRows     int64
+	MaxBytes    int64
+	CursorTTL   time.Duration
+	MaxCursors  int
+	JobSpoolDir string
+	JobTimeout  time.Duration
+	JobTTL      time.Duration
+	MaxJobs     int
+	MaxQueued   int
+
+	// The result of a job is truncated once it reaches MaxJobBytes,
+	// where zero means there is no limit.
+	MaxJobBytes int64
+
+	CompressionMinSize int64
+
+	// A TTL of zero means results are not cached.
+	CacheTTL      time.Duration
+	CacheMaxBytes int64
+
+	MaxRequestBytes int64
+	MaxQueryLength  int64
+}
+
+func NewQueryEnv() *Env {
+	return &Env{}
+}
+
+func (q *Env) Populate() error {
+	maxRows, err := parseLimit("QUERY_MAX_ROWS")
+	if err != nil {
+		return err
+	}
+	q.MaxRows = maxRows
+
+	maxBytes, err := parseLimit("QUERY_MAX_BYTES")
+	if err != nil {
+		return err
+	}
+	q.MaxBytes = maxBytes
+
+	q.CursorTTL = DefaultCursorTTL
+	if s := os.Getenv("CURSOR_TTL"); s != "" {
+		ttl, err := time.ParseDuration(s)
+		if err != nil || ttl <= 0 {
+			return &env.TypeError{Name: "CURSOR_TTL"}
+		}
+		q.CursorTTL = ttl
+	}
+
+	q.MaxCursors = DefaultMaxCursors
+	if s := os.Getenv("CURSOR_MAX"); s != "" {
+		n, err := strconv.ParseInt(s, 10, 0)
+		if err != nil || n < 0 {
+			return &env.TypeError{Name: "CURSOR_MAX"}
+		}
+		q.MaxCursors = int(n)
+	}
+
+	// Left empty, the default spool directory is used.
+	q.JobSpoolDir = os.Getenv("JOB_SPOOL_DIR")
+
+	q.JobTimeout, err = parseDuration("JOB_TIMEOUT", DefaultJobTimeout)
+	if err != nil {
+		return err
+	}
+
+	q.JobTTL, err = parseDuration("JOB_TTL", DefaultJobTTL)
+	if err != nil {
+		return err
+	}
+
+	q.MaxJobs = DefaultMaxJobs
+	if s := os.Getenv("JOB_MAX"); s != "" {
+		n, err := strconv.ParseInt(s, 10, 0)
+		if err != nil || n < 0 {
+			return &env.TypeError{Name: "JOB_MAX"}
+		}
+		q.MaxJobs = int(n)
+	}
+
+	q.MaxQueued = DefaultMaxQueued
+	if s := os.Getenv("JOB_MAX_QUEUED"); s != "" {
+		n, err := strconv.ParseInt(s, 10, 0)
+		if err != nil || n < 0 {
+			return &env.TypeError{Name: "JOB_MAX_QUEUED"}
+		}
+		q.MaxQueued = int(n)
+	}
+
+	q.MaxJobBytes = DefaultMaxJobBytes
+	if s := os.Getenv("JOB_MAX_BYTES"); s != "" {
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || n < 0 {
+			return &env.TypeError{Name: "JOB_MAX_BYTES"}
+		}
+		q.MaxJobBytes = n
+	}
+
+	q.CompressionMinSize = DefaultCompressionMinSize
+	if s := os.Getenv("COMPRESSION_MIN_SIZE"); s != "" {
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || n < 0 {
+			return &env.TypeError{Name: "COMPRESSION_MIN_SIZE"}
+		}
+		q.CompressionMinSize = n
+	}
+
+	// Zero, as when not set, means results are not cached.
+	q.CacheTTL = 0
+	if s := os.Getenv("CACHE_TTL"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d < 0 {
+			return &env.TypeError{Name: "CACHE_TTL"}
+		}
+		q.CacheTTL = d
+	}
+
+	q.CacheMaxBytes = DefaultCacheMaxBytes
+	if s := os.Getenv("CACHE_MAX_BYTES"); s != "" {
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || n <= 0 {
+			return &env.TypeError{Name: "CACHE_MAX_BYTES"}
+		}
+		q.CacheMaxBytes = n
+	}
+
+	q.MaxRequestBytes = DefaultMaxRequestBytes
+	if s := os.Getenv("REQUEST_MAX_BYTES"); s != "" {
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || n <= 0 {
+			return &env.TypeError{Name: "REQUEST_MAX_BYTES"}
+		}
+		q.MaxRequestBytes = n
+	}
+
+	q.MaxQueryLength = DefaultMaxQueryLength
+	if s := os.Getenv("QUERY_MAX_LENGTH"); s != "" {
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || n <= 0 {
+			return &env.TypeError{Name: "QUERY_MAX_LENGTH"}
+		}
+		q.MaxQueryLength = n
+	}
+
+	return nil
+}
+
+func parseDuration(name string, value time.Duration) (time.Duration, error) {
+	s := os.Getenv(name)
+	if s == "" {
+		return value, nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, &env.TypeError{Name: name}
+	}
+
+	return d, nil
+}
+
+// A limit that is not set, or set to zero, means there is no limit.
+func parseLimit(name string) (int64, error) {
+	s := os.Getenv(name)
+	if s == "" {
+		return 0, nil
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, &env.TypeError{Name: name}
+	}
+
+	return n, nil
+}
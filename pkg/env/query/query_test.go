@@ -0,0 +1,231 @@
+package query
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewQueryEnv(t *testing.T) {
+	t.Parallel()
+
+	actual := NewQueryEnv()
+
+	require.NotNil(t, actual)
+	assert.IsType(t, &Env{}, actual)
+}
+
+func TestPopulate(t *testing.T) {
+
+	cases := []struct {
+		description string
+		given       func()
+		expected    *Env
+		error       bool
+		want        string
+	}{
+		{
+			"all environment variables set",
+			func() {
+				t.Setenv("QUERY_MAX_ROWS", "1000")
+				t.Setenv("QUERY_MAX_BYTES", "1048576")
+				t.Setenv("CURSOR_TTL", "1m")
+				t.Setenv("CURSOR_MAX", "5")
+				t.Setenv("JOB_SPOOL_DIR", "/tmp/test")
+				t.Setenv("JOB_TIMEOUT", "10m")
+				t.Setenv("JOB_TTL", "30m")
+				t.Setenv("JOB_MAX", "1")
+				t.Setenv("JOB_MAX_QUEUED", "3")
+				t.Setenv("JOB_MAX_BYTES", "4096")
+				t.Setenv("COMPRESSION_MIN_SIZE", "0")
+				t.Setenv("CACHE_TTL", "10s")
+				t.Setenv("CACHE_MAX_BYTES", "1024")
+				t.Setenv("REQUEST_MAX_BYTES", "2048")
+				t.Setenv("QUERY_MAX_LENGTH", "512")
+			},
+			&Env{MaxRows: 1000, MaxBytes: 1048576, CursorTTL: time.Minute, MaxCursors: 5, JobSpoolDir: "/tmp/test", JobTimeout: 10 * time.Minute, JobTTL: 30 * time.Minute, MaxJobs: 1, MaxQueued: 3, MaxJobBytes: 4096, CompressionMinSize: 0, CacheTTL: 10 * time.Second, CacheMaxBytes: 1024, MaxRequestBytes: 2048, MaxQueryLength: 512},
+			false,
+			``,
+		},
+		{
+			"no environment variables set",
+			func() {
+				// No-op.
+			},
+			&Env{CursorTTL: DefaultCursorTTL, MaxCursors: DefaultMaxCursors, JobTimeout: DefaultJobTimeout, JobTTL: DefaultJobTTL, MaxJobs: DefaultMaxJobs, MaxQueued: DefaultMaxQueued, MaxJobBytes: DefaultMaxJobBytes, CompressionMinSize: DefaultCompressionMinSize, CacheMaxBytes: DefaultCacheMaxBytes, MaxRequestBytes: DefaultMaxRequestBytes, MaxQueryLength: DefaultMaxQueryLength},
+			false,
+			``,
+		},
+		{
+			"environment variable QUERY_MAX_ROWS with empty value set",
+			func() {
+				t.Setenv("QUERY_MAX_ROWS", "")
+			},
+			&Env{CursorTTL: DefaultCursorTTL, MaxCursors: DefaultMaxCursors, JobTimeout: DefaultJobTimeout, JobTTL: DefaultJobTTL, MaxJobs: DefaultMaxJobs, MaxQueued: DefaultMaxQueued, MaxJobBytes: DefaultMaxJobBytes, CompressionMinSize: DefaultCompressionMinSize, CacheMaxBytes: DefaultCacheMaxBytes, MaxRequestBytes: DefaultMaxRequestBytes, MaxQueryLength: DefaultMaxQueryLength},
+			false,
+			``,
+		},
+		{
+			"environment variable QUERY_MAX_ROWS with invalid value set",
+			func() {
+				t.Setenv("QUERY_MAX_ROWS", "test")
+			},
+			&Env{},
+			true,
+			`unable to convert environment variable: QUERY_MAX_ROWS`,
+		},
+		{
+			"environment variable QUERY_MAX_BYTES with negative value set",
+			func() {
+				t.Setenv("QUERY_MAX_ROWS", "10")
+				t.Setenv("QUERY_MAX_BYTES", "-1")
+			},
+			&Env{MaxRows: 10},
+			true,
+			`unable to convert environment variable: QUERY_MAX_BYTES`,
+		},
+		{
+			"environment variable CURSOR_TTL with invalid value set",
+			func() {
+				t.Setenv("CURSOR_TTL", "test")
+			},
+			&Env{CursorTTL: DefaultCursorTTL},
+			true,
+			`unable to convert environment variable: CURSOR_TTL`,
+		},
+		{
+			"environment variable CURSOR_MAX with invalid value set",
+			func() {
+				t.Setenv("CURSOR_MAX", "-1")
+			},
+			&Env{CursorTTL: DefaultCursorTTL, MaxCursors: DefaultMaxCursors},
+			true,
+			`unable to convert environment variable: CURSOR_MAX`,
+		},
+		{
+			"environment variable JOB_TIMEOUT with invalid value set",
+			func() {
+				t.Setenv("JOB_TIMEOUT", "0s")
+			},
+			&Env{CursorTTL: DefaultCursorTTL, MaxCursors: DefaultMaxCursors},
+			true,
+			`unable to convert environment variable: JOB_TIMEOUT`,
+		},
+		{
+			"environment variable JOB_MAX with invalid value set",
+			func() {
+				t.Setenv("JOB_MAX", "test")
+			},
+			&Env{CursorTTL: DefaultCursorTTL, MaxCursors: DefaultMaxCursors, JobTimeout: DefaultJobTimeout, JobTTL: DefaultJobTTL, MaxJobs: DefaultMaxJobs},
+			true,
+			`unable to convert environment variable: JOB_MAX`,
+		},
+		{
+			"environment variable JOB_MAX_QUEUED with invalid value set",
+			func() {
+				t.Setenv("JOB_MAX_QUEUED", "-1")
+			},
+			&Env{CursorTTL: DefaultCursorTTL, MaxCursors: DefaultMaxCursors, JobTimeout: DefaultJobTimeout, JobTTL: DefaultJobTTL, MaxJobs: DefaultMaxJobs, MaxQueued: DefaultMaxQueued},
+			true,
+			`unable to convert environment variable: JOB_MAX_QUEUED`,
+		},
+		{
+			"environment variable JOB_MAX_BYTES with invalid value set",
+			func() {
+				t.Setenv("JOB_MAX_BYTES", "-1")
+			},
+			&Env{CursorTTL: DefaultCursorTTL, MaxCursors: DefaultMaxCursors, JobTimeout: DefaultJobTimeout, JobTTL: DefaultJobTTL, MaxJobs: DefaultMaxJobs, MaxQueued: DefaultMaxQueued, MaxJobBytes: DefaultMaxJobBytes},
+			true,
+			`unable to convert environment variable: JOB_MAX_BYTES`,
+		},
+		{
+			"environment variable COMPRESSION_MIN_SIZE with invalid value set",
+			func() {
+				t.Setenv("COMPRESSION_MIN_SIZE", "-1")
+			},
+			&Env{CursorTTL: DefaultCursorTTL, MaxCursors: DefaultMaxCursors, JobTimeout: DefaultJobTimeout, JobTTL: DefaultJobTTL, MaxJobs: DefaultMaxJobs, MaxQueued: DefaultMaxQueued, MaxJobBytes: DefaultMaxJobBytes, CompressionMinSize: DefaultCompressionMinSize},
+			true,
+			`unable to convert environment variable: COMPRESSION_MIN_SIZE`,
+		},
+		{
+			"environment variable CACHE_TTL with invalid value set",
+			func() {
+				t.Setenv("CACHE_TTL", "test")
+			},
+			&Env{CursorTTL: DefaultCursorTTL, MaxCursors: DefaultMaxCursors, JobTimeout: DefaultJobTimeout, JobTTL: DefaultJobTTL, MaxJobs: DefaultMaxJobs, MaxQueued: DefaultMaxQueued, MaxJobBytes: DefaultMaxJobBytes, CompressionMinSize: DefaultCompressionMinSize},
+			true,
+			`unable to convert environment variable: CACHE_TTL`,
+		},
+		{
+			"environment variable CACHE_TTL with zero value set",
+			func() {
+				t.Setenv("CACHE_TTL", "0s")
+			},
+			&Env{CursorTTL: DefaultCursorTTL, MaxCursors: DefaultMaxCursors, JobTimeout: DefaultJobTimeout, JobTTL: DefaultJobTTL, MaxJobs: DefaultMaxJobs, MaxQueued: DefaultMaxQueued, MaxJobBytes: DefaultMaxJobBytes, CompressionMinSize: DefaultCompressionMinSize, CacheMaxBytes: DefaultCacheMaxBytes, MaxRequestBytes: DefaultMaxRequestBytes, MaxQueryLength: DefaultMaxQueryLength},
+			false,
+			``,
+		},
+		{
+			"environment variable CACHE_TTL with negative value set",
+			func() {
+				t.Setenv("CACHE_TTL", "-10s")
+			},
+			&Env{CursorTTL: DefaultCursorTTL, MaxCursors: DefaultMaxCursors, JobTimeout: DefaultJobTimeout, JobTTL: DefaultJobTTL, MaxJobs: DefaultMaxJobs, MaxQueued: DefaultMaxQueued, MaxJobBytes: DefaultMaxJobBytes, CompressionMinSize: DefaultCompressionMinSize},
+			true,
+			`unable to convert environment variable: CACHE_TTL`,
+		},
+		{
+			"environment variable CACHE_MAX_BYTES with invalid value set",
+			func() {
+				t.Setenv("CACHE_MAX_BYTES", "0")
+			},
+			&Env{CursorTTL: DefaultCursorTTL, MaxCursors: DefaultMaxCursors, JobTimeout: DefaultJobTimeout, JobTTL: DefaultJobTTL, MaxJobs: DefaultMaxJobs, MaxQueued: DefaultMaxQueued, MaxJobBytes: DefaultMaxJobBytes, CompressionMinSize: DefaultCompressionMinSize, CacheMaxBytes: DefaultCacheMaxBytes},
+			true,
+			`unable to convert environment variable: CACHE_MAX_BYTES`,
+		},
+		{
+			"environment variable REQUEST_MAX_BYTES with invalid value set",
+			func() {
+				t.Setenv("REQUEST_MAX_BYTES", "0")
+			},
+			&Env{CursorTTL: DefaultCursorTTL, MaxCursors: DefaultMaxCursors, JobTimeout: DefaultJobTimeout, JobTTL: DefaultJobTTL, MaxJobs: DefaultMaxJobs, MaxQueued: DefaultMaxQueued, MaxJobBytes: DefaultMaxJobBytes, CompressionMinSize: DefaultCompressionMinSize, CacheMaxBytes: DefaultCacheMaxBytes, MaxRequestBytes: DefaultMaxRequestBytes},
+			true,
+			`unable to convert environment variable: REQUEST_MAX_BYTES`,
+		},
+		{
+			"environment variable QUERY_MAX_LENGTH with invalid value set",
+			func() {
+				t.Setenv("QUERY_MAX_LENGTH", "test")
+			},
+			&Env{CursorTTL: DefaultCursorTTL, MaxCursors: DefaultMaxCursors, JobTimeout: DefaultJobTimeout, JobTTL: DefaultJobTTL, MaxJobs: DefaultMaxJobs, MaxQueued: DefaultMaxQueued, MaxJobBytes: DefaultMaxJobBytes, CompressionMinSize: DefaultCompressionMinSize, CacheMaxBytes: DefaultCacheMaxBytes, MaxRequestBytes: DefaultMaxRequestBytes, MaxQueryLength: DefaultMaxQueryLength},
+			true,
+			`unable to convert environment variable: QUERY_MAX_LENGTH`,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Cleanup(func() {
+				os.Clearenv()
+			})
+
+			tc.given()
+
+			actual := &Env{}
+			err := actual.Populate()
+
+			if tc.error {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.want)
+			} else {
+				require.NoError(t, err)
+			}
+
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
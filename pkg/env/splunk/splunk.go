@@ -2,10 +2,40 @@ package splunk
 
 import (
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/app-sre/gabi/pkg/env"
 )
 
+const (
+	DefaultRetries      = 3
+	DefaultRetryBackoff = 100 * time.Millisecond
+	DefaultRetryTimeout = 15 * time.Second
+
+	DefaultBreakerThreshold = 5
+	DefaultBreakerCooldown  = 30 * time.Second
+
+	DefaultSpoolMaxBytes = 1 << 30
+
+	DefaultAckTimeout  = time.Minute
+	DefaultAckInterval = time.Second
+)
+
+// AckMode is what is done with the acknowledgment Splunk sends once an
+// event has been indexed.
+type AckMode string
+
+const (
+	// Acknowledgments are not requested.
+	AckNone AckMode = "none"
+	// Acknowledgments are checked for in the background, and events
+	// not acknowledged in time are logged.
+	AckTrack AckMode = "track"
+	// Sending an event only succeeds once it has been acknowledged.
+	AckWait AckMode = "wait"
+)
+
 type Env struct {
 	Index     string
 	Endpoint  string
@@ -13,6 +43,33 @@ type Env struct {
 	Host      string
 	Namespace string
 	Pod       string
+
+	// Retries is the number of times sending an event is retried, and
+	// RetryBackoff the initial delay between tries, which doubles after
+	// every try. Sending an event, including every retry, takes at most
+	// RetryTimeout.
+	Retries      int
+	RetryBackoff time.Duration
+	RetryTimeout time.Duration
+
+	// BreakerThreshold is the number of events in a row that could not
+	// be sent after which sending is suspended for BreakerCooldown. A
+	// threshold of zero means sending is never suspended.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+
+	// SpoolDir is where events are kept until they have been sent, so
+	// that queries can proceed while Splunk is unavailable, holding at
+	// most SpoolMaxBytes of events not sent yet. No directory means
+	// events are sent straight away.
+	SpoolDir      string
+	SpoolMaxBytes int64
+
+	// Ack is whether events have to be acknowledged as indexed within
+	// AckTimeout, which is checked for every AckInterval.
+	Ack         AckMode
+	AckTimeout  time.Duration
+	AckInterval time.Duration
 }
 
 func NewSplunkEnv() *Env {
@@ -20,6 +77,8 @@ func NewSplunkEnv() *Env {
 }
 
 func (s *Env) Populate() error {
+	var err error
+
 	index := os.Getenv("SPLUNK_INDEX")
 	if index == "" {
 		return &env.Error{Name: "SPLUNK_INDEX"}
@@ -56,5 +115,99 @@ func (s *Env) Populate() error {
 	}
 	s.Pod = pod
 
+	s.Retries, err = parseCount("SPLUNK_RETRIES", DefaultRetries)
+	if err != nil {
+		return err
+	}
+
+	s.RetryBackoff, err = parseDuration("SPLUNK_RETRY_BACKOFF", DefaultRetryBackoff)
+	if err != nil {
+		return err
+	}
+
+	s.RetryTimeout, err = parseDuration("SPLUNK_RETRY_TIMEOUT", DefaultRetryTimeout)
+	if err != nil {
+		return err
+	}
+
+	s.BreakerThreshold, err = parseCount("SPLUNK_BREAKER_THRESHOLD", DefaultBreakerThreshold)
+	if err != nil {
+		return err
+	}
+
+	s.BreakerCooldown, err = parseDuration("SPLUNK_BREAKER_COOLDOWN", DefaultBreakerCooldown)
+	if err != nil {
+		return err
+	}
+
+	s.SpoolDir = os.Getenv("SPLUNK_SPOOL_DIR")
+
+	s.SpoolMaxBytes, err = parseSize("SPLUNK_SPOOL_MAX_BYTES", DefaultSpoolMaxBytes)
+	if err != nil {
+		return err
+	}
+
+	s.Ack = AckNone
+	if ack := os.Getenv("SPLUNK_ACK"); ack != "" {
+		switch AckMode(ack) {
+		case AckNone, AckTrack, AckWait:
+			s.Ack = AckMode(ack)
+		default:
+			return &env.TypeError{Name: "SPLUNK_ACK"}
+		}
+	}
+
+	s.AckTimeout, err = parseDuration("SPLUNK_ACK_TIMEOUT", DefaultAckTimeout)
+	if err != nil {
+		return err
+	}
+
+	s.AckInterval, err = parseDuration("SPLUNK_ACK_INTERVAL", DefaultAckInterval)
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
+
+func parseCount(name string, value int) (int, error) {
+	s := os.Getenv(name)
+	if s == "" {
+		return value, nil
+	}
+
+	n, err := strconv.ParseInt(s, 10, 0)
+	if err != nil || n < 0 {
+		return 0, &env.TypeError{Name: name}
+	}
+
+	return int(n), nil
+}
+
+func parseSize(name string, value int64) (int64, error) {
+	s := os.Getenv(name)
+	if s == "" {
+		return value, nil
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, &env.TypeError{Name: name}
+	}
+
+	return n, nil
+}
+
+func parseDuration(name string, value time.Duration) (time.Duration, error) {
+	s := os.Getenv(name)
+	if s == "" {
+		return value, nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, &env.TypeError{Name: name}
+	}
+
+	return d, nil
+}
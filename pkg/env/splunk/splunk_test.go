@@ -3,6 +3,7 @@ package splunk
 import (
 	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -35,10 +36,138 @@ func TestPopulate(t *testing.T) {
 				t.Setenv("NAMESPACE", "test")
 				t.Setenv("POD_NAME", "test")
 			},
+			&Env{Index: "test", Endpoint: "test", Token: "test123", Host: "test", Namespace: "test", Pod: "test", Retries: DefaultRetries, RetryBackoff: DefaultRetryBackoff, RetryTimeout: DefaultRetryTimeout, BreakerThreshold: DefaultBreakerThreshold, BreakerCooldown: DefaultBreakerCooldown, SpoolMaxBytes: DefaultSpoolMaxBytes, Ack: AckNone, AckTimeout: DefaultAckTimeout, AckInterval: DefaultAckInterval},
+			false,
+			``,
+		},
+		{
+			"all environment variables set including retries and circuit breaker",
+			func() {
+				t.Setenv("SPLUNK_INDEX", "test")
+				t.Setenv("SPLUNK_ENDPOINT", "test")
+				t.Setenv("SPLUNK_TOKEN", "test123")
+				t.Setenv("HOST", "test")
+				t.Setenv("NAMESPACE", "test")
+				t.Setenv("POD_NAME", "test")
+				t.Setenv("SPLUNK_RETRIES", "0")
+				t.Setenv("SPLUNK_RETRY_BACKOFF", "1s")
+				t.Setenv("SPLUNK_RETRY_TIMEOUT", "5s")
+				t.Setenv("SPLUNK_BREAKER_THRESHOLD", "10")
+				t.Setenv("SPLUNK_BREAKER_COOLDOWN", "1m")
+			},
+			&Env{Index: "test", Endpoint: "test", Token: "test123", Host: "test", Namespace: "test", Pod: "test", Retries: 0, RetryBackoff: time.Second, RetryTimeout: 5 * time.Second, BreakerThreshold: 10, BreakerCooldown: time.Minute, SpoolMaxBytes: DefaultSpoolMaxBytes, Ack: AckNone, AckTimeout: DefaultAckTimeout, AckInterval: DefaultAckInterval},
+			false,
+			``,
+		},
+		{
+			"all environment variables set including spool",
+			func() {
+				t.Setenv("SPLUNK_INDEX", "test")
+				t.Setenv("SPLUNK_ENDPOINT", "test")
+				t.Setenv("SPLUNK_TOKEN", "test123")
+				t.Setenv("HOST", "test")
+				t.Setenv("NAMESPACE", "test")
+				t.Setenv("POD_NAME", "test")
+				t.Setenv("SPLUNK_SPOOL_DIR", "/test")
+				t.Setenv("SPLUNK_SPOOL_MAX_BYTES", "1024")
+			},
+			&Env{Index: "test", Endpoint: "test", Token: "test123", Host: "test", Namespace: "test", Pod: "test", Retries: DefaultRetries, RetryBackoff: DefaultRetryBackoff, RetryTimeout: DefaultRetryTimeout, BreakerThreshold: DefaultBreakerThreshold, BreakerCooldown: DefaultBreakerCooldown, SpoolDir: "/test", SpoolMaxBytes: 1024, Ack: AckNone, AckTimeout: DefaultAckTimeout, AckInterval: DefaultAckInterval},
+			false,
+			``,
+		},
+		{
+			"invalid SPLUNK_RETRIES environment variable",
+			func() {
+				t.Setenv("SPLUNK_INDEX", "test")
+				t.Setenv("SPLUNK_ENDPOINT", "test")
+				t.Setenv("SPLUNK_TOKEN", "test123")
+				t.Setenv("HOST", "test")
+				t.Setenv("NAMESPACE", "test")
+				t.Setenv("POD_NAME", "test")
+				t.Setenv("SPLUNK_RETRIES", "-1")
+			},
 			&Env{Index: "test", Endpoint: "test", Token: "test123", Host: "test", Namespace: "test", Pod: "test"},
+			true,
+			`unable to convert environment variable: SPLUNK_RETRIES`,
+		},
+		{
+			"invalid SPLUNK_RETRY_TIMEOUT environment variable",
+			func() {
+				t.Setenv("SPLUNK_INDEX", "test")
+				t.Setenv("SPLUNK_ENDPOINT", "test")
+				t.Setenv("SPLUNK_TOKEN", "test123")
+				t.Setenv("HOST", "test")
+				t.Setenv("NAMESPACE", "test")
+				t.Setenv("POD_NAME", "test")
+				t.Setenv("SPLUNK_RETRY_TIMEOUT", "0s")
+			},
+			&Env{Index: "test", Endpoint: "test", Token: "test123", Host: "test", Namespace: "test", Pod: "test", Retries: DefaultRetries, RetryBackoff: DefaultRetryBackoff},
+			true,
+			`unable to convert environment variable: SPLUNK_RETRY_TIMEOUT`,
+		},
+		{
+			"invalid SPLUNK_BREAKER_COOLDOWN environment variable",
+			func() {
+				t.Setenv("SPLUNK_INDEX", "test")
+				t.Setenv("SPLUNK_ENDPOINT", "test")
+				t.Setenv("SPLUNK_TOKEN", "test123")
+				t.Setenv("HOST", "test")
+				t.Setenv("NAMESPACE", "test")
+				t.Setenv("POD_NAME", "test")
+				t.Setenv("SPLUNK_BREAKER_COOLDOWN", "test")
+			},
+			&Env{Index: "test", Endpoint: "test", Token: "test123", Host: "test", Namespace: "test", Pod: "test", Retries: DefaultRetries, RetryBackoff: DefaultRetryBackoff, RetryTimeout: DefaultRetryTimeout, BreakerThreshold: DefaultBreakerThreshold},
+			true,
+			`unable to convert environment variable: SPLUNK_BREAKER_COOLDOWN`,
+		},
+		{
+			"invalid SPLUNK_SPOOL_MAX_BYTES environment variable",
+			func() {
+				t.Setenv("SPLUNK_INDEX", "test")
+				t.Setenv("SPLUNK_ENDPOINT", "test")
+				t.Setenv("SPLUNK_TOKEN", "test123")
+				t.Setenv("HOST", "test")
+				t.Setenv("NAMESPACE", "test")
+				t.Setenv("POD_NAME", "test")
+				t.Setenv("SPLUNK_SPOOL_DIR", "/test")
+				t.Setenv("SPLUNK_SPOOL_MAX_BYTES", "0")
+			},
+			&Env{Index: "test", Endpoint: "test", Token: "test123", Host: "test", Namespace: "test", Pod: "test", Retries: DefaultRetries, RetryBackoff: DefaultRetryBackoff, RetryTimeout: DefaultRetryTimeout, BreakerThreshold: DefaultBreakerThreshold, BreakerCooldown: DefaultBreakerCooldown, SpoolDir: "/test"},
+			true,
+			`unable to convert environment variable: SPLUNK_SPOOL_MAX_BYTES`,
+		},
+		{
+			"all environment variables set including acknowledgment",
+			func() {
+				t.Setenv("SPLUNK_INDEX", "test")
+				t.Setenv("SPLUNK_ENDPOINT", "test")
+				t.Setenv("SPLUNK_TOKEN", "test123")
+				t.Setenv("HOST", "test")
+				t.Setenv("NAMESPACE", "test")
+				t.Setenv("POD_NAME", "test")
+				t.Setenv("SPLUNK_ACK", "wait")
+				t.Setenv("SPLUNK_ACK_TIMEOUT", "30s")
+				t.Setenv("SPLUNK_ACK_INTERVAL", "100ms")
+			},
+			&Env{Index: "test", Endpoint: "test", Token: "test123", Host: "test", Namespace: "test", Pod: "test", Retries: DefaultRetries, RetryBackoff: DefaultRetryBackoff, RetryTimeout: DefaultRetryTimeout, BreakerThreshold: DefaultBreakerThreshold, BreakerCooldown: DefaultBreakerCooldown, SpoolMaxBytes: DefaultSpoolMaxBytes, Ack: AckWait, AckTimeout: 30 * time.Second, AckInterval: 100 * time.Millisecond},
 			false,
 			``,
 		},
+		{
+			"invalid SPLUNK_ACK environment variable",
+			func() {
+				t.Setenv("SPLUNK_INDEX", "test")
+				t.Setenv("SPLUNK_ENDPOINT", "test")
+				t.Setenv("SPLUNK_TOKEN", "test123")
+				t.Setenv("HOST", "test")
+				t.Setenv("NAMESPACE", "test")
+				t.Setenv("POD_NAME", "test")
+				t.Setenv("SPLUNK_ACK", "test")
+			},
+			&Env{Index: "test", Endpoint: "test", Token: "test123", Host: "test", Namespace: "test", Pod: "test", Retries: DefaultRetries, RetryBackoff: DefaultRetryBackoff, RetryTimeout: DefaultRetryTimeout, BreakerThreshold: DefaultBreakerThreshold, BreakerCooldown: DefaultBreakerCooldown, SpoolMaxBytes: DefaultSpoolMaxBytes, Ack: AckNone},
+			true,
+			`unable to convert environment variable: SPLUNK_ACK`,
+		},
 		{
 			"missing required SPLUNK_INDEX environment variable",
 			func() {
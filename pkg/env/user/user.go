@@ -17,6 +17,7 @@ const ExpiryDateLayout = "2006-01-02"
 type Env struct {
 	Expiration time.Time `json:"expiration"`
 	Users      []string  `json:"users"`
+	Admins     []string  `json:"admins,omitempty"`
 }
 
 func NewUserEnv() *Env {
@@ -47,15 +48,10 @@ func (u *Env) Populate() error {
 	}
 
 	if users := os.Getenv("AUTHORIZED_USERS"); users != "" {
-		ss := strings.Split(users, ",")
-		aux := make([]string, 0, len(ss))
-
-		for _, entry := range ss {
-			if s := strings.Trim(entry, " "); s != "" {
-				aux = append(aux, s)
-			}
-		}
-		u.Users = aux
+		u.Users = splitUsers(users)
+	}
+	if admins := os.Getenv("AUTHORIZED_ADMINS"); admins != "" {
+		u.Admins = splitUsers(admins)
 	}
 
 	return nil
@@ -65,6 +61,17 @@ func (u *Env) IsExpired() bool {
 	return u.Expiration.Before(time.Now())
 }
 
+// IsAdmin returns whether the user can act on behalf of other users,
+// such as cancelling their queries.
+func (u *Env) IsAdmin(user string) bool {
+	for _, admin := range u.Admins {
+		if user == admin {
+			return true
+		}
+	}
+	return false
+}
+
 func (u *Env) MarshalJSON() ([]byte, error) {
 	type alias Env
 
@@ -76,6 +83,9 @@ func (u *Env) MarshalJSON() ([]byte, error) {
 		Expiration: u.Expiration.Format(ExpiryDateLayout),
 	}
 	aux.Users = append([]string{}, u.Users...)
+	if len(u.Admins) > 0 {
+		aux.Admins = append([]string{}, u.Admins...)
+	}
 
 	json, err := json.Marshal(aux)
 	if err != nil {
@@ -124,5 +134,36 @@ func (u *Env) UnmarshalJSON(b []byte) error {
 		}
 	}
 
+	// Admins are optional.
+	if _, found := raw["admins"]; !found {
+		return nil
+	}
+	admins, ok := raw["admins"].([]any)
+	if !ok {
+		return fmt.Errorf("unable to parse admins list: %v", raw["admins"])
+	}
+
+	for _, v := range admins {
+		admin, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("unable to parse admin: %v", v)
+		}
+		if s := strings.Trim(admin, " "); s != "" {
+			u.Admins = append(u.Admins, s)
+		}
+	}
+
 	return nil
 }
+
+func splitUsers(users string) []string {
+	ss := strings.Split(users, ",")
+	aux := make([]string, 0, len(ss))
+
+	for _, entry := range ss {
+		if s := strings.Trim(entry, " "); s != "" {
+			aux = append(aux, s)
+		}
+	}
+	return aux
+}
@@ -37,6 +37,18 @@ func TestPopulate(t *testing.T) {
 			false,
 			``,
 		},
+		{
+			"not using configuration file with admins set",
+			func() string {
+				t.Setenv("EXPIRATION_DATE", "2023-01-01")
+				t.Setenv("AUTHORIZED_USERS", "test, test2")
+				t.Setenv("AUTHORIZED_ADMINS", "test2")
+				return ""
+			},
+			&Env{Expiration: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC), Users: []string{"test", "test2"}, Admins: []string{"test2"}},
+			false,
+			``,
+		},
 		{
 			"not using configuration file with no environment variables set",
 			func() string {
@@ -223,6 +235,16 @@ func TestIsExpired(t *testing.T) {
 	}
 }
 
+func TestIsAdmin(t *testing.T) {
+	t.Parallel()
+
+	u := &Env{Users: []string{"test", "test2"}, Admins: []string{"test2"}}
+
+	assert.False(t, u.IsAdmin("test"))
+	assert.True(t, u.IsAdmin("test2"))
+	assert.False(t, u.IsAdmin(""))
+}
+
 func TestMarshalJSON(t *testing.T) {
 	t.Parallel()
 
@@ -271,6 +293,11 @@ func TestMarshalJSON(t *testing.T) {
 			Env{Users: []string{"test"}, Expiration: time.Time{}},
 			`{"users":["test"],"expiration":"0001-01-01"}`,
 		},
+		{
+			"users, admins and expiration date",
+			Env{Expiration: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC), Users: []string{"test"}, Admins: []string{"test"}},
+			`{"users":["test"],"admins":["test"],"expiration":"2023-01-01"}`,
+		},
 		{
 			"no users and empty expiration date",
 			Env{Expiration: time.Time{}},
@@ -308,6 +335,20 @@ func TestUnmarshalJSON(t *testing.T) {
 			false,
 			``,
 		},
+		{
+			"valid JSON with users, admins and expiration date",
+			`{"users":["test"],"admins":["test"],"expiration":"2023-01-01"}`,
+			Env{Expiration: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC), Users: []string{"test"}, Admins: []string{"test"}},
+			false,
+			``,
+		},
+		{
+			"valid JSON with admins set to invalid value",
+			`{"users":["test"],"admins":"test","expiration":"2023-01-01"}`,
+			Env{Expiration: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC), Users: []string{"test"}},
+			true,
+			`unable to parse admins list`,
+		},
 		{
 			"valid JSON with users and expiration date set to null",
 			`{"users":null,"expiration":null}`,
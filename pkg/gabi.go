@@ -10,8 +10,13 @@ import (
 	"time"
 
 	"github.com/app-sre/gabi/pkg/audit"
+	"github.com/app-sre/gabi/pkg/cache"
+	"github.com/app-sre/gabi/pkg/cursor"
 	"github.com/app-sre/gabi/pkg/env/db"
+	"github.com/app-sre/gabi/pkg/env/query"
 	"github.com/app-sre/gabi/pkg/env/user"
+	"github.com/app-sre/gabi/pkg/job"
+	"github.com/app-sre/gabi/pkg/running"
 	"go.uber.org/zap"
 )
 
@@ -24,13 +29,17 @@ const (
 )
 
 type Config struct {
-	DB          *sql.DB
-	DBEnv       *db.Env
-	UserEnv     *user.Env
-	LoggerAudit audit.Audit
-	SplunkAudit audit.Audit
-	Logger      *zap.SugaredLogger
-	Encoder     *base64.Encoding
+	DB       *sql.DB
+	DBEnv    *db.Env
+	QueryEnv *query.Env
+	UserEnv  *user.Env
+	Audit    *audit.Pipeline
+	Cursors  *cursor.Store
+	Queries  *running.Registry
+	Jobs     *job.Store
+	Cache    *cache.Store
+	Logger   *zap.SugaredLogger
+	Encoder  *base64.Encoding
 	sync.Mutex
 }
 
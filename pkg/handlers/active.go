@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	gabi "github.com/app-sre/gabi/pkg"
+	"github.com/app-sre/gabi/pkg/middleware"
+	"github.com/app-sre/gabi/pkg/models"
+)
+
+// The query text is only meant to identify the query.
+const activeQueryLength = 512
+
+// ActiveQueries lists the queries that are running, oldest first. Users
+// only see their own queries, whereas admins see all of them.
+func ActiveQueries(cfg *gabi.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if cfg.Queries == nil {
+			http.Error(w, "Listing queries is not supported", http.StatusBadRequest)
+			return
+		}
+
+		user, _ := r.Context().Value(middleware.ContextKeyUser).(string)
+		admin := cfg.UserEnv.IsAdmin(user)
+
+		response := &models.ActiveQueriesResponse{
+			Queries: []models.ActiveQuery{},
+		}
+		for _, q := range cfg.Queries.List() {
+			if q.User != user && !admin {
+				continue
+			}
+
+			query := models.ActiveQuery{
+				ID:       q.ID,
+				User:     q.User,
+				Database: q.Database,
+				Query:    q.Query,
+				Started:  q.Start.UTC(),
+			}
+			if s := []rune(q.Query); len(s) > activeQueryLength {
+				query.Query = string(s[:activeQueryLength])
+				query.Truncated = true
+			}
+			if backend := q.Backend(); backend != 0 {
+				query.Backend = &backend
+			}
+			response.Queries = append(response.Queries, query)
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Header().Set("Cache-Control", "private, no-store")
+
+		err := json.NewEncoder(w).Encode(response)
+		if err != nil {
+			cfg.Logger.Errorf("Unable to send response: %s", err)
+		}
+	}
+}
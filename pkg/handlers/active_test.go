@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/app-sre/gabi/internal/test"
+	gabi "github.com/app-sre/gabi/pkg"
+	"github.com/app-sre/gabi/pkg/env/user"
+	"github.com/app-sre/gabi/pkg/middleware"
+	"github.com/app-sre/gabi/pkg/models"
+	"github.com/app-sre/gabi/pkg/running"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestActiveQueries(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		description string
+		user        string
+		users       []string
+		queries     []string
+	}{
+		{
+			"queries listed for their owner",
+			"test",
+			[]string{"test", "test"},
+			[]string{"select 1;", strings.Repeat("a", activeQueryLength)},
+		},
+		{
+			"queries of every user listed for an admin",
+			"admin",
+			[]string{"test", "test", "other"},
+			[]string{"select 1;", strings.Repeat("a", activeQueryLength), "select 2;"},
+		},
+		{
+			"no queries listed for user without any running",
+			"none",
+			[]string{},
+			[]string{},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			var output bytes.Buffer
+
+			queries := running.NewRegistry()
+
+			for _, given := range []struct{ user, query string }{
+				{"test", "select 1;"},
+				{"test", strings.Repeat("a", activeQueryLength+1)},
+				{"other", "select 2;"},
+			} {
+				_, q, err := queries.Start(context.TODO(), given.user, "test", given.query)
+				require.NoError(t, err)
+				q.SetBackend(42)
+				defer q.Finish()
+			}
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			ctx := context.WithValue(context.TODO(), middleware.ContextKeyUser, tc.user)
+
+			expected := &gabi.Config{
+				UserEnv: &user.Env{Admins: []string{"admin"}},
+				Queries: queries,
+				Logger:  test.DummyLogger(&output).Sugar(),
+			}
+			ActiveQueries(expected).ServeHTTP(w, r.WithContext(ctx))
+
+			var actual models.ActiveQueriesResponse
+
+			require.Equal(t, 200, w.Code)
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &actual))
+			require.Len(t, actual.Queries, len(tc.queries))
+
+			for i, q := range actual.Queries {
+				assert.Equal(t, tc.users[i], q.User)
+				assert.Equal(t, tc.queries[i], q.Query)
+				assert.Equal(t, len(q.Query) == activeQueryLength, q.Truncated)
+				assert.Equal(t, "test", q.Database)
+				assert.Equal(t, int64(42), *q.Backend)
+				assert.Len(t, q.ID, 32)
+			}
+		})
+	}
+}
+
+func TestActiveQueriesNotSupported(t *testing.T) {
+	t.Parallel()
+
+	var output bytes.Buffer
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	cfg := &gabi.Config{Logger: test.DummyLogger(&output).Sugar()}
+	ActiveQueries(cfg).ServeHTTP(w, r)
+
+	assert.Equal(t, 400, w.Code)
+	assert.Contains(t, w.Body.String(), "Listing queries is not supported")
+}
@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/app-sre/gabi/pkg/models"
+)
+
+// queryArgs converts the arguments of a query into values that the
+// database drivers bind to its placeholders, whether these are written
+// as $1 (PostgreSQL) or as ? (MySQL).
+func queryArgs(encoder *base64.Encoding, args []models.QueryArg) ([]any, error) {
+	values := make([]any, len(args))
+
+	for i, arg := range args {
+		value, err := queryArg(encoder, arg)
+		if err != nil {
+			return nil, fmt.Errorf("argument %d: %w", i+1, err)
+		}
+		values[i] = value
+	}
+
+	return values, nil
+}
+
+func queryArg(encoder *base64.Encoding, arg models.QueryArg) (any, error) {
+	if len(arg.Value) == 0 {
+		return nil, errors.New("value is missing")
+	}
+
+	var value any
+
+	decoder := json.NewDecoder(bytes.NewReader(arg.Value))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return nil, fmt.Errorf("unable to decode value: %w", err)
+	}
+	if value == nil {
+		return nil, nil
+	}
+
+	if arg.Type == "" {
+		return untypedArg(value, arg.Value)
+	}
+
+	class, ok := argType(arg.Type)
+	if !ok {
+		return nil, fmt.Errorf("unsupported type: %s", arg.Type)
+	}
+	if class == classJSON {
+		return compactJSON(arg.Value)
+	}
+
+	switch v := value.(type) {
+	case json.Number:
+		switch class {
+		case classInteger:
+			return v.Int64()
+		case classNumeric:
+			return v.String(), nil
+		}
+	case bool:
+		if class == classBoolean {
+			return v, nil
+		}
+	case string:
+		switch class {
+		case classText:
+			return v, nil
+		case classInteger:
+			return strconv.ParseInt(v, 10, 64)
+		case classNumeric:
+			if _, err := strconv.ParseFloat(v, 64); err != nil {
+				return nil, err
+			}
+			return v, nil
+		case classBoolean:
+			return strconv.ParseBool(v)
+		case classDate:
+			return time.Parse(time.DateOnly, v)
+		case classTimestamp:
+			return time.Parse(time.RFC3339Nano, v)
+		case classBinary:
+			return encoder.DecodeString(v)
+		}
+	}
+
+	return nil, fmt.Errorf("value %s cannot be bound as %s", arg.Value, arg.Type)
+}
+
+// Without a type hint, the type is inferred from the JSON value, and
+// objects and arrays are bound as JSON text.
+func untypedArg(value any, raw json.RawMessage) (any, error) {
+	switch v := value.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i, nil
+		}
+		return v.Float64()
+	case bool, string:
+		return v, nil
+	default:
+		return compactJSON(raw)
+	}
+}
+
+// argType accepts both generic type names, such as "integer" or
+// "timestamp", and the names of database types.
+func argType(hint string) (typeClass, bool) {
+	class := classifyType(hint)
+	if class != classText {
+		return class, true
+	}
+
+	switch strings.ToUpper(strings.TrimSpace(hint)) {
+	case "TEXT", "STRING", "CHAR", "VARCHAR", "UUID":
+		return classText, true
+	default:
+		return classText, false
+	}
+}
+
+func compactJSON(raw json.RawMessage) (string, error) {
+	var b bytes.Buffer
+	if err := json.Compact(&b, raw); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/app-sre/gabi/pkg/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueryArgs(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		description string
+		given       string
+		want        []any
+		error       string
+	}{
+		{
+			"no arguments",
+			`[]`,
+			[]any{},
+			``,
+		},
+		{
+			"arguments without type hints",
+			`[1, 1.5, "test", true, null, [1, 2], {"value": {"a": 1}}]`,
+			[]any{int64(1), 1.5, "test", true, nil, `[1,2]`, `{"a":1}`},
+			``,
+		},
+		{
+			"arguments with generic type hints",
+			`[
+				{"value": "1", "type": "integer"},
+				{"value": 1.10, "type": "numeric"},
+				{"value": "true", "type": "boolean"},
+				{"value": "2023-01-01", "type": "date"},
+				{"value": "2023-01-01T12:00:00Z", "type": "timestamp"},
+				{"value": {"a": 1}, "type": "json"},
+				{"value": "dGVzdA==", "type": "binary"},
+				{"value": "1", "type": "text"},
+				{"value": null, "type": "integer"}
+			]`,
+			[]any{
+				int64(1),
+				"1.10",
+				true,
+				time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+				time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC),
+				`{"a":1}`,
+				[]byte("test"),
+				"1",
+				nil,
+			},
+			``,
+		},
+		{
+			"arguments with database type hints",
+			`[{"value": 1, "type": "int8"}, {"value": "2023-01-01T12:00:00Z", "type": "timestamptz"}, {"value": "a", "type": "varchar"}]`,
+			[]any{int64(1), time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC), "a"},
+			``,
+		},
+		{
+			"argument with unsupported type hint",
+			`[1, {"value": 1, "type": "test"}]`,
+			nil,
+			`argument 2: unsupported type: test`,
+		},
+		{
+			"argument with value that does not match type hint",
+			`[{"value": true, "type": "integer"}]`,
+			nil,
+			`argument 1: value true cannot be bound as integer`,
+		},
+		{
+			"argument with malformed value",
+			`[{"value": "test", "type": "date"}]`,
+			nil,
+			`argument 1: parsing time "test"`,
+		},
+		{
+			"argument with value missing",
+			`[{"type": "text"}]`,
+			nil,
+			`argument 1: value is missing`,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			var args []models.QueryArg
+
+			err := json.Unmarshal([]byte(tc.given), &args)
+			require.NoError(t, err)
+
+			actual, err := queryArgs(base64.StdEncoding, args)
+
+			if tc.error != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.error)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, tc.want, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,347 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	gabi "github.com/app-sre/gabi/pkg"
+	"github.com/app-sre/gabi/pkg/audit"
+	"github.com/app-sre/gabi/pkg/middleware"
+	"github.com/app-sre/gabi/pkg/models"
+)
+
+// Batch runs every statement of a batch, in order, within a single
+// transaction, which is rolled back should any of them fail. As the
+// outcome is only known once all of them have run, the results are
+// sent once the transaction has been committed.
+func Batch(cfg *gabi.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		var (
+			decodeQuery bool
+			request     models.BatchRequest
+		)
+
+		results, _ := ctx.Value(middleware.ContextKeyResults).([]*audit.ResultData)
+
+		// Recorded for every statement however executing the batch went,
+		// even when it has not been executed at all.
+		ow := &outcomeWriter{ResponseWriter: w}
+		defer func(started time.Time) { ow.outcome(started, results...) }(time.Now())
+		w = ow
+
+		if s := r.URL.Query().Get("format"); s != "" && s != formatJSON {
+			l := fmt.Sprintf("Unsupported result format: %s", s)
+			http.Error(w, l, http.StatusBadRequest)
+			return
+		}
+
+		mode, encoding, err := resultEncoding(r)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Unable to process result encoding: %s", err), http.StatusBadRequest)
+			return
+		}
+
+		if s := r.URL.Query().Get("base64_query"); s != "" {
+			if ok, err := strconv.ParseBool(s); err == nil && ok {
+				decodeQuery = true
+			}
+		}
+
+		err = json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			cfg.Logger.Errorf("Unable to decode request body: %s", err)
+			if errors.Is(err, io.EOF) {
+				http.Error(w, "Request body cannot be empty", http.StatusBadRequest)
+				return
+			}
+			_ = queryErrorResponse(ctx, w, err)
+			return
+		}
+
+		if len(request.Statements) == 0 {
+			http.Error(w, "Batch must contain at least one statement", http.StatusBadRequest)
+			return
+		}
+		if request.RowLimit < 0 || request.ByteLimit < 0 {
+			http.Error(w, "Query limits cannot be negative", http.StatusBadRequest)
+			return
+		}
+
+		r, cancel, err := withTimeout(r, request.Timeout)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Unable to process query timeout: %s", err), http.StatusBadRequest)
+			return
+		}
+		defer cancel()
+		ctx = r.Context()
+
+		// The queries might have already been decoded upstream.
+		ctxQueries, _ := ctx.Value(middleware.ContextKeyQueries).([]string)
+		if len(ctxQueries) == len(request.Statements) {
+			for i := range request.Statements {
+				request.Statements[i].Query = ctxQueries[i]
+			}
+		} else if decodeQuery {
+			for i := range request.Statements {
+				bytes, err := cfg.Encoder.DecodeString(request.Statements[i].Query)
+				if err != nil {
+					l := "Unable to decode Base64-encoded query"
+					cfg.Logger.Errorf("%s: %s", l, err)
+					http.Error(w, l, http.StatusBadRequest)
+					return
+				}
+				request.Statements[i].Query = string(bytes)
+			}
+		}
+
+		args := make([][]any, len(request.Statements))
+		for i, statement := range request.Statements {
+			args[i], err = queryArgs(cfg.Encoder, statement.Args)
+			if err != nil {
+				l := "Unable to process query arguments"
+				cfg.Logger.Errorf("%s: statement %d: %s", l, i+1, err)
+				http.Error(w, fmt.Sprintf("%s: statement %d: %s", l, i+1, err), http.StatusBadRequest)
+				return
+			}
+		}
+
+		if len(results) != len(request.Statements) {
+			results = make([]*audit.ResultData, len(request.Statements))
+			for i := range results {
+				results[i] = &audit.ResultData{}
+			}
+		}
+
+		rowLimit, byteLimit := queryLimits(cfg, &models.QueryRequest{RowLimit: request.RowLimit, ByteLimit: request.ByteLimit})
+		for _, result := range results {
+			result.RowLimit, result.ByteLimit = rowLimit, byteLimit
+		}
+
+		batchID, _ := ctx.Value(middleware.ContextKeyBatchID).(string)
+
+		response := &models.BatchResponse{
+			BatchID: batchID,
+			Results: make([]models.BatchResult, 0, len(request.Statements)),
+		}
+
+		queries := make([]string, len(request.Statements))
+		for i, statement := range request.Statements {
+			queries[i] = statement.Query
+		}
+		r, finish, err := startQuery(w, r, cfg, strings.Join(queries, "\n"))
+		if err != nil {
+			cfg.Logger.Errorf("Unable to register query: %s", err)
+			http.Error(w, "An internal error has occurred", http.StatusInternalServerError)
+			return
+		}
+		defer finish()
+		ctx = r.Context()
+
+		tx, release, err := beginTx(ctx, cfg)
+		if err != nil {
+			cfg.Logger.Errorf("Unable to start database transaction: %s", err)
+			_ = queryErrorResponse(ctx, w, err)
+			return
+		}
+		defer release()
+		defer func() { _ = tx.Rollback() }()
+
+		err = setStatementTimeout(ctx, cfg, tx)
+		if err != nil {
+			cfg.Logger.Errorf("Unable to set statement timeout: %s", err)
+			_ = queryErrorResponse(ctx, w, err)
+			return
+		}
+
+		untrack, err := trackBackend(ctx, cfg, tx)
+		if err != nil {
+			cfg.Logger.Errorf("Unable to identify database connection: %s", err)
+			_ = queryErrorResponse(ctx, w, err)
+			return
+		}
+		defer untrack()
+
+		b := &batch{
+			cfg:   cfg,
+			tx:    tx,
+			cells: newCellEncoder(cfg, mode, encoding),
+		}
+
+		for i, statement := range request.Statements {
+			result, err := b.run(ctx, &statement, args[i], results[i])
+			if err != nil {
+				cfg.Logger.Errorf("Unable to run statement %d of batch %s: %s", i+1, batchID, err)
+				_ = batchErrorResponse(ctx, w, response, fmt.Errorf("statement %d: %w", i+1, err))
+				return
+			}
+			response.Results = append(response.Results, result)
+		}
+
+		untrack()
+		err = tx.Commit()
+		if err != nil {
+			cfg.Logger.Errorf("Unable to commit database changes: %s", err)
+			_ = batchErrorResponse(ctx, w, response, err)
+			return
+		}
+
+		// Only changes that have been committed are audited.
+		for i, result := range response.Results {
+			results[i].RowsAffected = result.RowsAffected
+			results[i].LastInsertID = result.LastInsertID
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Header().Set("Cache-Control", "private, no-store")
+		if mode != encodeNone {
+			w.Header().Set(encodingHeader, encoding)
+		}
+
+		err = json.NewEncoder(w).Encode(response)
+		if err != nil {
+			cfg.Logger.Errorf("Unable to send response: %s", err)
+		}
+	}
+}
+
+// batch runs the statements of a batch, keeping track of the size of
+// the results, to which the byte limit applies as a whole.
+type batch struct {
+	cfg   *gabi.Config
+	tx    *sql.Tx
+	cells *cellEncoder
+	size  int64
+}
+
+func (b *batch) run(ctx context.Context, statement *models.BatchStatement, args []any, result *audit.ResultData) (models.BatchResult, error) {
+	if statement.Exec {
+		r, err := b.tx.ExecContext(ctx, statement.Query, args...)
+		if err != nil {
+			return models.BatchResult{}, err
+		}
+		n, err := r.RowsAffected()
+		if err != nil {
+			return models.BatchResult{}, err
+		}
+		res := models.BatchResult{RowsAffected: &n}
+		if id, err := r.LastInsertId(); err == nil {
+			res.LastInsertID = &id
+		}
+		return res, nil
+	}
+
+	rows, err := b.tx.QueryContext(ctx, statement.Query, args...)
+	if err != nil {
+		return models.BatchResult{}, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		return models.BatchResult{}, err
+	}
+	b.cells.Begin(types)
+
+	cols := make([]string, len(types))
+	vals := make([]any, len(types))
+	for i := range types {
+		cols[i] = types[i].Name()
+		vals[i] = new(sql.RawBytes)
+	}
+
+	res := models.BatchResult{
+		Result: [][]string{append([]string{}, cols...)},
+	}
+
+	for rows.Next() {
+		if result.RowLimit > 0 && result.Rows >= result.RowLimit {
+			result.Truncated = true
+			break
+		}
+
+		err := rows.Scan(vals...)
+		if err != nil {
+			return models.BatchResult{}, err
+		}
+
+		row := make([]string, len(vals))
+		for i, value := range vals {
+			row[i] = b.cells.Value(i, *value.(*sql.RawBytes))
+		}
+
+		content, err := json.Marshal(row)
+		if err != nil {
+			return models.BatchResult{}, err
+		}
+		size := int64(len(content)) + 1
+		if result.ByteLimit > 0 && b.size+size > result.ByteLimit {
+			result.Truncated = true
+			break
+		}
+		b.size += size
+
+		res.Result = append(res.Result, row)
+		result.Rows++
+		b.cells.Next()
+	}
+	if err := rows.Err(); err != nil {
+		return models.BatchResult{}, err
+	}
+	if err := rows.Close(); err != nil {
+		return models.BatchResult{}, err
+	}
+
+	if result.Truncated {
+		res.Truncated = true
+		res.Rows = result.Rows
+	}
+	res.EncodedColumns = b.cells.Columns()
+	res.EncodedCells = b.cells.Cells(result.Rows)
+	res.EncodedFrom = b.cells.From(result.Rows)
+
+	return res, nil
+}
+
+func batchErrorResponse(ctx context.Context, w http.ResponseWriter, response *models.BatchResponse, err error) error {
+	err = interruptedError(ctx, err)
+	recordError(w, err)
+
+	if connectionError(err) {
+		http.Error(w, connectionErrorMessage, http.StatusServiceUnavailable)
+		return nil
+	}
+	if timeoutError(err) {
+		http.Error(w, timeoutErrorMessage, http.StatusGatewayTimeout)
+		return nil
+	}
+	if cancelledError(err) {
+		http.Error(w, cancelledErrorMessage, http.StatusConflict)
+		return nil
+	}
+
+	// The transaction has been rolled back, so none of the results stand.
+	response.Results = nil
+	response.Error = err.Error()
+
+	var code int
+	response.DatabaseError, code = databaseError(err)
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		return fmt.Errorf("unable to marshal error response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,386 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/app-sre/gabi/internal/test"
+	gabi "github.com/app-sre/gabi/pkg"
+	"github.com/app-sre/gabi/pkg/audit"
+	gabidb "github.com/app-sre/gabi/pkg/env/db"
+	"github.com/app-sre/gabi/pkg/middleware"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBatch(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		description string
+		mock        func(sqlmock.Sqlmock)
+		context     func() context.Context
+		parameters  func(*http.Request)
+		request     func() *bytes.Buffer
+		code        int
+		body        string
+		want        string
+	}{
+		{
+			"valid batch",
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectExec(`update test set name = \$1 where id = \$2;`).
+					WithArgs("test", int64(1)).
+					WillReturnResult(driver.RowsAffected(1))
+				mock.ExpectQuery(`select name from test where id = 1;`).
+					WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("test"))
+				mock.ExpectCommit()
+			},
+			func() context.Context {
+				return context.WithValue(context.TODO(), middleware.ContextKeyBatchID, "test")
+			},
+			func(r *http.Request) {
+				// No-op.
+			},
+			func() *bytes.Buffer {
+				return bytes.NewBufferString(`{"statements": [
+					{"query": "update test set name = $1 where id = $2;", "args": ["test", 1], "exec": true},
+					{"query": "select name from test where id = 1;"}
+				]}`)
+			},
+			200,
+			`{"batch_id":"test","results":[{"rows_affected":1},{"result":[["name"],["test"]]}],"error":""}`,
+			``,
+		},
+		{
+			"valid batch with SQL statements passed via context",
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(`select 2;`).
+					WillReturnRows(sqlmock.NewRows([]string{"?column?"}).AddRow("2"))
+				mock.ExpectCommit()
+			},
+			func() context.Context {
+				return context.WithValue(context.TODO(), middleware.ContextKeyQueries, []string{"select 2;"})
+			},
+			func(r *http.Request) {
+				// No-op.
+			},
+			func() *bytes.Buffer {
+				return bytes.NewBufferString(`{"statements": [{"query": "select 1;"}]}`)
+			},
+			200,
+			`{"results":[{"result":[["?column?"],["2"]]}],"error":""}`,
+			``,
+		},
+		{
+			"valid batch with Base64-encoded queries and results",
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(`select 1;`).
+					WillReturnRows(sqlmock.NewRows([]string{"?column?"}).AddRow("1"))
+				mock.ExpectCommit()
+			},
+			func() context.Context {
+				return context.TODO()
+			},
+			func(r *http.Request) {
+				q := r.URL.Query()
+				q.Add("base64_query", "true")
+				q.Add("base64_results", "true")
+				r.URL.RawQuery = q.Encode()
+			},
+			func() *bytes.Buffer {
+				return bytes.NewBufferString(`{"statements": [{"query": "c2VsZWN0IDE7"}]}`)
+			},
+			200,
+			`{"results":[{"result":[["?column?"],["MQ=="]]}],"error":""}`,
+			``,
+		},
+		{
+			"valid batch with only binary values encoded",
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(`select name, data from test;`).
+					WillReturnRows(sqlmock.NewRowsWithColumnDefinition(
+						sqlmock.NewColumn("name").OfType("TEXT", ""),
+						sqlmock.NewColumn("data").OfType("BYTEA", []byte{}),
+					).AddRow("test", []byte{0xde, 0xad}).AddRow([]byte{0xff, 0xfe}, nil))
+				mock.ExpectCommit()
+			},
+			func() context.Context {
+				return context.TODO()
+			},
+			func(r *http.Request) {
+				q := r.URL.Query()
+				q.Add("encode", "auto")
+				q.Add("encoding", "hex")
+				r.URL.RawQuery = q.Encode()
+			},
+			func() *bytes.Buffer {
+				return bytes.NewBufferString(`{"statements": [{"query": "select name, data from test;"}]}`)
+			},
+			200,
+			`{"results":[{"result":[["name","data"],["test","dead"],["fffe",""]],"encoded_columns":[1],"encoded_cells":[[1,0]]}],"error":""}`,
+			``,
+		},
+		{
+			"valid batch with row limit",
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(`select id from test;`).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("1").AddRow("2"))
+				mock.ExpectCommit()
+			},
+			func() context.Context {
+				return context.TODO()
+			},
+			func(r *http.Request) {
+				// No-op.
+			},
+			func() *bytes.Buffer {
+				return bytes.NewBufferString(`{"statements": [{"query": "select id from test;"}], "row_limit": 1}`)
+			},
+			200,
+			`{"results":[{"result":[["id"],["1"]],"truncated":true,"rows":1}],"error":""}`,
+			``,
+		},
+		{
+			"invalid batch with statement that fails",
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectExec(`delete from test;`).
+					WillReturnResult(sqlmock.NewResult(0, 2))
+				mock.ExpectQuery(`select test;`).
+					WillReturnError(errors.New("test"))
+				mock.ExpectRollback()
+			},
+			func() context.Context {
+				return context.WithValue(context.TODO(), middleware.ContextKeyBatchID, "test")
+			},
+			func(r *http.Request) {
+				// No-op.
+			},
+			func() *bytes.Buffer {
+				return bytes.NewBufferString(`{"statements": [{"query": "delete from test;", "exec": true}, {"query": "select test;"}]}`)
+			},
+			400,
+			`{"batch_id":"test","results":null,"error":"statement 2: test"}`,
+			`Unable to run statement 2 of batch test: test`,
+		},
+		{
+			"invalid batch with database connection error",
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin().WillReturnError(&os.SyscallError{Syscall: "connect", Err: errors.New("test")})
+			},
+			func() context.Context {
+				return context.TODO()
+			},
+			func(r *http.Request) {
+				// No-op.
+			},
+			func() *bytes.Buffer {
+				return bytes.NewBufferString(`{"statements": [{"query": "select 1;"}]}`)
+			},
+			503,
+			`Unable to connect to the database`,
+			`Unable to start database transaction`,
+		},
+		{
+			"invalid batch with no statements",
+			func(mock sqlmock.Sqlmock) {
+				// No-op.
+			},
+			func() context.Context {
+				return context.TODO()
+			},
+			func(r *http.Request) {
+				// No-op.
+			},
+			func() *bytes.Buffer {
+				return bytes.NewBufferString(`{"statements": []}`)
+			},
+			400,
+			`Batch must contain at least one statement`,
+			``,
+		},
+		{
+			"invalid batch with argument that cannot be bound",
+			func(mock sqlmock.Sqlmock) {
+				// No-op.
+			},
+			func() context.Context {
+				return context.TODO()
+			},
+			func(r *http.Request) {
+				// No-op.
+			},
+			func() *bytes.Buffer {
+				return bytes.NewBufferString(`{"statements": [{"query": "select 1;"}, {"query": "select ?;", "args": [{"value": 1, "type": "test"}]}]}`)
+			},
+			400,
+			`Unable to process query arguments: statement 2: argument 1: unsupported type: test`,
+			``,
+		},
+		{
+			"invalid batch with unsupported result format",
+			func(mock sqlmock.Sqlmock) {
+				// No-op.
+			},
+			func() context.Context {
+				return context.TODO()
+			},
+			func(r *http.Request) {
+				q := r.URL.Query()
+				q.Add("format", "csv")
+				r.URL.RawQuery = q.Encode()
+			},
+			func() *bytes.Buffer {
+				return bytes.NewBufferString(`{"statements": [{"query": "select 1;"}]}`)
+			},
+			400,
+			`Unsupported result format: csv`,
+			``,
+		},
+		{
+			"invalid batch with empty body",
+			func(mock sqlmock.Sqlmock) {
+				// No-op.
+			},
+			func() context.Context {
+				return context.TODO()
+			},
+			func(r *http.Request) {
+				// No-op.
+			},
+			func() *bytes.Buffer {
+				return &bytes.Buffer{}
+			},
+			400,
+			`Request body cannot be empty`,
+			`Unable to decode request body`,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			var body, output bytes.Buffer
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/", tc.request())
+
+			logger := test.DummyLogger(&output).Sugar()
+			encoder := base64.StdEncoding
+
+			db, mock, _ := sqlmock.New()
+			defer func() { _ = db.Close() }()
+
+			tc.mock(mock)
+			tc.parameters(r)
+
+			expected := &gabi.Config{DB: db, DBEnv: &gabidb.Env{AllowWrite: true}, Logger: logger, Encoder: encoder}
+			Batch(expected).ServeHTTP(w, r.WithContext(tc.context()))
+
+			actual := w.Result()
+			defer func() { _ = actual.Body.Close() }()
+
+			_, _ = io.Copy(&body, actual.Body)
+
+			err := mock.ExpectationsWereMet()
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.code, actual.StatusCode)
+			assert.Contains(t, body.String(), tc.body)
+			assert.Contains(t, output.String(), tc.want)
+		})
+	}
+}
+
+func TestBatchResults(t *testing.T) {
+	t.Parallel()
+
+	var output bytes.Buffer
+
+	db, mock, _ := sqlmock.New()
+	defer func() { _ = db.Close() }()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`select id from test;`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("1").AddRow("2"))
+	mock.ExpectExec(`insert into test values \(3\);`).
+		WillReturnResult(sqlmock.NewResult(3, 1))
+	mock.ExpectCommit()
+
+	results := []*audit.ResultData{{}, {}}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(`{"statements": [{"query": "select id from test;"}, {"query": "insert into test values (3);", "exec": true}]}`))
+	ctx := context.WithValue(context.TODO(), middleware.ContextKeyResults, results)
+
+	cfg := &gabi.Config{DB: db, DBEnv: &gabidb.Env{AllowWrite: true}, Logger: test.DummyLogger(&output).Sugar(), Encoder: base64.StdEncoding}
+	Batch(cfg).ServeHTTP(w, r.WithContext(ctx))
+
+	require.NoError(t, mock.ExpectationsWereMet())
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, int64(2), results[0].Rows)
+	assert.Nil(t, results[0].RowsAffected)
+	rowsAffected, lastInsertID := int64(1), int64(3)
+
+	assert.Equal(t, &rowsAffected, results[1].RowsAffected)
+	assert.Equal(t, &lastInsertID, results[1].LastInsertID)
+	assert.Contains(t, w.Body.String(), `{"rows_affected":1,"last_insert_id":3}`)
+
+	// Every statement is given the outcome of the batch.
+	for _, result := range results {
+		assert.Equal(t, audit.StatusSuccess, result.Status)
+		assert.Equal(t, 200, result.Code)
+		assert.Equal(t, int64(w.Body.Len()), result.Bytes)
+	}
+}
+
+func TestBatchOutcome(t *testing.T) {
+	t.Parallel()
+
+	var output bytes.Buffer
+
+	db, mock, _ := sqlmock.New()
+	defer func() { _ = db.Close() }()
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`insert into test values \(3\);`).
+		WillReturnResult(sqlmock.NewResult(3, 1))
+	mock.ExpectExec(`insert into test values \(3\);`).
+		WillReturnError(&pgconn.PgError{Code: "23505"})
+	mock.ExpectRollback()
+
+	results := []*audit.ResultData{{}, {}}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(`{"statements": [{"query": "insert into test values (3);", "exec": true}, {"query": "insert into test values (3);", "exec": true}]}`))
+	ctx := context.WithValue(context.TODO(), middleware.ContextKeyResults, results)
+
+	cfg := &gabi.Config{DB: db, DBEnv: &gabidb.Env{AllowWrite: true}, Logger: test.DummyLogger(&output).Sugar(), Encoder: base64.StdEncoding}
+	Batch(cfg).ServeHTTP(w, r.WithContext(ctx))
+
+	require.NoError(t, mock.ExpectationsWereMet())
+	assert.Equal(t, 400, w.Code)
+	for _, result := range results {
+		assert.Equal(t, audit.StatusSQLError, result.Status)
+		assert.Equal(t, "23", result.ErrorClass)
+		assert.Equal(t, 400, result.Code)
+	}
+}
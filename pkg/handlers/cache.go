@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	gabi "github.com/app-sre/gabi/pkg"
+	"github.com/app-sre/gabi/pkg/audit"
+	"github.com/app-sre/gabi/pkg/cache"
+	"github.com/app-sre/gabi/pkg/models"
+)
+
+const (
+	cacheHeader = "X-Gabi-Cache"
+
+	cacheHit  = "hit"
+	cacheMiss = "miss"
+)
+
+// cacheHeaders are the headers describing a result, and thus kept along
+// with it.
+var cacheHeaders = map[string]bool{
+	"Content-Type":              true,
+	"Cache-Control":             true,
+	encodingHeader:              true,
+	streamTruncatedTrailer:      true,
+	streamRowsTrailer:           true,
+	streamEncodedColumnsTrailer: true,
+	streamEncodedCellsTrailer:   true,
+	streamEncodedFromTrailer:    true,
+	streamDigestTrailer:         true,
+}
+
+// Queries using dollar quoting, escapes or comments are left as they
+// are, as collapsing whitespace could change what they mean.
+var cacheVerbatimQuery = regexp.MustCompile(`\$\w*\$|\\|--|/\*|#`)
+
+// cacheable reports whether the result of the query can be served from
+// the cache, and kept in it, which is only the case when the instance
+// cannot write to the database, and the query is run in full.
+func cacheable(cfg *gabi.Config, request *models.QueryRequest) bool {
+	return cfg.Cache != nil && !cfg.DBEnv.AllowWrite &&
+		!request.Exec && !request.DryRun && request.PageSize == 0 && request.Cursor == ""
+}
+
+// cacheKey identifies a result by everything that has a bearing on
+// what is sent to the client.
+func cacheKey(cfg *gabi.Config, request *models.QueryRequest, format, mode, encoding string, result *audit.ResultData) string {
+	args, _ := json.Marshal(request.Args)
+
+	parts := []string{
+		cfg.GetCurrentDBName(),
+		normalizeQuery(request.Query),
+		string(args),
+		format,
+		mode,
+		encoding,
+		strconv.FormatInt(result.RowLimit, 10),
+		strconv.FormatInt(result.ByteLimit, 10),
+	}
+
+	h := sha256.New()
+	for _, part := range parts {
+		_, _ = h.Write([]byte(part))
+		_, _ = h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// normalizeQuery collapses whitespace outside of quoted literals and
+// identifiers, so that queries differing only in layout share a result.
+func normalizeQuery(query string) string {
+	query = strings.TrimSpace(query)
+	if cacheVerbatimQuery.MatchString(query) {
+		return query
+	}
+
+	var (
+		b     strings.Builder
+		quote rune
+		space bool
+	)
+	for _, r := range query {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case unicode.IsSpace(r):
+			space = true
+			continue
+		case r == '\'' || r == '"' || r == '`':
+			quote = r
+		}
+		if space {
+			b.WriteByte(' ')
+			space = false
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// cachedResponse sends the response kept under the given key, if any,
+// and records the result it carries as having come from the cache.
+func cachedResponse(cfg *gabi.Config, w http.ResponseWriter, key string, result *audit.ResultData) bool {
+	e, ok := cfg.Cache.Get(key)
+	if !ok {
+		return false
+	}
+
+	for name, values := range e.Header {
+		w.Header()[name] = values
+	}
+	w.Header().Set(cacheHeader, cacheHit)
+	w.WriteHeader(http.StatusOK)
+
+	*result = e.Result
+	result.CacheHit = true
+
+	_, err := w.Write(e.Body)
+	if err != nil {
+		cfg.Logger.Errorf("Unable to send response: %s", err)
+	}
+	return true
+}
+
+// cacheWriter keeps a copy of the response as it is being sent, up to
+// the size a response can have to be cached, so that it can be kept
+// once complete. As the result is complete by then, what is sent as
+// trailers is kept as headers.
+type cacheWriter struct {
+	http.ResponseWriter
+
+	max      int64
+	code     int
+	buf      bytes.Buffer
+	overflow bool
+}
+
+func newCacheWriter(w http.ResponseWriter, max int64) *cacheWriter {
+	w.Header().Set(cacheHeader, cacheMiss)
+	return &cacheWriter{ResponseWriter: w, max: max}
+}
+
+func (cw *cacheWriter) WriteHeader(code int) {
+	if cw.code == 0 {
+		cw.code = code
+	}
+	cw.ResponseWriter.WriteHeader(code)
+}
+
+func (cw *cacheWriter) Write(b []byte) (int, error) {
+	if cw.code == 0 {
+		cw.code = http.StatusOK
+	}
+	if !cw.overflow {
+		if int64(cw.buf.Len()+len(b)) > cw.max {
+			cw.overflow = true
+			cw.buf = bytes.Buffer{}
+		} else {
+			cw.buf.Write(b)
+		}
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+func (cw *cacheWriter) Flush() {
+	_ = http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+func (cw *cacheWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// store keeps the response, provided the result has been sent in full
+// without an error. Only the headers describing the result are kept,
+// as the others might have been set by the middleware, or only apply
+// to the request, such as the ID of the query.
+func (cw *cacheWriter) store(cfg *gabi.Config, key string, result *audit.ResultData) {
+	if cw.code != http.StatusOK || cw.overflow || result.Digest == "" || cw.Header().Get(streamErrorTrailer) != "" {
+		return
+	}
+
+	header := make(http.Header)
+	for name, values := range cw.Header() {
+		if cacheHeaders[name] {
+			header[name] = append([]string(nil), values...)
+		}
+	}
+
+	cfg.Cache.Set(key, &cache.Entry{Header: header, Body: cw.buf.Bytes(), Result: *result})
+}
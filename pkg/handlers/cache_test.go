@@ -0,0 +1,215 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/app-sre/gabi/internal/test"
+	gabi "github.com/app-sre/gabi/pkg"
+	"github.com/app-sre/gabi/pkg/audit"
+	"github.com/app-sre/gabi/pkg/cache"
+	gabidb "github.com/app-sre/gabi/pkg/env/db"
+	"github.com/app-sre/gabi/pkg/middleware"
+	"github.com/app-sre/gabi/pkg/running"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeQuery(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		description string
+		given       string
+		want        string
+	}{
+		{
+			"query with whitespace collapsed",
+			"  select *\n\tfrom   test;\n",
+			"select * from test;",
+		},
+		{
+			"query with whitespace in literals retained",
+			"select  'a  b', \"c  d\"  from `e  f`;",
+			"select 'a  b', \"c  d\" from `e  f`;",
+		},
+		{
+			"query with comment left as it is",
+			"select 1 -- test\nfrom  test;",
+			"select 1 -- test\nfrom  test;",
+		},
+		{
+			"query with escaped quote left as it is",
+			"select  'a\\'  b';",
+			"select  'a\\'  b';",
+		},
+		{
+			"query with dollar quoting left as it is",
+			"select  $$a  b$$;",
+			"select  $$a  b$$;",
+		},
+		{
+			"query with placeholders collapsed",
+			"select  $1,\n$2;",
+			"select $1, $2;",
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.want, normalizeQuery(tc.given))
+		})
+	}
+}
+
+func TestQueryCache(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		description string
+		allowWrite  bool
+		mock        func(sqlmock.Sqlmock)
+		first       [2]string
+		second      [2]string
+		header      string
+		hit         bool
+	}{
+		{
+			"result served from cache",
+			false,
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(`select \* from test;`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("1"))
+				mock.ExpectCommit()
+			},
+			[2]string{`?format=csv`, `{"query": "select * from test;"}`},
+			[2]string{`?format=csv`, `{"query": "select *\n  from test;"}`},
+			cacheHit,
+			true,
+		},
+		{
+			"result not served from cache with different format",
+			false,
+			func(mock sqlmock.Sqlmock) {
+				for i := 0; i < 2; i++ {
+					mock.ExpectBegin()
+					mock.ExpectQuery(`select \* from test;`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("1"))
+					mock.ExpectCommit()
+				}
+			},
+			[2]string{`?format=csv`, `{"query": "select * from test;"}`},
+			[2]string{`?format=tsv`, `{"query": "select * from test;"}`},
+			cacheMiss,
+			false,
+		},
+		{
+			"result not served from cache with different arguments",
+			false,
+			func(mock sqlmock.Sqlmock) {
+				for i := 0; i < 2; i++ {
+					mock.ExpectBegin()
+					mock.ExpectQuery(`select \* from test where id = \$1;`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("1"))
+					mock.ExpectCommit()
+				}
+			},
+			[2]string{``, `{"query": "select * from test where id = $1;", "args": [1]}`},
+			[2]string{``, `{"query": "select * from test where id = $1;", "args": [2]}`},
+			cacheMiss,
+			false,
+		},
+		{
+			"result not served from cache after database error",
+			false,
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(`select \* from test;`).WillReturnError(errors.New("test"))
+				mock.ExpectRollback()
+				mock.ExpectBegin()
+				mock.ExpectQuery(`select \* from test;`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("1"))
+				mock.ExpectCommit()
+			},
+			[2]string{``, `{"query": "select * from test;"}`},
+			[2]string{``, `{"query": "select * from test;"}`},
+			cacheMiss,
+			false,
+		},
+		{
+			"result not cached with write access allowed",
+			true,
+			func(mock sqlmock.Sqlmock) {
+				for i := 0; i < 2; i++ {
+					mock.ExpectBegin()
+					mock.ExpectQuery(`select \* from test;`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("1"))
+					mock.ExpectCommit()
+				}
+			},
+			[2]string{``, `{"query": "select * from test;"}`},
+			[2]string{``, `{"query": "select * from test;"}`},
+			``,
+			false,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			var output bytes.Buffer
+
+			db, mock, _ := sqlmock.New()
+			defer func() { _ = db.Close() }()
+
+			tc.mock(mock)
+
+			cfg := &gabi.Config{
+				DB:      db,
+				DBEnv:   &gabidb.Env{Name: "test", AllowWrite: tc.allowWrite},
+				Cache:   cache.NewStore(time.Minute, 1024),
+				Queries: running.NewRegistry(),
+				Logger:  test.DummyLogger(&output).Sugar(),
+				Encoder: base64.StdEncoding,
+			}
+
+			run := func(parameters, request string) (*httptest.ResponseRecorder, *audit.ResultData) {
+				result := &audit.ResultData{}
+				ctx := context.WithValue(context.TODO(), middleware.ContextKeyResult, result)
+
+				w := httptest.NewRecorder()
+				r := httptest.NewRequestWithContext(ctx, http.MethodPost, "/"+parameters, bytes.NewBufferString(request))
+				Query(cfg).ServeHTTP(w, r)
+
+				return w, result
+			}
+
+			fw, first := run(tc.first[0], tc.first[1])
+			w, second := run(tc.second[0], tc.second[1])
+
+			require.NoError(t, mock.ExpectationsWereMet())
+			assert.Equal(t, 200, w.Code)
+			assert.Equal(t, tc.header, w.Header().Get("X-Gabi-Cache"))
+			assert.Equal(t, tc.hit, second.CacheHit)
+			assert.NotEmpty(t, second.Digest)
+			if tc.hit {
+				assert.Equal(t, first.Digest, second.Digest)
+				assert.Equal(t, second.Digest, w.Header().Get("X-Gabi-Digest"))
+				assert.Equal(t, "text/csv; charset=utf-8; header=present", w.Header().Get("Content-Type"))
+				assert.Equal(t, "id\r\n1\r\n", w.Body.String())
+				assert.Empty(t, w.Header().Get("Trailer"))
+				// Only applies to the query that was run.
+				assert.NotEmpty(t, fw.Header().Get("X-Gabi-Query-Id"))
+				assert.Empty(t, w.Header().Get("X-Gabi-Query-Id"))
+			}
+		})
+	}
+}
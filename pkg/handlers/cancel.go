@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+
+	gabi "github.com/app-sre/gabi/pkg"
+	"github.com/app-sre/gabi/pkg/middleware"
+	"github.com/app-sre/gabi/pkg/running"
+	"github.com/go-sql-driver/mysql"
+	"github.com/gorilla/mux"
+)
+
+const queryIDHeader = "X-Gabi-Query-Id"
+
+const cancelledErrorMessage = "Query was cancelled"
+
+// ER_NO_SUCH_THREAD, raised when the query has finished by the time it
+// is killed.
+const mysqlUnknownThread = 1094
+
+// Cancel stops a running query on behalf of the user who started it,
+// or of an admin. Besides cancelling the context of the query, the
+// database is asked to stop it beforehand, as not every driver does so
+// once the context has been cancelled.
+func Cancel(cfg *gabi.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		if cfg.Queries == nil {
+			http.Error(w, "Cancelling queries is not supported", http.StatusBadRequest)
+			return
+		}
+
+		id := mux.Vars(r)["id"]
+		user, _ := ctx.Value(middleware.ContextKeyUser).(string)
+
+		q, err := cfg.Queries.Get(id)
+		// Do not disclose queries that belong to other users.
+		if err != nil || (q.User != user && !cfg.UserEnv.IsAdmin(user)) {
+			http.Error(w, "Query not found", http.StatusNotFound)
+			return
+		}
+
+		err = q.Stop(func(backend int64) error {
+			s := cfg.DBEnv.Driver.CancelQuery(backend)
+			if s == "" {
+				return nil
+			}
+			_, err := cfg.DB.ExecContext(ctx, s)
+			return err
+		})
+		cfg.Logger.Infof("Cancelled query %s (user: %s, owner: %s)", q.ID, user, q.User)
+
+		switch {
+		case finishedError(err):
+			// Nothing left to stop.
+			cfg.Logger.Infof("Unable to cancel database query: %s", err)
+		case err != nil:
+			cfg.Logger.Errorf("Unable to cancel database query: %s", err)
+			_ = queryErrorResponse(ctx, w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// startQuery registers the query as running, so that it can be
+// cancelled, and sends its ID to the client before the response has
+// been committed. The returned function has to be called once the
+// query has finished.
+func startQuery(w http.ResponseWriter, r *http.Request, cfg *gabi.Config, query string) (*http.Request, func(), error) {
+	if cfg.Queries == nil {
+		return r, func() {}, nil
+	}
+
+	// Jobs register their query when submitted.
+	if q := running.FromContext(r.Context()); q != nil {
+		w.Header().Set(queryIDHeader, q.ID)
+		return r, func() {}, nil
+	}
+
+	user, _ := r.Context().Value(middleware.ContextKeyUser).(string)
+
+	ctx, q, err := cfg.Queries.Start(r.Context(), user, cfg.GetCurrentDBName(), query)
+	if err != nil {
+		return nil, nil, err
+	}
+	w.Header().Set(queryIDHeader, q.ID)
+
+	return r.WithContext(ctx), q.Finish, nil
+}
+
+// trackBackend records the database connection the running query uses,
+// so that the database can be asked to stop it should it be cancelled.
+// The returned function forgets the connection, and has to be called
+// before the transaction ends, as the connection is then returned to
+// the pool.
+func trackBackend(ctx context.Context, cfg *gabi.Config, tx *sql.Tx) (func(), error) {
+	q := running.FromContext(ctx)
+	s := cfg.DBEnv.Driver.BackendID()
+	if q == nil || s == "" {
+		return func() {}, nil
+	}
+
+	var id int64
+	if err := tx.QueryRowContext(ctx, s).Scan(&id); err != nil {
+		return nil, err
+	}
+	q.SetBackend(id)
+
+	return func() { q.SetBackend(0) }, nil
+}
+
+func cancelledError(err error) bool {
+	return errors.Is(err, context.Canceled)
+}
+
+func finishedError(err error) bool {
+	var mysqlError *mysql.MySQLError
+	return errors.As(err, &mysqlError) && mysqlError.Number == mysqlUnknownThread
+}
@@ -0,0 +1,243 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/app-sre/gabi/internal/test"
+	gabi "github.com/app-sre/gabi/pkg"
+	gabidb "github.com/app-sre/gabi/pkg/env/db"
+	"github.com/app-sre/gabi/pkg/env/user"
+	"github.com/app-sre/gabi/pkg/middleware"
+	"github.com/app-sre/gabi/pkg/running"
+	"github.com/go-sql-driver/mysql"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCancel(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		description string
+		driver      gabidb.DriverType
+		user        string
+		backend     int64
+		id          func(*running.Query) string
+		mock        func(sqlmock.Sqlmock)
+		code        int
+		body        string
+		cancelled   bool
+	}{
+		{
+			"query cancelled by its owner",
+			"pgx",
+			"test",
+			42,
+			func(q *running.Query) string {
+				return q.ID
+			},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(`^SELECT pg_cancel_backend\(42\)$`).WillReturnResult(sqlmock.NewResult(0, 0))
+			},
+			204,
+			``,
+			true,
+		},
+		{
+			"query cancelled by an admin",
+			"mysql",
+			"admin",
+			42,
+			func(q *running.Query) string {
+				return q.ID
+			},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(`^KILL QUERY 42$`).WillReturnResult(sqlmock.NewResult(0, 0))
+			},
+			204,
+			``,
+			true,
+		},
+		{
+			"query cancelled without its database connection known",
+			"pgx",
+			"test",
+			0,
+			func(q *running.Query) string {
+				return q.ID
+			},
+			func(mock sqlmock.Sqlmock) {
+				// No-op.
+			},
+			204,
+			``,
+			true,
+		},
+		{
+			"query cancelled with database returning an error",
+			"pgx",
+			"test",
+			42,
+			func(q *running.Query) string {
+				return q.ID
+			},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(`^SELECT pg_cancel_backend\(42\)$`).WillReturnError(errors.New("test"))
+			},
+			400,
+			`{"result":null,"error":"test"}`,
+			true,
+		},
+		{
+			"query cancelled once finished",
+			"mysql",
+			"test",
+			42,
+			func(q *running.Query) string {
+				return q.ID
+			},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(`^KILL QUERY 42$`).WillReturnError(&mysql.MySQLError{Number: 1094, Message: "Unknown thread id: 42"})
+			},
+			204,
+			``,
+			true,
+		},
+		{
+			"query not cancelled for another user",
+			"pgx",
+			"other",
+			42,
+			func(q *running.Query) string {
+				return q.ID
+			},
+			func(mock sqlmock.Sqlmock) {
+				// No-op.
+			},
+			404,
+			`Query not found`,
+			false,
+		},
+		{
+			"query not cancelled with unknown ID",
+			"pgx",
+			"test",
+			42,
+			func(q *running.Query) string {
+				return "test"
+			},
+			func(mock sqlmock.Sqlmock) {
+				// No-op.
+			},
+			404,
+			`Query not found`,
+			false,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			var body, output bytes.Buffer
+
+			db, mock, _ := sqlmock.New()
+			defer func() { _ = db.Close() }()
+
+			tc.mock(mock)
+
+			queries := running.NewRegistry()
+
+			ctx, q, err := queries.Start(context.TODO(), "test", "test", "select pg_sleep(10);")
+			require.NoError(t, err)
+			defer q.Finish()
+
+			q.SetBackend(tc.backend)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodDelete, "/", nil)
+			r = mux.SetURLVars(r, map[string]string{"id": tc.id(q)})
+			r = r.WithContext(context.WithValue(r.Context(), middleware.ContextKeyUser, tc.user))
+
+			expected := &gabi.Config{
+				DB:      db,
+				DBEnv:   &gabidb.Env{Driver: tc.driver},
+				UserEnv: &user.Env{Users: []string{"test", "other", "admin"}, Admins: []string{"admin"}},
+				Queries: queries,
+				Logger:  test.DummyLogger(&output).Sugar(),
+				Encoder: base64.StdEncoding,
+			}
+			Cancel(expected).ServeHTTP(w, r)
+
+			actual := w.Result()
+			defer func() { _ = actual.Body.Close() }()
+
+			_, _ = io.Copy(&body, actual.Body)
+
+			require.NoError(t, mock.ExpectationsWereMet())
+			assert.Equal(t, tc.code, actual.StatusCode)
+			assert.Contains(t, body.String(), tc.body)
+			assert.Equal(t, tc.cancelled, ctx.Err() != nil)
+		})
+	}
+}
+
+func TestCancelNotSupported(t *testing.T) {
+	t.Parallel()
+
+	var output bytes.Buffer
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodDelete, "/", nil)
+
+	cfg := &gabi.Config{DBEnv: &gabidb.Env{}, Logger: test.DummyLogger(&output).Sugar()}
+	Cancel(cfg).ServeHTTP(w, r)
+
+	assert.Equal(t, 400, w.Code)
+	assert.Contains(t, w.Body.String(), "Cancelling queries is not supported")
+}
+
+func TestQueryRunning(t *testing.T) {
+	t.Parallel()
+
+	var output bytes.Buffer
+
+	db, mock, _ := sqlmock.New()
+	defer func() { _ = db.Close() }()
+
+	queries := running.NewRegistry()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`^SELECT pg_backend_pid\(\)$`).
+		WillReturnRows(sqlmock.NewRows([]string{"pg_backend_pid"}).AddRow(42))
+	mock.ExpectQuery(`select 1;`).
+		WillReturnRows(sqlmock.NewRows([]string{"?column?"}).AddRow("1"))
+	mock.ExpectCommit()
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(`{"query": "select 1;"}`))
+	ctx := context.WithValue(context.TODO(), middleware.ContextKeyUser, "test")
+
+	cfg := &gabi.Config{DB: db, DBEnv: &gabidb.Env{Driver: "pgx"}, Queries: queries, Logger: test.DummyLogger(&output).Sugar(), Encoder: base64.StdEncoding}
+	Query(cfg).ServeHTTP(w, r.WithContext(ctx))
+
+	id := w.Header().Get("X-Gabi-Query-Id")
+
+	require.NoError(t, mock.ExpectationsWereMet())
+	assert.Equal(t, 200, w.Code)
+	assert.Len(t, id, 32)
+
+	// No longer running once the response has been sent.
+	_, err := queries.Get(id)
+	assert.ErrorIs(t, err, running.ErrNotFound)
+}
@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	gabi "github.com/app-sre/gabi/pkg"
+	"github.com/app-sre/gabi/pkg/cursor"
+	"github.com/app-sre/gabi/pkg/middleware"
+	"github.com/app-sre/gabi/pkg/models"
+)
+
+// firstPage opens a cursor for the query and sends the first page.
+func firstPage(r *http.Request, cfg *gabi.Config, rw *resultWriter, request *models.QueryRequest, args []any) {
+	if cfg.Cursors == nil {
+		http.Error(rw.w, "Cursors are not supported", http.StatusBadRequest)
+		return
+	}
+
+	user, _ := r.Context().Value(middleware.ContextKeyUser).(string)
+
+	// Cursors outlive the request, so the deadline of the request can
+	// only bound the pages that are fetched from the database as they
+	// are requested. Otherwise, the query runs unbounded. Neither is the
+	// connection of the cursor tracked, so cancelling the query only
+	// cancels the request.
+	var c *cursor.Cursor
+	var err error
+	if cfg.DBEnv.Driver.DeclaresCursors() {
+		c, err = cfg.Cursors.Declare(r.Context(), cfg.DB, user, request.Query, args...)
+	} else {
+		setup := cfg.DBEnv.Driver.StatementTimeout(0)
+		c, err = cfg.Cursors.Open(r.Context(), cfg.DB, user, setup, request.Query, args...)
+	}
+	if err != nil {
+		cfg.Logger.Errorf("Unable to open cursor: %s", err)
+		cursorErrorResponse(rw.ctx, rw.w, err)
+		return
+	}
+	cfg.Logger.Debugf("Opened cursor %s (user: %s)", c.ID, user)
+
+	page(r, cfg, rw, c, request.PageSize)
+}
+
+// nextPage sends the next page of a cursor opened by the same user.
+func nextPage(r *http.Request, cfg *gabi.Config, rw *resultWriter, request *models.QueryRequest) {
+	if cfg.Cursors == nil {
+		http.Error(rw.w, "Cursors are not supported", http.StatusBadRequest)
+		return
+	}
+
+	user, _ := r.Context().Value(middleware.ContextKeyUser).(string)
+
+	c, err := cfg.Cursors.Acquire(r.Context(), request.Cursor, user)
+	if err != nil {
+		cfg.Logger.Errorf("Unable to acquire cursor %s: %s", request.Cursor, err)
+		cursorErrorResponse(rw.ctx, rw.w, err)
+		return
+	}
+
+	pageSize := request.PageSize
+	if pageSize == 0 {
+		pageSize = c.PageSize
+	}
+	page(r, cfg, rw, c, pageSize)
+}
+
+func page(r *http.Request, cfg *gabi.Config, rw *resultWriter, c *cursor.Cursor, pageSize int64) {
+	c.PageSize = pageSize
+	limit := tighterLimit(rw.result.RowLimit, pageSize)
+
+	rows, err := c.Fetch(r.Context(), statementTimeout(r.Context(), cfg), limit)
+	if err != nil {
+		c.Close()
+		rw.Fail("Unable to fetch from cursor", err)
+		return
+	}
+
+	if !rw.Begin(c.Columns) {
+		c.Close()
+		return
+	}
+
+	more, ok := rw.Rows(rows, c.Pending, limit)
+	if !ok {
+		c.Close()
+		return
+	}
+
+	status := resultStatus{Rows: rw.result.Rows}
+
+	// A row that on its own does not fit within the byte limit would
+	// otherwise leave the cursor stuck on it.
+	if more && rw.result.Rows > 0 {
+		if err := c.Keep(r.Context(), true); err != nil {
+			c.Close()
+			rw.Fail("Unable to keep cursor", err)
+			return
+		}
+		status.Cursor = c.ID
+		rw.result.NextCursor = c.ID
+		c.Release()
+	} else {
+		rw.result.Truncated = more
+		status.Truncated = more
+		cfg.Logger.Debugf("Closed cursor %s", c.ID)
+		c.Close()
+	}
+
+	rw.End(status)
+}
+
+func cursorErrorResponse(ctx context.Context, w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, cursor.ErrNotFound):
+		http.Error(w, "Cursor not found", http.StatusNotFound)
+	case errors.Is(err, cursor.ErrBusy):
+		http.Error(w, "Cursor is in use", http.StatusConflict)
+	case errors.Is(err, cursor.ErrLimitReached):
+		http.Error(w, "Too many open cursors", http.StatusTooManyRequests)
+	default:
+		_ = queryErrorResponse(ctx, w, err)
+	}
+}
@@ -0,0 +1,195 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/app-sre/gabi/internal/test"
+	gabi "github.com/app-sre/gabi/pkg"
+	"github.com/app-sre/gabi/pkg/audit"
+	"github.com/app-sre/gabi/pkg/cursor"
+	gabidb "github.com/app-sre/gabi/pkg/env/db"
+	"github.com/app-sre/gabi/pkg/middleware"
+	"github.com/app-sre/gabi/pkg/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueryPages(t *testing.T) {
+	t.Parallel()
+
+	var output bytes.Buffer
+
+	db, mock, _ := sqlmock.New()
+	defer func() { _ = db.Close() }()
+
+	rows := sqlmock.NewRows([]string{"id"}).AddRow("1").AddRow("2").AddRow("3")
+	mock.ExpectBegin()
+	mock.ExpectQuery(`select id from test;`).WillReturnRows(rows)
+	mock.ExpectRollback()
+
+	cfg := &gabi.Config{
+		DB:      db,
+		DBEnv:   &gabidb.Env{},
+		Logger:  test.DummyLogger(&output).Sugar(),
+		Encoder: base64.StdEncoding,
+		Cursors: cursor.NewStore(time.Minute, 1),
+	}
+
+	// The result of the most recent request.
+	var result *audit.ResultData
+
+	serve := func(user, body string) (int, string) {
+		var b bytes.Buffer
+
+		result = &audit.ResultData{}
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(body))
+		ctx := context.WithValue(context.TODO(), middleware.ContextKeyUser, user)
+		ctx = context.WithValue(ctx, middleware.ContextKeyResult, result)
+
+		Query(cfg).ServeHTTP(w, r.WithContext(ctx))
+
+		actual := w.Result()
+		defer func() { _ = actual.Body.Close() }()
+
+		_, _ = io.Copy(&b, actual.Body)
+		return actual.StatusCode, b.String()
+	}
+
+	var page models.QueryResponse
+
+	code, body := serve("test", `{"query": "select id from test;", "page_size": 2}`)
+	require.Equal(t, 200, code)
+	require.NoError(t, json.Unmarshal([]byte(body), &page))
+	assert.Equal(t, [][]string{{"id"}, {"1"}, {"2"}}, page.Result)
+	assert.Len(t, page.Cursor, 32)
+	assert.Equal(t, page.Cursor, result.NextCursor)
+
+	code, _ = serve("test", `{"query": "select 1;", "page_size": 2}`)
+	assert.Equal(t, 429, code)
+
+	code, _ = serve("test", `{"query": "select 1;", "cursor": "`+page.Cursor+`"}`)
+	assert.Equal(t, 400, code)
+
+	code, _ = serve("other", `{"cursor": "`+page.Cursor+`"}`)
+	assert.Equal(t, 404, code)
+
+	cursor := page.Cursor
+	page = models.QueryResponse{}
+
+	code, body = serve("test", `{"cursor": "`+cursor+`"}`)
+	require.Equal(t, 200, code)
+	require.NoError(t, json.Unmarshal([]byte(body), &page))
+	assert.Equal(t, [][]string{{"id"}, {"3"}}, page.Result)
+	assert.Empty(t, page.Cursor)
+	assert.False(t, page.Truncated)
+	assert.Empty(t, result.NextCursor)
+	assert.Equal(t, int64(1), result.Rows)
+	assert.Equal(t, audit.StatusSuccess, result.Status)
+
+	code, _ = serve("test", `{"cursor": "`+cursor+`"}`)
+	assert.Equal(t, 404, code)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+	assert.Equal(t, 0, cfg.Cursors.Len())
+}
+
+func TestQueryPagesDeclared(t *testing.T) {
+	t.Parallel()
+
+	var output bytes.Buffer
+
+	db, mock, _ := sqlmock.New()
+	defer func() { _ = db.Close() }()
+
+	// Every page is fetched from the database, bounded by the deadline
+	// of the request fetching it.
+	mock.ExpectBegin()
+	mock.ExpectExec(`DECLARE gabi_cursor SCROLL CURSOR FOR select id from test;`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`SET LOCAL statement_timeout = \d+`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`FETCH FORWARD 3 FROM gabi_cursor`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("1").AddRow("2").AddRow("3"))
+	mock.ExpectExec(`MOVE BACKWARD 1 FROM gabi_cursor`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`SET LOCAL statement_timeout = \d+`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`FETCH FORWARD 3 FROM gabi_cursor`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("3"))
+	mock.ExpectRollback()
+
+	cfg := &gabi.Config{
+		DB:      db,
+		DBEnv:   &gabidb.Env{Driver: "pgx"},
+		Logger:  test.DummyLogger(&output).Sugar(),
+		Encoder: base64.StdEncoding,
+		Cursors: cursor.NewStore(time.Minute, 1),
+	}
+
+	serve := func(body string) (int, string) {
+		var b bytes.Buffer
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(body))
+		ctx := context.WithValue(context.TODO(), middleware.ContextKeyUser, "test")
+		ctx, cancel := context.WithTimeout(ctx, time.Minute)
+		defer cancel()
+
+		Query(cfg).ServeHTTP(w, r.WithContext(ctx))
+
+		actual := w.Result()
+		defer func() { _ = actual.Body.Close() }()
+
+		_, _ = io.Copy(&b, actual.Body)
+		return actual.StatusCode, b.String()
+	}
+
+	var page models.QueryResponse
+
+	code, body := serve(`{"query": "select id from test;", "page_size": 2}`)
+	require.Equal(t, 200, code)
+	require.NoError(t, json.Unmarshal([]byte(body), &page))
+	assert.Equal(t, [][]string{{"id"}, {"1"}, {"2"}}, page.Result)
+	require.Len(t, page.Cursor, 32)
+
+	cursor := page.Cursor
+	page = models.QueryResponse{}
+
+	code, body = serve(`{"cursor": "` + cursor + `"}`)
+	require.Equal(t, 200, code)
+	require.NoError(t, json.Unmarshal([]byte(body), &page))
+	assert.Equal(t, [][]string{{"id"}, {"3"}}, page.Result)
+	assert.Empty(t, page.Cursor)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+	assert.Equal(t, 0, cfg.Cursors.Len())
+}
+
+func TestQueryPagesNotSupported(t *testing.T) {
+	t.Parallel()
+
+	var body, output bytes.Buffer
+
+	db, mock, _ := sqlmock.New()
+	defer func() { _ = db.Close() }()
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(`{"query": "select 1;", "page_size": 2}`))
+
+	cfg := &gabi.Config{DB: db, DBEnv: &gabidb.Env{}, Logger: test.DummyLogger(&output).Sugar(), Encoder: base64.StdEncoding}
+	Query(cfg).ServeHTTP(w, r)
+
+	actual := w.Result()
+	defer func() { _ = actual.Body.Close() }()
+
+	_, _ = io.Copy(&body, actual.Body)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+	assert.Equal(t, 400, actual.StatusCode)
+	assert.Contains(t, body.String(), "Cursors are not supported")
+}
@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"encoding/hex"
+	"hash"
+	"math"
+)
+
+// The length written in place of a NULL value, so that it cannot be
+// mistaken for an empty one.
+const digestNullLength = math.MaxUint64
+
+// resultDigest computes a SHA-256 digest over the rows of a result as
+// text, as scanned from the values the database driver returned, so
+// that it does not depend on the format and encoding they are sent in.
+// Every value, row after row, is written as its length, an unsigned
+// 64-bit big-endian integer, followed by its content, where NULL is
+// written as the largest possible length and no content.
+type resultDigest struct {
+	hash   hash.Hash
+	length [8]byte
+}
+
+func newResultDigest() *resultDigest {
+	return &resultDigest{hash: sha256.New()}
+}
+
+// Row adds the most recently scanned row, whose values have to have
+// been scanned as text.
+func (d *resultDigest) Row(vals []any) {
+	for _, value := range vals {
+		v := value.(*sql.RawBytes)
+		d.value(*v, *v == nil)
+	}
+}
+
+// digestValues returns what the values of a row have to be scanned into
+// as well for the digest, unless they are already scanned as text.
+func digestValues(vals []any) []any {
+	for _, value := range vals {
+		if _, ok := value.(*sql.RawBytes); !ok {
+			raw := make([]any, len(vals))
+			for i := range raw {
+				raw[i] = new(sql.RawBytes)
+			}
+			return raw
+		}
+	}
+	return nil
+}
+
+// Sum returns the hex-encoded digest of the rows added so far.
+func (d *resultDigest) Sum() string {
+	return hex.EncodeToString(d.hash.Sum(nil))
+}
+
+func (d *resultDigest) value(b []byte, null bool) {
+	length := uint64(len(b))
+	if null {
+		length = digestNullLength
+	}
+	binary.BigEndian.PutUint64(d.length[:], length)
+
+	_, _ = d.hash.Write(d.length[:])
+	_, _ = d.hash.Write(b)
+}
@@ -0,0 +1,211 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/app-sre/gabi/internal/test"
+	gabi "github.com/app-sre/gabi/pkg"
+	"github.com/app-sre/gabi/pkg/audit"
+	gabidb "github.com/app-sre/gabi/pkg/env/db"
+	"github.com/app-sre/gabi/pkg/middleware"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResultDigest(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		description string
+		given       [][]any
+		want        []byte
+	}{
+		{
+			"result with no rows",
+			nil,
+			nil,
+		},
+		{
+			"result with text values",
+			[][]any{{rawBytes("a"), rawBytes("bc")}},
+			[]byte("\x00\x00\x00\x00\x00\x00\x00\x01a\x00\x00\x00\x00\x00\x00\x00\x02bc"),
+		},
+		{
+			"result with empty value",
+			[][]any{{rawBytes("")}},
+			[]byte("\x00\x00\x00\x00\x00\x00\x00\x00"),
+		},
+		{
+			"result with NULL value",
+			[][]any{{new(sql.RawBytes)}},
+			[]byte("\xff\xff\xff\xff\xff\xff\xff\xff"),
+		},
+		{
+			"result with many rows",
+			[][]any{{rawBytes("a")}, {rawBytes("b")}},
+			[]byte("\x00\x00\x00\x00\x00\x00\x00\x01a\x00\x00\x00\x00\x00\x00\x00\x01b"),
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			d := newResultDigest()
+			for _, row := range tc.given {
+				d.Row(row)
+			}
+
+			assert.Equal(t, digest(tc.want), d.Sum())
+		})
+	}
+}
+
+func TestQueryDigest(t *testing.T) {
+	t.Parallel()
+
+	// The digest is the same regardless of how the rows are sent.
+	want := digest([]byte("\x00\x00\x00\x00\x00\x00\x00\x01" + "1" + "\x00\x00\x00\x00\x00\x00\x00\x04" + "test" +
+		"\x00\x00\x00\x00\x00\x00\x00\x01" + "2" + "\xff\xff\xff\xff\xff\xff\xff\xff"))
+
+	cases := []struct {
+		description string
+		parameters  string
+		request     string
+		want        string
+	}{
+		{
+			"digest of result sent as JSON",
+			``,
+			`{"query": "select * from test;"}`,
+			want,
+		},
+		{
+			"digest of result sent as CSV with every value encoded",
+			`?format=csv&encode=all`,
+			`{"query": "select * from test;"}`,
+			want,
+		},
+		{
+			"digest of result sent with types",
+			`?format=typed`,
+			`{"query": "select * from test;"}`,
+			want,
+		},
+		{
+			"digest of result that has been truncated",
+			`?format=ndjson`,
+			`{"query": "select * from test;", "row_limit": 1}`,
+			digest([]byte("\x00\x00\x00\x00\x00\x00\x00\x01" + "1" + "\x00\x00\x00\x00\x00\x00\x00\x04" + "test")),
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			var output bytes.Buffer
+
+			db, mock, _ := sqlmock.New()
+			defer func() { _ = db.Close() }()
+
+			rows := sqlmock.NewRows([]string{"id", "name"}).AddRow("1", "test").AddRow("2", nil)
+			mock.ExpectBegin()
+			mock.ExpectQuery(`select \* from test;`).WillReturnRows(rows)
+			mock.ExpectCommit()
+
+			result := &audit.ResultData{}
+
+			cfg := &gabi.Config{DB: db, DBEnv: &gabidb.Env{}, Logger: test.DummyLogger(&output).Sugar(), Encoder: base64.StdEncoding}
+			ctx := context.WithValue(context.TODO(), middleware.ContextKeyResult, result)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequestWithContext(ctx, http.MethodPost, "/"+tc.parameters, bytes.NewBufferString(tc.request))
+			Query(cfg).ServeHTTP(w, r)
+
+			require.NoError(t, mock.ExpectationsWereMet())
+			assert.Equal(t, 200, w.Code)
+			assert.Equal(t, tc.want, w.Header().Get("X-Gabi-Digest"))
+			assert.Equal(t, tc.want, result.Digest)
+			assert.Equal(t, int64(w.Body.Len()), result.Bytes)
+		})
+	}
+}
+
+func rawBytes(s string) *sql.RawBytes {
+	b := sql.RawBytes(s)
+	return &b
+}
+
+func digest(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestQueryDigestConverted(t *testing.T) {
+	t.Parallel()
+
+	// Values converted by the driver are hashed as text regardless of
+	// the format.
+	want := digest([]byte("\x00\x00\x00\x00\x00\x00\x00\x14" + "2023-01-01T00:00:00Z" + "\x00\x00\x00\x00\x00\x00\x00\x04" + "true"))
+
+	cases := []struct {
+		description string
+		parameters  string
+	}{
+		{
+			"digest of result sent as JSON",
+			``,
+		},
+		{
+			"digest of result sent as CSV",
+			`?format=csv`,
+		},
+		{
+			"digest of result sent with types",
+			`?format=typed`,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			var output bytes.Buffer
+
+			db, mock, _ := sqlmock.New()
+			defer func() { _ = db.Close() }()
+
+			rows := sqlmock.NewRows([]string{"created", "active"}).AddRow(time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC), true)
+			mock.ExpectBegin()
+			mock.ExpectQuery(`select \* from test;`).WillReturnRows(rows)
+			mock.ExpectCommit()
+
+			result := &audit.ResultData{}
+
+			cfg := &gabi.Config{DB: db, DBEnv: &gabidb.Env{}, Logger: test.DummyLogger(&output).Sugar(), Encoder: base64.StdEncoding}
+			ctx := context.WithValue(context.TODO(), middleware.ContextKeyResult, result)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequestWithContext(ctx, http.MethodPost, "/"+tc.parameters, bytes.NewBufferString(`{"query": "select * from test;"}`))
+			Query(cfg).ServeHTTP(w, r)
+
+			require.NoError(t, mock.ExpectationsWereMet())
+			assert.Equal(t, 200, w.Code)
+			assert.Equal(t, want, result.Digest)
+		})
+	}
+}
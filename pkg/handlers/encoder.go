@@ -0,0 +1,371 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// resultEncoder writes a result set to the client one row at a time,
+// so that the whole result never has to be held in memory.
+type resultEncoder interface {
+	// ContentType returns the media type of the encoded result.
+	ContentType() string
+	// Begin writes everything preceding the first row, and returns
+	// the destinations each row should be scanned into.
+	Begin(w io.Writer, columns []*sql.ColumnType) ([]any, error)
+	// Row writes the most recently scanned row.
+	Row(w io.Writer) error
+	// End completes the result, marking it as failed when an error
+	// has been encountered after the response has been committed.
+	End(w io.Writer, status resultStatus) error
+}
+
+// resultStatus describes the result once no more rows will be written.
+type resultStatus struct {
+	Rows           int64
+	Truncated      bool
+	Cursor         string
+	DryRun         bool
+	EncodedColumns []int
+	EncodedCells   [][2]int64
+	EncodedFrom    [][2]int64
+	Err            error
+}
+
+// jsonEncoder produces a document matching models.QueryResponse,
+// where every value is encoded as a string.
+type jsonEncoder struct {
+	cells *cellEncoder
+	vals  []any
+}
+
+var _ resultEncoder = (*jsonEncoder)(nil)
+
+func (e *jsonEncoder) ContentType() string {
+	return "application/json; charset=utf-8"
+}
+
+func (e *jsonEncoder) Begin(w io.Writer, columns []*sql.ColumnType) ([]any, error) {
+	var keys []string
+
+	e.vals = make([]any, len(columns))
+	for i := range columns {
+		e.vals[i] = new(sql.RawBytes)
+		keys = append(keys, columns[i].Name())
+	}
+
+	if _, err := io.WriteString(w, `{"result":[`); err != nil {
+		return nil, err
+	}
+	return e.vals, writeJSON(w, keys)
+}
+
+func (e *jsonEncoder) Row(w io.Writer) error {
+	var row []string
+
+	for i, value := range e.vals {
+		row = append(row, e.cells.Value(i, *value.(*sql.RawBytes)))
+	}
+
+	if _, err := io.WriteString(w, ","); err != nil {
+		return err
+	}
+	return writeJSON(w, row)
+}
+
+func (e *jsonEncoder) End(w io.Writer, status resultStatus) error {
+	return writeJSONFooter(w, status)
+}
+
+// typedEncoder produces a document matching models.TypedQueryResponse.
+type typedEncoder struct {
+	cells *cellEncoder
+	types []string
+	vals  []any
+	rows  int64
+}
+
+var _ resultEncoder = (*typedEncoder)(nil)
+
+func (e *typedEncoder) ContentType() string {
+	return "application/json; charset=utf-8"
+}
+
+func (e *typedEncoder) Begin(w io.Writer, columns []*sql.ColumnType) ([]any, error) {
+	e.types = make([]string, len(columns))
+	e.vals = make([]any, len(columns))
+	for i := range columns {
+		e.types[i] = columns[i].DatabaseTypeName()
+		e.vals[i] = new(any)
+	}
+
+	// Binary values are always encoded.
+	cols := typedColumns(columns)
+	for _, i := range e.cells.Columns() {
+		cols[i].Encoding = e.cells.name
+	}
+
+	if _, err := io.WriteString(w, `{"columns":`); err != nil {
+		return nil, err
+	}
+	if err := writeJSON(w, cols); err != nil {
+		return nil, err
+	}
+	_, err := io.WriteString(w, `,"result":[`)
+	return e.vals, err
+}
+
+func (e *typedEncoder) Row(w io.Writer) error {
+	row := make([]any, len(e.vals))
+	for i, value := range e.vals {
+		row[i] = e.cells.Typed(i, e.types[i], *value.(*any))
+	}
+
+	if e.rows > 0 {
+		if _, err := io.WriteString(w, ","); err != nil {
+			return err
+		}
+	}
+	e.rows++
+
+	return writeJSON(w, row)
+}
+
+func (e *typedEncoder) End(w io.Writer, status resultStatus) error {
+	return writeJSONFooter(w, status)
+}
+
+// csvEncoder produces RFC 4180 comma-separated values, with the column
+// names in the first record.
+type csvEncoder struct {
+	cells  *cellEncoder
+	vals   []any
+	writer *csv.Writer
+}
+
+var _ resultEncoder = (*csvEncoder)(nil)
+
+func (e *csvEncoder) ContentType() string {
+	return "text/csv; charset=utf-8; header=present"
+}
+
+func (e *csvEncoder) Begin(w io.Writer, columns []*sql.ColumnType) ([]any, error) {
+	keys := make([]string, len(columns))
+
+	e.vals = make([]any, len(columns))
+	for i := range columns {
+		e.vals[i] = new(sql.RawBytes)
+		keys[i] = columns[i].Name()
+	}
+
+	e.writer = csv.NewWriter(w)
+	e.writer.UseCRLF = true
+
+	return e.vals, e.write(keys)
+}
+
+func (e *csvEncoder) Row(_ io.Writer) error {
+	row := make([]string, len(e.vals))
+	for i, value := range e.vals {
+		row[i] = e.cells.Value(i, *value.(*sql.RawBytes))
+	}
+	return e.write(row)
+}
+
+func (e *csvEncoder) End(_ io.Writer, _ resultStatus) error {
+	return nil
+}
+
+func (e *csvEncoder) write(record []string) error {
+	if err := e.writer.Write(record); err != nil {
+		return err
+	}
+	e.writer.Flush()
+	return e.writer.Error()
+}
+
+var tsvEscaper = strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`, "\r", `\r`)
+
+// tsvEncoder produces tab-separated values, with the column names in
+// the first line. As fields cannot contain tabs or line breaks, these
+// are escaped the same way PostgreSQL and MySQL escape them.
+type tsvEncoder struct {
+	cells *cellEncoder
+	vals  []any
+}
+
+var _ resultEncoder = (*tsvEncoder)(nil)
+
+func (e *tsvEncoder) ContentType() string {
+	return "text/tab-separated-values; charset=utf-8"
+}
+
+func (e *tsvEncoder) Begin(w io.Writer, columns []*sql.ColumnType) ([]any, error) {
+	keys := make([]string, len(columns))
+
+	e.vals = make([]any, len(columns))
+	for i := range columns {
+		e.vals[i] = new(sql.RawBytes)
+		keys[i] = columns[i].Name()
+	}
+
+	return e.vals, e.write(w, keys)
+}
+
+func (e *tsvEncoder) Row(w io.Writer) error {
+	row := make([]string, len(e.vals))
+	for i, value := range e.vals {
+		row[i] = e.cells.Value(i, *value.(*sql.RawBytes))
+	}
+	return e.write(w, row)
+}
+
+func (e *tsvEncoder) End(_ io.Writer, _ resultStatus) error {
+	return nil
+}
+
+func (e *tsvEncoder) write(w io.Writer, fields []string) error {
+	for i, field := range fields {
+		if i > 0 {
+			if _, err := io.WriteString(w, "\t"); err != nil {
+				return err
+			}
+		}
+		if _, err := tsvEscaper.WriteString(w, field); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// ndjsonEncoder produces one JSON object per line for every row, keyed
+// by column name, in the order the columns were returned.
+type ndjsonEncoder struct {
+	cells *cellEncoder
+	keys  [][]byte
+	vals  []any
+}
+
+var _ resultEncoder = (*ndjsonEncoder)(nil)
+
+func (e *ndjsonEncoder) ContentType() string {
+	return "application/x-ndjson"
+}
+
+func (e *ndjsonEncoder) Begin(_ io.Writer, columns []*sql.ColumnType) ([]any, error) {
+	e.keys = make([][]byte, len(columns))
+	e.vals = make([]any, len(columns))
+	for i := range columns {
+		key, err := json.Marshal(columns[i].Name())
+		if err != nil {
+			return nil, err
+		}
+		e.keys[i] = key
+		e.vals[i] = new(sql.RawBytes)
+	}
+	return e.vals, nil
+}
+
+func (e *ndjsonEncoder) Row(w io.Writer) error {
+	var b bytes.Buffer
+
+	b.WriteByte('{')
+	for i, value := range e.vals {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.Write(e.keys[i])
+		b.WriteByte(':')
+
+		content := value.(*sql.RawBytes)
+		if *content == nil {
+			b.WriteString("null")
+			continue
+		}
+		if err := writeJSON(&b, e.cells.Value(i, *content)); err != nil {
+			return err
+		}
+	}
+	b.WriteString("}\n")
+
+	_, err := b.WriteTo(w)
+	return err
+}
+
+func (e *ndjsonEncoder) End(_ io.Writer, _ resultStatus) error {
+	return nil
+}
+
+func writeJSON(w io.Writer, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// writeJSONFooter closes the result array and sets the error field,
+// which carries an in-band error marker for streamed responses, and
+// marks a result that has been truncated, that has more pages, that
+// comes from a dry run, or where only some of the values are encoded.
+func writeJSONFooter(w io.Writer, status resultStatus) error {
+	var s string
+	if status.Err != nil {
+		s = queryErrorMessage(status.Err)
+	}
+
+	if _, err := io.WriteString(w, `],"error":`); err != nil {
+		return err
+	}
+	if err := writeJSON(w, s); err != nil {
+		return err
+	}
+	if status.Truncated {
+		if _, err := fmt.Fprintf(w, `,"truncated":true,"rows":%d`, status.Rows); err != nil {
+			return err
+		}
+	}
+	if status.Cursor != "" {
+		if _, err := fmt.Fprintf(w, `,"cursor":%q`, status.Cursor); err != nil {
+			return err
+		}
+	}
+	if status.DryRun {
+		if _, err := io.WriteString(w, `,"dry_run":true`); err != nil {
+			return err
+		}
+	}
+	if len(status.EncodedColumns) > 0 {
+		if _, err := io.WriteString(w, `,"encoded_columns":`); err != nil {
+			return err
+		}
+		if err := writeJSON(w, status.EncodedColumns); err != nil {
+			return err
+		}
+	}
+	if len(status.EncodedCells) > 0 {
+		if _, err := io.WriteString(w, `,"encoded_cells":`); err != nil {
+			return err
+		}
+		if err := writeJSON(w, status.EncodedCells); err != nil {
+			return err
+		}
+	}
+	if len(status.EncodedFrom) > 0 {
+		if _, err := io.WriteString(w, `,"encoded_from":`); err != nil {
+			return err
+		}
+		if err := writeJSON(w, status.EncodedFrom); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(w, "}\n")
+	return err
+}
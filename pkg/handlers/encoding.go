@@ -0,0 +1,223 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	gabi "github.com/app-sre/gabi/pkg"
+)
+
+const (
+	encodeNone = "none"
+	encodeAll  = "all"
+	encodeAuto = "auto"
+)
+
+const (
+	encodingBase64    = "base64"
+	encodingBase64URL = "base64url"
+	encodingHex       = "hex"
+)
+
+const encodingHeader = "X-Gabi-Encoding"
+
+// At most this many encoded values are listed individually, so that
+// keeping track of them does not grow with the size of the result.
+const maxEncodedCells = 1000
+
+// binaryEncoding turns values that cannot be sent as they are into text.
+type binaryEncoding interface {
+	EncodeToString(src []byte) string
+}
+
+type hexEncoding struct{}
+
+func (hexEncoding) EncodeToString(src []byte) string {
+	return hex.EncodeToString(src)
+}
+
+// resultEncoding picks which values of the result are encoded from the
+// "encode" query parameter, and how from the "encoding" query parameter.
+// Setting "base64_results" is the same as encoding every value.
+func resultEncoding(r *http.Request) (string, string, error) {
+	mode := encodeNone
+	if s := r.URL.Query().Get("base64_results"); s != "" {
+		if ok, err := strconv.ParseBool(s); err == nil && ok {
+			mode = encodeAll
+		}
+	}
+
+	if s := r.URL.Query().Get("encode"); s != "" {
+		switch s {
+		case encodeNone, encodeAll, encodeAuto:
+			mode = s
+		default:
+			return "", "", fmt.Errorf("unsupported encode mode: %s", s)
+		}
+	}
+
+	name := encodingBase64
+	if s := r.URL.Query().Get("encoding"); s != "" {
+		switch s {
+		case encodingBase64, encodingBase64URL, encodingHex:
+			name = s
+		default:
+			return "", "", fmt.Errorf("unsupported encoding: %s", s)
+		}
+	}
+
+	return mode, name, nil
+}
+
+// cellEncoder converts the values of a result into text, encoding either
+// every value, or only those that cannot be sent as text, which are the
+// values of binary columns and values that are not valid UTF-8. As the
+// latter can show up in any column, the values encoded outside of binary
+// columns are reported individually, by row and column. Once too many
+// have been, every value of a column that another value is encoded in is
+// encoded from that row on instead.
+type cellEncoder struct {
+	encoding binaryEncoding
+	name     string
+	mode     string
+	always   bool
+	binary   []bool
+	cells    [][2]int64
+	from     []int64
+	row      int64
+}
+
+func newCellEncoder(cfg *gabi.Config, mode, name string) *cellEncoder {
+	var encoding binaryEncoding = cfg.Encoder
+
+	switch name {
+	case encodingBase64URL:
+		encoding = base64.URLEncoding
+	case encodingHex:
+		encoding = hexEncoding{}
+	}
+
+	return &cellEncoder{encoding: encoding, name: name, mode: mode}
+}
+
+// Selective reports whether only some of the values are encoded, which
+// is also the case for formats that always encode binary values.
+func (e *cellEncoder) Selective() bool {
+	return e.mode == encodeAuto || e.always
+}
+
+// Begin starts a new result, made of the given columns.
+func (e *cellEncoder) Begin(columns []*sql.ColumnType) {
+	e.binary = make([]bool, len(columns))
+	e.from = make([]int64, len(columns))
+	for i := range columns {
+		e.binary[i] = classifyType(columns[i].DatabaseTypeName()) == classBinary
+		e.from[i] = -1
+	}
+	e.cells = nil
+	e.row = 0
+}
+
+// Value returns a value of the current row as text.
+func (e *cellEncoder) Value(col int, content []byte) string {
+	switch {
+	case e.mode == encodeAll:
+		return e.encoding.EncodeToString(content)
+	case e.mode == encodeAuto && (e.binary[col] || e.encodedFrom(col) || !utf8.Valid(content)):
+		e.mark(col)
+		return e.encoding.EncodeToString(content)
+	default:
+		return string(content)
+	}
+}
+
+// Typed returns a value of the current row retaining its type, where
+// binary values are always encoded.
+func (e *cellEncoder) Typed(col int, typeName string, value any) any {
+	if b, ok := value.([]byte); ok && !utf8.Valid(b) {
+		e.mark(col)
+	}
+	return typedValue(typeName, value, e.encoding, e.mode == encodeAll || e.encodedFrom(col))
+}
+
+// Next moves on to the next row.
+func (e *cellEncoder) Next() {
+	e.row++
+}
+
+// Columns returns the columns whose every value is encoded.
+func (e *cellEncoder) Columns() []int {
+	if !e.Selective() {
+		return nil
+	}
+
+	var columns []int
+	for i, binary := range e.binary {
+		if binary {
+			columns = append(columns, i)
+		}
+	}
+	return columns
+}
+
+// Cells returns the values encoded outside of binary columns, within
+// the given number of rows, as a row that has not been sent has to be
+// left out.
+func (e *cellEncoder) Cells(rows int64) [][2]int64 {
+	var cells [][2]int64
+	for _, cell := range e.cells {
+		if cell[0] < rows {
+			cells = append(cells, cell)
+		}
+	}
+	return cells
+}
+
+// From returns the columns whose every value is encoded from a given
+// row on, as pairs of row and column, within the given number of rows.
+func (e *cellEncoder) From(rows int64) [][2]int64 {
+	var from [][2]int64
+	for col, row := range e.from {
+		if row >= 0 && row < rows {
+			from = append(from, [2]int64{row, int64(col)})
+		}
+	}
+	return from
+}
+
+func (e *cellEncoder) mark(col int) {
+	switch {
+	case e.binary[col] || e.encodedFrom(col):
+	case len(e.cells) < maxEncodedCells:
+		e.cells = append(e.cells, [2]int64{e.row, int64(col)})
+	default:
+		e.from[col] = e.row
+	}
+}
+
+func (e *cellEncoder) encodedFrom(col int) bool {
+	return e.from[col] >= 0 && e.row >= e.from[col]
+}
+
+// formatCells renders cells as a list of row and column pairs.
+func formatCells(cells [][2]int64) string {
+	s := make([]string, len(cells))
+	for i, cell := range cells {
+		s[i] = fmt.Sprintf("%d:%d", cell[0], cell[1])
+	}
+	return strings.Join(s, ",")
+}
+
+func formatColumns(columns []int) string {
+	s := make([]string, len(columns))
+	for i, column := range columns {
+		s[i] = strconv.Itoa(column)
+	}
+	return strings.Join(s, ",")
+}
@@ -0,0 +1,247 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/app-sre/gabi/internal/test"
+	gabi "github.com/app-sre/gabi/pkg"
+	gabidb "github.com/app-sre/gabi/pkg/env/db"
+	"github.com/app-sre/gabi/pkg/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResultEncoding(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		description string
+		parameters  map[string]string
+		mode        string
+		encoding    string
+		error       string
+	}{
+		{
+			"no encoding requested",
+			map[string]string{},
+			encodeNone,
+			encodingBase64,
+			``,
+		},
+		{
+			"every value encoded using Base64-encoded results flag",
+			map[string]string{"base64_results": "true"},
+			encodeAll,
+			encodingBase64,
+			``,
+		},
+		{
+			"encode mode takes precedence over Base64-encoded results flag",
+			map[string]string{"base64_results": "true", "encode": "auto"},
+			encodeAuto,
+			encodingBase64,
+			``,
+		},
+		{
+			"only binary values encoded using hex",
+			map[string]string{"encode": "auto", "encoding": "hex"},
+			encodeAuto,
+			encodingHex,
+			``,
+		},
+		{
+			"every value encoded using Base64 URL encoding",
+			map[string]string{"encode": "all", "encoding": "base64url"},
+			encodeAll,
+			encodingBase64URL,
+			``,
+		},
+		{
+			"unsupported encode mode",
+			map[string]string{"encode": "test"},
+			"",
+			"",
+			`unsupported encode mode: test`,
+		},
+		{
+			"unsupported encoding",
+			map[string]string{"encoding": "test"},
+			"",
+			"",
+			`unsupported encoding: test`,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			r := httptest.NewRequest(http.MethodPost, "/", nil)
+			q := r.URL.Query()
+			for key, value := range tc.parameters {
+				q.Add(key, value)
+			}
+			r.URL.RawQuery = q.Encode()
+
+			mode, encoding, err := resultEncoding(r)
+
+			if tc.error != "" {
+				assert.EqualError(t, err, tc.error)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.mode, mode)
+			assert.Equal(t, tc.encoding, encoding)
+		})
+	}
+}
+
+func TestQueryEncodedValues(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		description string
+		parameters  string
+		request     string
+		body        string
+		header      http.Header
+	}{
+		{
+			"encoded values reported in trailers",
+			`?format=tsv&encode=auto&encoding=hex`,
+			`{"query": "select * from test;"}`,
+			"name\tdata\ntest\t74657374\nfffe\tdead\n",
+			http.Header{
+				"X-Gabi-Encoding":        {"hex"},
+				"X-Gabi-Encoded-Columns": {"1"},
+				"X-Gabi-Encoded-Cells":   {"1:0"},
+			},
+		},
+		{
+			"encoded values of rows that have not been sent left out",
+			`?format=ndjson&encode=auto`,
+			`{"query": "select * from test;", "row_limit": 1}`,
+			`{"name":"test","data":"dGVzdA=="}` + "\n",
+			http.Header{
+				"X-Gabi-Encoding":        {"base64"},
+				"X-Gabi-Encoded-Columns": {"1"},
+				"X-Gabi-Encoded-Cells":   nil,
+			},
+		},
+		{
+			"encoded values reported with types",
+			`?format=typed`,
+			`{"query": "select * from test;"}`,
+			`{"columns":[{"name":"name","type":"TEXT"},{"name":"data","type":"BYTEA","encoding":"base64"}],"result":[["test","dGVzdA=="],["//4=","3q0="]],"error":"","encoded_columns":[1],"encoded_cells":[[1,0]]}`,
+			http.Header{
+				"X-Gabi-Encoding": {"base64"},
+			},
+		},
+		{
+			"encoded values not reported when every value is encoded",
+			`?encode=all`,
+			`{"query": "select * from test;"}`,
+			`{"result":[["name","data"],["dGVzdA==","dGVzdA=="],["//4=","3q0="]],"error":""}`,
+			http.Header{
+				"X-Gabi-Encoding":        {"base64"},
+				"X-Gabi-Encoded-Columns": nil,
+				"X-Gabi-Encoded-Cells":   nil,
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			var output bytes.Buffer
+
+			db, mock, _ := sqlmock.New()
+			defer func() { _ = db.Close() }()
+
+			rows := sqlmock.NewRowsWithColumnDefinition(
+				sqlmock.NewColumn("name").OfType("TEXT", ""),
+				sqlmock.NewColumn("data").OfType("BYTEA", []byte{}),
+			).AddRow("test", []byte("test")).AddRow([]byte{0xff, 0xfe}, []byte{0xde, 0xad})
+			mock.ExpectBegin()
+			mock.ExpectQuery(`select \* from test;`).WillReturnRows(rows)
+			mock.ExpectCommit()
+
+			cfg := &gabi.Config{DB: db, DBEnv: &gabidb.Env{}, Logger: test.DummyLogger(&output).Sugar(), Encoder: base64.StdEncoding}
+
+			s := httptest.NewServer(Query(cfg))
+			defer s.Close()
+
+			r, err := http.NewRequestWithContext(context.TODO(), http.MethodPost, s.URL+tc.parameters, bytes.NewBufferString(tc.request))
+			require.NoError(t, err)
+
+			actual, err := http.DefaultClient.Do(r)
+			require.NoError(t, err)
+			defer func() { _ = actual.Body.Close() }()
+
+			body, err := io.ReadAll(actual.Body)
+			require.NoError(t, err)
+
+			assert.Equal(t, 200, actual.StatusCode)
+			assert.Contains(t, string(body), tc.body)
+			for key, values := range tc.header {
+				if values == nil {
+					assert.Empty(t, actual.Header.Get(key)+actual.Trailer.Get(key))
+					continue
+				}
+				assert.Equal(t, values[0], actual.Header.Get(key)+actual.Trailer.Get(key))
+			}
+		})
+	}
+}
+
+func TestQueryEncodedValuesLimit(t *testing.T) {
+	t.Parallel()
+
+	var output bytes.Buffer
+
+	db, mock, _ := sqlmock.New()
+	defer func() { _ = db.Close() }()
+
+	rows := sqlmock.NewRowsWithColumnDefinition(
+		sqlmock.NewColumn("name").OfType("TEXT", ""),
+		sqlmock.NewColumn("id").OfType("INT8", int64(0)),
+	)
+	for i := range maxEncodedCells + 1 {
+		rows.AddRow([]byte{0xff, 0xfe}, int64(i))
+	}
+	rows.AddRow("test", int64(maxEncodedCells+1))
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`select \* from test;`).WillReturnRows(rows)
+	mock.ExpectCommit()
+
+	cfg := &gabi.Config{DB: db, DBEnv: &gabidb.Env{}, Logger: test.DummyLogger(&output).Sugar(), Encoder: base64.StdEncoding}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/?encode=auto", bytes.NewBufferString(`{"query": "select * from test;"}`))
+	Query(cfg).ServeHTTP(w, r)
+
+	var response models.QueryResponse
+
+	require.Equal(t, 200, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	require.NoError(t, mock.ExpectationsWereMet())
+
+	// Past the limit, every value of the column is encoded instead.
+	assert.Len(t, response.EncodedCells, maxEncodedCells)
+	assert.Equal(t, [][2]int64{{maxEncodedCells, 0}}, response.EncodedFrom)
+	assert.Equal(t, []string{"//4=", strconv.Itoa(maxEncodedCells)}, response.Result[maxEncodedCells+1])
+	assert.Equal(t, []string{"dGVzdA==", strconv.Itoa(maxEncodedCells + 1)}, response.Result[maxEncodedCells+2])
+}
@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/app-sre/gabi/pkg/models"
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Errors reported by PostgreSQL, by SQLSTATE.
+const (
+	pgInsufficientPrivilege  = "42501"
+	pgReadOnlySQLTransaction = "25006"
+	pgSerializationFailure   = "40001"
+	pgDeadlockDetected       = "40P01"
+	pgLockNotAvailable       = "55P03"
+)
+
+// Errors reported by MySQL, by error number.
+const (
+	mysqlDBAccessDenied          = 1044 // ER_DBACCESS_DENIED_ERROR
+	mysqlTableAccessDenied       = 1142 // ER_TABLEACCESS_DENIED_ERROR
+	mysqlColumnAccessDenied      = 1143 // ER_COLUMNACCESS_DENIED_ERROR
+	mysqlSpecificAccessDenied    = 1227 // ER_SPECIFIC_ACCESS_DENIED_ERROR
+	mysqlOptionPreventsStatement = 1290 // ER_OPTION_PREVENTS_STATEMENT
+	mysqlReadOnlyTransaction     = 1792 // ER_CANT_EXECUTE_IN_READ_ONLY_TRANSACTION
+	mysqlLockDeadlock            = 1213 // ER_LOCK_DEADLOCK
+	mysqlLockWaitTimeout         = 1205 // ER_LOCK_WAIT_TIMEOUT
+)
+
+// databaseError unwraps an error reported by the database, and returns
+// it along with the status code it is best described by. Errors not
+// reported by the database are bad requests.
+func databaseError(err error) (*models.DatabaseError, int) {
+	var (
+		pgError    *pgconn.PgError
+		mysqlError *mysql.MySQLError
+	)
+	switch {
+	case errors.As(err, &pgError):
+		return &models.DatabaseError{
+			Code:     pgError.Code,
+			SQLState: pgError.Code,
+			Severity: pgError.Severity,
+			Message:  pgError.Message,
+			Detail:   pgError.Detail,
+			Hint:     pgError.Hint,
+			Position: pgError.Position,
+		}, pgErrorStatus(pgError.Code)
+	case errors.As(err, &mysqlError):
+		return &models.DatabaseError{
+			Code:     strconv.Itoa(int(mysqlError.Number)),
+			SQLState: string(mysqlError.SQLState[:]),
+			Message:  mysqlError.Message,
+		}, mysqlErrorStatus(mysqlError.Number)
+	default:
+		return nil, http.StatusBadRequest
+	}
+}
+
+func pgErrorStatus(code string) int {
+	switch code {
+	case pgInsufficientPrivilege, pgReadOnlySQLTransaction:
+		return http.StatusForbidden
+	case pgSerializationFailure, pgDeadlockDetected:
+		return http.StatusConflict
+	case pgLockNotAvailable:
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+func mysqlErrorStatus(number uint16) int {
+	switch number {
+	case mysqlDBAccessDenied, mysqlTableAccessDenied, mysqlColumnAccessDenied, mysqlSpecificAccessDenied,
+		mysqlOptionPreventsStatement, mysqlReadOnlyTransaction:
+		return http.StatusForbidden
+	case mysqlLockDeadlock:
+		return http.StatusConflict
+	case mysqlLockWaitTimeout:
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusBadRequest
+	}
+}
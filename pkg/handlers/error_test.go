@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/app-sre/gabi/pkg/models"
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDatabaseError(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		description string
+		given       error
+		want        *models.DatabaseError
+		code        int
+	}{
+		{
+			"error not reported by the database",
+			errors.New("test"),
+			nil,
+			400,
+		},
+		{
+			"PostgreSQL syntax error",
+			&pgconn.PgError{Severity: "ERROR", Code: "42601", Message: "test", Position: 8},
+			&models.DatabaseError{Code: "42601", SQLState: "42601", Severity: "ERROR", Message: "test", Position: 8},
+			400,
+		},
+		{
+			"PostgreSQL permission error",
+			&pgconn.PgError{Severity: "ERROR", Code: "42501", Message: "test"},
+			&models.DatabaseError{Code: "42501", SQLState: "42501", Severity: "ERROR", Message: "test"},
+			403,
+		},
+		{
+			"PostgreSQL read-only violation",
+			&pgconn.PgError{Severity: "ERROR", Code: "25006", Message: "test", Detail: "detail", Hint: "hint"},
+			&models.DatabaseError{Code: "25006", SQLState: "25006", Severity: "ERROR", Message: "test", Detail: "detail", Hint: "hint"},
+			403,
+		},
+		{
+			"PostgreSQL serialization failure",
+			&pgconn.PgError{Code: "40001"},
+			&models.DatabaseError{Code: "40001", SQLState: "40001"},
+			409,
+		},
+		{
+			"PostgreSQL deadlock",
+			&pgconn.PgError{Code: "40P01"},
+			&models.DatabaseError{Code: "40P01", SQLState: "40P01"},
+			409,
+		},
+		{
+			"PostgreSQL lock timeout",
+			&pgconn.PgError{Code: "55P03"},
+			&models.DatabaseError{Code: "55P03", SQLState: "55P03"},
+			504,
+		},
+		{
+			"wrapped PostgreSQL error",
+			fmt.Errorf("statement 1: %w", &pgconn.PgError{Code: "40P01"}),
+			&models.DatabaseError{Code: "40P01", SQLState: "40P01"},
+			409,
+		},
+		{
+			"MySQL syntax error",
+			&mysql.MySQLError{Number: 1064, SQLState: [5]byte{'4', '2', '0', '0', '0'}, Message: "test"},
+			&models.DatabaseError{Code: "1064", SQLState: "42000", Message: "test"},
+			400,
+		},
+		{
+			"MySQL permission error",
+			&mysql.MySQLError{Number: 1142, SQLState: [5]byte{'4', '2', '0', '0', '0'}, Message: "test"},
+			&models.DatabaseError{Code: "1142", SQLState: "42000", Message: "test"},
+			403,
+		},
+		{
+			"MySQL read-only violation",
+			&mysql.MySQLError{Number: 1792, SQLState: [5]byte{'2', '5', '0', '0', '6'}, Message: "test"},
+			&models.DatabaseError{Code: "1792", SQLState: "25006", Message: "test"},
+			403,
+		},
+		{
+			"MySQL deadlock",
+			&mysql.MySQLError{Number: 1213, SQLState: [5]byte{'4', '0', '0', '0', '1'}, Message: "test"},
+			&models.DatabaseError{Code: "1213", SQLState: "40001", Message: "test"},
+			409,
+		},
+		{
+			"MySQL lock wait timeout",
+			&mysql.MySQLError{Number: 1205, SQLState: [5]byte{'H', 'Y', '0', '0', '0'}, Message: "test"},
+			&models.DatabaseError{Code: "1205", SQLState: "HY000", Message: "test"},
+			504,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			actual, code := databaseError(tc.given)
+
+			assert.Equal(t, tc.want, actual)
+			assert.Equal(t, tc.code, code)
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	gabi "github.com/app-sre/gabi/pkg"
+	"github.com/app-sre/gabi/pkg/audit"
+	"github.com/app-sre/gabi/pkg/middleware"
+	"github.com/app-sre/gabi/pkg/models"
+)
+
+// Explain returns the plan of a query using the syntax of the database
+// in use. When the plan is to be analysed, the query is executed, so
+// the transaction is always rolled back.
+func Explain(cfg *gabi.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		var request models.ExplainRequest
+
+		result, ok := ctx.Value(middleware.ContextKeyResult).(*audit.ResultData)
+		if !ok {
+			result = &audit.ResultData{}
+		}
+
+		// Recorded however getting the plan went, even when the query
+		// has not been executed at all.
+		ow := &outcomeWriter{ResponseWriter: w}
+		defer ow.outcome(time.Now(), result)
+		w = ow
+
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			cfg.Logger.Errorf("Unable to decode request body: %s", err)
+			if errors.Is(err, io.EOF) {
+				http.Error(w, "Request body cannot be empty", http.StatusBadRequest)
+				return
+			}
+			_ = queryErrorResponse(ctx, w, err)
+			return
+		}
+
+		// The query might have already been decoded upstream.
+		ctxQuery, _ := ctx.Value(middleware.ContextKeyQuery).(string)
+		if ctxQuery != "" {
+			request.Query = ctxQuery
+		} else if s := r.URL.Query().Get("base64_query"); s != "" {
+			if ok, err := strconv.ParseBool(s); err == nil && ok {
+				bytes, err := cfg.Encoder.DecodeString(request.Query)
+				if err != nil {
+					l := "Unable to decode Base64-encoded query"
+					cfg.Logger.Errorf("%s: %s", l, err)
+					http.Error(w, l, http.StatusBadRequest)
+					return
+				}
+				request.Query = string(bytes)
+			}
+		}
+
+		if request.Query == "" {
+			http.Error(w, "Query cannot be empty", http.StatusBadRequest)
+			return
+		}
+
+		r, cancel, err := withTimeout(r, request.Timeout)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Unable to process query timeout: %s", err), http.StatusBadRequest)
+			return
+		}
+		defer cancel()
+		ctx = r.Context()
+
+		explain := cfg.DBEnv.Driver.Explain(request.Analyze)
+		if explain == "" {
+			http.Error(w, "Query plans are not supported for the database in use", http.StatusBadRequest)
+			return
+		}
+
+		args, err := queryArgs(cfg.Encoder, request.Args)
+		if err != nil {
+			l := "Unable to process query arguments"
+			cfg.Logger.Errorf("%s: %s", l, err)
+			http.Error(w, fmt.Sprintf("%s: %s", l, err), http.StatusBadRequest)
+			return
+		}
+
+		r, finish, err := startQuery(w, r, cfg, request.Query)
+		if err != nil {
+			cfg.Logger.Errorf("Unable to register query: %s", err)
+			http.Error(w, "An internal error has occurred", http.StatusInternalServerError)
+			return
+		}
+		defer finish()
+		ctx = r.Context()
+
+		tx, release, err := beginTx(ctx, cfg)
+		if err != nil {
+			cfg.Logger.Errorf("Unable to start database transaction: %s", err)
+			_ = queryErrorResponse(ctx, w, err)
+			return
+		}
+		defer release()
+		defer func() { _ = tx.Rollback() }()
+
+		err = setStatementTimeout(ctx, cfg, tx)
+		if err != nil {
+			cfg.Logger.Errorf("Unable to set statement timeout: %s", err)
+			_ = queryErrorResponse(ctx, w, err)
+			return
+		}
+
+		untrack, err := trackBackend(ctx, cfg, tx)
+		if err != nil {
+			cfg.Logger.Errorf("Unable to identify database connection: %s", err)
+			_ = queryErrorResponse(ctx, w, err)
+			return
+		}
+		defer untrack()
+
+		rows, err := tx.QueryContext(ctx, explain+request.Query, args...)
+		if err != nil {
+			cfg.Logger.Errorf("Unable to query database: %s", err)
+			_ = queryErrorResponse(ctx, w, err)
+			return
+		}
+		defer func() { _ = rows.Close() }()
+
+		// The plan is returned in the first column, and split across
+		// several rows only when reported as text.
+		var plan bytes.Buffer
+		for rows.Next() {
+			var line sql.RawBytes
+			if err := rows.Scan(&line); err != nil {
+				cfg.Logger.Errorf("Unable to process database rows: %s", err)
+				_ = queryErrorResponse(ctx, w, err)
+				return
+			}
+			if plan.Len() > 0 {
+				plan.WriteByte('\n')
+			}
+			plan.Write(line)
+			result.Rows++
+		}
+		if err := rows.Err(); err != nil {
+			cfg.Logger.Errorf("Unable to process database rows: %s", err)
+			_ = queryErrorResponse(ctx, w, err)
+			return
+		}
+		_ = rows.Close()
+
+		untrack()
+		err = tx.Rollback()
+		if err != nil {
+			cfg.Logger.Errorf("Unable to roll back database changes: %s", err)
+			_ = queryErrorResponse(ctx, w, err)
+			return
+		}
+
+		response := &models.ExplainResponse{
+			Plan:    plan.Bytes(),
+			Analyze: request.Analyze,
+		}
+		if !json.Valid(response.Plan) {
+			response.Plan, err = json.Marshal(plan.String())
+			if err != nil {
+				cfg.Logger.Errorf("Unable to process query plan: %s", err)
+				http.Error(w, "An internal error has occurred", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Header().Set("Cache-Control", "private, no-store")
+
+		err = json.NewEncoder(w).Encode(response)
+		if err != nil {
+			cfg.Logger.Errorf("Unable to send response: %s", err)
+		}
+	}
+}
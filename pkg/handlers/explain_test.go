@@ -0,0 +1,378 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/app-sre/gabi/internal/test"
+	gabi "github.com/app-sre/gabi/pkg"
+	"github.com/app-sre/gabi/pkg/audit"
+	gabidb "github.com/app-sre/gabi/pkg/env/db"
+	"github.com/app-sre/gabi/pkg/middleware"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExplain(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		description string
+		driver      gabidb.DriverType
+		mock        func(sqlmock.Sqlmock)
+		context     func() context.Context
+		request     func() *bytes.Buffer
+		code        int
+		body        string
+		want        string
+	}{
+		{
+			"valid query plan for PostgreSQL",
+			"pgx",
+			func(mock sqlmock.Sqlmock) {
+				rows := sqlmock.NewRows([]string{"QUERY PLAN"}).AddRow(`[{"Plan": {"Node Type": "Result"}}]`)
+				mock.ExpectBegin()
+				mock.ExpectQuery(`^EXPLAIN \(FORMAT JSON\) select \$1;$`).WithArgs(int64(1)).WillReturnRows(rows)
+				mock.ExpectRollback()
+			},
+			func() context.Context {
+				return context.TODO()
+			},
+			func() *bytes.Buffer {
+				return bytes.NewBufferString(`{"query": "select $1;", "args": [1]}`)
+			},
+			200,
+			`{"plan":[{"Plan":{"Node Type":"Result"}}],"error":""}`,
+			``,
+		},
+		{
+			"valid query plan for MySQL",
+			"mysql",
+			func(mock sqlmock.Sqlmock) {
+				rows := sqlmock.NewRows([]string{"EXPLAIN"}).AddRow(`{"query_block": {"select_id": 1}}`)
+				mock.ExpectBegin()
+				mock.ExpectExec(`^SET SESSION max_execution_time = DEFAULT$`).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectQuery(`^EXPLAIN FORMAT=JSON select 1;$`).WillReturnRows(rows)
+				mock.ExpectRollback()
+				mock.ExpectExec(`^SET SESSION max_execution_time = DEFAULT$`).WillReturnResult(sqlmock.NewResult(0, 0))
+			},
+			func() context.Context {
+				return context.TODO()
+			},
+			func() *bytes.Buffer {
+				return bytes.NewBufferString(`{"query": "select 1;"}`)
+			},
+			200,
+			`{"plan":{"query_block":{"select_id":1}},"error":""}`,
+			``,
+		},
+		{
+			"valid analysed query plan for PostgreSQL",
+			"postgres",
+			func(mock sqlmock.Sqlmock) {
+				rows := sqlmock.NewRows([]string{"QUERY PLAN"}).AddRow(`[{"Plan": {}, "Execution Time": 0.1}]`)
+				mock.ExpectBegin()
+				mock.ExpectQuery(`^EXPLAIN \(ANALYZE, FORMAT JSON\) delete from test;$`).WillReturnRows(rows)
+				mock.ExpectRollback()
+			},
+			func() context.Context {
+				return context.TODO()
+			},
+			func() *bytes.Buffer {
+				return bytes.NewBufferString(`{"query": "delete from test;", "analyze": true}`)
+			},
+			200,
+			`{"plan":[{"Plan":{},"Execution Time":0.1}],"analyze":true,"error":""}`,
+			``,
+		},
+		{
+			"valid analysed query plan for MySQL reported as text",
+			"mysql",
+			func(mock sqlmock.Sqlmock) {
+				rows := sqlmock.NewRows([]string{"EXPLAIN"}).AddRow("-> Rows fetched before execution  (cost=0..0 rows=1)")
+				mock.ExpectBegin()
+				mock.ExpectExec(`^SET SESSION max_execution_time = DEFAULT$`).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectQuery(`^EXPLAIN ANALYZE select 1;$`).WillReturnRows(rows)
+				mock.ExpectRollback()
+				mock.ExpectExec(`^SET SESSION max_execution_time = DEFAULT$`).WillReturnResult(sqlmock.NewResult(0, 0))
+			},
+			func() context.Context {
+				return context.TODO()
+			},
+			func() *bytes.Buffer {
+				return bytes.NewBufferString(`{"query": "select 1;", "analyze": true}`)
+			},
+			200,
+			`{"plan":"-\u003e Rows fetched before execution  (cost=0..0 rows=1)","analyze":true,"error":""}`,
+			``,
+		},
+		{
+			"valid query plan with timeout enforced by the database",
+			"pgx",
+			func(mock sqlmock.Sqlmock) {
+				rows := sqlmock.NewRows([]string{"QUERY PLAN"}).AddRow(`[]`)
+				mock.ExpectBegin()
+				mock.ExpectExec(`^SET LOCAL statement_timeout = \d+$`).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectQuery(`^EXPLAIN \(FORMAT JSON\) select 1;$`).WillReturnRows(rows)
+				mock.ExpectRollback()
+			},
+			func() context.Context {
+				return context.TODO()
+			},
+			func() *bytes.Buffer {
+				return bytes.NewBufferString(`{"query": "select 1;", "timeout": "5s"}`)
+			},
+			200,
+			`{"plan":[],"error":""}`,
+			``,
+		},
+		{
+			"query plan that timed out",
+			"pgx",
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectExec(`^SET LOCAL statement_timeout = \d+$`).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectQuery(`^EXPLAIN \(FORMAT JSON\) select pg_sleep\(10\);$`).WillReturnError(context.DeadlineExceeded)
+				mock.ExpectRollback()
+			},
+			func() context.Context {
+				return context.TODO()
+			},
+			func() *bytes.Buffer {
+				return bytes.NewBufferString(`{"query": "select pg_sleep(10);", "timeout": "1s"}`)
+			},
+			504,
+			`Query timed out`,
+			``,
+		},
+		{
+			"query plan cancelled before the timeout",
+			"pgx",
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectExec(`^SET LOCAL statement_timeout = \d+$`).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectQuery(`^EXPLAIN \(FORMAT JSON\) select pg_sleep\(10\);$`).WillReturnError(&pgconn.PgError{Code: "57014"})
+				mock.ExpectRollback()
+			},
+			func() context.Context {
+				return context.TODO()
+			},
+			func() *bytes.Buffer {
+				return bytes.NewBufferString(`{"query": "select pg_sleep(10);", "timeout": "1m"}`)
+			},
+			409,
+			`Query was cancelled`,
+			``,
+		},
+		{
+			"invalid query plan with negative timeout",
+			"pgx",
+			func(mock sqlmock.Sqlmock) {
+				// No-op.
+			},
+			func() context.Context {
+				return context.TODO()
+			},
+			func() *bytes.Buffer {
+				return bytes.NewBufferString(`{"query": "select 1;", "timeout": "-1s"}`)
+			},
+			400,
+			`Unable to process query timeout: timeout has to be positive`,
+			``,
+		},
+		{
+			"valid query plan with SQL statements passed via context",
+			"pgx",
+			func(mock sqlmock.Sqlmock) {
+				rows := sqlmock.NewRows([]string{"QUERY PLAN"}).AddRow(`[]`)
+				mock.ExpectBegin()
+				mock.ExpectQuery(`^EXPLAIN \(FORMAT JSON\) select 2;$`).WillReturnRows(rows)
+				mock.ExpectRollback()
+			},
+			func() context.Context {
+				return context.WithValue(context.TODO(), middleware.ContextKeyQuery, "select 2;")
+			},
+			func() *bytes.Buffer {
+				return bytes.NewBufferString(`{"query": "select 1;"}`)
+			},
+			200,
+			`{"plan":[],"error":""}`,
+			``,
+		},
+		{
+			"invalid query plan for which database returned an error",
+			"pgx",
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(`^EXPLAIN \(FORMAT JSON\) select test;$`).WillReturnError(errors.New("test"))
+				mock.ExpectRollback()
+			},
+			func() context.Context {
+				return context.TODO()
+			},
+			func() *bytes.Buffer {
+				return bytes.NewBufferString(`{"query": "select test;"}`)
+			},
+			400,
+			`{"result":null,"error":"test"}`,
+			`Unable to query database: test`,
+		},
+		{
+			"invalid query plan with no query provided",
+			"pgx",
+			func(mock sqlmock.Sqlmock) {
+				// No-op.
+			},
+			func() context.Context {
+				return context.TODO()
+			},
+			func() *bytes.Buffer {
+				return bytes.NewBufferString(`{"query": ""}`)
+			},
+			400,
+			`Query cannot be empty`,
+			``,
+		},
+		{
+			"invalid query plan with unsupported database",
+			"",
+			func(mock sqlmock.Sqlmock) {
+				// No-op.
+			},
+			func() context.Context {
+				return context.TODO()
+			},
+			func() *bytes.Buffer {
+				return bytes.NewBufferString(`{"query": "select 1;"}`)
+			},
+			400,
+			`Query plans are not supported for the database in use`,
+			``,
+		},
+		{
+			"invalid query plan with empty body",
+			"pgx",
+			func(mock sqlmock.Sqlmock) {
+				// No-op.
+			},
+			func() context.Context {
+				return context.TODO()
+			},
+			func() *bytes.Buffer {
+				return &bytes.Buffer{}
+			},
+			400,
+			`Request body cannot be empty`,
+			`Unable to decode request body`,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			var body, output bytes.Buffer
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/", tc.request())
+
+			logger := test.DummyLogger(&output).Sugar()
+			encoder := base64.StdEncoding
+
+			db, mock, _ := sqlmock.New()
+			defer func() { _ = db.Close() }()
+
+			tc.mock(mock)
+
+			expected := &gabi.Config{DB: db, DBEnv: &gabidb.Env{Driver: tc.driver}, Logger: logger, Encoder: encoder}
+			Explain(expected).ServeHTTP(w, r.WithContext(tc.context()))
+
+			actual := w.Result()
+			defer func() { _ = actual.Body.Close() }()
+
+			_, _ = io.Copy(&body, actual.Body)
+
+			err := mock.ExpectationsWereMet()
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.code, actual.StatusCode)
+			assert.Contains(t, body.String(), tc.body)
+			assert.Contains(t, output.String(), tc.want)
+		})
+	}
+}
+
+func TestExplainOutcome(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		description string
+		mock        func(sqlmock.Sqlmock)
+		code        int
+		status      string
+		class       string
+	}{
+		{
+			"query plan returned",
+			func(mock sqlmock.Sqlmock) {
+				rows := sqlmock.NewRows([]string{"QUERY PLAN"}).AddRow(`[]`)
+				mock.ExpectBegin()
+				mock.ExpectQuery(`^EXPLAIN \(FORMAT JSON\) select 1;$`).WillReturnRows(rows)
+				mock.ExpectRollback()
+			},
+			200,
+			audit.StatusSuccess,
+			"",
+		},
+		{
+			"query plan rejected by the database",
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(`^EXPLAIN \(FORMAT JSON\) select 1;$`).WillReturnError(&pgconn.PgError{Code: "42601"})
+				mock.ExpectRollback()
+			},
+			400,
+			audit.StatusSQLError,
+			"42",
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			var output bytes.Buffer
+
+			db, mock, _ := sqlmock.New()
+			defer func() { _ = db.Close() }()
+
+			tc.mock(mock)
+
+			result := &audit.ResultData{}
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(`{"query": "select 1;"}`))
+			ctx := context.WithValue(context.TODO(), middleware.ContextKeyResult, result)
+
+			cfg := &gabi.Config{DB: db, DBEnv: &gabidb.Env{Driver: "pgx"}, Logger: test.DummyLogger(&output).Sugar(), Encoder: base64.StdEncoding}
+			Explain(cfg).ServeHTTP(w, r.WithContext(ctx))
+
+			require.NoError(t, mock.ExpectationsWereMet())
+			assert.Equal(t, tc.code, w.Code)
+			assert.Equal(t, tc.status, result.Status)
+			assert.Equal(t, tc.class, result.ErrorClass)
+			assert.Equal(t, tc.code, result.Code)
+			assert.Equal(t, int64(w.Body.Len()), result.Bytes)
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	formatJSON   = "json"
+	formatTyped  = "typed"
+	formatCSV    = "csv"
+	formatTSV    = "tsv"
+	formatNDJSON = "ndjson"
+)
+
+var mediaTypeFormats = map[string]string{
+	"application/json":          formatJSON,
+	"text/csv":                  formatCSV,
+	"text/tab-separated-values": formatTSV,
+	"application/x-ndjson":      formatNDJSON,
+	"application/ndjson":        formatNDJSON,
+	"application/*":             formatJSON,
+	"*/*":                       formatJSON,
+}
+
+// resultFormat picks the format of the result either from the "format"
+// query parameter, which takes precedence, or from the Accept header.
+// Clients that do not accept any of the supported media types are sent
+// JSON, as has always been the case.
+func resultFormat(r *http.Request) (string, bool) {
+	if s := r.URL.Query().Get("format"); s != "" {
+		switch s {
+		case formatJSON, formatTyped, formatCSV, formatTSV, formatNDJSON:
+			return s, true
+		default:
+			return "", false
+		}
+	}
+
+	type mediaRange struct {
+		format string
+		q      float64
+	}
+
+	var ranges []mediaRange
+
+	for _, accept := range r.Header.Values("Accept") {
+		for _, entry := range strings.Split(accept, ",") {
+			mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(entry))
+			if err != nil {
+				continue
+			}
+			format, ok := mediaTypeFormats[mediaType]
+			if !ok {
+				continue
+			}
+			q := 1.0
+			if s, ok := params["q"]; ok {
+				if f, err := strconv.ParseFloat(s, 64); err == nil {
+					q = f
+				}
+			}
+			if q > 0 {
+				ranges = append(ranges, mediaRange{format: format, q: q})
+			}
+		}
+	}
+
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return ranges[i].q > ranges[j].q
+	})
+	if len(ranges) > 0 {
+		return ranges[0].format, true
+	}
+
+	return formatJSON, true
+}
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResultFormat(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		description string
+		format      string
+		accept      []string
+		want        string
+		ok          bool
+	}{
+		{
+			"no format requested",
+			"",
+			nil,
+			formatJSON,
+			true,
+		},
+		{
+			"format requested using query parameter",
+			"csv",
+			nil,
+			formatCSV,
+			true,
+		},
+		{
+			"format requested using query parameter takes precedence",
+			"tsv",
+			[]string{"application/x-ndjson"},
+			formatTSV,
+			true,
+		},
+		{
+			"unsupported format requested using query parameter",
+			"xml",
+			nil,
+			"",
+			false,
+		},
+		{
+			"format requested using Accept header",
+			"",
+			[]string{"text/csv"},
+			formatCSV,
+			true,
+		},
+		{
+			"format requested using Accept header with parameters",
+			"",
+			[]string{"text/tab-separated-values; charset=utf-8"},
+			formatTSV,
+			true,
+		},
+		{
+			"format requested using Accept header with quality values",
+			"",
+			[]string{"application/json;q=0.5, application/x-ndjson"},
+			formatNDJSON,
+			true,
+		},
+		{
+			"format requested using Accept header with media type that is not acceptable",
+			"",
+			[]string{"text/csv;q=0, application/json"},
+			formatJSON,
+			true,
+		},
+		{
+			"format requested using Accept header with any media type",
+			"",
+			[]string{"*/*"},
+			formatJSON,
+			true,
+		},
+		{
+			"unsupported format requested using Accept header",
+			"",
+			[]string{"application/xml"},
+			formatJSON,
+			true,
+		},
+		{
+			"malformed Accept header",
+			"",
+			[]string{";;;"},
+			formatJSON,
+			true,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			r := httptest.NewRequest(http.MethodPost, "/", nil)
+			if tc.format != "" {
+				q := r.URL.Query()
+				q.Add("format", tc.format)
+				r.URL.RawQuery = q.Encode()
+			}
+			for _, accept := range tc.accept {
+				r.Header.Add("Accept", accept)
+			}
+
+			actual, ok := resultFormat(r)
+
+			assert.Equal(t, tc.ok, ok)
+			assert.Equal(t, tc.want, actual)
+		})
+	}
+}
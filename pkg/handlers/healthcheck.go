@@ -3,12 +3,14 @@ package handlers
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net/http"
 	"time"
 
 	"github.com/etherlabsio/healthcheck/v2"
 
 	gabi "github.com/app-sre/gabi/pkg"
+	"github.com/app-sre/gabi/pkg/audit"
 )
 
 const healthcheckTimeout = 5 * time.Second
@@ -29,5 +31,48 @@ func Healthcheck(cfg *gabi.Config) http.Handler {
 				},
 			),
 		),
+		// Reported without failing the check, as restarting does not
+		// make Splunk available again.
+		healthcheck.WithObserver(
+			"splunk", healthcheck.CheckerFunc(
+				func(ctx context.Context) error {
+					for _, breaker := range auditSinks[audit.Breaker](cfg) {
+						if breaker.Circuit() == audit.CircuitOpen {
+							return errors.New("Unable to send audit to Splunk (circuit breaker is open)")
+						}
+					}
+					return nil
+				},
+			),
+		),
+		healthcheck.WithObserver(
+			"spool", healthcheck.CheckerFunc(
+				func(ctx context.Context) error {
+					for _, spooler := range auditSinks[audit.Spooler](cfg) {
+						if stats := spooler.Spool(); stats.Events > 0 {
+							age := time.Since(stats.Oldest).Round(time.Second)
+							return fmt.Errorf("Audit events not sent to Splunk yet: %d (oldest from %s ago)", stats.Events, age)
+						}
+					}
+					return nil
+				},
+			),
+		),
 	)
 }
+
+// auditSinks returns the audit sinks that report on their state in the
+// given way.
+func auditSinks[T any](cfg *gabi.Config) []T {
+	if cfg.Audit == nil {
+		return nil
+	}
+
+	var sinks []T
+	for _, sink := range cfg.Audit.Sinks() {
+		if t, ok := sink.Audit.(T); ok {
+			sinks = append(sinks, t)
+		}
+	}
+	return sinks
+}
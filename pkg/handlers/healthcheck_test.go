@@ -2,15 +2,19 @@ package handlers
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/app-sre/gabi/internal/test"
 	gabi "github.com/app-sre/gabi/pkg"
+	"github.com/app-sre/gabi/pkg/audit"
+	"github.com/app-sre/gabi/pkg/env/splunk"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -21,6 +25,8 @@ func TestHealthcheck(t *testing.T) {
 	cases := []struct {
 		description string
 		given       func(sqlmock.Sqlmock)
+		open        bool
+		spooled     bool
 		code        int
 		body        string
 	}{
@@ -29,14 +35,38 @@ func TestHealthcheck(t *testing.T) {
 			func(mock sqlmock.Sqlmock) {
 				mock.ExpectPing()
 			},
+			false,
+			false,
 			200,
 			`{"status":"OK"}`,
 		},
+		{
+			"database is accessible and Splunk circuit breaker is open",
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectPing()
+			},
+			true,
+			false,
+			200,
+			`{"splunk":"Unable to send audit to Splunk (circuit breaker is open)"}`,
+		},
+		{
+			"database is accessible and audit spool holds events",
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectPing()
+			},
+			false,
+			true,
+			200,
+			`{"spool":"Audit events not sent to Splunk yet: 1 (oldest from`,
+		},
 		{
 			"database is not accessible",
 			func(mock sqlmock.Sqlmock) {
 				mock.ExpectPing().WillReturnError(errors.New("test"))
 			},
+			false,
+			false,
 			503,
 			`{"database":"Unable to connect to the database"}`,
 		},
@@ -59,7 +89,33 @@ func TestHealthcheck(t *testing.T) {
 
 			tc.given(mock)
 
-			expected := &gabi.Config{DB: db, Logger: logger}
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}))
+			defer server.Close()
+
+			sa := audit.NewSplunkAudit(&splunk.Env{
+				Endpoint:         server.URL,
+				BreakerThreshold: 1,
+				BreakerCooldown:  time.Minute,
+			}, audit.WithHTTPClient(server.Client()))
+			if tc.open {
+				_ = sa.Write(context.TODO(), &audit.QueryData{Query: "select 1;", User: "test"})
+			}
+
+			var splunkAudit audit.Audit = sa
+			if tc.spooled {
+				spool, err := audit.NewSpoolAudit(sa, t.TempDir(), 1<<20, nil)
+				require.NoError(t, err)
+				defer func() { _ = spool.Close() }()
+
+				_ = spool.Write(context.TODO(), &audit.QueryData{Query: "select 1;", User: "test", Timestamp: time.Now().Unix()})
+				splunkAudit = spool
+			}
+
+			pipeline := audit.NewPipeline([]audit.Sink{{Name: "splunk", Audit: splunkAudit, Required: true}}, 0, logger)
+
+			expected := &gabi.Config{DB: db, Audit: pipeline, Logger: logger}
 			Healthcheck(expected).ServeHTTP(w, r)
 
 			actual := w.Result()
@@ -0,0 +1,298 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	gabi "github.com/app-sre/gabi/pkg"
+	"github.com/app-sre/gabi/pkg/job"
+	"github.com/app-sre/gabi/pkg/middleware"
+	"github.com/app-sre/gabi/pkg/models"
+	"github.com/app-sre/gabi/pkg/running"
+	"github.com/gorilla/mux"
+)
+
+// Only the start of an error response is kept to describe the error.
+const spoolErrorLength = 1024
+
+// SubmitJob runs a query in the background, as a job, and reports the
+// job straight away. A job takes the same request as a query, and its
+// result is the response the query would have been sent.
+func SubmitJob(cfg *gabi.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		var request models.QueryRequest
+
+		if cfg.Jobs == nil {
+			http.Error(w, "Jobs are not supported", http.StatusBadRequest)
+			return
+		}
+
+		if _, ok := resultFormat(r); !ok {
+			l := fmt.Sprintf("Unsupported result format: %s", r.URL.Query().Get("format"))
+			http.Error(w, l, http.StatusBadRequest)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			cfg.Logger.Errorf("Unable to read request body: %s", err)
+			http.Error(w, "An internal error has occurred", http.StatusInternalServerError)
+			return
+		}
+
+		err = json.Unmarshal(body, &request)
+		if err != nil {
+			cfg.Logger.Errorf("Unable to decode request body: %s", err)
+			if len(bytes.TrimSpace(body)) == 0 {
+				http.Error(w, "Request body cannot be empty", http.StatusBadRequest)
+				return
+			}
+			_ = queryErrorResponse(ctx, w, err)
+			return
+		}
+
+		if request.PageSize > 0 || request.Cursor != "" {
+			http.Error(w, "Jobs cannot be paginated", http.StatusBadRequest)
+			return
+		}
+
+		// The result of a job is spooled to disk, so it is truncated
+		// like any other result, only never beyond JOB_MAX_BYTES.
+		if cfg.QueryEnv != nil && cfg.QueryEnv.MaxJobBytes > 0 {
+			request.ByteLimit = tighterLimit(cfg.QueryEnv.MaxJobBytes, request.ByteLimit)
+			body, err = json.Marshal(request)
+			if err != nil {
+				cfg.Logger.Errorf("Unable to encode request body: %s", err)
+				http.Error(w, "An internal error has occurred", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		user, _ := ctx.Value(middleware.ContextKeyUser).(string)
+
+		// The query might have already been decoded upstream.
+		query := request.Query
+		if ctxQuery, _ := ctx.Value(middleware.ContextKeyQuery).(string); ctxQuery != "" {
+			query = ctxQuery
+		}
+
+		// The job outlives the request, and can be cancelled like any
+		// other query, even while queued.
+		jobCtx := context.WithoutCancel(ctx)
+		finish := func() {}
+
+		var queryID string
+		if cfg.Queries != nil {
+			var q *running.Query
+			jobCtx, q, err = cfg.Queries.Start(jobCtx, user, cfg.GetCurrentDBName(), query)
+			if err != nil {
+				cfg.Logger.Errorf("Unable to register query: %s", err)
+				http.Error(w, "An internal error has occurred", http.StatusInternalServerError)
+				return
+			}
+			queryID, finish = q.ID, q.Finish
+		}
+
+		audited := middleware.DeferResultAudit(ctx)
+		req := r.Clone(jobCtx)
+
+		run := func(ctx context.Context, w io.Writer) job.Result {
+			defer audited()
+			defer finish()
+
+			spool := newSpoolWriter(w)
+			req.Body = io.NopCloser(bytes.NewReader(body))
+			Query(cfg).ServeHTTP(spool, req.WithContext(ctx))
+
+			return spool.result(ctx)
+		}
+
+		j, err := cfg.Jobs.Submit(jobCtx, user, queryID, run)
+		if err != nil {
+			finish()
+			audited()
+			cfg.Logger.Errorf("Unable to submit job: %s", err)
+			if errors.Is(err, job.ErrLimitReached) {
+				http.Error(w, "Too many jobs", http.StatusTooManyRequests)
+				return
+			}
+			http.Error(w, "An internal error has occurred", http.StatusInternalServerError)
+			return
+		}
+		cfg.Logger.Debugf("Submitted job %s (user: %s)", j.Info().ID, user)
+
+		w.Header().Set("Location", "/jobs/"+j.Info().ID)
+		writeJob(cfg, w, http.StatusAccepted, j.Info())
+	}
+}
+
+// GetJob reports the status of a job submitted by the same user.
+func GetJob(cfg *gabi.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		j, ok := userJob(cfg, w, r)
+		if !ok {
+			return
+		}
+		writeJob(cfg, w, http.StatusOK, j.Info())
+	}
+}
+
+// GetJobResult sends the result of a job that has finished, as it
+// would have been sent had the query not been run as a job. As the
+// result is complete, what would otherwise be sent as trailers is
+// sent as headers instead.
+func GetJobResult(cfg *gabi.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		j, ok := userJob(cfg, w, r)
+		if !ok {
+			return
+		}
+
+		f, err := j.Open()
+		if err != nil {
+			cfg.Logger.Errorf("Unable to open result of job %s: %s", j.Info().ID, err)
+			switch {
+			case errors.Is(err, job.ErrNotFinished):
+				http.Error(w, "Job has not finished", http.StatusConflict)
+			case errors.Is(err, job.ErrNotFound):
+				http.Error(w, "Job not found", http.StatusNotFound)
+			default:
+				http.Error(w, "An internal error has occurred", http.StatusInternalServerError)
+			}
+			return
+		}
+		defer func() { _ = f.Close() }()
+
+		info := j.Info()
+		for key, values := range info.Header {
+			if key == "Trailer" {
+				continue
+			}
+			w.Header()[key] = values
+		}
+		w.WriteHeader(info.Code)
+
+		_, err = io.Copy(w, f)
+		if err != nil {
+			cfg.Logger.Errorf("Unable to send response: %s", err)
+		}
+	}
+}
+
+func userJob(cfg *gabi.Config, w http.ResponseWriter, r *http.Request) (*job.Job, bool) {
+	if cfg.Jobs == nil {
+		http.Error(w, "Jobs are not supported", http.StatusBadRequest)
+		return nil, false
+	}
+
+	user, _ := r.Context().Value(middleware.ContextKeyUser).(string)
+
+	j, err := cfg.Jobs.Get(mux.Vars(r)["id"], user)
+	if err != nil {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return nil, false
+	}
+	return j, true
+}
+
+func writeJob(cfg *gabi.Config, w http.ResponseWriter, code int, info job.Info) {
+	response := &models.JobResponse{
+		ID:      info.ID,
+		Status:  string(info.Status),
+		QueryID: info.QueryID,
+		Created: info.Created.UTC(),
+		Error:   info.Error,
+	}
+	if !info.Started.IsZero() {
+		started := info.Started.UTC()
+		response.Started = &started
+	}
+	if !info.Finished.IsZero() {
+		finished := info.Finished.UTC()
+		response.Finished = &finished
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "private, no-store")
+	w.WriteHeader(code)
+
+	err := json.NewEncoder(w).Encode(response)
+	if err != nil {
+		cfg.Logger.Errorf("Unable to send response: %s", err)
+	}
+}
+
+// spoolWriter captures the response to a query run as a job, writing
+// its body to the spool file of the job.
+type spoolWriter struct {
+	w      io.Writer
+	header http.Header
+	code   int
+	errBuf bytes.Buffer
+}
+
+func newSpoolWriter(w io.Writer) *spoolWriter {
+	return &spoolWriter{w: w, header: make(http.Header)}
+}
+
+func (s *spoolWriter) Header() http.Header {
+	return s.header
+}
+
+func (s *spoolWriter) WriteHeader(code int) {
+	if s.code == 0 {
+		s.code = code
+	}
+}
+
+func (s *spoolWriter) Write(b []byte) (int, error) {
+	s.WriteHeader(http.StatusOK)
+	if s.code != http.StatusOK && s.errBuf.Len() < spoolErrorLength {
+		s.errBuf.Write(b[:min(len(b), spoolErrorLength-s.errBuf.Len())])
+	}
+	return s.w.Write(b)
+}
+
+// result describes how the query went, which failed when it was either
+// sent with an error status code, or with an error reported in-band.
+func (s *spoolWriter) result(ctx context.Context) job.Result {
+	s.WriteHeader(http.StatusOK)
+
+	result := job.Result{
+		Status: job.StatusSucceeded,
+		Code:   s.code,
+		Header: s.header,
+	}
+
+	switch {
+	case errors.Is(ctx.Err(), context.Canceled):
+		result.Status = job.StatusCancelled
+		result.Error = cancelledErrorMessage
+	case s.code != http.StatusOK:
+		result.Status = job.StatusFailed
+		result.Error = s.errorMessage()
+	case s.header.Get(streamErrorTrailer) != "":
+		result.Status = job.StatusFailed
+		result.Error = s.header.Get(streamErrorTrailer)
+	}
+
+	return result
+}
+
+func (s *spoolWriter) errorMessage() string {
+	var response struct {
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(s.errBuf.Bytes(), &response); err == nil && response.Error != "" {
+		return response.Error
+	}
+	return strings.TrimSpace(s.errBuf.String())
+}
@@ -0,0 +1,349 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/app-sre/gabi/internal/test"
+	gabi "github.com/app-sre/gabi/pkg"
+	gabidb "github.com/app-sre/gabi/pkg/env/db"
+	gabiquery "github.com/app-sre/gabi/pkg/env/query"
+	"github.com/app-sre/gabi/pkg/job"
+	"github.com/app-sre/gabi/pkg/middleware"
+	"github.com/app-sre/gabi/pkg/models"
+	"github.com/app-sre/gabi/pkg/running"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJob(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		description string
+		mock        func(sqlmock.Sqlmock)
+		parameters  string
+		request     string
+		maxBytes    int64
+		status      job.Status
+		error       string
+		code        int
+		body        string
+		header      http.Header
+	}{
+		{
+			"job that succeeded",
+			func(mock sqlmock.Sqlmock) {
+				rows := sqlmock.NewRows([]string{"?column?"}).AddRow("1")
+				mock.ExpectBegin()
+				mock.ExpectQuery(`select 1;`).WillReturnRows(rows)
+				mock.ExpectCommit()
+			},
+			``,
+			`{"query": "select 1;"}`,
+			0,
+			job.StatusSucceeded,
+			``,
+			200,
+			`{"result":[["?column?"],["1"]],"error":""}`,
+			http.Header{"Content-Type": {"application/json; charset=utf-8"}},
+		},
+		{
+			"job that succeeded with result truncated as CSV",
+			func(mock sqlmock.Sqlmock) {
+				rows := sqlmock.NewRows([]string{"id"}).AddRow("1").AddRow("2")
+				mock.ExpectBegin()
+				mock.ExpectQuery(`select id from test;`).WillReturnRows(rows)
+				mock.ExpectCommit()
+			},
+			`?format=csv`,
+			`{"query": "select id from test;", "row_limit": 1}`,
+			0,
+			job.StatusSucceeded,
+			``,
+			200,
+			"id\r\n1\r\n",
+			http.Header{"X-Gabi-Truncated": {"true"}, "X-Gabi-Rows": {"1"}},
+		},
+		{
+			"job that succeeded with result truncated by JOB_MAX_BYTES",
+			func(mock sqlmock.Sqlmock) {
+				rows := sqlmock.NewRows([]string{"id"}).AddRow("1").AddRow("2")
+				mock.ExpectBegin()
+				mock.ExpectQuery(`select id from test;`).WillReturnRows(rows)
+				mock.ExpectCommit()
+			},
+			`?format=csv`,
+			`{"query": "select id from test;", "byte_limit": 1024}`,
+			7,
+			job.StatusSucceeded,
+			``,
+			200,
+			"id\r\n1\r\n",
+			http.Header{"X-Gabi-Truncated": {"true"}, "X-Gabi-Rows": {"1"}},
+		},
+		{
+			"job that failed due to database error",
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(`select 1;`).WillReturnError(errors.New("test"))
+				mock.ExpectRollback()
+			},
+			``,
+			`{"query": "select 1;"}`,
+			0,
+			job.StatusFailed,
+			`test`,
+			400,
+			`{"result":null,"error":"test"}`,
+			http.Header{},
+		},
+		{
+			"job that failed due to cancelled query",
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(`select pg_sleep\(10\);`).WillReturnError(context.Canceled)
+				mock.ExpectRollback()
+			},
+			``,
+			`{"query": "select pg_sleep(10);"}`,
+			0,
+			job.StatusFailed,
+			`Query was cancelled`,
+			409,
+			`Query was cancelled`,
+			http.Header{},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			var output bytes.Buffer
+
+			db, mock, _ := sqlmock.New()
+			defer func() { _ = db.Close() }()
+
+			tc.mock(mock)
+
+			jobs, err := job.NewStore(t.TempDir(), time.Minute, time.Minute, 1, 0)
+			require.NoError(t, err)
+
+			cfg := &gabi.Config{
+				DB:       db,
+				DBEnv:    &gabidb.Env{},
+				QueryEnv: &gabiquery.Env{MaxJobBytes: tc.maxBytes},
+				Queries:  running.NewRegistry(),
+				Jobs:     jobs,
+				Logger:   test.DummyLogger(&output).Sugar(),
+				Encoder:  base64.StdEncoding,
+			}
+			ctx := context.WithValue(context.TODO(), middleware.ContextKeyUser, "test")
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/"+tc.parameters, bytes.NewBufferString(tc.request))
+			SubmitJob(cfg).ServeHTTP(w, r.WithContext(ctx))
+
+			var submitted models.JobResponse
+
+			require.Equal(t, 202, w.Code)
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &submitted))
+			assert.Len(t, submitted.ID, 32)
+			assert.Len(t, submitted.QueryID, 32)
+			assert.Equal(t, "/jobs/"+submitted.ID, w.Header().Get("Location"))
+
+			var status models.JobResponse
+
+			assert.Eventually(t, func() bool {
+				w := httptest.NewRecorder()
+				r := httptest.NewRequestWithContext(ctx, http.MethodGet, "/", nil)
+				r = mux.SetURLVars(r, map[string]string{"id": submitted.ID})
+				GetJob(cfg).ServeHTTP(w, r)
+
+				status = models.JobResponse{}
+				_ = json.Unmarshal(w.Body.Bytes(), &status)
+				return status.Finished != nil
+			}, time.Second, 5*time.Millisecond)
+
+			require.NoError(t, mock.ExpectationsWereMet())
+			assert.Equal(t, string(tc.status), status.Status)
+			assert.Equal(t, tc.error, status.Error)
+
+			// The query is no longer running.
+			_, err = cfg.Queries.Get(submitted.QueryID)
+			assert.ErrorIs(t, err, running.ErrNotFound)
+
+			w = httptest.NewRecorder()
+			r = httptest.NewRequestWithContext(ctx, http.MethodGet, "/", nil)
+			r = mux.SetURLVars(r, map[string]string{"id": submitted.ID})
+			GetJobResult(cfg).ServeHTTP(w, r)
+
+			assert.Equal(t, tc.code, w.Code)
+			assert.Contains(t, w.Body.String(), tc.body)
+			assert.Empty(t, w.Header().Get("Trailer"))
+			for key := range tc.header {
+				assert.Equal(t, tc.header.Get(key), w.Header().Get(key))
+			}
+		})
+	}
+}
+
+func TestJobInvalid(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		description string
+		jobs        bool
+		handler     func(*gabi.Config) http.HandlerFunc
+		parameters  string
+		request     string
+		code        int
+		body        string
+	}{
+		{
+			"invalid job with pagination",
+			true,
+			SubmitJob,
+			``,
+			`{"query": "select 1;", "page_size": 10}`,
+			400,
+			`Jobs cannot be paginated`,
+		},
+		{
+			"invalid job with empty body",
+			true,
+			SubmitJob,
+			``,
+			``,
+			400,
+			`Request body cannot be empty`,
+		},
+		{
+			"invalid job with unsupported format",
+			true,
+			SubmitJob,
+			`?format=test`,
+			`{"query": "select 1;"}`,
+			400,
+			`Unsupported result format: test`,
+		},
+		{
+			"invalid job submitted with jobs not supported",
+			false,
+			SubmitJob,
+			``,
+			`{"query": "select 1;"}`,
+			400,
+			`Jobs are not supported`,
+		},
+		{
+			"invalid job status with unknown ID",
+			true,
+			GetJob,
+			``,
+			``,
+			404,
+			`Job not found`,
+		},
+		{
+			"invalid job result with unknown ID",
+			true,
+			GetJobResult,
+			``,
+			``,
+			404,
+			`Job not found`,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			var output bytes.Buffer
+
+			cfg := &gabi.Config{DBEnv: &gabidb.Env{}, Logger: test.DummyLogger(&output).Sugar()}
+			if tc.jobs {
+				jobs, err := job.NewStore(t.TempDir(), time.Minute, time.Minute, 1, 0)
+				require.NoError(t, err)
+				cfg.Jobs = jobs
+			}
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/"+tc.parameters, bytes.NewBufferString(tc.request))
+			r = mux.SetURLVars(r, map[string]string{"id": "test"})
+			tc.handler(cfg).ServeHTTP(w, r)
+
+			assert.Equal(t, tc.code, w.Code)
+			assert.Contains(t, w.Body.String(), tc.body)
+		})
+	}
+}
+
+func TestJobLimitReached(t *testing.T) {
+	t.Parallel()
+
+	var output bytes.Buffer
+
+	db, mock, _ := sqlmock.New()
+	defer func() { _ = db.Close() }()
+
+	// The first job is kept running until the test is done.
+	mock.ExpectBegin()
+	mock.ExpectQuery(`select pg_sleep\(10\);`).WillDelayFor(time.Minute)
+
+	jobs, err := job.NewStore(t.TempDir(), time.Minute, time.Minute, 1, 1)
+	require.NoError(t, err)
+
+	cfg := &gabi.Config{
+		DB:      db,
+		DBEnv:   &gabidb.Env{},
+		Queries: running.NewRegistry(),
+		Jobs:    jobs,
+		Logger:  test.DummyLogger(&output).Sugar(),
+		Encoder: base64.StdEncoding,
+	}
+	ctx := context.WithValue(context.TODO(), middleware.ContextKeyUser, "test")
+
+	submit := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(`{"query": "select pg_sleep(10);"}`))
+		SubmitJob(cfg).ServeHTTP(w, r.WithContext(ctx))
+		return w
+	}
+
+	w := submit()
+	require.Equal(t, 202, w.Code)
+
+	var submitted models.JobResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &submitted))
+
+	w = submit()
+	assert.Equal(t, 429, w.Code)
+	assert.Contains(t, w.Body.String(), `Too many jobs`)
+
+	// Only the query of the job that has been submitted is running.
+	assert.Len(t, cfg.Queries.List(), 1)
+
+	q, err := cfg.Queries.Get(submitted.QueryID)
+	require.NoError(t, err)
+	_ = q.Stop(func(int64) error { return nil })
+
+	j, err := jobs.Get(submitted.ID, "test")
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		return j.Info().Status == job.StatusCancelled
+	}, time.Second, 5*time.Millisecond)
+}
@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/app-sre/gabi/pkg/audit"
+)
+
+// Classes of errors not reported by the database.
+const (
+	errorClassConnection = "connection"
+	errorClassRequest    = "request"
+	errorClassInternal   = "internal"
+)
+
+// outcomeWriter keeps track of the response sent for a query, so that
+// how executing it went can be audited once it has been executed.
+type outcomeWriter struct {
+	http.ResponseWriter
+
+	code  int
+	bytes int64
+	err   error
+}
+
+func (ow *outcomeWriter) WriteHeader(code int) {
+	if ow.code == 0 {
+		ow.code = code
+	}
+	ow.ResponseWriter.WriteHeader(code)
+}
+
+func (ow *outcomeWriter) Write(b []byte) (int, error) {
+	if ow.code == 0 {
+		ow.code = http.StatusOK
+	}
+	n, err := ow.ResponseWriter.Write(b)
+	ow.bytes += int64(n)
+	return n, err
+}
+
+func (ow *outcomeWriter) Flush() {
+	_ = http.NewResponseController(ow.ResponseWriter).Flush()
+}
+
+func (ow *outcomeWriter) Unwrap() http.ResponseWriter {
+	return ow.ResponseWriter
+}
+
+// outcome records how executing the query went in the results, which
+// are audited once the handler returns. Every statement of a batch is
+// given the outcome of the batch.
+func (ow *outcomeWriter) outcome(started time.Time, results ...*audit.ResultData) {
+	code := ow.code
+	if code == 0 {
+		code = http.StatusOK
+	}
+
+	status, class := queryOutcome(ow.err, code)
+	duration := time.Since(started)
+
+	for _, result := range results {
+		result.Status, result.ErrorClass = status, class
+		result.Code = code
+		result.Duration = duration
+		// Rows that have been sent are already accounted for.
+		if result.Digest == "" {
+			result.Bytes = ow.bytes
+		}
+	}
+}
+
+// recordError records the error that the response reports, provided
+// that the response is being kept track of.
+func recordError(w http.ResponseWriter, err error) {
+	for {
+		switch t := w.(type) {
+		case *outcomeWriter:
+			t.err = err
+			return
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = t.Unwrap()
+		default:
+			return
+		}
+	}
+}
+
+// queryOutcome returns the outcome of executing a query, along with
+// the class of the error it failed with, if any. Requests rejected
+// before the query was executed have no error to tell them apart.
+func queryOutcome(err error, code int) (string, string) {
+	switch {
+	case err == nil && code < http.StatusBadRequest:
+		return audit.StatusSuccess, ""
+	case err == nil:
+		return audit.StatusError, statusErrorClass(code)
+	case timeoutError(err):
+		return audit.StatusTimeout, sqlStateClass(err)
+	case cancelledError(err):
+		return audit.StatusCancelled, ""
+	case connectionError(err):
+		return audit.StatusError, errorClassConnection
+	}
+
+	if class := sqlStateClass(err); class != "" {
+		return audit.StatusSQLError, class
+	}
+	return audit.StatusError, statusErrorClass(code)
+}
+
+// sqlStateClass returns the first two characters of the SQLSTATE of an
+// error reported by the database, which is what tells errors apart.
+func sqlStateClass(err error) string {
+	dbError, _ := databaseError(err)
+	if dbError == nil || len(dbError.SQLState) < 2 {
+		return ""
+	}
+	return dbError.SQLState[:2]
+}
+
+func statusErrorClass(code int) string {
+	if code >= http.StatusInternalServerError {
+		return errorClassInternal
+	}
+	return errorClassRequest
+}
@@ -0,0 +1,211 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/app-sre/gabi/internal/test"
+	gabi "github.com/app-sre/gabi/pkg"
+	"github.com/app-sre/gabi/pkg/audit"
+	gabidb "github.com/app-sre/gabi/pkg/env/db"
+	"github.com/app-sre/gabi/pkg/middleware"
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueryOutcome(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		description string
+		given       error
+		code        int
+		status      string
+		class       string
+	}{
+		{
+			"query executed",
+			nil,
+			200,
+			audit.StatusSuccess,
+			"",
+		},
+		{
+			"request rejected before executing query",
+			nil,
+			400,
+			audit.StatusError,
+			"request",
+		},
+		{
+			"PostgreSQL syntax error",
+			&pgconn.PgError{Code: "42601"},
+			400,
+			audit.StatusSQLError,
+			"42",
+		},
+		{
+			"MySQL serialization failure",
+			fmt.Errorf("statement 1: %w", &mysql.MySQLError{Number: 1213, SQLState: [5]byte{'4', '0', '0', '0', '1'}}),
+			409,
+			audit.StatusSQLError,
+			"40",
+		},
+		{
+			"PostgreSQL statement timeout",
+			fmt.Errorf("%w: %w", context.DeadlineExceeded, &pgconn.PgError{Code: "57014"}),
+			504,
+			audit.StatusTimeout,
+			"57",
+		},
+		{
+			"PostgreSQL statement cancelled",
+			fmt.Errorf("%w: %w", context.Canceled, &pgconn.PgError{Code: "57014"}),
+			409,
+			audit.StatusCancelled,
+			"",
+		},
+		{
+			"query timeout",
+			context.DeadlineExceeded,
+			504,
+			audit.StatusTimeout,
+			"",
+		},
+		{
+			"query cancelled",
+			context.Canceled,
+			409,
+			audit.StatusCancelled,
+			"",
+		},
+		{
+			"database not accessible",
+			&os.SyscallError{Syscall: "connect", Err: errors.New("test")},
+			503,
+			audit.StatusError,
+			"connection",
+		},
+		{
+			"error not reported by the database",
+			errors.New("test"),
+			500,
+			audit.StatusError,
+			"internal",
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			status, class := queryOutcome(tc.given, tc.code)
+
+			assert.Equal(t, tc.status, status)
+			assert.Equal(t, tc.class, class)
+		})
+	}
+}
+
+func TestQueryResultOutcome(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		description string
+		given       func(sqlmock.Sqlmock)
+		body        string
+		code        int
+		status      string
+		class       string
+	}{
+		{
+			"query executed",
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(`select 1;`).
+					WillReturnRows(sqlmock.NewRows([]string{"?column?"}).AddRow("1"))
+				mock.ExpectCommit()
+			},
+			`{"query": "select 1;"}`,
+			200,
+			audit.StatusSuccess,
+			"",
+		},
+		{
+			"query with syntax error",
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(`selec 1;`).
+					WillReturnError(&pgconn.PgError{Severity: "ERROR", Code: "42601", Message: "test"})
+				mock.ExpectRollback()
+			},
+			`{"query": "selec 1;"}`,
+			400,
+			audit.StatusSQLError,
+			"42",
+		},
+		{
+			"query cancelled",
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(`select pg_sleep\(10\);`).WillReturnError(context.Canceled)
+				mock.ExpectRollback()
+			},
+			`{"query": "select pg_sleep(10);"}`,
+			409,
+			audit.StatusCancelled,
+			"",
+		},
+		{
+			"request with invalid body",
+			func(mock sqlmock.Sqlmock) {
+				// No-op.
+			},
+			`{"query": }`,
+			400,
+			audit.StatusError,
+			"request",
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			db, mock, _ := sqlmock.New()
+			defer func() { _ = db.Close() }()
+
+			tc.given(mock)
+
+			result := &audit.ResultData{}
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(tc.body))
+			ctx := context.WithValue(context.TODO(), middleware.ContextKeyResult, result)
+
+			cfg := &gabi.Config{DB: db, DBEnv: &gabidb.Env{}, Logger: test.DummyLogger(io.Discard).Sugar(), Encoder: base64.StdEncoding}
+			Query(cfg).ServeHTTP(w, r.WithContext(ctx))
+
+			require.NoError(t, mock.ExpectationsWereMet())
+			assert.Equal(t, tc.code, w.Code)
+			assert.Equal(t, tc.status, result.Status)
+			assert.Equal(t, tc.class, result.ErrorClass)
+			assert.Equal(t, tc.code, result.Code)
+			assert.Equal(t, int64(w.Body.Len()), result.Bytes)
+			assert.Positive(t, result.Duration)
+		})
+	}
+}
@@ -1,7 +1,7 @@
 package handlers
 
 import (
-	"database/sql"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -9,181 +9,412 @@ import (
 	"net/http"
 	"net/url"
 	"os"
-	"reflect"
 	"strconv"
+	"time"
 
 	gabi "github.com/app-sre/gabi/pkg"
+	"github.com/app-sre/gabi/pkg/audit"
 	"github.com/app-sre/gabi/pkg/middleware"
 	"github.com/app-sre/gabi/pkg/models"
+	"github.com/jackc/pgx/v5/pgconn"
 )
 
-const (
-	base64EncodeResults byte = 1 << iota
-	base64DecodeQuery
-)
+const connectionErrorMessage = "Unable to connect to the database"
+
+const dryRunHeader = "X-Gabi-Dry-Run"
 
 func Query(cfg *gabi.Config) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 
 		var (
-			base64Mode byte
-			request    models.QueryRequest
+			decodeQuery bool
+			request     models.QueryRequest
 		)
 
-		if s := r.URL.Query().Get("base64_results"); s != "" {
+		result, ok := ctx.Value(middleware.ContextKeyResult).(*audit.ResultData)
+		if !ok {
+			result = &audit.ResultData{}
+		}
+
+		// Recorded however executing the query went, even when it has
+		// not been executed at all.
+		ow := &outcomeWriter{ResponseWriter: w}
+		defer ow.outcome(time.Now(), result)
+		w = ow
+
+		format, ok := resultFormat(r)
+		if !ok {
+			l := fmt.Sprintf("Unsupported result format: %s", r.URL.Query().Get("format"))
+			http.Error(w, l, http.StatusBadRequest)
+			return
+		}
+
+		mode, encoding, err := resultEncoding(r)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Unable to process result encoding: %s", err), http.StatusBadRequest)
+			return
+		}
+
+		if s := r.URL.Query().Get("base64_query"); s != "" {
 			if ok, err := strconv.ParseBool(s); err == nil && ok {
-				base64Mode |= base64EncodeResults
+				decodeQuery = true
 			}
 		}
 
-		if ctxQuery := ctx.Value(middleware.ContextKeyQuery); ctxQuery != nil {
-			if s, ok := ctxQuery.(string); ok {
-				request.Query = s
+		err = json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			cfg.Logger.Errorf("Unable to decode request body: %s", err)
+			if errors.Is(err, io.EOF) {
+				http.Error(w, "Request body cannot be empty", http.StatusBadRequest)
+				return
 			}
+			_ = queryErrorResponse(ctx, w, err)
+			return
 		}
-		if request.Query == "" {
-			if s := r.URL.Query().Get("base64_query"); s != "" {
-				if ok, err := strconv.ParseBool(s); err == nil && ok {
-					base64Mode |= base64DecodeQuery
-				}
-			}
 
-			err := json.NewDecoder(r.Body).Decode(&request)
+		// The query might have already been decoded upstream.
+		ctxQuery, _ := ctx.Value(middleware.ContextKeyQuery).(string)
+		if ctxQuery != "" {
+			request.Query = ctxQuery
+		} else if decodeQuery {
+			bytes, err := cfg.Encoder.DecodeString(request.Query)
 			if err != nil {
-				cfg.Logger.Errorf("Unable to decode request body: %s", err)
-				if errors.Is(err, io.EOF) {
-					http.Error(w, "Request body cannot be empty", http.StatusBadRequest)
-					return
-				}
-				_ = queryErrorResponse(w, err)
+				l := "Unable to decode Base64-encoded query"
+				cfg.Logger.Errorf("%s: %s", l, err)
+				http.Error(w, l, http.StatusBadRequest)
 				return
 			}
+			request.Query = string(bytes)
+		}
 
-			if base64Mode&base64DecodeQuery != 0 {
-				bytes, err := cfg.Encoder.DecodeString(request.Query)
-				if err != nil {
-					l := "Unable to decode Base64-encoded query"
-					cfg.Logger.Errorf("%s: %s", l, err)
-					http.Error(w, l, http.StatusBadRequest)
-					return
-				}
-				request.Query = string(bytes)
-			}
+		if request.RowLimit < 0 || request.ByteLimit < 0 {
+			http.Error(w, "Query limits cannot be negative", http.StatusBadRequest)
+			return
+		}
+		if request.PageSize < 0 {
+			http.Error(w, "Page size cannot be negative", http.StatusBadRequest)
+			return
+		}
+		if request.DryRun && (request.PageSize > 0 || request.Cursor != "") {
+			http.Error(w, "Dry runs cannot be paginated", http.StatusBadRequest)
+			return
+		}
+		if request.DryRun && cfg.DBEnv.Driver.CommitsImplicitly(request.Query) {
+			http.Error(w, "Statements that commit implicitly cannot be run as a dry run", http.StatusBadRequest)
+			return
+		}
+		if request.Exec && (request.PageSize > 0 || request.Cursor != "") {
+			http.Error(w, "Statements run without returning rows cannot be paginated", http.StatusBadRequest)
+			return
 		}
 
-		tx, err := cfg.DB.BeginTx(ctx, &sql.TxOptions{
-			ReadOnly: !cfg.DBEnv.AllowWrite,
-		})
+		r, cancel, err := withTimeout(r, request.Timeout)
 		if err != nil {
-			cfg.Logger.Errorf("Unable to start database transaction: %s", err)
-			_ = queryErrorResponse(w, err)
+			http.Error(w, fmt.Sprintf("Unable to process query timeout: %s", err), http.StatusBadRequest)
 			return
 		}
-		defer func() { _ = tx.Rollback() }()
+		defer cancel()
+		ctx = r.Context()
 
-		rows, err := tx.QueryContext(ctx, request.Query)
+		args, err := queryArgs(cfg.Encoder, request.Args)
 		if err != nil {
-			cfg.Logger.Errorf("Unable to query database: %s", err)
-			_ = queryErrorResponse(w, err)
+			l := "Unable to process query arguments"
+			cfg.Logger.Errorf("%s: %s", l, err)
+			http.Error(w, fmt.Sprintf("%s: %s", l, err), http.StatusBadRequest)
 			return
 		}
-		defer func() { _ = rows.Close() }()
 
-		// Remember to check err afterwards.
-		cols, err := rows.Columns()
+		result.RowLimit, result.ByteLimit = queryLimits(cfg, &request)
+
+		// Every request is still authorized and audited, even though
+		// the query is not run when its result has been cached.
+		if cacheable(cfg, &request) {
+			key := cacheKey(cfg, &request, format, mode, encoding, result)
+			if cachedResponse(cfg, w, key, result) {
+				return
+			}
+			cw := newCacheWriter(w, cfg.Cache.Max())
+			defer cw.store(cfg, key, result)
+			w = cw
+		}
+
+		r, finish, err := startQuery(w, r, cfg, request.Query)
 		if err != nil {
-			cfg.Logger.Errorf("Unable to process database columns: %s", err)
-			_ = queryErrorResponse(w, err)
+			cfg.Logger.Errorf("Unable to register query: %s", err)
+			http.Error(w, "An internal error has occurred", http.StatusInternalServerError)
 			return
 		}
+		defer finish()
 
-		vals := make([]interface{}, len(cols))
-
-		var (
-			result [][]string
-			keys   []string
-		)
+		rw := newResultWriter(r.Context(), cfg, w, format, newCellEncoder(cfg, mode, encoding), result)
 
-		for i := range cols {
-			vals[i] = new(sql.RawBytes)
-			keys = append(keys, cols[i])
+		// Known before the response is committed, so that every format
+		// can state it.
+		if request.DryRun {
+			rw.dryRun = true
+			w.Header().Set(dryRunHeader, "true")
 		}
-		result = append(result, keys)
 
-		for rows.Next() {
-			err = rows.Scan(vals...)
-			// Now you can check each element of vals for nil-ness,
-			// and you can use type introspection and type assertions
-			// to fetch the column into a typed variable.
-			if err != nil {
-				cfg.Logger.Errorf("Unable to process database rows: %s", err)
-				_ = queryErrorResponse(w, err)
+		switch {
+		case request.Exec:
+			exec(r, cfg, rw, &request, args)
+		case request.Cursor != "":
+			if request.Query != "" || len(request.Args) > 0 {
+				http.Error(w, "Query and arguments cannot be set when fetching the next page", http.StatusBadRequest)
 				return
 			}
+			nextPage(r, cfg, rw, &request)
+		case request.PageSize > 0:
+			firstPage(r, cfg, rw, &request, args)
+		default:
+			query(r, cfg, rw, &request, args)
+		}
+	}
+}
 
-			var row []string
-
-			for _, value := range vals {
-				content, ok := reflect.ValueOf(value).Interface().(*sql.RawBytes)
-				if !ok {
-					err = fmt.Errorf("unable to convert value type %T to *sql.RawBytes", value)
-					cfg.Logger.Errorf("Unable to process database query: %s", err)
-					_ = queryErrorResponse(w, err)
-					return
-				}
-				s := string(*content)
-
-				if base64Mode&base64EncodeResults != 0 {
-					s = cfg.Encoder.EncodeToString(*content)
-				}
-				row = append(row, s)
-			}
-			result = append(result, row)
+func query(r *http.Request, cfg *gabi.Config, rw *resultWriter, request *models.QueryRequest, args []any) {
+	ctx := r.Context()
+
+	tx, release, err := beginTx(ctx, cfg)
+	if err != nil {
+		cfg.Logger.Errorf("Unable to start database transaction: %s", err)
+		_ = queryErrorResponse(ctx, rw.w, err)
+		return
+	}
+	defer release()
+	defer func() { _ = tx.Rollback() }()
+
+	err = setStatementTimeout(ctx, cfg, tx)
+	if err != nil {
+		cfg.Logger.Errorf("Unable to set statement timeout: %s", err)
+		_ = queryErrorResponse(ctx, rw.w, err)
+		return
+	}
+
+	untrack, err := trackBackend(ctx, cfg, tx)
+	if err != nil {
+		cfg.Logger.Errorf("Unable to identify database connection: %s", err)
+		_ = queryErrorResponse(ctx, rw.w, err)
+		return
+	}
+	defer untrack()
+
+	rows, err := tx.QueryContext(ctx, request.Query, args...)
+	if err != nil {
+		cfg.Logger.Errorf("Unable to query database: %s", err)
+		_ = queryErrorResponse(ctx, rw.w, err)
+		return
+	}
+	defer func() { _ = rows.Close() }()
+
+	// Remember to check err afterwards.
+	cols, err := rows.ColumnTypes()
+	if err != nil {
+		cfg.Logger.Errorf("Unable to process database columns: %s", err)
+		_ = queryErrorResponse(ctx, rw.w, err)
+		return
+	}
+
+	if !rw.Begin(cols) {
+		return
+	}
+
+	more, ok := rw.Rows(rows, false, rw.result.RowLimit)
+	if !ok {
+		return
+	}
+	if more {
+		cfg.Logger.Debugf("Query result truncated after %d rows", rw.result.Rows)
+		rw.result.Truncated = true
+		if err := rows.Close(); err != nil {
+			rw.Fail("Unable to process database rows", err)
+			return
 		}
+	}
 
-		err = rows.Err()
+	untrack()
+	if request.DryRun {
+		err = tx.Rollback()
 		if err != nil {
-			cfg.Logger.Errorf("Unable to process database rows: %s", err)
-			_ = queryErrorResponse(w, err)
+			rw.Fail("Unable to roll back database changes", err)
 			return
 		}
+	} else {
+		err = tx.Commit()
+		if err != nil {
+			rw.Fail("Unable to commit database changes", err)
+			return
+		}
+	}
+
+	rw.End(resultStatus{Rows: rw.result.Rows, Truncated: rw.result.Truncated})
+}
+
+// exec runs a statement that returns no rows, such as an UPDATE, and
+// reports the number of rows it affected. The response is always JSON,
+// as there is no result to format.
+func exec(r *http.Request, cfg *gabi.Config, rw *resultWriter, request *models.QueryRequest, args []any) {
+	ctx := r.Context()
 
+	tx, release, err := beginTx(ctx, cfg)
+	if err != nil {
+		cfg.Logger.Errorf("Unable to start database transaction: %s", err)
+		_ = queryErrorResponse(ctx, rw.w, err)
+		return
+	}
+	defer release()
+	defer func() { _ = tx.Rollback() }()
+
+	err = setStatementTimeout(ctx, cfg, tx)
+	if err != nil {
+		cfg.Logger.Errorf("Unable to set statement timeout: %s", err)
+		_ = queryErrorResponse(ctx, rw.w, err)
+		return
+	}
+
+	untrack, err := trackBackend(ctx, cfg, tx)
+	if err != nil {
+		cfg.Logger.Errorf("Unable to identify database connection: %s", err)
+		_ = queryErrorResponse(ctx, rw.w, err)
+		return
+	}
+	defer untrack()
+
+	res, err := tx.ExecContext(ctx, request.Query, args...)
+	if err != nil {
+		cfg.Logger.Errorf("Unable to execute database statement: %s", err)
+		_ = queryErrorResponse(ctx, rw.w, err)
+		return
+	}
+
+	response := &models.ExecResponse{}
+
+	response.RowsAffected, err = res.RowsAffected()
+	if err != nil {
+		cfg.Logger.Errorf("Unable to process database statement: %s", err)
+		_ = queryErrorResponse(ctx, rw.w, err)
+		return
+	}
+	if id, err := res.LastInsertId(); err == nil {
+		response.LastInsertID = &id
+	}
+
+	untrack()
+	if request.DryRun {
+		err = tx.Rollback()
+		if err != nil {
+			cfg.Logger.Errorf("Unable to roll back database changes: %s", err)
+			_ = queryErrorResponse(ctx, rw.w, err)
+			return
+		}
+		response.DryRun = true
+	} else {
 		err = tx.Commit()
 		if err != nil {
 			cfg.Logger.Errorf("Unable to commit database changes: %s", err)
-			_ = queryErrorResponse(w, err)
+			_ = queryErrorResponse(ctx, rw.w, err)
 			return
 		}
+	}
+
+	rw.result.RowsAffected = &response.RowsAffected
+	rw.result.LastInsertID = response.LastInsertID
+
+	rw.w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	rw.w.Header().Set("Cache-Control", "private, no-store")
 
-		w.Header().Set("Cache-Control", "private, no-store")
-		w.Header().Set("Content-Type", "application/json; charset=utf-8")
-		_ = json.NewEncoder(w).Encode(&models.QueryResponse{
-			Result: result,
-		})
+	err = json.NewEncoder(rw.w).Encode(response)
+	if err != nil {
+		cfg.Logger.Errorf("Unable to send response: %s", err)
 	}
 }
 
-func queryErrorResponse(w http.ResponseWriter, err error) error {
-	var (
-		parseError   *url.Error
-		syscallError *os.SyscallError
-	)
+// queryLimits returns the limits that apply to the query, where those
+// set for the instance can only be tightened by the request.
+func queryLimits(cfg *gabi.Config, request *models.QueryRequest) (int64, int64) {
+	var maxRows, maxBytes int64
+	if cfg.QueryEnv != nil {
+		maxRows, maxBytes = cfg.QueryEnv.MaxRows, cfg.QueryEnv.MaxBytes
+	}
+	return tighterLimit(maxRows, request.RowLimit), tighterLimit(maxBytes, request.ByteLimit)
+}
 
-	// Stop the SQL drivers from leaking credentials on connection errors.
-	if errors.As(err, &parseError) || errors.As(err, &syscallError) {
-		http.Error(w, "Unable to connect to the database", http.StatusServiceUnavailable)
+// A limit of zero means there is no limit.
+func tighterLimit(limit, requested int64) int64 {
+	if requested > 0 && (limit == 0 || requested < limit) {
+		return requested
+	}
+	return limit
+}
+
+func newResultEncoder(format string, cells *cellEncoder) resultEncoder {
+	switch format {
+	case formatTyped:
+		cells.always = true
+		return &typedEncoder{cells: cells}
+	case formatCSV:
+		return &csvEncoder{cells: cells}
+	case formatTSV:
+		return &tsvEncoder{cells: cells}
+	case formatNDJSON:
+		return &ndjsonEncoder{cells: cells}
+	default:
+		return &jsonEncoder{cells: cells}
+	}
+}
+
+func queryErrorResponse(ctx context.Context, w http.ResponseWriter, err error) error {
+	err = interruptedError(ctx, err)
+	recordError(w, err)
+
+	if connectionError(err) {
+		http.Error(w, connectionErrorMessage, http.StatusServiceUnavailable)
+		return nil
+	}
+	if timeoutError(err) {
+		http.Error(w, timeoutErrorMessage, http.StatusGatewayTimeout)
+		return nil
+	}
+	if cancelledError(err) {
+		http.Error(w, cancelledErrorMessage, http.StatusConflict)
 		return nil
 	}
 
+	dbError, code := databaseError(err)
+
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	w.WriteHeader(http.StatusBadRequest)
+	w.WriteHeader(code)
 
 	err = json.NewEncoder(w).Encode(&models.QueryResponse{
-		Error: err.Error(),
+		Error:         err.Error(),
+		DatabaseError: dbError,
 	})
 	if err != nil {
 		return fmt.Errorf("unable to marshal error response: %w", err)
 	}
 	return nil
 }
+
+func queryErrorMessage(err error) string {
+	if connectionError(err) {
+		return connectionErrorMessage
+	}
+	if timeoutError(err) {
+		return timeoutErrorMessage
+	}
+	if cancelledError(err) {
+		return cancelledErrorMessage
+	}
+	return err.Error()
+}
+
+// Stop the SQL drivers from leaking credentials on connection errors.
+func connectionError(err error) bool {
+	var (
+		parseError   *url.Error
+		syscallError *os.SyscallError
+		connectError *pgconn.ConnectError
+	)
+	return errors.As(err, &parseError) || errors.As(err, &syscallError) || errors.As(err, &connectError)
+}
@@ -4,18 +4,26 @@ import (
 	"bytes"
 	"context"
 	"database/sql"
+	"database/sql/driver"
 	"encoding/base64"
 	"errors"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/app-sre/gabi/internal/test"
 	gabi "github.com/app-sre/gabi/pkg"
+	"github.com/app-sre/gabi/pkg/audit"
 	gabidb "github.com/app-sre/gabi/pkg/env/db"
+	gabiquery "github.com/app-sre/gabi/pkg/env/query"
 	"github.com/app-sre/gabi/pkg/middleware"
+	"github.com/app-sre/gabi/pkg/models"
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5/pgconn"
 	_ "github.com/jackc/pgx/v5/stdlib"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -193,6 +201,94 @@ func TestQuery(t *testing.T) {
 			`{"result":[["?column?"],["MQ=="]],"error":""}`,
 			``,
 		},
+		{
+			"valid query with only binary values encoded",
+			func() (*sql.DB, sqlmock.Sqlmock) {
+				db, mock, _ := sqlmock.New()
+				return db, mock
+			},
+			func(mock sqlmock.Sqlmock) {
+				rows := sqlmock.NewRowsWithColumnDefinition(
+					sqlmock.NewColumn("id").OfType("INT8", int64(0)),
+					sqlmock.NewColumn("name").OfType("TEXT", ""),
+					sqlmock.NewColumn("data").OfType("BYTEA", []byte{}),
+				).AddRow("1", "test", []byte("test")).AddRow("2", []byte{0xff, 0xfe}, []byte{0xde, 0xad})
+				mock.ExpectBegin()
+				mock.ExpectQuery(`select \* from test;`).WillReturnRows(rows)
+				mock.ExpectCommit()
+			},
+			func() context.Context {
+				return context.TODO()
+			},
+			func(r *http.Request) {
+				q := r.URL.Query()
+				q.Add("encode", "auto")
+				r.URL.RawQuery = q.Encode()
+			},
+			func() *bytes.Buffer {
+				return bytes.NewBufferString(`{"query": "select * from test;"}`)
+			},
+			200,
+			`{"result":[["id","name","data"],["1","test","dGVzdA=="],["2","//4=","3q0="]],"error":"","encoded_columns":[2],"encoded_cells":[[1,1]]}`,
+			``,
+		},
+		{
+			"valid query with hex-encoded results",
+			func() (*sql.DB, sqlmock.Sqlmock) {
+				db, mock, _ := sqlmock.New()
+				return db, mock
+			},
+			func(mock sqlmock.Sqlmock) {
+				rows := sqlmock.NewRows([]string{"?column?"}).AddRow("1")
+				mock.ExpectBegin()
+				mock.ExpectQuery(`select 1;`).WillReturnRows(rows)
+				mock.ExpectCommit()
+			},
+			func() context.Context {
+				return context.TODO()
+			},
+			func(r *http.Request) {
+				q := r.URL.Query()
+				q.Add("encode", "all")
+				q.Add("encoding", "hex")
+				r.URL.RawQuery = q.Encode()
+			},
+			func() *bytes.Buffer {
+				return bytes.NewBufferString(`{"query": "select 1;"}`)
+			},
+			200,
+			`{"result":[["?column?"],["31"]],"error":""}`,
+			``,
+		},
+		{
+			"valid query with only invalid UTF-8 values encoded using Base64 URL encoding",
+			func() (*sql.DB, sqlmock.Sqlmock) {
+				db, mock, _ := sqlmock.New()
+				return db, mock
+			},
+			func(mock sqlmock.Sqlmock) {
+				rows := sqlmock.NewRows([]string{"name"}).AddRow([]byte{0xfb, 0xff})
+				mock.ExpectBegin()
+				mock.ExpectQuery(`select name from test;`).WillReturnRows(rows)
+				mock.ExpectCommit()
+			},
+			func() context.Context {
+				return context.TODO()
+			},
+			func(r *http.Request) {
+				q := r.URL.Query()
+				q.Add("encode", "auto")
+				q.Add("encoding", "base64url")
+				q.Add("format", "csv")
+				r.URL.RawQuery = q.Encode()
+			},
+			func() *bytes.Buffer {
+				return bytes.NewBufferString(`{"query": "select name from test;"}`)
+			},
+			200,
+			"name\r\n-_8=\r\n",
+			``,
+		},
 		{
 			"valid query without Base64-encoded results with empty HTTP query parameters provided",
 			func() (*sql.DB, sqlmock.Sqlmock) {
@@ -200,37 +296,797 @@ func TestQuery(t *testing.T) {
 				return db, mock
 			},
 			func(mock sqlmock.Sqlmock) {
-				rows := sqlmock.NewRows([]string{"?column?"}).AddRow("1")
-				mock.ExpectBegin()
-				mock.ExpectQuery(`select 1;`).WillReturnRows(rows)
-				mock.ExpectCommit()
+				rows := sqlmock.NewRows([]string{"?column?"}).AddRow("1")
+				mock.ExpectBegin()
+				mock.ExpectQuery(`select 1;`).WillReturnRows(rows)
+				mock.ExpectCommit()
+			},
+			func() context.Context {
+				return context.TODO()
+			},
+			func(r *http.Request) {
+				q := r.URL.Query()
+				q.Add("base64_results", "")
+				r.URL.RawQuery = q.Encode()
+			},
+			func() *bytes.Buffer {
+				return bytes.NewBufferString(`{"query": "select 1;"}`)
+			},
+			200,
+			`{"result":[["?column?"],["1"]],"error":""}`,
+			``,
+		},
+		{
+			"valid query with typed results",
+			func() (*sql.DB, sqlmock.Sqlmock) {
+				db, mock, _ := sqlmock.New()
+				return db, mock
+			},
+			func(mock sqlmock.Sqlmock) {
+				rows := sqlmock.NewRowsWithColumnDefinition(
+					sqlmock.NewColumn("id").OfType("INT8", int64(0)).Nullable(false),
+					sqlmock.NewColumn("price").OfType("NUMERIC", "").WithPrecisionAndScale(10, 2),
+					sqlmock.NewColumn("name").OfType("TEXT", "").Nullable(true),
+				).AddRow(int64(1), "9.99", nil)
+				mock.ExpectBegin()
+				mock.ExpectQuery(`select \* from test;`).WillReturnRows(rows)
+				mock.ExpectCommit()
+			},
+			func() context.Context {
+				return context.TODO()
+			},
+			func(r *http.Request) {
+				q := r.URL.Query()
+				q.Add("format", "typed")
+				r.URL.RawQuery = q.Encode()
+			},
+			func() *bytes.Buffer {
+				return bytes.NewBufferString(`{"query": "select * from test;"}`)
+			},
+			200,
+			`{"columns":[{"name":"id","type":"INT8","nullable":false},{"name":"price","type":"NUMERIC","precision":10,"scale":2},{"name":"name","type":"TEXT","nullable":true}],"result":[[1,9.99,null]],"error":""}`,
+			``,
+		},
+		{
+			"valid query with typed and Base64-encoded results",
+			func() (*sql.DB, sqlmock.Sqlmock) {
+				db, mock, _ := sqlmock.New()
+				return db, mock
+			},
+			func(mock sqlmock.Sqlmock) {
+				rows := sqlmock.NewRowsWithColumnDefinition(
+					sqlmock.NewColumn("id").OfType("INT4", int64(0)),
+					sqlmock.NewColumn("name").OfType("TEXT", ""),
+				).AddRow([]byte("1"), []byte("test"))
+				mock.ExpectBegin()
+				mock.ExpectQuery(`select \* from test;`).WillReturnRows(rows)
+				mock.ExpectCommit()
+			},
+			func() context.Context {
+				return context.TODO()
+			},
+			func(r *http.Request) {
+				q := r.URL.Query()
+				q.Add("format", "typed")
+				q.Add("base64_results", "true")
+				r.URL.RawQuery = q.Encode()
+			},
+			func() *bytes.Buffer {
+				return bytes.NewBufferString(`{"query": "select * from test;"}`)
+			},
+			200,
+			`"result":[[1,"dGVzdA=="]],"error":""}`,
+			``,
+		},
+		{
+			"valid query with typed results and no rows returned",
+			func() (*sql.DB, sqlmock.Sqlmock) {
+				db, mock, _ := sqlmock.New()
+				return db, mock
+			},
+			func(mock sqlmock.Sqlmock) {
+				rows := sqlmock.NewRows([]string{"?column?"})
+				mock.ExpectBegin()
+				mock.ExpectQuery(`select 1 where false;`).WillReturnRows(rows)
+				mock.ExpectCommit()
+			},
+			func() context.Context {
+				return context.TODO()
+			},
+			func(r *http.Request) {
+				q := r.URL.Query()
+				q.Add("format", "typed")
+				r.URL.RawQuery = q.Encode()
+			},
+			func() *bytes.Buffer {
+				return bytes.NewBufferString(`{"query": "select 1 where false;"}`)
+			},
+			200,
+			`{"columns":[{"name":"?column?","type":""}],"result":[],"error":""}`,
+			``,
+		},
+		{
+			"valid query with results in CSV format",
+			func() (*sql.DB, sqlmock.Sqlmock) {
+				db, mock, _ := sqlmock.New()
+				return db, mock
+			},
+			func(mock sqlmock.Sqlmock) {
+				rows := sqlmock.NewRows([]string{"id", "name"}).AddRow("1", `a "quoted", value`).AddRow("2", nil)
+				mock.ExpectBegin()
+				mock.ExpectQuery(`select \* from test;`).WillReturnRows(rows)
+				mock.ExpectCommit()
+			},
+			func() context.Context {
+				return context.TODO()
+			},
+			func(r *http.Request) {
+				q := r.URL.Query()
+				q.Add("format", "csv")
+				r.URL.RawQuery = q.Encode()
+			},
+			func() *bytes.Buffer {
+				return bytes.NewBufferString(`{"query": "select * from test;"}`)
+			},
+			200,
+			"id,name\r\n1,\"a \"\"quoted\"\", value\"\r\n2,\r\n",
+			``,
+		},
+		{
+			"valid query with results in TSV format requested using Accept header",
+			func() (*sql.DB, sqlmock.Sqlmock) {
+				db, mock, _ := sqlmock.New()
+				return db, mock
+			},
+			func(mock sqlmock.Sqlmock) {
+				rows := sqlmock.NewRows([]string{"id", "name"}).AddRow("1", "a\tb\nc\\d")
+				mock.ExpectBegin()
+				mock.ExpectQuery(`select \* from test;`).WillReturnRows(rows)
+				mock.ExpectCommit()
+			},
+			func() context.Context {
+				return context.TODO()
+			},
+			func(r *http.Request) {
+				r.Header.Set("Accept", "text/tab-separated-values")
+			},
+			func() *bytes.Buffer {
+				return bytes.NewBufferString(`{"query": "select * from test;"}`)
+			},
+			200,
+			"id\tname\n1\ta\\tb\\nc\\\\d\n",
+			``,
+		},
+		{
+			"valid query with results in NDJSON format",
+			func() (*sql.DB, sqlmock.Sqlmock) {
+				db, mock, _ := sqlmock.New()
+				return db, mock
+			},
+			func(mock sqlmock.Sqlmock) {
+				rows := sqlmock.NewRows([]string{"id", "name"}).AddRow("1", "test").AddRow("2", nil)
+				mock.ExpectBegin()
+				mock.ExpectQuery(`select \* from test;`).WillReturnRows(rows)
+				mock.ExpectCommit()
+			},
+			func() context.Context {
+				return context.TODO()
+			},
+			func(r *http.Request) {
+				q := r.URL.Query()
+				q.Add("format", "ndjson")
+				r.URL.RawQuery = q.Encode()
+			},
+			func() *bytes.Buffer {
+				return bytes.NewBufferString(`{"query": "select * from test;"}`)
+			},
+			200,
+			"{\"id\":\"1\",\"name\":\"test\"}\n{\"id\":\"2\",\"name\":null}\n",
+			``,
+		},
+		{
+			"valid query with Base64-encoded results in CSV format",
+			func() (*sql.DB, sqlmock.Sqlmock) {
+				db, mock, _ := sqlmock.New()
+				return db, mock
+			},
+			func(mock sqlmock.Sqlmock) {
+				rows := sqlmock.NewRows([]string{"id", "name"}).AddRow("1", "test")
+				mock.ExpectBegin()
+				mock.ExpectQuery(`select \* from test;`).WillReturnRows(rows)
+				mock.ExpectCommit()
+			},
+			func() context.Context {
+				return context.TODO()
+			},
+			func(r *http.Request) {
+				q := r.URL.Query()
+				q.Add("format", "csv")
+				q.Add("base64_results", "true")
+				r.URL.RawQuery = q.Encode()
+			},
+			func() *bytes.Buffer {
+				return bytes.NewBufferString(`{"query": "select * from test;"}`)
+			},
+			200,
+			"id,name\r\nMQ==,dGVzdA==\r\n",
+			``,
+		},
+		{
+			"valid query with row limit exceeded",
+			func() (*sql.DB, sqlmock.Sqlmock) {
+				db, mock, _ := sqlmock.New()
+				return db, mock
+			},
+			func(mock sqlmock.Sqlmock) {
+				rows := sqlmock.NewRows([]string{"id"}).AddRow("1").AddRow("2").AddRow("3")
+				mock.ExpectBegin()
+				mock.ExpectQuery(`select \* from test;`).WillReturnRows(rows)
+				mock.ExpectCommit()
+			},
+			func() context.Context {
+				return context.TODO()
+			},
+			func(r *http.Request) {
+				// No-op.
+			},
+			func() *bytes.Buffer {
+				return bytes.NewBufferString(`{"query": "select * from test;", "row_limit": 2}`)
+			},
+			200,
+			`{"result":[["id"],["1"],["2"]],"error":"","truncated":true,"rows":2}`,
+			``,
+		},
+		{
+			"valid query with row limit not exceeded",
+			func() (*sql.DB, sqlmock.Sqlmock) {
+				db, mock, _ := sqlmock.New()
+				return db, mock
+			},
+			func(mock sqlmock.Sqlmock) {
+				rows := sqlmock.NewRows([]string{"id"}).AddRow("1").AddRow("2").AddRow("3")
+				mock.ExpectBegin()
+				mock.ExpectQuery(`select \* from test;`).WillReturnRows(rows)
+				mock.ExpectCommit()
+			},
+			func() context.Context {
+				return context.TODO()
+			},
+			func(r *http.Request) {
+				// No-op.
+			},
+			func() *bytes.Buffer {
+				return bytes.NewBufferString(`{"query": "select * from test;", "row_limit": 3}`)
+			},
+			200,
+			`{"result":[["id"],["1"],["2"],["3"]],"error":""}`,
+			``,
+		},
+		{
+			"valid query with byte limit exceeded",
+			func() (*sql.DB, sqlmock.Sqlmock) {
+				db, mock, _ := sqlmock.New()
+				return db, mock
+			},
+			func(mock sqlmock.Sqlmock) {
+				rows := sqlmock.NewRows([]string{"id"}).AddRow("1").AddRow("2").AddRow("3")
+				mock.ExpectBegin()
+				mock.ExpectQuery(`select \* from test;`).WillReturnRows(rows)
+				mock.ExpectCommit()
+			},
+			func() context.Context {
+				return context.TODO()
+			},
+			func(r *http.Request) {
+				// No-op.
+			},
+			func() *bytes.Buffer {
+				return bytes.NewBufferString(`{"query": "select * from test;", "byte_limit": 29}`)
+			},
+			200,
+			`{"result":[["id"],["1"],["2"]],"error":"","truncated":true,"rows":2}`,
+			``,
+		},
+		{
+			"valid query with typed results and row limit exceeded",
+			func() (*sql.DB, sqlmock.Sqlmock) {
+				db, mock, _ := sqlmock.New()
+				return db, mock
+			},
+			func(mock sqlmock.Sqlmock) {
+				rows := sqlmock.NewRows([]string{"id"}).AddRow("1").AddRow("2").AddRow("3")
+				mock.ExpectBegin()
+				mock.ExpectQuery(`select \* from test;`).WillReturnRows(rows)
+				mock.ExpectCommit()
+			},
+			func() context.Context {
+				return context.TODO()
+			},
+			func(r *http.Request) {
+				q := r.URL.Query()
+				q.Add("format", "typed")
+				r.URL.RawQuery = q.Encode()
+			},
+			func() *bytes.Buffer {
+				return bytes.NewBufferString(`{"query": "select * from test;", "row_limit": 1}`)
+			},
+			200,
+			`"result":[["1"]],"error":"","truncated":true,"rows":1}`,
+			``,
+		},
+		{
+			"valid query with no SQL statements provided",
+			func() (*sql.DB, sqlmock.Sqlmock) {
+				db, mock, _ := sqlmock.New()
+				return db, mock
+			},
+			func(mock sqlmock.Sqlmock) {
+				rows := sqlmock.NewRows([]string{})
+				mock.ExpectBegin()
+				mock.ExpectQuery(``).WillReturnRows(rows)
+				mock.ExpectCommit()
+			},
+			func() context.Context {
+				return context.TODO()
+			},
+			func(r *http.Request) {
+				// No-op.
+			},
+			func() *bytes.Buffer {
+				return bytes.NewBufferString(`{"query": ""}`)
+			},
+			200,
+			`{"result":[null],"error":""}`,
+			``,
+		},
+		{
+			"valid query for which database returned query error",
+			func() (*sql.DB, sqlmock.Sqlmock) {
+				db, mock, _ := sqlmock.New()
+				return db, mock
+			},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(`select 1;`).WillReturnError(errors.New("test"))
+				mock.ExpectRollback()
+			},
+			func() context.Context {
+				return context.TODO()
+			},
+			func(r *http.Request) {
+				// No-op.
+			},
+			func() *bytes.Buffer {
+				return bytes.NewBufferString(`{"query":"select 1;"}`)
+			},
+			400,
+			`{"result":null,"error":"test"}`,
+			``,
+		},
+		{
+			"valid query for which database added a log entry",
+			func() (*sql.DB, sqlmock.Sqlmock) {
+				db, mock, _ := sqlmock.New()
+				return db, mock
+			},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(`select 1;`).WillReturnError(errors.New("test"))
+				mock.ExpectRollback()
+			},
+			func() context.Context {
+				return context.TODO()
+			},
+			func(r *http.Request) {
+				// No-op.
+			},
+			func() *bytes.Buffer {
+				return bytes.NewBufferString(`{"query":"select 1;"}`)
+			},
+			400,
+			``,
+			`Unable to query database: test`,
+		},
+		{
+			"valid query for which database returned transaction error",
+			func() (*sql.DB, sqlmock.Sqlmock) {
+				db, mock, _ := sqlmock.New()
+				return db, mock
+			},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin().WillReturnError(errors.New("test"))
+			},
+			func() context.Context {
+				return context.TODO()
+			},
+			func(r *http.Request) {
+				// No-op.
+			},
+			func() *bytes.Buffer {
+				return bytes.NewBufferString(`{"query":"select 1;"}`)
+			},
+			400,
+			``,
+			`Unable to start database transaction: test`,
+		},
+		{
+			"valid query for which database returned commit error",
+			func() (*sql.DB, sqlmock.Sqlmock) {
+				db, mock, _ := sqlmock.New()
+				return db, mock
+			},
+			func(mock sqlmock.Sqlmock) {
+				rows := sqlmock.NewRows([]string{"?column?"}).AddRow("1")
+				mock.ExpectBegin()
+				mock.ExpectQuery(`select 1;`).WillReturnRows(rows)
+				mock.ExpectCommit().WillReturnError(errors.New("test"))
+			},
+			func() context.Context {
+				return context.TODO()
+			},
+			func(r *http.Request) {
+				// No-op.
+			},
+			func() *bytes.Buffer {
+				return bytes.NewBufferString(`{"query": "select 1;"}`)
+			},
+			400,
+			``,
+			`Unable to commit database changes: test`,
+		},
+		{
+			"valid query for which database returned query row error",
+			func() (*sql.DB, sqlmock.Sqlmock) {
+				db, mock, _ := sqlmock.New()
+				return db, mock
+			},
+			func(mock sqlmock.Sqlmock) {
+				rows := sqlmock.NewRows([]string{"?column?"})
+				rows.AddRow("1")
+				rows.AddRow("2").RowError(1, errors.New("test"))
+				mock.ExpectBegin()
+				mock.ExpectQuery(`select \* from test;`).WillReturnRows(rows)
+				mock.ExpectRollback()
+			},
+			func() context.Context {
+				return context.TODO()
+			},
+			func(r *http.Request) {
+				// No-op.
+			},
+			func() *bytes.Buffer {
+				return bytes.NewBufferString(`{"query": "select * from test;"}`)
+			},
+			400,
+			``,
+			`Unable to process database rows: test`,
+		},
+		{
+			"valid query for which database returned row error after response was committed",
+			func() (*sql.DB, sqlmock.Sqlmock) {
+				db, mock, _ := sqlmock.New()
+				return db, mock
+			},
+			func(mock sqlmock.Sqlmock) {
+				rows := sqlmock.NewRows([]string{"?column?"})
+				value := strings.Repeat("a", 1024)
+				for i := 0; i < 100; i++ {
+					rows.AddRow(value)
+				}
+				rows.RowError(99, errors.New("test"))
+				mock.ExpectBegin()
+				mock.ExpectQuery(`select \* from test;`).WillReturnRows(rows)
+				mock.ExpectRollback()
+			},
+			func() context.Context {
+				return context.TODO()
+			},
+			func(r *http.Request) {
+				// No-op.
+			},
+			func() *bytes.Buffer {
+				return bytes.NewBufferString(`{"query": "select * from test;"}`)
+			},
+			200,
+			`"]],"error":"test"}`,
+			`Unable to process database rows: test`,
+		},
+		{
+			"valid query with database connection error",
+			func() (*sql.DB, sqlmock.Sqlmock) {
+				_, mock, _ := sqlmock.New()
+				db, _ := sql.Open("pgx", "postgres://")
+				return db, mock
+			},
+			func(mock sqlmock.Sqlmock) {
+				// No-op.
+			},
+			func() context.Context {
+				return context.TODO()
+			},
+			func(r *http.Request) {
+				// No-op.
+			},
+			func() *bytes.Buffer {
+				return bytes.NewBufferString(`{"query": "select 1;"}`)
+			},
+			503,
+			`Unable to connect to the database`,
+			``,
+		},
+		{
+			"invalid query with unsupported result format",
+			func() (*sql.DB, sqlmock.Sqlmock) {
+				db, mock, _ := sqlmock.New()
+				return db, mock
+			},
+			func(mock sqlmock.Sqlmock) {
+				// No-op.
+			},
+			func() context.Context {
+				return context.TODO()
+			},
+			func(r *http.Request) {
+				q := r.URL.Query()
+				q.Add("format", "test")
+				r.URL.RawQuery = q.Encode()
+			},
+			func() *bytes.Buffer {
+				return bytes.NewBufferString(`{"query": "select 1;"}`)
+			},
+			400,
+			`Unsupported result format: test`,
+			``,
+		},
+		{
+			"valid query with arguments",
+			func() (*sql.DB, sqlmock.Sqlmock) {
+				db, mock, _ := sqlmock.New()
+				return db, mock
+			},
+			func(mock sqlmock.Sqlmock) {
+				rows := sqlmock.NewRows([]string{"name"}).AddRow("test")
+				mock.ExpectBegin()
+				mock.ExpectQuery(`select name from test where id = \$1 and created > \$2;`).
+					WithArgs(int64(1), time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)).
+					WillReturnRows(rows)
+				mock.ExpectCommit()
+			},
+			func() context.Context {
+				return context.TODO()
+			},
+			func(r *http.Request) {
+				// No-op.
+			},
+			func() *bytes.Buffer {
+				return bytes.NewBufferString(`{"query": "select name from test where id = $1 and created > $2;", "args": [1, {"value": "2023-01-01", "type": "date"}]}`)
+			},
+			200,
+			`{"result":[["name"],["test"]],"error":""}`,
+			``,
+		},
+		{
+			"invalid query with argument that cannot be bound",
+			func() (*sql.DB, sqlmock.Sqlmock) {
+				db, mock, _ := sqlmock.New()
+				return db, mock
+			},
+			func(mock sqlmock.Sqlmock) {
+				// No-op.
+			},
+			func() context.Context {
+				return context.TODO()
+			},
+			func(r *http.Request) {
+				// No-op.
+			},
+			func() *bytes.Buffer {
+				return bytes.NewBufferString(`{"query": "select ?;", "args": [{"value": "test", "type": "integer"}]}`)
+			},
+			400,
+			`Unable to process query arguments: argument 1:`,
+			`Unable to process query arguments`,
+		},
+		{
+			"valid statement run without returning rows",
+			func() (*sql.DB, sqlmock.Sqlmock) {
+				db, mock, _ := sqlmock.New()
+				return db, mock
+			},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectExec(`insert into test values \(\$1\);`).
+					WithArgs("test").
+					WillReturnResult(sqlmock.NewResult(5, 1))
+				mock.ExpectCommit()
+			},
+			func() context.Context {
+				return context.TODO()
+			},
+			func(r *http.Request) {
+				// No-op.
+			},
+			func() *bytes.Buffer {
+				return bytes.NewBufferString(`{"query": "insert into test values ($1);", "args": ["test"], "exec": true}`)
+			},
+			200,
+			`{"rows_affected":1,"last_insert_id":5,"error":""}`,
+			``,
+		},
+		{
+			"valid statement run without returning rows and with no last insert ID",
+			func() (*sql.DB, sqlmock.Sqlmock) {
+				db, mock, _ := sqlmock.New()
+				return db, mock
+			},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectExec(`delete from test;`).
+					WillReturnResult(driver.RowsAffected(2))
+				mock.ExpectCommit()
+			},
+			func() context.Context {
+				return context.TODO()
+			},
+			func(r *http.Request) {
+				// No-op.
+			},
+			func() *bytes.Buffer {
+				return bytes.NewBufferString(`{"query": "delete from test;", "exec": true}`)
+			},
+			200,
+			`{"rows_affected":2,"error":""}`,
+			``,
+		},
+		{
+			"invalid statement run without returning rows",
+			func() (*sql.DB, sqlmock.Sqlmock) {
+				db, mock, _ := sqlmock.New()
+				return db, mock
+			},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectExec(`delete from test;`).
+					WillReturnError(errors.New("test"))
+				mock.ExpectRollback()
+			},
+			func() context.Context {
+				return context.TODO()
+			},
+			func(r *http.Request) {
+				// No-op.
+			},
+			func() *bytes.Buffer {
+				return bytes.NewBufferString(`{"query": "delete from test;", "exec": true}`)
+			},
+			400,
+			`{"result":null,"error":"test"}`,
+			`Unable to execute database statement: test`,
+		},
+		{
+			"invalid statement run without returning rows with page size set",
+			func() (*sql.DB, sqlmock.Sqlmock) {
+				db, mock, _ := sqlmock.New()
+				return db, mock
+			},
+			func(mock sqlmock.Sqlmock) {
+				// No-op.
+			},
+			func() context.Context {
+				return context.TODO()
+			},
+			func(r *http.Request) {
+				// No-op.
+			},
+			func() *bytes.Buffer {
+				return bytes.NewBufferString(`{"query": "delete from test;", "exec": true, "page_size": 10}`)
+			},
+			400,
+			`Statements run without returning rows cannot be paginated`,
+			``,
+		},
+		{
+			"valid query run as a dry run",
+			func() (*sql.DB, sqlmock.Sqlmock) {
+				db, mock, _ := sqlmock.New()
+				return db, mock
+			},
+			func(mock sqlmock.Sqlmock) {
+				rows := sqlmock.NewRows([]string{"id"}).AddRow("1")
+				mock.ExpectBegin()
+				mock.ExpectQuery(`delete from test returning id;`).WillReturnRows(rows)
+				mock.ExpectRollback()
+			},
+			func() context.Context {
+				return context.TODO()
+			},
+			func(r *http.Request) {
+				// No-op.
+			},
+			func() *bytes.Buffer {
+				return bytes.NewBufferString(`{"query": "delete from test returning id;", "dry_run": true}`)
+			},
+			200,
+			`{"result":[["id"],["1"]],"error":"","dry_run":true}`,
+			``,
+		},
+		{
+			"valid statement run without returning rows as a dry run",
+			func() (*sql.DB, sqlmock.Sqlmock) {
+				db, mock, _ := sqlmock.New()
+				return db, mock
+			},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectExec(`delete from test;`).
+					WillReturnResult(driver.RowsAffected(2))
+				mock.ExpectRollback()
+			},
+			func() context.Context {
+				return context.TODO()
+			},
+			func(r *http.Request) {
+				// No-op.
+			},
+			func() *bytes.Buffer {
+				return bytes.NewBufferString(`{"query": "delete from test;", "exec": true, "dry_run": true}`)
+			},
+			200,
+			`{"rows_affected":2,"dry_run":true,"error":""}`,
+			``,
+		},
+		{
+			"invalid query run as a dry run with page size set",
+			func() (*sql.DB, sqlmock.Sqlmock) {
+				db, mock, _ := sqlmock.New()
+				return db, mock
+			},
+			func(mock sqlmock.Sqlmock) {
+				// No-op.
+			},
+			func() context.Context {
+				return context.TODO()
+			},
+			func(r *http.Request) {
+				// No-op.
+			},
+			func() *bytes.Buffer {
+				return bytes.NewBufferString(`{"query": "select 1;", "dry_run": true, "page_size": 10}`)
+			},
+			400,
+			`Dry runs cannot be paginated`,
+			``,
+		},
+		{
+			"invalid query with negative row limit",
+			func() (*sql.DB, sqlmock.Sqlmock) {
+				db, mock, _ := sqlmock.New()
+				return db, mock
+			},
+			func(mock sqlmock.Sqlmock) {
+				// No-op.
 			},
 			func() context.Context {
 				return context.TODO()
 			},
 			func(r *http.Request) {
-				q := r.URL.Query()
-				q.Add("base64_results", "")
-				r.URL.RawQuery = q.Encode()
+				// No-op.
 			},
 			func() *bytes.Buffer {
-				return bytes.NewBufferString(`{"query": "select 1;"}`)
+				return bytes.NewBufferString(`{"query": "select 1;", "row_limit": -1}`)
 			},
-			200,
-			`{"result":[["?column?"],["1"]],"error":""}`,
+			400,
+			`Query limits cannot be negative`,
 			``,
 		},
 		{
-			"valid query with no SQL statements provided",
+			"invalid query with empty body",
 			func() (*sql.DB, sqlmock.Sqlmock) {
 				db, mock, _ := sqlmock.New()
 				return db, mock
 			},
 			func(mock sqlmock.Sqlmock) {
-				rows := sqlmock.NewRows([]string{})
-				mock.ExpectBegin()
-				mock.ExpectQuery(``).WillReturnRows(rows)
-				mock.ExpectCommit()
+				// No-op.
 			},
 			func() context.Context {
 				return context.TODO()
@@ -239,22 +1095,20 @@ func TestQuery(t *testing.T) {
 				// No-op.
 			},
 			func() *bytes.Buffer {
-				return bytes.NewBufferString(`{"query": ""}`)
+				return &bytes.Buffer{}
 			},
-			200,
-			`{"result":[null],"error":""}`,
+			400,
+			`Request body cannot be empty`,
 			``,
 		},
 		{
-			"valid query for which database returned query error",
+			"invalid query with malformed JSON in the body",
 			func() (*sql.DB, sqlmock.Sqlmock) {
 				db, mock, _ := sqlmock.New()
 				return db, mock
 			},
 			func(mock sqlmock.Sqlmock) {
-				mock.ExpectBegin()
-				mock.ExpectQuery(`select 1;`).WillReturnError(errors.New("test"))
-				mock.ExpectRollback()
+				// No-op.
 			},
 			func() context.Context {
 				return context.TODO()
@@ -263,22 +1117,20 @@ func TestQuery(t *testing.T) {
 				// No-op.
 			},
 			func() *bytes.Buffer {
-				return bytes.NewBufferString(`{"query":"select 1;"}`)
+				return bytes.NewBufferString(`{"query: "select 1;"}`)
 			},
 			400,
-			`{"result":null,"error":"test"}`,
 			``,
+			`Unable to decode request body`,
 		},
 		{
-			"valid query for which database added a log entry",
+			"invalid query with incorrect type provided in the body",
 			func() (*sql.DB, sqlmock.Sqlmock) {
 				db, mock, _ := sqlmock.New()
 				return db, mock
 			},
 			func(mock sqlmock.Sqlmock) {
-				mock.ExpectBegin()
-				mock.ExpectQuery(`select 1;`).WillReturnError(errors.New("test"))
-				mock.ExpectRollback()
+				// No-op.
 			},
 			func() context.Context {
 				return context.TODO()
@@ -287,71 +1139,93 @@ func TestQuery(t *testing.T) {
 				// No-op.
 			},
 			func() *bytes.Buffer {
-				return bytes.NewBufferString(`{"query":"select 1;"}`)
+				return bytes.NewBufferString(`{"query": -1}`)
 			},
 			400,
 			``,
-			`Unable to query database: test`,
+			`Unable to decode request body`,
 		},
 		{
-			"valid query for which database returned transaction error",
+			"invalid query with malformed Base64-encoded value in the body",
 			func() (*sql.DB, sqlmock.Sqlmock) {
 				db, mock, _ := sqlmock.New()
 				return db, mock
 			},
 			func(mock sqlmock.Sqlmock) {
-				mock.ExpectBegin().WillReturnError(errors.New("test"))
+				// No-op.
 			},
 			func() context.Context {
 				return context.TODO()
 			},
 			func(r *http.Request) {
-				// No-op.
+				q := r.URL.Query()
+				q.Add("base64_query", "true")
+				r.URL.RawQuery = q.Encode()
 			},
 			func() *bytes.Buffer {
-				return bytes.NewBufferString(`{"query":"select 1;"}`)
+				return bytes.NewBufferString(`{"query": "dGhpcyBpcyBhIHRlc3Q=="}`)
 			},
 			400,
+			`Unable to decode Base64-encoded query`,
 			``,
-			`Unable to start database transaction: test`,
 		},
 		{
-			"valid query for which database returned commit error",
+			"invalid query with unsupported encoding",
 			func() (*sql.DB, sqlmock.Sqlmock) {
 				db, mock, _ := sqlmock.New()
 				return db, mock
 			},
 			func(mock sqlmock.Sqlmock) {
-				rows := sqlmock.NewRows([]string{"?column?"}).AddRow("1")
-				mock.ExpectBegin()
-				mock.ExpectQuery(`select 1;`).WillReturnRows(rows)
-				mock.ExpectCommit().WillReturnError(errors.New("test"))
+				// No-op.
 			},
 			func() context.Context {
 				return context.TODO()
 			},
 			func(r *http.Request) {
+				q := r.URL.Query()
+				q.Add("encoding", "test")
+				r.URL.RawQuery = q.Encode()
+			},
+			func() *bytes.Buffer {
+				return bytes.NewBufferString(`{"query": "select 1;"}`)
+			},
+			400,
+			`Unable to process result encoding: unsupported encoding: test`,
+			``,
+		},
+		{
+			"invalid query with unsupported encode mode",
+			func() (*sql.DB, sqlmock.Sqlmock) {
+				db, mock, _ := sqlmock.New()
+				return db, mock
+			},
+			func(mock sqlmock.Sqlmock) {
 				// No-op.
 			},
+			func() context.Context {
+				return context.TODO()
+			},
+			func(r *http.Request) {
+				q := r.URL.Query()
+				q.Add("encode", "test")
+				r.URL.RawQuery = q.Encode()
+			},
 			func() *bytes.Buffer {
 				return bytes.NewBufferString(`{"query": "select 1;"}`)
 			},
 			400,
+			`Unable to process result encoding: unsupported encode mode: test`,
 			``,
-			`Unable to commit database changes: test`,
 		},
 		{
-			"valid query for which database returned query row error",
+			"invalid query that timed out",
 			func() (*sql.DB, sqlmock.Sqlmock) {
 				db, mock, _ := sqlmock.New()
 				return db, mock
 			},
 			func(mock sqlmock.Sqlmock) {
-				rows := sqlmock.NewRows([]string{"?column?"})
-				rows.AddRow("1")
-				rows.AddRow("2").RowError(1, errors.New("test"))
 				mock.ExpectBegin()
-				mock.ExpectQuery(`select \* from test;`).WillReturnRows(rows)
+				mock.ExpectQuery(`select sleep\(10\);`).WillReturnError(&mysql.MySQLError{Number: 3024})
 				mock.ExpectRollback()
 			},
 			func() context.Context {
@@ -361,21 +1235,22 @@ func TestQuery(t *testing.T) {
 				// No-op.
 			},
 			func() *bytes.Buffer {
-				return bytes.NewBufferString(`{"query": "select * from test;"}`)
+				return bytes.NewBufferString(`{"query": "select sleep(10);", "timeout": "1s"}`)
 			},
-			400,
-			``,
-			`Unable to process database rows: test`,
+			504,
+			`Query timed out`,
+			`Unable to query database`,
 		},
 		{
-			"valid query with database connection error",
+			"invalid query that was cancelled",
 			func() (*sql.DB, sqlmock.Sqlmock) {
-				_, mock, _ := sqlmock.New()
-				db, _ := sql.Open("pgx", "postgres://")
+				db, mock, _ := sqlmock.New()
 				return db, mock
 			},
 			func(mock sqlmock.Sqlmock) {
-				// No-op.
+				mock.ExpectBegin()
+				mock.ExpectQuery(`select pg_sleep\(10\);`).WillReturnError(context.Canceled)
+				mock.ExpectRollback()
 			},
 			func() context.Context {
 				return context.TODO()
@@ -384,20 +1259,27 @@ func TestQuery(t *testing.T) {
 				// No-op.
 			},
 			func() *bytes.Buffer {
-				return bytes.NewBufferString(`{"query": "select 1;"}`)
+				return bytes.NewBufferString(`{"query": "select pg_sleep(10);"}`)
 			},
-			503,
-			`Unable to connect to the database`,
-			``,
+			409,
+			`Query was cancelled`,
+			`Unable to query database`,
 		},
 		{
-			"invalid query with empty body",
+			"invalid query with syntax error",
 			func() (*sql.DB, sqlmock.Sqlmock) {
 				db, mock, _ := sqlmock.New()
 				return db, mock
 			},
 			func(mock sqlmock.Sqlmock) {
-				// No-op.
+				mock.ExpectBegin()
+				mock.ExpectQuery(`selec 1;`).WillReturnError(&pgconn.PgError{
+					Severity: "ERROR",
+					Code:     "42601",
+					Message:  `syntax error at or near "selec"`,
+					Position: 1,
+				})
+				mock.ExpectRollback()
 			},
 			func() context.Context {
 				return context.TODO()
@@ -406,20 +1288,26 @@ func TestQuery(t *testing.T) {
 				// No-op.
 			},
 			func() *bytes.Buffer {
-				return &bytes.Buffer{}
+				return bytes.NewBufferString(`{"query": "selec 1;"}`)
 			},
 			400,
-			`Request body cannot be empty`,
-			``,
+			`{"result":null,"error":"ERROR: syntax error at or near \"selec\" (SQLSTATE 42601)","database_error":{"code":"42601","sqlstate":"42601","severity":"ERROR","message":"syntax error at or near \"selec\"","position":1}}`,
+			`Unable to query database`,
 		},
 		{
-			"invalid query with malformed JSON in the body",
+			"invalid query without permission",
 			func() (*sql.DB, sqlmock.Sqlmock) {
 				db, mock, _ := sqlmock.New()
 				return db, mock
 			},
 			func(mock sqlmock.Sqlmock) {
-				// No-op.
+				mock.ExpectBegin()
+				mock.ExpectQuery(`select \* from secrets;`).WillReturnError(&pgconn.PgError{
+					Severity: "ERROR",
+					Code:     "42501",
+					Message:  "permission denied for table secrets",
+				})
+				mock.ExpectRollback()
 			},
 			func() context.Context {
 				return context.TODO()
@@ -428,21 +1316,49 @@ func TestQuery(t *testing.T) {
 				// No-op.
 			},
 			func() *bytes.Buffer {
-				return bytes.NewBufferString(`{"query: "select 1;"}`)
+				return bytes.NewBufferString(`{"query": "select * from secrets;"}`)
 			},
-			400,
-			``,
-			`Unable to decode request body`,
+			403,
+			`"database_error":{"code":"42501","sqlstate":"42501","severity":"ERROR","message":"permission denied for table secrets"}`,
+			`Unable to query database`,
 		},
 		{
-			"invalid query with incorrect type provided in the body",
+			"invalid query that deadlocked",
 			func() (*sql.DB, sqlmock.Sqlmock) {
 				db, mock, _ := sqlmock.New()
 				return db, mock
 			},
 			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(`select \* from test for update;`).WillReturnError(&mysql.MySQLError{
+					Number:   1213,
+					SQLState: [5]byte{'4', '0', '0', '0', '1'},
+					Message:  "Deadlock found when trying to get lock; try restarting transaction",
+				})
+				mock.ExpectRollback()
+			},
+			func() context.Context {
+				return context.TODO()
+			},
+			func(r *http.Request) {
 				// No-op.
 			},
+			func() *bytes.Buffer {
+				return bytes.NewBufferString(`{"query": "select * from test for update;"}`)
+			},
+			409,
+			`"database_error":{"code":"1213","sqlstate":"40001","message":"Deadlock found when trying to get lock; try restarting transaction"}`,
+			`Unable to query database`,
+		},
+		{
+			"invalid query with connection error",
+			func() (*sql.DB, sqlmock.Sqlmock) {
+				db, mock, _ := sqlmock.New()
+				return db, mock
+			},
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin().WillReturnError(&pgconn.ConnectError{})
+			},
 			func() context.Context {
 				return context.TODO()
 			},
@@ -450,14 +1366,14 @@ func TestQuery(t *testing.T) {
 				// No-op.
 			},
 			func() *bytes.Buffer {
-				return bytes.NewBufferString(`{"query": -1}`)
+				return bytes.NewBufferString(`{"query": "select 1;"}`)
 			},
-			400,
-			``,
-			`Unable to decode request body`,
+			503,
+			`Unable to connect to the database`,
+			`Unable to start database transaction`,
 		},
 		{
-			"invalid query with malformed Base64-encoded value in the body",
+			"invalid query with malformed timeout",
 			func() (*sql.DB, sqlmock.Sqlmock) {
 				db, mock, _ := sqlmock.New()
 				return db, mock
@@ -469,15 +1385,13 @@ func TestQuery(t *testing.T) {
 				return context.TODO()
 			},
 			func(r *http.Request) {
-				q := r.URL.Query()
-				q.Add("base64_query", "true")
-				r.URL.RawQuery = q.Encode()
+				// No-op.
 			},
 			func() *bytes.Buffer {
-				return bytes.NewBufferString(`{"query": "dGhpcyBpcyBhIHRlc3Q=="}`)
+				return bytes.NewBufferString(`{"query": "select 1;", "timeout": "test"}`)
 			},
 			400,
-			`Unable to decode Base64-encoded query`,
+			`Unable to process query timeout`,
 			``,
 		},
 	}
@@ -518,3 +1432,177 @@ func TestQuery(t *testing.T) {
 		})
 	}
 }
+
+func TestQueryLimits(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		description string
+		given       *gabiquery.Env
+		request     models.QueryRequest
+		rows        int64
+		bytes       int64
+	}{
+		{
+			"no limits set",
+			nil,
+			models.QueryRequest{},
+			0,
+			0,
+		},
+		{
+			"limits set for the instance",
+			&gabiquery.Env{MaxRows: 10, MaxBytes: 1024},
+			models.QueryRequest{},
+			10,
+			1024,
+		},
+		{
+			"limits set for the request",
+			&gabiquery.Env{},
+			models.QueryRequest{RowLimit: 5, ByteLimit: 512},
+			5,
+			512,
+		},
+		{
+			"limits set for the request that are tighter than those set for the instance",
+			&gabiquery.Env{MaxRows: 10, MaxBytes: 1024},
+			models.QueryRequest{RowLimit: 5, ByteLimit: 512},
+			5,
+			512,
+		},
+		{
+			"limits set for the request that are looser than those set for the instance",
+			&gabiquery.Env{MaxRows: 10, MaxBytes: 1024},
+			models.QueryRequest{RowLimit: 50, ByteLimit: 2048},
+			10,
+			1024,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			rows, bytes := queryLimits(&gabi.Config{QueryEnv: tc.given}, &tc.request)
+
+			assert.Equal(t, tc.rows, rows)
+			assert.Equal(t, tc.bytes, bytes)
+		})
+	}
+}
+
+func TestQueryExecResult(t *testing.T) {
+	t.Parallel()
+
+	var output bytes.Buffer
+
+	db, mock, _ := sqlmock.New()
+	defer func() { _ = db.Close() }()
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`delete from test;`).
+		WillReturnResult(driver.RowsAffected(2))
+	mock.ExpectCommit()
+
+	result := &audit.ResultData{}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(`{"query": "delete from test;", "exec": true}`))
+	ctx := context.WithValue(context.TODO(), middleware.ContextKeyResult, result)
+
+	cfg := &gabi.Config{DB: db, DBEnv: &gabidb.Env{AllowWrite: true}, Logger: test.DummyLogger(&output).Sugar(), Encoder: base64.StdEncoding}
+	Query(cfg).ServeHTTP(w, r.WithContext(ctx))
+
+	rowsAffected := int64(2)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, &rowsAffected, result.RowsAffected)
+	assert.Equal(t, audit.StatusSuccess, result.Status)
+	assert.Equal(t, 200, result.Code)
+	assert.Equal(t, int64(w.Body.Len()), result.Bytes)
+}
+
+func TestQueryDryRunHeader(t *testing.T) {
+	t.Parallel()
+
+	var output bytes.Buffer
+
+	db, mock, _ := sqlmock.New()
+	defer func() { _ = db.Close() }()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`delete from test returning id;`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("1"))
+	mock.ExpectRollback()
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/?format=csv", bytes.NewBufferString(`{"query": "delete from test returning id;", "dry_run": true}`))
+
+	cfg := &gabi.Config{DB: db, DBEnv: &gabidb.Env{AllowWrite: true}, Logger: test.DummyLogger(&output).Sugar(), Encoder: base64.StdEncoding}
+	Query(cfg).ServeHTTP(w, r)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "true", w.Header().Get("X-Gabi-Dry-Run"))
+	assert.Equal(t, "id\r\n1\r\n", w.Body.String())
+}
+
+func TestQueryDryRunImplicitCommit(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		description string
+		driver      gabidb.DriverType
+		mock        func(sqlmock.Sqlmock)
+		code        int
+		body        string
+	}{
+		{
+			"dry run rejected with MySQL committing the statement implicitly",
+			"mysql",
+			func(mock sqlmock.Sqlmock) {
+				// No-op.
+			},
+			400,
+			`Statements that commit implicitly cannot be run as a dry run`,
+		},
+		{
+			"dry run rolled back with PostgreSQL",
+			"pgx",
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectExec(`create table test \(id int\);`).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectRollback()
+			},
+			200,
+			`"dry_run":true`,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			var output bytes.Buffer
+
+			db, mock, _ := sqlmock.New()
+			defer func() { _ = db.Close() }()
+
+			tc.mock(mock)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(`{"query": "create table test (id int);", "exec": true, "dry_run": true}`))
+
+			cfg := &gabi.Config{DB: db, DBEnv: &gabidb.Env{Driver: tc.driver, AllowWrite: true}, Logger: test.DummyLogger(&output).Sugar(), Encoder: base64.StdEncoding}
+			Query(cfg).ServeHTTP(w, r)
+
+			require.NoError(t, mock.ExpectationsWereMet())
+			assert.Equal(t, tc.code, w.Code)
+			assert.Contains(t, w.Body.String(), tc.body)
+		})
+	}
+}
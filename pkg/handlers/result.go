@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strconv"
+
+	gabi "github.com/app-sre/gabi/pkg"
+	"github.com/app-sre/gabi/pkg/audit"
+)
+
+// resultWriter sends the rows of a result to the client using the
+// requested format, while keeping track of what has been sent.
+type resultWriter struct {
+	ctx     context.Context
+	cfg     *gabi.Config
+	w       http.ResponseWriter
+	encoder resultEncoder
+	cells   *cellEncoder
+	digest  *resultDigest
+	stream  *resultStream
+	result  *audit.ResultData
+	vals    []any
+	raw     []any
+	dryRun  bool
+}
+
+func newResultWriter(ctx context.Context, cfg *gabi.Config, w http.ResponseWriter, format string, cells *cellEncoder, result *audit.ResultData) *resultWriter {
+	encoder := newResultEncoder(format, cells)
+
+	// Set ahead of the rows, as it applies to every format.
+	if cells.mode != encodeNone || cells.always {
+		w.Header().Set(encodingHeader, cells.name)
+	}
+
+	return &resultWriter{
+		ctx:     ctx,
+		cfg:     cfg,
+		w:       w,
+		encoder: encoder,
+		cells:   cells,
+		digest:  newResultDigest(),
+		stream:  newResultStream(w, encoder.ContentType()),
+		result:  result,
+	}
+}
+
+// Fail reports an error either with an appropriate status code, or
+// in-band when the response has already been committed.
+func (rw *resultWriter) Fail(l string, err error) {
+	rw.cfg.Logger.Errorf("%s: %s", l, err)
+
+	if !rw.stream.Committed() {
+		rw.stream.Discard()
+		_ = queryErrorResponse(rw.ctx, rw.w, err)
+		return
+	}
+
+	err = interruptedError(rw.ctx, err)
+
+	recordError(rw.w, err)
+
+	status := resultStatus{Rows: rw.result.Rows, Truncated: rw.result.Truncated, DryRun: rw.dryRun, Err: err}
+	rw.encoded(&status)
+	if err := rw.encoder.End(rw.stream, status); err != nil {
+		rw.cfg.Logger.Errorf("Unable to complete response: %s", err)
+		return
+	}
+	_ = rw.stream.Close()
+	rw.stream.Trailer(streamErrorTrailer, queryErrorMessage(err))
+	rw.encodedTrailers(status)
+	rw.digested()
+}
+
+// Begin writes everything that precedes the rows.
+func (rw *resultWriter) Begin(cols []*sql.ColumnType) bool {
+	rw.cells.Begin(cols)
+
+	vals, err := rw.encoder.Begin(rw.stream, cols)
+	if err != nil {
+		rw.Fail("Unable to process database query", err)
+		return false
+	}
+	rw.vals = vals
+	rw.raw = digestValues(vals)
+	return true
+}
+
+// Rows writes rows until there are none left, or either the row limit
+// or the byte limit has been reached. When pending is set, the current
+// row is written first. It reports whether the current row is pending,
+// as it did not fit within the limits, and whether writing succeeded.
+func (rw *resultWriter) Rows(rows *sql.Rows, pending bool, rowLimit int64) (bool, bool) {
+	for {
+		if !pending && !rows.Next() {
+			if err := rows.Err(); err != nil {
+				rw.Fail("Unable to process database rows", err)
+				return false, false
+			}
+			return false, true
+		}
+		pending = true
+
+		if rowLimit > 0 && rw.result.Rows >= rowLimit {
+			return true, true
+		}
+
+		err := rows.Scan(rw.vals...)
+		if err == nil && rw.raw != nil {
+			err = rows.Scan(rw.raw...)
+		}
+		if err != nil {
+			rw.Fail("Unable to process database rows", err)
+			return false, false
+		}
+
+		size := rw.stream.Len()
+		err = rw.encoder.Row(rw.stream)
+		if err != nil {
+			rw.Fail("Unable to process database query", err)
+			return false, false
+		}
+
+		// Drop the row that does not fit within the limit.
+		if rw.result.ByteLimit > 0 && rw.stream.Len() > rw.result.ByteLimit {
+			rw.stream.Truncate(size)
+			return true, true
+		}
+		pending = false
+		rw.result.Rows++
+		rw.cells.Next()
+		if rw.raw != nil {
+			rw.digest.Row(rw.raw)
+		} else {
+			rw.digest.Row(rw.vals)
+		}
+
+		err = rw.stream.Flush()
+		if err != nil {
+			rw.cfg.Logger.Errorf("Unable to send response: %s", err)
+			return false, false
+		}
+	}
+}
+
+// End completes the response.
+func (rw *resultWriter) End(status resultStatus) {
+	status.DryRun = rw.dryRun
+	rw.encoded(&status)
+
+	err := rw.encoder.End(rw.stream, status)
+	if err == nil {
+		err = rw.stream.Close()
+	}
+	if err != nil {
+		rw.cfg.Logger.Errorf("Unable to send response: %s", err)
+		return
+	}
+
+	if status.Truncated {
+		rw.stream.Trailer(streamTruncatedTrailer, "true")
+		rw.stream.Trailer(streamRowsTrailer, strconv.FormatInt(status.Rows, 10))
+	}
+	if status.Cursor != "" {
+		rw.stream.Trailer(streamCursorTrailer, status.Cursor)
+	}
+	rw.encodedTrailers(status)
+	rw.digested()
+}
+
+// encoded marks the values that have been encoded, when only some are.
+func (rw *resultWriter) encoded(status *resultStatus) {
+	status.EncodedColumns = rw.cells.Columns()
+	status.EncodedCells = rw.cells.Cells(status.Rows)
+	status.EncodedFrom = rw.cells.From(status.Rows)
+}
+
+func (rw *resultWriter) encodedTrailers(status resultStatus) {
+	if len(status.EncodedColumns) > 0 {
+		rw.stream.Trailer(streamEncodedColumnsTrailer, formatColumns(status.EncodedColumns))
+	}
+	if len(status.EncodedCells) > 0 {
+		rw.stream.Trailer(streamEncodedCellsTrailer, formatCells(status.EncodedCells))
+	}
+	if len(status.EncodedFrom) > 0 {
+		rw.stream.Trailer(streamEncodedFromTrailer, formatCells(status.EncodedFrom))
+	}
+}
+
+// digested records the digest of the rows that have been sent, along
+// with the size of the response body, once the response is complete.
+func (rw *resultWriter) digested() {
+	rw.result.Digest = rw.digest.Sum()
+	rw.result.Bytes = rw.stream.Len()
+	rw.stream.Trailer(streamDigestTrailer, rw.result.Digest)
+}
@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	streamBufferSize    = 64 * 1024
+	streamFlushInterval = 1 * time.Second
+
+	// Carry the status of the result for formats that cannot convey it in-band.
+	streamErrorTrailer          = "X-Gabi-Error"
+	streamTruncatedTrailer      = "X-Gabi-Truncated"
+	streamRowsTrailer           = "X-Gabi-Rows"
+	streamCursorTrailer         = "X-Gabi-Cursor"
+	streamEncodedColumnsTrailer = "X-Gabi-Encoded-Columns"
+	streamEncodedCellsTrailer   = "X-Gabi-Encoded-Cells"
+	streamEncodedFromTrailer    = "X-Gabi-Encoded-From"
+	streamDigestTrailer         = "X-Gabi-Digest"
+)
+
+var streamTrailers = strings.Join([]string{
+	streamErrorTrailer,
+	streamTruncatedTrailer,
+	streamRowsTrailer,
+	streamCursorTrailer,
+	streamEncodedColumnsTrailer,
+	streamEncodedCellsTrailer,
+	streamEncodedFromTrailer,
+	streamDigestTrailer,
+}, ", ")
+
+// resultStream buffers the response body and only commits it to the client
+// once enough has accumulated, so that errors raised before the first flush
+// can still be reported with an appropriate status code. Once committed,
+// the response is flushed periodically as rows are written.
+type resultStream struct {
+	w           http.ResponseWriter
+	contentType string
+	buf         bytes.Buffer
+	written     int64
+	committed   bool
+	flushed     time.Time
+}
+
+func newResultStream(w http.ResponseWriter, contentType string) *resultStream {
+	return &resultStream{w: w, contentType: contentType}
+}
+
+func (s *resultStream) Write(b []byte) (int, error) {
+	return s.buf.Write(b)
+}
+
+// Len returns the size of the response body written so far.
+func (s *resultStream) Len() int64 {
+	return s.written + int64(s.buf.Len())
+}
+
+// Truncate drops everything written past the first n bytes of the
+// response body, provided that these have not been sent yet.
+func (s *resultStream) Truncate(n int64) {
+	if n >= s.written && n <= s.Len() {
+		s.buf.Truncate(int(n - s.written))
+	}
+}
+
+// Committed reports whether any part of the response has been sent.
+func (s *resultStream) Committed() bool {
+	return s.committed
+}
+
+// Discard drops everything buffered so far, which is only possible
+// for as long as the response has not been committed.
+func (s *resultStream) Discard() {
+	s.buf.Reset()
+}
+
+// Flush sends buffered content to the client when the buffer is full,
+// or when the flush interval has elapsed since the last flush.
+func (s *resultStream) Flush() error {
+	if s.buf.Len() < streamBufferSize && (!s.committed || time.Since(s.flushed) < streamFlushInterval) {
+		return nil
+	}
+	return s.flush()
+}
+
+// Close sends whatever remains buffered to the client.
+func (s *resultStream) Close() error {
+	return s.flush()
+}
+
+// Trailer sets one of the trailers declared when the response was
+// committed, which can only be done once the response body is complete.
+func (s *resultStream) Trailer(key, value string) {
+	if s.committed {
+		s.w.Header().Set(key, value)
+	}
+}
+
+func (s *resultStream) flush() error {
+	if !s.committed {
+		s.w.Header().Set("Cache-Control", "private, no-store")
+		s.w.Header().Set("Content-Type", s.contentType)
+		s.w.Header().Set("Trailer", streamTrailers)
+		s.w.WriteHeader(http.StatusOK)
+		s.committed = true
+	}
+
+	n, err := s.buf.WriteTo(s.w)
+	s.written += n
+	if err != nil {
+		return err
+	}
+	s.flushed = time.Now()
+
+	err = http.NewResponseController(s.w).Flush()
+	if err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return err
+	}
+	return nil
+}
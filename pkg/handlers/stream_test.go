@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"bytes"
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResultStream(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		description string
+		given       func(*resultStream)
+		committed   bool
+		code        int
+		body        string
+	}{
+		{
+			"small response is not committed before it is closed",
+			func(s *resultStream) {
+				_, _ = io.WriteString(s, "test")
+				_ = s.Flush()
+			},
+			false,
+			200,
+			``,
+		},
+		{
+			"small response is sent when closed",
+			func(s *resultStream) {
+				_, _ = io.WriteString(s, "test")
+				_ = s.Flush()
+				_ = s.Close()
+			},
+			true,
+			200,
+			`test`,
+		},
+		{
+			"large response is committed once the buffer fills up",
+			func(s *resultStream) {
+				_, _ = s.Write(bytes.Repeat([]byte("a"), streamBufferSize))
+				_ = s.Flush()
+			},
+			true,
+			200,
+			`aaaa`,
+		},
+		{
+			"discarded response is never sent",
+			func(s *resultStream) {
+				_, _ = io.WriteString(s, "test")
+				s.Discard()
+				_ = s.Close()
+			},
+			true,
+			200,
+			``,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			w := httptest.NewRecorder()
+
+			actual := newResultStream(w, "text/plain")
+			tc.given(actual)
+
+			require.Equal(t, tc.committed, actual.Committed())
+			assert.Equal(t, tc.code, w.Code)
+			assert.Contains(t, w.Body.String(), tc.body)
+			if tc.committed {
+				assert.Equal(t, "text/plain", w.Header().Get("Content-Type"))
+				assert.Equal(t, "private, no-store", w.Header().Get("Cache-Control"))
+				assert.True(t, w.Flushed)
+			} else {
+				assert.Empty(t, w.Body.String())
+				assert.Empty(t, w.Header().Get("Content-Type"))
+			}
+		})
+	}
+}
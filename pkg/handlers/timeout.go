@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	gabi "github.com/app-sre/gabi/pkg"
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const timeoutErrorMessage = "Query timed out"
+
+// How far ahead of the deadline of the request the database might stop
+// a statement, as the statement timeout is set in milliseconds.
+const deadlineSlack = 10 * time.Millisecond
+
+const (
+	// Raised by PostgreSQL when a statement has been cancelled, which
+	// includes a statement timeout.
+	pgQueryCanceled = "57014"
+	// ER_QUERY_TIMEOUT, only raised for statements that read data, as
+	// MySQL does not bound others.
+	mysqlQueryTimeout = 3024
+	// ER_QUERY_INTERRUPTED, raised when a statement has been killed.
+	mysqlQueryInterrupted = 1317
+)
+
+// withTimeout bounds the request by the timeout requested by the client,
+// which can only shorten the deadline set for every request.
+func withTimeout(r *http.Request, timeout string) (*http.Request, context.CancelFunc, error) {
+	if timeout == "" {
+		return r, func() {}, nil
+	}
+
+	d, err := time.ParseDuration(timeout)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to parse timeout: %w", err)
+	}
+	if d <= 0 {
+		return nil, nil, errors.New("timeout has to be positive")
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), d)
+	return r.WithContext(ctx), cancel, nil
+}
+
+// statementTimeout returns the statement that makes the database itself
+// enforce the deadline of the request, as cancelling the context does
+// not always stop a query that is running.
+func statementTimeout(ctx context.Context, cfg *gabi.Config) string {
+	var d time.Duration
+	if deadline, ok := ctx.Deadline(); ok {
+		d = max(time.Until(deadline), time.Millisecond)
+	}
+	return cfg.DBEnv.Driver.StatementTimeout(d)
+}
+
+// beginTx starts a transaction on a connection of its own, which has to
+// be released once the transaction has ended. MySQL keeps the statement
+// timeout for the session rather than the transaction, so it is reset
+// before the connection is returned to the pool, or else the connection
+// is discarded, so that it does not bound whatever runs on it next.
+func beginTx(ctx context.Context, cfg *gabi.Config) (*sql.Tx, func(), error) {
+	conn, err := cfg.DB.Conn(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tx, err := conn.BeginTx(ctx, &sql.TxOptions{
+		ReadOnly: !cfg.DBEnv.AllowWrite,
+	})
+	if err != nil {
+		_ = conn.Close()
+		return nil, nil, err
+	}
+
+	release := func() {
+		if s := cfg.DBEnv.Driver.StatementTimeout(0); s != "" {
+			if _, err := conn.ExecContext(context.WithoutCancel(ctx), s); err != nil {
+				cfg.Logger.Errorf("Unable to reset statement timeout: %s", err)
+				_ = conn.Raw(func(any) error { return driver.ErrBadConn })
+			}
+		}
+		_ = conn.Close()
+	}
+
+	return tx, release, nil
+}
+
+func setStatementTimeout(ctx context.Context, cfg *gabi.Config, tx *sql.Tx) error {
+	s := statementTimeout(ctx, cfg)
+	if s == "" {
+		return nil
+	}
+	_, err := tx.ExecContext(ctx, s)
+	return err
+}
+
+func timeoutError(err error) bool {
+	var mysqlError *mysql.MySQLError
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return true
+	case errors.As(err, &mysqlError):
+		return mysqlError.Number == mysqlQueryTimeout
+	default:
+		return false
+	}
+}
+
+// interruptedError tells a statement the database stopped once the
+// deadline of the request had passed apart from one that was cancelled,
+// either through gabi or by someone else, as the database reports both
+// in the same way.
+func interruptedError(ctx context.Context, err error) error {
+	var (
+		pgError    *pgconn.PgError
+		mysqlError *mysql.MySQLError
+	)
+	switch {
+	case errors.As(err, &pgError) && pgError.Code == pgQueryCanceled:
+	case errors.As(err, &mysqlError) && mysqlError.Number == mysqlQueryInterrupted:
+	default:
+		return err
+	}
+
+	// The database enforces the same deadline, and might do so slightly
+	// ahead of the context.
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < deadlineSlack {
+		return fmt.Errorf("%w: %w", context.DeadlineExceeded, err)
+	}
+	return fmt.Errorf("%w: %w", context.Canceled, err)
+}
@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/app-sre/gabi/internal/test"
+	gabi "github.com/app-sre/gabi/pkg"
+	gabidb "github.com/app-sre/gabi/pkg/env/db"
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInterruptedError(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		description string
+		given       error
+		deadline    time.Duration
+		timeout     bool
+		cancelled   bool
+	}{
+		{
+			"PostgreSQL statement stopped at the deadline",
+			&pgconn.PgError{Code: "57014"},
+			-time.Second,
+			true,
+			false,
+		},
+		{
+			"PostgreSQL statement stopped just ahead of the deadline",
+			&pgconn.PgError{Code: "57014"},
+			time.Millisecond,
+			true,
+			false,
+		},
+		{
+			"PostgreSQL statement cancelled before the deadline",
+			&pgconn.PgError{Code: "57014"},
+			time.Minute,
+			false,
+			true,
+		},
+		{
+			"PostgreSQL statement cancelled without a deadline",
+			&pgconn.PgError{Code: "57014"},
+			0,
+			false,
+			true,
+		},
+		{
+			"MySQL statement killed at the deadline",
+			&mysql.MySQLError{Number: 1317},
+			-time.Second,
+			true,
+			false,
+		},
+		{
+			"MySQL statement killed without a deadline",
+			&mysql.MySQLError{Number: 1317},
+			0,
+			false,
+			true,
+		},
+		{
+			"MySQL statement timeout",
+			&mysql.MySQLError{Number: 3024},
+			-time.Second,
+			true,
+			false,
+		},
+		{
+			"PostgreSQL syntax error",
+			&pgconn.PgError{Code: "42601"},
+			-time.Second,
+			false,
+			false,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			if tc.deadline != 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tc.deadline)
+				defer cancel()
+			}
+
+			err := interruptedError(ctx, tc.given)
+
+			assert.ErrorIs(t, err, tc.given)
+			assert.Equal(t, tc.timeout, timeoutError(err))
+			assert.Equal(t, tc.cancelled, cancelledError(err))
+		})
+	}
+}
+
+func TestBeginTx(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		description string
+		driver      gabidb.DriverType
+		mock        func(sqlmock.Sqlmock)
+		idle        int
+		log         string
+	}{
+		{
+			"PostgreSQL connection returned to the pool as it is",
+			"pgx",
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectRollback()
+			},
+			1,
+			``,
+		},
+		{
+			"MySQL connection returned to the pool once its statement timeout has been reset",
+			"mysql",
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectRollback()
+				mock.ExpectExec(`^SET SESSION max_execution_time = DEFAULT$`).WillReturnResult(sqlmock.NewResult(0, 0))
+			},
+			1,
+			``,
+		},
+		{
+			"MySQL connection discarded when its statement timeout cannot be reset",
+			"mysql",
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectRollback()
+				mock.ExpectExec(`^SET SESSION max_execution_time = DEFAULT$`).WillReturnError(errors.New("test"))
+			},
+			0,
+			`Unable to reset statement timeout: test`,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			var output bytes.Buffer
+
+			db, mock, _ := sqlmock.New()
+			defer func() { _ = db.Close() }()
+
+			tc.mock(mock)
+
+			cfg := &gabi.Config{DB: db, DBEnv: &gabidb.Env{Driver: tc.driver}, Logger: test.DummyLogger(&output).Sugar()}
+
+			tx, release, err := beginTx(context.TODO(), cfg)
+			require.NoError(t, err)
+			require.NoError(t, tx.Rollback())
+			release()
+
+			require.NoError(t, mock.ExpectationsWereMet())
+			assert.Equal(t, tc.idle, db.Stats().Idle)
+			assert.Contains(t, output.String(), tc.log)
+		})
+	}
+}
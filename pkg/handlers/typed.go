@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/app-sre/gabi/pkg/models"
+)
+
+// The MySQL driver returns date and time values as text unless
+// the connection has been configured to parse them.
+const mysqlDateTimeLayout = "2006-01-02 15:04:05.999999999"
+
+type typeClass int
+
+const (
+	classText typeClass = iota
+	classInteger
+	classNumeric
+	classBoolean
+	classDate
+	classTimestamp
+	classJSON
+	classBinary
+)
+
+func typedColumns(types []*sql.ColumnType) []models.Column {
+	columns := make([]models.Column, 0, len(types))
+
+	for _, t := range types {
+		column := models.Column{
+			Name: t.Name(),
+			Type: t.DatabaseTypeName(),
+		}
+		if nullable, ok := t.Nullable(); ok {
+			column.Nullable = &nullable
+		}
+		if precision, scale, ok := t.DecimalSize(); ok {
+			column.Precision = &precision
+			column.Scale = &scale
+		}
+		columns = append(columns, column)
+	}
+
+	return columns
+}
+
+// typedValue converts a value returned by the database driver into
+// a value that retains its type once encoded as JSON. Textual values
+// are Base64-encoded when requested, and binary values always are.
+func typedValue(typeName string, value any, encoder binaryEncoding, encodeText bool) any {
+	class := classifyType(typeName)
+
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case bool:
+		return v
+	case int64, int32, int16, int8, int, uint64, uint32, uint16, uint8, uint:
+		return v
+	case float32:
+		return typedFloat(float64(v))
+	case float64:
+		return typedFloat(v)
+	case time.Time:
+		if class == classDate {
+			return v.Format(time.DateOnly)
+		}
+		return v.Format(time.RFC3339Nano)
+	case []byte:
+		if class == classBinary || !utf8.Valid(v) {
+			return encoder.EncodeToString(v)
+		}
+		return typedText(class, string(v), encoder, encodeText)
+	case string:
+		return typedText(class, v, encoder, encodeText)
+	default:
+		return typedText(classText, fmt.Sprint(v), encoder, encodeText)
+	}
+}
+
+func typedText(class typeClass, s string, encoder binaryEncoding, encodeText bool) any {
+	switch class {
+	case classInteger, classNumeric:
+		if isNumber(s) {
+			return json.Number(s)
+		}
+	case classBoolean:
+		if b, err := strconv.ParseBool(s); err == nil {
+			return b
+		}
+	case classTimestamp:
+		if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+			return t.Format(time.RFC3339Nano)
+		}
+		if t, err := time.Parse(mysqlDateTimeLayout, s); err == nil {
+			return t.Format(time.RFC3339Nano)
+		}
+	case classJSON:
+		if !encodeText && json.Valid([]byte(s)) {
+			return json.RawMessage(s)
+		}
+	}
+
+	if encodeText {
+		return encoder.EncodeToString([]byte(s))
+	}
+	return s
+}
+
+// JSON has no representation for NaN and infinite values.
+func typedFloat(f float64) any {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+	return f
+}
+
+// isNumber reports whether s can be passed through as a JSON number
+// literal, which preserves the precision of decimal values.
+func isNumber(s string) bool {
+	if s == "" || s[len(s)-1] < '0' || s[len(s)-1] > '9' {
+		return false
+	}
+	if s[0] != '-' && (s[0] < '0' || s[0] > '9') {
+		return false
+	}
+	return json.Valid([]byte(s))
+}
+
+func classifyType(typeName string) typeClass {
+	name := strings.ToUpper(strings.TrimSpace(typeName))
+	name = strings.TrimPrefix(name, "UNSIGNED ")
+
+	switch name {
+	case "INT", "INTEGER", "TINYINT", "SMALLINT", "MEDIUMINT", "BIGINT", "YEAR",
+		"INT2", "INT4", "INT8", "OID":
+		return classInteger
+	case "DECIMAL", "NUMERIC", "FLOAT", "DOUBLE", "REAL", "FLOAT4", "FLOAT8":
+		return classNumeric
+	case "BOOL", "BOOLEAN":
+		return classBoolean
+	case "DATE":
+		return classDate
+	case "DATETIME", "TIMESTAMP", "TIMESTAMPTZ":
+		return classTimestamp
+	case "JSON", "JSONB":
+		return classJSON
+	case "BYTEA", "BLOB", "TINYBLOB", "MEDIUMBLOB", "LONGBLOB", "BINARY", "VARBINARY", "BIT", "GEOMETRY":
+		return classBinary
+	default:
+		return classText
+	}
+}
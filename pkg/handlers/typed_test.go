@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTypedValue(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		description string
+		typeName    string
+		given       any
+		encode      bool
+		want        any
+	}{
+		{
+			"null value",
+			"INT8",
+			nil,
+			false,
+			nil,
+		},
+		{
+			"native integer value",
+			"INT8",
+			int64(42),
+			false,
+			int64(42),
+		},
+		{
+			"native boolean value",
+			"BOOL",
+			true,
+			false,
+			true,
+		},
+		{
+			"native floating-point value that is not a number",
+			"FLOAT8",
+			math.NaN(),
+			false,
+			"NaN",
+		},
+		{
+			"native timestamp value",
+			"TIMESTAMPTZ",
+			time.Date(2023, 1, 1, 12, 30, 0, 0, time.UTC),
+			false,
+			"2023-01-01T12:30:00Z",
+		},
+		{
+			"native date value",
+			"DATE",
+			time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+			false,
+			"2023-01-01",
+		},
+		{
+			"textual integer value",
+			"BIGINT",
+			[]byte("123"),
+			false,
+			json.Number("123"),
+		},
+		{
+			"textual unsigned integer value",
+			"UNSIGNED BIGINT",
+			[]byte("18446744073709551615"),
+			false,
+			json.Number("18446744073709551615"),
+		},
+		{
+			"textual decimal value",
+			"DECIMAL",
+			[]byte("1.50"),
+			false,
+			json.Number("1.50"),
+		},
+		{
+			"textual decimal value that is not a number",
+			"NUMERIC",
+			"NaN",
+			false,
+			"NaN",
+		},
+		{
+			"textual boolean value",
+			"BOOL",
+			"t",
+			false,
+			true,
+		},
+		{
+			"textual timestamp value",
+			"DATETIME",
+			[]byte("2023-01-01 12:30:00.5"),
+			false,
+			"2023-01-01T12:30:00.5Z",
+		},
+		{
+			"textual timestamp value that is invalid",
+			"DATETIME",
+			[]byte("0000-00-00 00:00:00"),
+			false,
+			"0000-00-00 00:00:00",
+		},
+		{
+			"textual JSON value",
+			"JSONB",
+			`{"a": 1}`,
+			false,
+			json.RawMessage(`{"a": 1}`),
+		},
+		{
+			"textual value",
+			"TEXT",
+			[]byte("test"),
+			false,
+			"test",
+		},
+		{
+			"textual value with Base64-encoding",
+			"TEXT",
+			[]byte("test"),
+			true,
+			"dGVzdA==",
+		},
+		{
+			"textual integer value with Base64-encoding",
+			"INT4",
+			[]byte("1"),
+			true,
+			json.Number("1"),
+		},
+		{
+			"binary value",
+			"BYTEA",
+			[]byte{0xde, 0xad, 0xbe, 0xef},
+			false,
+			"3q2+7w==",
+		},
+		{
+			"invalid UTF-8 value",
+			"VARCHAR",
+			[]byte{0xff, 0xfe},
+			false,
+			"//4=",
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			actual := typedValue(tc.typeName, tc.given, base64.StdEncoding, tc.encode)
+
+			assert.Equal(t, tc.want, actual)
+		})
+	}
+}
+
+func TestClassifyType(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		description string
+		given       string
+		want        typeClass
+	}{
+		{"PostgreSQL integer type", "INT4", classInteger},
+		{"MySQL unsigned integer type", "UNSIGNED INT", classInteger},
+		{"numeric type in lower case", "numeric", classNumeric},
+		{"boolean type", "BOOL", classBoolean},
+		{"timestamp type", "TIMESTAMPTZ", classTimestamp},
+		{"binary type", "VARBINARY", classBinary},
+		{"unknown type", "UUID", classText},
+		{"empty type name", "", classText},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.want, classifyType(tc.given))
+		})
+	}
+}
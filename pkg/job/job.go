@@ -0,0 +1,276 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/app-sre/gabi/internal/random"
+)
+
+const spoolFileSuffix = ".spool"
+
+var (
+	ErrNotFound     = errors.New("job not found")
+	ErrNotFinished  = errors.New("job has not finished")
+	ErrLimitReached = errors.New("too many jobs")
+)
+
+type Status string
+
+const (
+	StatusQueued    Status = "queued"
+	StatusRunning   Status = "running"
+	StatusSucceeded Status = "succeeded"
+	StatusFailed    Status = "failed"
+	StatusCancelled Status = "cancelled"
+)
+
+// Result is what running a job produced, besides the content written
+// to its spool file, which is sent to the client as the response.
+type Result struct {
+	Status Status
+	Error  string
+	Code   int
+	Header http.Header
+}
+
+// RunFunc runs a job, writing its output to w.
+type RunFunc func(ctx context.Context, w io.Writer) Result
+
+// Info describes a job at a point in time.
+type Info struct {
+	ID       string
+	User     string
+	QueryID  string
+	Created  time.Time
+	Started  time.Time
+	Finished time.Time
+	Result
+}
+
+// Job is a query run in the background on behalf of a user, whose
+// output is kept in a spool file until it expires.
+type Job struct {
+	info  Info
+	path  string
+	store *Store
+	mu    sync.Mutex
+}
+
+type Store struct {
+	dir     string
+	timeout time.Duration
+	ttl     time.Duration
+	max     int
+	queued  int
+	jobs    map[string]*Job
+	slots   map[string]chan struct{}
+	pending map[string]int
+	mu      sync.Mutex
+}
+
+// NewStore returns a store for jobs that run for at most timeout, and
+// whose output is kept in dir for ttl once finished. A max of zero
+// means there is no limit on the jobs a user can run at the same time,
+// otherwise jobs are queued until others of the same user finish. At
+// most queued jobs of a user can be queued or running, where zero means
+// there is no limit.
+func NewStore(dir string, timeout, ttl time.Duration, max, queued int) (*Store, error) {
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "gabi-jobs")
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("unable to create spool directory: %w", err)
+	}
+
+	// The output of jobs run before a restart cannot be retrieved.
+	files, err := filepath.Glob(filepath.Join(dir, "*"+spoolFileSuffix))
+	if err != nil {
+		return nil, fmt.Errorf("unable to list spool files: %w", err)
+	}
+	for _, file := range files {
+		_ = os.Remove(file)
+	}
+
+	return &Store{
+		dir:     dir,
+		timeout: timeout,
+		ttl:     ttl,
+		max:     max,
+		queued:  queued,
+		jobs:    make(map[string]*Job),
+		slots:   make(map[string]chan struct{}),
+		pending: make(map[string]int),
+	}, nil
+}
+
+// Len returns the number of jobs, including those that have finished
+// but have not expired yet.
+func (s *Store) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.jobs)
+}
+
+// Submit queues a job that is run once the user has fewer jobs running
+// than allowed. Cancelling the context cancels the job, even while it
+// is still queued. The ID of the query run by the job is optional.
+func (s *Store) Submit(ctx context.Context, user, queryID string, run RunFunc) (*Job, error) {
+	id, err := random.ID()
+	if err != nil {
+		return nil, fmt.Errorf("unable to generate job ID: %w", err)
+	}
+
+	j := &Job{
+		info: Info{
+			ID:      id,
+			User:    user,
+			QueryID: queryID,
+			Created: time.Now(),
+			Result:  Result{Status: StatusQueued},
+		},
+		path:  filepath.Join(s.dir, id+spoolFileSuffix),
+		store: s,
+	}
+
+	s.mu.Lock()
+	if s.queued > 0 && s.pending[user] >= s.queued {
+		s.mu.Unlock()
+		return nil, ErrLimitReached
+	}
+	s.pending[user]++
+	s.jobs[id] = j
+	slot := s.slot(user)
+	s.mu.Unlock()
+
+	go j.run(ctx, slot, run)
+
+	return j, nil
+}
+
+// Get returns the job with the given ID, provided it has been
+// submitted by the same user.
+func (s *Store) Get(id, user string) (*Job, error) {
+	s.mu.Lock()
+	j, ok := s.jobs[id]
+	s.mu.Unlock()
+
+	// Do not disclose jobs that belong to other users.
+	if !ok || j.info.User != user {
+		return nil, ErrNotFound
+	}
+	return j, nil
+}
+
+// Open returns the output of a job that has finished, which has to be
+// closed by the caller.
+func (j *Job) Open() (*os.File, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.info.Finished.IsZero() {
+		return nil, ErrNotFinished
+	}
+
+	f, err := os.Open(j.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+// Info returns what is known about the job so far.
+func (j *Job) Info() Info {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	info := j.info
+	info.Header = j.info.Header.Clone()
+	return info
+}
+
+func (j *Job) run(ctx context.Context, slot chan struct{}, run RunFunc) {
+	if slot != nil {
+		select {
+		case slot <- struct{}{}:
+			defer func() { <-slot }()
+		case <-ctx.Done():
+			j.finish(Result{Status: StatusCancelled, Error: "job cancelled while queued"})
+			return
+		}
+	}
+
+	// Only jobs that are running take up a spool file.
+	f, err := os.OpenFile(j.path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		j.finish(Result{Status: StatusFailed, Error: fmt.Sprintf("unable to create spool file: %s", err)})
+		return
+	}
+	defer func() { _ = f.Close() }()
+
+	j.mu.Lock()
+	j.info.Status = StatusRunning
+	j.info.Started = time.Now()
+	j.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, j.store.timeout)
+	defer cancel()
+
+	result := run(ctx, f)
+	if err := f.Sync(); err != nil && result.Status == StatusSucceeded {
+		result = Result{Status: StatusFailed, Error: fmt.Sprintf("unable to write spool file: %s", err)}
+	}
+	j.finish(result)
+}
+
+// finish records the result of the job, which expires after the TTL.
+func (j *Job) finish(result Result) {
+	s := j.store
+
+	s.mu.Lock()
+	if s.pending[j.info.User]--; s.pending[j.info.User] == 0 {
+		delete(s.pending, j.info.User)
+	}
+	s.mu.Unlock()
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.info.Result = result
+	j.info.Finished = time.Now()
+	time.AfterFunc(j.store.ttl, j.expire)
+}
+
+func (j *Job) expire() {
+	s := j.store
+
+	s.mu.Lock()
+	delete(s.jobs, j.info.ID)
+	s.mu.Unlock()
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	_ = os.Remove(j.path)
+}
+
+// slot returns the semaphore that bounds the jobs of the user running
+// at the same time, if any. It must be called with the store locked.
+func (s *Store) slot(user string) chan struct{} {
+	if s.max == 0 {
+		return nil
+	}
+	slot, ok := s.slots[user]
+	if !ok {
+		slot = make(chan struct{}, s.max)
+		s.slots[user] = slot
+	}
+	return slot
+}
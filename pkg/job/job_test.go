@@ -0,0 +1,214 @@
+package job
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewStore(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	stale := filepath.Join(dir, "test"+spoolFileSuffix)
+	require.NoError(t, os.WriteFile(stale, []byte("test"), 0o600))
+	other := filepath.Join(dir, "test")
+	require.NoError(t, os.WriteFile(other, []byte("test"), 0o600))
+
+	actual, err := NewStore(dir, time.Minute, time.Minute, 1, 0)
+
+	require.NoError(t, err)
+	require.NotNil(t, actual)
+	assert.Equal(t, 0, actual.Len())
+	assert.NoFileExists(t, stale)
+	assert.FileExists(t, other)
+}
+
+func TestSubmit(t *testing.T) {
+	t.Parallel()
+
+	s, err := NewStore(t.TempDir(), time.Minute, time.Minute, 0, 0)
+	require.NoError(t, err)
+
+	release := make(chan struct{})
+
+	j, err := s.Submit(context.TODO(), "test", "query", func(ctx context.Context, w io.Writer) Result {
+		<-release
+		_, _ = io.WriteString(w, "test")
+		return Result{Status: StatusSucceeded, Code: 200}
+	})
+	require.NoError(t, err)
+
+	info := j.Info()
+	assert.Len(t, info.ID, 32)
+	assert.Equal(t, "test", info.User)
+	assert.Equal(t, "query", info.QueryID)
+
+	_, err = j.Open()
+	assert.ErrorIs(t, err, ErrNotFinished)
+
+	_, err = s.Get(info.ID, "other")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	actual, err := s.Get(info.ID, "test")
+	require.NoError(t, err)
+	assert.Same(t, j, actual)
+
+	close(release)
+
+	assert.Eventually(t, func() bool {
+		return j.Info().Status == StatusSucceeded
+	}, time.Second, 5*time.Millisecond)
+
+	info = j.Info()
+	assert.Equal(t, 200, info.Code)
+	assert.False(t, info.Started.IsZero())
+	assert.False(t, info.Finished.IsZero())
+
+	f, err := j.Open()
+	require.NoError(t, err)
+	defer func() { _ = f.Close() }()
+
+	b, err := io.ReadAll(f)
+	require.NoError(t, err)
+	assert.Equal(t, "test", string(b))
+}
+
+func TestSubmitTimeout(t *testing.T) {
+	t.Parallel()
+
+	s, err := NewStore(t.TempDir(), 10*time.Millisecond, time.Minute, 0, 0)
+	require.NoError(t, err)
+
+	j, err := s.Submit(context.TODO(), "test", "", func(ctx context.Context, w io.Writer) Result {
+		<-ctx.Done()
+		return Result{Status: StatusFailed, Error: ctx.Err().Error()}
+	})
+	require.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		return j.Info().Status == StatusFailed
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, context.DeadlineExceeded.Error(), j.Info().Error)
+}
+
+func TestSubmitQueued(t *testing.T) {
+	t.Parallel()
+
+	s, err := NewStore(t.TempDir(), time.Minute, time.Minute, 1, 0)
+	require.NoError(t, err)
+
+	release := make(chan struct{})
+	run := func(ctx context.Context, w io.Writer) Result {
+		<-release
+		return Result{Status: StatusSucceeded, Code: 200}
+	}
+
+	first, err := s.Submit(context.TODO(), "test", "", run)
+	require.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		return first.Info().Status == StatusRunning
+	}, time.Second, 5*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.TODO())
+
+	second, err := s.Submit(context.TODO(), "test", "", run)
+	require.NoError(t, err)
+	third, err := s.Submit(ctx, "test", "", run)
+	require.NoError(t, err)
+	other, err := s.Submit(context.TODO(), "other", "", run)
+	require.NoError(t, err)
+
+	// Jobs of other users are not held back.
+	assert.Eventually(t, func() bool {
+		return other.Info().Status == StatusRunning
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, StatusQueued, second.Info().Status)
+
+	cancel()
+
+	assert.Eventually(t, func() bool {
+		return third.Info().Status == StatusCancelled
+	}, time.Second, 5*time.Millisecond)
+
+	close(release)
+
+	assert.Eventually(t, func() bool {
+		return second.Info().Status == StatusSucceeded
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestSubmitLimitReached(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	s, err := NewStore(dir, time.Minute, time.Minute, 1, 2)
+	require.NoError(t, err)
+
+	release := make(chan struct{})
+	run := func(ctx context.Context, w io.Writer) Result {
+		<-release
+		return Result{Status: StatusSucceeded, Code: 200}
+	}
+
+	first, err := s.Submit(context.TODO(), "test", "", run)
+	require.NoError(t, err)
+	second, err := s.Submit(context.TODO(), "test", "", run)
+	require.NoError(t, err)
+
+	// Queued jobs count towards the limit too.
+	_, err = s.Submit(context.TODO(), "test", "", run)
+	assert.ErrorIs(t, err, ErrLimitReached)
+
+	_, err = s.Submit(context.TODO(), "other", "", run)
+	require.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		return first.Info().Status == StatusRunning
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, StatusQueued, second.Info().Status)
+
+	// Only running jobs take up a spool file.
+	assert.FileExists(t, filepath.Join(dir, first.Info().ID+spoolFileSuffix))
+	assert.NoFileExists(t, filepath.Join(dir, second.Info().ID+spoolFileSuffix))
+
+	close(release)
+
+	assert.Eventually(t, func() bool {
+		return second.Info().Status == StatusSucceeded
+	}, time.Second, 5*time.Millisecond)
+
+	_, err = s.Submit(context.TODO(), "test", "", run)
+	assert.NoError(t, err)
+}
+
+func TestExpire(t *testing.T) {
+	t.Parallel()
+
+	s, err := NewStore(t.TempDir(), time.Minute, 10*time.Millisecond, 0, 0)
+	require.NoError(t, err)
+
+	j, err := s.Submit(context.TODO(), "test", "", func(ctx context.Context, w io.Writer) Result {
+		return Result{Status: StatusSucceeded, Code: 200}
+	})
+	require.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		return s.Len() == 0
+	}, time.Second, 5*time.Millisecond)
+
+	_, err = s.Get(j.Info().ID, "test")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	_, err = j.Open()
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.NoFileExists(t, j.path)
+}
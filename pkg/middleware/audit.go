@@ -10,6 +10,7 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/app-sre/gabi/internal/random"
 	gabi "github.com/app-sre/gabi/pkg"
 	"github.com/app-sre/gabi/pkg/audit"
 	"github.com/app-sre/gabi/pkg/models"
@@ -21,55 +22,21 @@ func Audit(cfg *gabi.Config) Middleware {
 			ctx := r.Context()
 			now := time.Now()
 
-			var (
-				b       bytes.Buffer
-				request models.QueryRequest
-				user    string
-			)
+			var request models.QueryRequest
 
-			if s := r.Header.Get(contentLengthHeader); s == "" {
-				l := fmt.Sprintf("Request without required header: %s", contentLengthHeader)
-				http.Error(w, l, http.StatusBadRequest)
+			user, b, ok := readAuditRequest(cfg, w, r)
+			if !ok {
 				return
 			}
 
-			base64DecodeQuery := false
-			if s := r.URL.Query().Get("base64_query"); s != "" {
-				if ok, err := strconv.ParseBool(s); err == nil && ok {
-					base64DecodeQuery = true
-				}
-			}
-
-			if ctxUser := ctx.Value(ContextKeyUser); ctxUser != nil {
-				if s, ok := ctxUser.(string); ok {
-					user = s
-				}
-			} else {
-				user = r.Header.Get(forwardedUserHeader)
-			}
-			if user == "" {
-				l := fmt.Sprintf("Request without required header: %s", forwardedUserHeader)
-				http.Error(w, l, http.StatusBadRequest)
-				return
-			}
-
-			if _, err := io.Copy(&b, r.Body); err != nil {
-				cfg.Logger.Errorf("Unable to copy request body: %s", err)
-				http.Error(w, "An internal error has occurred", http.StatusInternalServerError)
-				return
-			}
-			_ = r.Body.Close()
-
-			r.Body = io.NopCloser(bytes.NewReader(b.Bytes()))
-
-			err := json.Unmarshal(b.Bytes(), &request)
+			err := json.Unmarshal(b, &request)
 			if err != nil {
 				cfg.Logger.Debugf("Unable to unmarshal request body: %s", err)
 				h.ServeHTTP(w, r)
 				return
 			}
 
-			if base64DecodeQuery {
+			if base64DecodeQuery(r) {
 				bytes, err := cfg.Encoder.DecodeString(request.Query)
 				if err != nil {
 					l := "Unable to decode Base64-encoded query"
@@ -80,21 +47,115 @@ func Audit(cfg *gabi.Config) Middleware {
 				request.Query = string(bytes)
 			}
 
+			args := auditArgs(request.Args)
+
+			id, err := random.ID()
+			if err != nil {
+				cfg.Logger.Errorf("Unable to audit request: %s", err)
+				http.Error(w, "An internal error has occurred", http.StatusInternalServerError)
+				return
+			}
+
+			// Fetching the next page of a result is audited under the
+			// cursor, as the query has been audited when it was opened.
 			query := &audit.QueryData{
 				Query:     request.Query,
+				Args:      args,
+				DryRun:    request.DryRun,
+				Cursor:    request.Cursor,
 				User:      user,
 				Timestamp: now.Unix(),
+				RequestID: id,
 			}
-			_ = cfg.LoggerAudit.Write(ctx, query)
-
-			if err := cfg.SplunkAudit.Write(ctx, query); err != nil {
-				cfg.Logger.Errorf("Unable to send audit to Splunk: %s", err)
+			if err := cfg.Audit.Write(ctx, query); err != nil {
+				cfg.Logger.Errorf("Unable to write audit: %s", err)
 				http.Error(w, "An internal error has occurred", http.StatusInternalServerError)
 				return
 			}
 
+			// Handlers fill in the result as the query is being executed,
+			// which is then audited once the handler returns.
+			result := &audit.ResultData{}
+
 			ctx = context.WithValue(ctx, ContextKeyQuery, request.Query)
+			ctx = context.WithValue(ctx, ContextKeyResult, result)
+
+			deferred := newDeferredAudit(func(results []*audit.ResultData) {
+				query := &audit.QueryData{
+					Query:     request.Query,
+					Args:      args,
+					DryRun:    request.DryRun,
+					Cursor:    request.Cursor,
+					User:      user,
+					Timestamp: time.Now().Unix(),
+					RequestID: id,
+					Result:    results[0],
+				}
+				writeResultAudit(ctx, cfg, query)
+			}, result)
+			ctx = context.WithValue(ctx, contextKeyDeferredAudit, deferred)
+
 			h.ServeHTTP(w, r.WithContext(ctx))
+
+			deferred.finish()
 		})
 	}
 }
+
+// readAuditRequest returns the user making the request and its body,
+// which is left in place for the handler to read.
+func readAuditRequest(cfg *gabi.Config, w http.ResponseWriter, r *http.Request) (string, []byte, bool) {
+	var (
+		b    bytes.Buffer
+		user string
+	)
+
+	if s := r.Header.Get(contentLengthHeader); s == "" {
+		l := fmt.Sprintf("Request without required header: %s", contentLengthHeader)
+		http.Error(w, l, http.StatusBadRequest)
+		return "", nil, false
+	}
+
+	if ctxUser := r.Context().Value(ContextKeyUser); ctxUser != nil {
+		if s, ok := ctxUser.(string); ok {
+			user = s
+		}
+	} else {
+		user = r.Header.Get(forwardedUserHeader)
+	}
+	if user == "" {
+		l := fmt.Sprintf("Request without required header: %s", forwardedUserHeader)
+		http.Error(w, l, http.StatusBadRequest)
+		return "", nil, false
+	}
+
+	if _, err := io.Copy(&b, r.Body); err != nil {
+		cfg.Logger.Errorf("Unable to copy request body: %s", err)
+		http.Error(w, "An internal error has occurred", http.StatusInternalServerError)
+		return "", nil, false
+	}
+	_ = r.Body.Close()
+
+	r.Body = io.NopCloser(bytes.NewReader(b.Bytes()))
+
+	return user, b.Bytes(), true
+}
+
+func base64DecodeQuery(r *http.Request) bool {
+	if s := r.URL.Query().Get("base64_query"); s != "" {
+		if ok, err := strconv.ParseBool(s); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Arguments are recorded separately from the statement, as they were
+// given, so that the two can be told apart.
+func auditArgs(queryArgs []models.QueryArg) []json.RawMessage {
+	var args []json.RawMessage
+	for _, arg := range queryArgs {
+		args = append(args, arg.Value)
+	}
+	return args
+}
@@ -16,6 +16,7 @@ import (
 	"github.com/app-sre/gabi/pkg/audit"
 	"github.com/app-sre/gabi/pkg/env/splunk"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestAudit(t *testing.T) {
@@ -64,10 +65,112 @@ func TestAudit(t *testing.T) {
 			},
 			200,
 			``,
-			`{"query":"select 1;","user":"test","namespace":"test","pod":"test"}`,
-			regexp.MustCompile(`AUDIT\s{"Query": "select 1;", "User": "test", "Timestamp": \d{10}}`),
+			`{"query":"select 1;","user":"test","namespace":"test","pod":"test","request_id":"`,
+			regexp.MustCompile(`AUDIT\s{"Query": "select 1;", "User": "test", "Timestamp": \d{10}, "RequestID": "[0-9a-f]{32}"}`),
 			`select 1;`,
 		},
+		{
+			"valid query with arguments audited separately",
+			func(s *httptest.Server) *splunk.Env {
+				return &splunk.Env{
+					Endpoint:  s.URL,
+					Host:      "test",
+					Namespace: "test",
+					Pod:       "test",
+				}
+			},
+			func() context.Context {
+				return context.TODO()
+			},
+			func(b *bytes.Buffer) func(r *http.Request) {
+				return func(r *http.Request) {
+					r.Header.Set("Content-Length", fmt.Sprint(b.Len()))
+					r.Header.Set("X-Forwarded-User", "test")
+				}
+			},
+			func() *bytes.Buffer {
+				return bytes.NewBufferString(`{"query": "select $1, $2;", "args": [1, {"value": "test", "type": "text"}]}`)
+			},
+			func(b *bytes.Buffer) func(w http.ResponseWriter, r *http.Request) {
+				return func(w http.ResponseWriter, r *http.Request) {
+					_, _ = io.Copy(b, r.Body)
+					fmt.Fprintln(w, `{"Code":0,"Text":""}`)
+				}
+			},
+			200,
+			``,
+			`{"query":"select $1, $2;","args":[1,"test"],"user":"test","namespace":"test","pod":"test","request_id":"`,
+			regexp.MustCompile(`AUDIT\s{"Query": "select \$1, \$2;", "User": "test", "Timestamp": \d{10}, "RequestID": "[0-9a-f]{32}", "Args": \[1,"test"\]}`),
+			`select \$1, \$2;`,
+		},
+		{
+			"valid query with result audited once executed",
+			func(s *httptest.Server) *splunk.Env {
+				return &splunk.Env{
+					Endpoint:  s.URL,
+					Host:      "test",
+					Namespace: "test",
+					Pod:       "test",
+				}
+			},
+			func() context.Context {
+				return context.TODO()
+			},
+			func(b *bytes.Buffer) func(r *http.Request) {
+				return func(r *http.Request) {
+					r.Header.Set("Content-Length", fmt.Sprint(b.Len()))
+					r.Header.Set("X-Forwarded-User", "test")
+				}
+			},
+			func() *bytes.Buffer {
+				return bytes.NewBufferString(`{"query": "select 1;"}`)
+			},
+			func(b *bytes.Buffer) func(w http.ResponseWriter, r *http.Request) {
+				return func(w http.ResponseWriter, r *http.Request) {
+					_, _ = io.Copy(b, r.Body)
+					fmt.Fprintln(w, `{"Code":0,"Text":""}`)
+				}
+			},
+			200,
+			``,
+			`"result":{"rows":0,"truncated":false,"row_limit":0,"byte_limit":0}}`,
+			regexp.MustCompile(`AUDIT\s{"Query": "select 1;", "User": "test", "Timestamp": \d{10}, "RequestID": "[0-9a-f]{32}", "Rows": 0, "Truncated": false, "RowLimit": 0, "ByteLimit": 0}`),
+			`select 1;`,
+		},
+		{
+			"valid query run as a dry run",
+			func(s *httptest.Server) *splunk.Env {
+				return &splunk.Env{
+					Endpoint:  s.URL,
+					Host:      "test",
+					Namespace: "test",
+					Pod:       "test",
+				}
+			},
+			func() context.Context {
+				return context.TODO()
+			},
+			func(b *bytes.Buffer) func(r *http.Request) {
+				return func(r *http.Request) {
+					r.Header.Set("Content-Length", fmt.Sprint(b.Len()))
+					r.Header.Set("X-Forwarded-User", "test")
+				}
+			},
+			func() *bytes.Buffer {
+				return bytes.NewBufferString(`{"query": "delete from test;", "dry_run": true}`)
+			},
+			func(b *bytes.Buffer) func(w http.ResponseWriter, r *http.Request) {
+				return func(w http.ResponseWriter, r *http.Request) {
+					_, _ = io.Copy(b, r.Body)
+					fmt.Fprintln(w, `{"Code":0,"Text":""}`)
+				}
+			},
+			200,
+			``,
+			`"result":{"rows":0,"truncated":false,"row_limit":0,"byte_limit":0}}`,
+			regexp.MustCompile(`AUDIT\s{"Query": "delete from test;", "User": "test", "Timestamp": \d{10}, "RequestID": "[0-9a-f]{32}", "DryRun": true, "Rows": 0, "Truncated": false, "RowLimit": 0, "ByteLimit": 0}`),
+			`delete from test;`,
+		},
 		{
 			"valid Base64-encoded query",
 			func(s *httptest.Server) *splunk.Env {
@@ -101,8 +204,8 @@ func TestAudit(t *testing.T) {
 			},
 			200,
 			``,
-			`{"query":"select 1;","user":"test","namespace":"test","pod":"test"}`,
-			regexp.MustCompile(`AUDIT\s{"Query": "select 1;", "User": "test", "Timestamp": \d{10}}`),
+			`{"query":"select 1;","user":"test","namespace":"test","pod":"test","request_id":"`,
+			regexp.MustCompile(`AUDIT\s{"Query": "select 1;", "User": "test", "Timestamp": \d{10}, "RequestID": "[0-9a-f]{32}"}`),
 			`select 1;`,
 		},
 		{
@@ -135,8 +238,8 @@ func TestAudit(t *testing.T) {
 			},
 			200,
 			``,
-			`{"query":"select 1;","user":"test2","namespace":"test","pod":"test"}`,
-			regexp.MustCompile(`AUDIT\s{"Query": "select 1;", "User": "test2", "Timestamp": \d{10}}`),
+			`{"query":"select 1;","user":"test2","namespace":"test","pod":"test","request_id":"`,
+			regexp.MustCompile(`AUDIT\s{"Query": "select 1;", "User": "test2", "Timestamp": \d{10}, "RequestID": "[0-9a-f]{32}"}`),
 			`select 1;`,
 		},
 		{
@@ -172,8 +275,8 @@ func TestAudit(t *testing.T) {
 			},
 			200,
 			``,
-			`{"query":"select 1;","user":"test","namespace":"test","pod":"test"}`,
-			regexp.MustCompile(`AUDIT\s{"Query": "select 1;", "User": "test", "Timestamp": \d{10}}`),
+			`{"query":"select 1;","user":"test","namespace":"test","pod":"test","request_id":"`,
+			regexp.MustCompile(`AUDIT\s{"Query": "select 1;", "User": "test", "Timestamp": \d{10}, "RequestID": "[0-9a-f]{32}"}`),
 			`select 1;`,
 		},
 		{
@@ -204,8 +307,39 @@ func TestAudit(t *testing.T) {
 			200,
 			``,
 			``,
-			regexp.MustCompile(`AUDIT\s{"Query": "", "User": "test", "Timestamp": \d{10}}`),
+			regexp.MustCompile(`AUDIT\s{"Query": "", "User": "test", "Timestamp": \d{10}, "RequestID": "[0-9a-f]{32}"}`),
+			``,
+		},
+		{
+			"valid request for the next page of a cursor audited under the cursor",
+			func(s *httptest.Server) *splunk.Env {
+				return &splunk.Env{
+					Endpoint: s.URL,
+				}
+			},
+			func() context.Context {
+				return context.TODO()
+			},
+			func(b *bytes.Buffer) func(r *http.Request) {
+				return func(r *http.Request) {
+					r.Header.Set("Content-Length", fmt.Sprint(b.Len()))
+					r.Header.Set("X-Forwarded-User", "test")
+				}
+			},
+			func() *bytes.Buffer {
+				return bytes.NewBufferString(`{"cursor": "test"}`)
+			},
+			func(b *bytes.Buffer) func(w http.ResponseWriter, r *http.Request) {
+				return func(w http.ResponseWriter, r *http.Request) {
+					_, _ = io.Copy(b, r.Body)
+					fmt.Fprintln(w, `{"Code":0,"Text":""}`)
+				}
+			},
+			200,
 			``,
+			`"cursor":"test"`,
+			regexp.MustCompile(`AUDIT\s{"Query": "", "User": "test", "Timestamp": \d{10}, "RequestID": "[0-9a-f]{32}", "Cursor": "test"}`),
+			`^$`,
 		},
 		{
 			"valid query with no Splunk endpoint configured",
@@ -510,7 +644,8 @@ func TestAudit(t *testing.T) {
 
 			tc.headers(tc.request())(r)
 
-			expected := &gabi.Config{LoggerAudit: la, SplunkAudit: sa, Logger: logger, Encoder: encoder}
+			pipeline := audit.NewPipeline([]audit.Sink{{Name: "console", Audit: la}, {Name: "splunk", Audit: sa, Required: true}}, 0, logger)
+			expected := &gabi.Config{Audit: pipeline, Logger: logger, Encoder: encoder}
 			Audit(expected)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 				query, _ = r.Context().Value(ContextKeyQuery).(string)
 			})).ServeHTTP(w, r.WithContext(tc.context()))
@@ -528,3 +663,53 @@ func TestAudit(t *testing.T) {
 		})
 	}
 }
+
+func TestDeferResultAudit(t *testing.T) {
+	t.Parallel()
+
+	var output bytes.Buffer
+
+	b := bytes.NewBufferString(`{"query": "select 1;"}`)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/", b)
+	r.Header.Set("Content-Length", fmt.Sprint(b.Len()))
+	r.Header.Set("X-Forwarded-User", "test")
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, `{"Code":0,"Text":""}`)
+	}))
+	defer s.Close()
+
+	logger := test.DummyLogger(&output).Sugar()
+
+	la := &audit.ConsoleAudit{Logger: logger}
+	sa := &audit.SplunkAudit{SplunkEnv: &splunk.Env{Endpoint: s.URL}}
+	sa.SetHTTPClient(http.DefaultClient)
+
+	var audited func()
+
+	pipeline := audit.NewPipeline([]audit.Sink{{Name: "console", Audit: la}, {Name: "splunk", Audit: sa, Required: true}}, 0, logger)
+	expected := &gabi.Config{Audit: pipeline, Logger: logger, Encoder: base64.StdEncoding}
+	Audit(expected)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		audited = DeferResultAudit(r.Context())
+	})).ServeHTTP(w, r)
+
+	result := regexp.MustCompile(`AUDIT\s{"Query": "select 1;", "User": "test", "Timestamp": \d{10}, "RequestID": "[0-9a-f]{32}", "Rows": 0`)
+
+	assert.Equal(t, 200, w.Code)
+	assert.Regexp(t, `AUDIT\s{"Query": "select 1;", "User": "test", "Timestamp": \d{10}, "RequestID": "[0-9a-f]{32}"}`, output.String())
+	assert.NotRegexp(t, result, output.String())
+
+	audited()
+
+	assert.Regexp(t, result, output.String())
+
+	// Both events are audited for the same request.
+	ids := regexp.MustCompile(`"RequestID": "([0-9a-f]{32})"`).FindAllStringSubmatch(output.String(), -1)
+	require.Len(t, ids, 2)
+	assert.Equal(t, ids[0][1], ids[1][1])
+
+	// Without the middleware, there is nothing to audit.
+	assert.NotPanics(t, func() { DeferResultAudit(context.TODO())() })
+}
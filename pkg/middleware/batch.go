@@ -0,0 +1,101 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/app-sre/gabi/internal/random"
+	gabi "github.com/app-sre/gabi/pkg"
+	"github.com/app-sre/gabi/pkg/audit"
+	"github.com/app-sre/gabi/pkg/models"
+)
+
+// AuditBatch audits every statement of a batch individually, under an
+// ID shared by all of them, both before the batch is executed and once
+// it has been.
+func AuditBatch(cfg *gabi.Config) Middleware {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			now := time.Now()
+
+			var request models.BatchRequest
+
+			user, b, ok := readAuditRequest(cfg, w, r)
+			if !ok {
+				return
+			}
+
+			err := json.Unmarshal(b, &request)
+			if err != nil {
+				cfg.Logger.Debugf("Unable to unmarshal request body: %s", err)
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			queries := make([]string, len(request.Statements))
+			for i, statement := range request.Statements {
+				queries[i] = statement.Query
+				if base64DecodeQuery(r) {
+					bytes, err := cfg.Encoder.DecodeString(statement.Query)
+					if err != nil {
+						l := "Unable to decode Base64-encoded query"
+						cfg.Logger.Errorf("%s: %s", l, err)
+						http.Error(w, l, http.StatusBadRequest)
+						return
+					}
+					queries[i] = string(bytes)
+				}
+			}
+
+			id, err := random.ID()
+			if err != nil {
+				cfg.Logger.Errorf("Unable to create batch ID: %s", err)
+				http.Error(w, "An internal error has occurred", http.StatusInternalServerError)
+				return
+			}
+
+			statements := make([]*audit.QueryData, len(request.Statements))
+			for i, statement := range request.Statements {
+				statements[i] = &audit.QueryData{
+					Query:     queries[i],
+					Args:      auditArgs(statement.Args),
+					BatchID:   id,
+					Statement: i + 1,
+					User:      user,
+					Timestamp: now.Unix(),
+				}
+				if err := cfg.Audit.Write(ctx, statements[i]); err != nil {
+					cfg.Logger.Errorf("Unable to write audit: %s", err)
+					http.Error(w, "An internal error has occurred", http.StatusInternalServerError)
+					return
+				}
+			}
+
+			results := make([]*audit.ResultData, len(request.Statements))
+			for i := range results {
+				results[i] = &audit.ResultData{}
+			}
+
+			ctx = context.WithValue(ctx, ContextKeyBatchID, id)
+			ctx = context.WithValue(ctx, ContextKeyQueries, queries)
+			ctx = context.WithValue(ctx, ContextKeyResults, results)
+
+			deferred := newDeferredAudit(func(results []*audit.ResultData) {
+				for i, statement := range statements {
+					query := *statement
+					query.Timestamp = time.Now().Unix()
+					query.Result = results[i]
+					writeResultAudit(ctx, cfg, &query)
+				}
+			}, results...)
+			ctx = context.WithValue(ctx, contextKeyDeferredAudit, deferred)
+
+			h.ServeHTTP(w, r.WithContext(ctx))
+
+			deferred.finish()
+		})
+	}
+}
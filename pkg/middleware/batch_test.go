@@ -0,0 +1,162 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/app-sre/gabi/internal/test"
+	gabi "github.com/app-sre/gabi/pkg"
+	"github.com/app-sre/gabi/pkg/audit"
+	"github.com/app-sre/gabi/pkg/env/splunk"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditBatch(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		description string
+		parameters  func(*http.Request)
+		request     func() *bytes.Buffer
+		handler     func(w http.ResponseWriter, r *http.Request)
+		code        int
+		body        string
+		want        []*regexp.Regexp
+		queries     []string
+	}{
+		{
+			"valid batch with every statement audited",
+			func(r *http.Request) {
+				// No-op.
+			},
+			func() *bytes.Buffer {
+				return bytes.NewBufferString(`{"statements": [{"query": "select 1;"}, {"query": "select $1;", "args": [2]}]}`)
+			},
+			func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprintln(w, `{"Code":0,"Text":""}`)
+			},
+			200,
+			``,
+			[]*regexp.Regexp{
+				regexp.MustCompile(`AUDIT\s{"Query": "select 1;", "User": "test", "Timestamp": \d{10}, "BatchID": "([0-9a-f]{32})", "Statement": 1}`),
+				regexp.MustCompile(`AUDIT\s{"Query": "select \$1;", "User": "test", "Timestamp": \d{10}, "Args": \[2\], "BatchID": "([0-9a-f]{32})", "Statement": 2}`),
+				regexp.MustCompile(`AUDIT\s{"Query": "select 1;", "User": "test", "Timestamp": \d{10}, "BatchID": "([0-9a-f]{32})", "Statement": 1, "Rows": 0, "Truncated": false, "RowLimit": 0, "ByteLimit": 0}`),
+				regexp.MustCompile(`AUDIT\s{"Query": "select \$1;", "User": "test", "Timestamp": \d{10}, "Args": \[2\], "BatchID": "([0-9a-f]{32})", "Statement": 2, "Rows": 0, "Truncated": false, "RowLimit": 0, "ByteLimit": 0}`),
+			},
+			[]string{"select 1;", "select $1;"},
+		},
+		{
+			"valid batch with Base64-encoded statements",
+			func(r *http.Request) {
+				q := r.URL.Query()
+				q.Add("base64_query", "true")
+				r.URL.RawQuery = q.Encode()
+			},
+			func() *bytes.Buffer {
+				return bytes.NewBufferString(`{"statements": [{"query": "c2VsZWN0IDE7"}]}`)
+			},
+			func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprintln(w, `{"Code":0,"Text":""}`)
+			},
+			200,
+			``,
+			[]*regexp.Regexp{
+				regexp.MustCompile(`AUDIT\s{"Query": "select 1;", "User": "test", "Timestamp": \d{10}, "BatchID": "[0-9a-f]{32}", "Statement": 1}`),
+			},
+			[]string{"select 1;"},
+		},
+		{
+			"invalid batch with malformed Base64-encoded statement",
+			func(r *http.Request) {
+				q := r.URL.Query()
+				q.Add("base64_query", "true")
+				r.URL.RawQuery = q.Encode()
+			},
+			func() *bytes.Buffer {
+				return bytes.NewBufferString(`{"statements": [{"query": "dGhpcyBpcyBhIHRlc3Q=="}]}`)
+			},
+			func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprintln(w, `{"Code":0,"Text":""}`)
+			},
+			400,
+			`Unable to decode Base64-encoded query`,
+			nil,
+			nil,
+		},
+		{
+			"invalid batch with an error in Splunk response",
+			func(r *http.Request) {
+				// No-op.
+			},
+			func() *bytes.Buffer {
+				return bytes.NewBufferString(`{"statements": [{"query": "select 1;"}]}`)
+			},
+			func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprintln(w, `{"Code":6,"Text":"Invalid data format"}`)
+			},
+			500,
+			`An internal error has occurred`,
+			nil,
+			nil,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			var (
+				body, output bytes.Buffer
+				batchID      string
+				queries      []string
+			)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/", tc.request())
+			r.Header.Set("Content-Length", fmt.Sprint(tc.request().Len()))
+			r.Header.Set("X-Forwarded-User", "test")
+			tc.parameters(r)
+
+			s := httptest.NewServer(http.HandlerFunc(tc.handler))
+			defer s.Close()
+
+			logger := test.DummyLogger(&output).Sugar()
+			encoder := base64.StdEncoding
+
+			la := &audit.ConsoleAudit{Logger: logger}
+			sa := &audit.SplunkAudit{SplunkEnv: &splunk.Env{Endpoint: s.URL}}
+			sa.SetHTTPClient(http.DefaultClient)
+
+			pipeline := audit.NewPipeline([]audit.Sink{{Name: "console", Audit: la}, {Name: "splunk", Audit: sa, Required: true}}, 0, logger)
+			expected := &gabi.Config{Audit: pipeline, Logger: logger, Encoder: encoder}
+			AuditBatch(expected)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				batchID, _ = r.Context().Value(ContextKeyBatchID).(string)
+				queries, _ = r.Context().Value(ContextKeyQueries).([]string)
+			})).ServeHTTP(w, r.WithContext(context.TODO()))
+
+			actual := w.Result()
+			defer func() { _ = actual.Body.Close() }()
+
+			_, _ = io.Copy(&body, actual.Body)
+
+			assert.Equal(t, tc.code, actual.StatusCode)
+			assert.Contains(t, body.String(), tc.body)
+			assert.Equal(t, tc.queries, queries)
+			for _, want := range tc.want {
+				match := want.FindStringSubmatch(output.String())
+				if assert.NotNil(t, match, want.String()) && len(match) > 1 {
+					assert.Equal(t, batchID, match[1])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	gabi "github.com/app-sre/gabi/pkg"
+	"github.com/app-sre/gabi/pkg/audit"
+	"github.com/gorilla/mux"
+)
+
+// AuditCancel audits a request to cancel a running query, along with
+// the query itself when it is still running, whether or not the user
+// is allowed to cancel it.
+func AuditCancel(cfg *gabi.Config) Middleware {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			now := time.Now()
+
+			user, _ := ctx.Value(ContextKeyUser).(string)
+			if user == "" {
+				user = r.Header.Get(forwardedUserHeader)
+			}
+			if user == "" {
+				l := fmt.Sprintf("Request without required header: %s", forwardedUserHeader)
+				http.Error(w, l, http.StatusBadRequest)
+				return
+			}
+
+			query := &audit.QueryData{
+				Cancel:    mux.Vars(r)["id"],
+				User:      user,
+				Timestamp: now.Unix(),
+			}
+			if cfg.Queries != nil {
+				if q, err := cfg.Queries.Get(query.Cancel); err == nil {
+					query.Query = q.Query
+				}
+			}
+			if err := cfg.Audit.Write(ctx, query); err != nil {
+				cfg.Logger.Errorf("Unable to write audit: %s", err)
+				http.Error(w, "An internal error has occurred", http.StatusInternalServerError)
+				return
+			}
+
+			h.ServeHTTP(w, r)
+		})
+	}
+}
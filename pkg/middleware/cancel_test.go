@@ -0,0 +1,119 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/app-sre/gabi/internal/test"
+	gabi "github.com/app-sre/gabi/pkg"
+	"github.com/app-sre/gabi/pkg/audit"
+	"github.com/app-sre/gabi/pkg/env/splunk"
+	"github.com/app-sre/gabi/pkg/running"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditCancel(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		description string
+		user        string
+		id          func(*running.Query) string
+		code        int
+		body        string
+		want        *regexp.Regexp
+		called      bool
+	}{
+		{
+			"valid cancellation of running query audited",
+			"test",
+			func(q *running.Query) string {
+				return q.ID
+			},
+			200,
+			``,
+			regexp.MustCompile(`AUDIT\s{"Query": "select pg_sleep\(10\);", "User": "test", "Timestamp": \d{10}, "Cancel": "[0-9a-f]{32}"}`),
+			true,
+		},
+		{
+			"valid cancellation of unknown query audited",
+			"test",
+			func(q *running.Query) string {
+				return "test"
+			},
+			200,
+			``,
+			regexp.MustCompile(`AUDIT\s{"Query": "", "User": "test", "Timestamp": \d{10}, "Cancel": "test"}`),
+			true,
+		},
+		{
+			"invalid cancellation without user",
+			"",
+			func(q *running.Query) string {
+				return q.ID
+			},
+			400,
+			`Request without required header: X-Forwarded-User`,
+			regexp.MustCompile(`^$`),
+			false,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			var (
+				body, output bytes.Buffer
+				called       bool
+			)
+
+			queries := running.NewRegistry()
+
+			_, q, err := queries.Start(context.TODO(), "test", "test", "select pg_sleep(10);")
+			require.NoError(t, err)
+			defer q.Finish()
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodDelete, "/", nil)
+			r.Header.Set("X-Forwarded-User", tc.user)
+			r = mux.SetURLVars(r, map[string]string{"id": tc.id(q)})
+
+			s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprintln(w, `{"Code":0,"Text":""}`)
+			}))
+			defer s.Close()
+
+			logger := test.DummyLogger(&output).Sugar()
+
+			la := &audit.ConsoleAudit{Logger: logger}
+			sa := &audit.SplunkAudit{SplunkEnv: &splunk.Env{Endpoint: s.URL}}
+			sa.SetHTTPClient(http.DefaultClient)
+
+			pipeline := audit.NewPipeline([]audit.Sink{{Name: "console", Audit: la}, {Name: "splunk", Audit: sa, Required: true}}, 0, logger)
+			expected := &gabi.Config{Audit: pipeline, Queries: queries, Logger: logger}
+			AuditCancel(expected)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				called = true
+			})).ServeHTTP(w, r)
+
+			actual := w.Result()
+			defer func() { _ = actual.Body.Close() }()
+
+			_, _ = io.Copy(&body, actual.Body)
+
+			assert.Equal(t, tc.code, actual.StatusCode)
+			assert.Contains(t, body.String(), tc.body)
+			assert.Regexp(t, tc.want, output.String())
+			assert.Equal(t, tc.called, called)
+		})
+	}
+}
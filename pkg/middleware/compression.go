@@ -0,0 +1,192 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	encodingGzip = "gzip"
+	encodingZstd = "zstd"
+)
+
+const (
+	acceptEncodingHeader  = "Accept-Encoding"
+	contentEncodingHeader = "Content-Encoding"
+	varyHeader            = "Vary"
+)
+
+// compressor is what both gzip and zstd writers have in common.
+type compressor interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+var compressors = map[string]*sync.Pool{
+	encodingGzip: {
+		New: func() any {
+			return gzip.NewWriter(nil)
+		},
+	},
+	encodingZstd: {
+		New: func() any {
+			w, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+			return w
+		},
+	},
+}
+
+// Compression compresses responses using either gzip or zstd, as
+// negotiated using the Accept-Encoding header, once they are at least
+// minSize bytes long. As the size of a streamed response is not known
+// upfront, its start is held back until either enough has been written
+// or the response is complete, even when the handler flushes it.
+func Compression(minSize int64) Middleware {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add(varyHeader, acceptEncodingHeader)
+
+			encoding := acceptEncoding(r)
+			if encoding == "" {
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			cw := &compressWriter{ResponseWriter: w, encoding: encoding, minSize: minSize}
+			defer cw.close()
+
+			h.ServeHTTP(cw, r)
+		})
+	}
+}
+
+// acceptEncoding picks the supported encoding the client prefers, where
+// zstd is preferred over gzip when the client accepts both equally.
+func acceptEncoding(r *http.Request) string {
+	var (
+		encoding string
+		quality  float64
+	)
+
+	for _, accept := range r.Header.Values(acceptEncodingHeader) {
+		for _, entry := range strings.Split(accept, ",") {
+			name, params, _ := strings.Cut(entry, ";")
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name != encodingGzip && name != encodingZstd {
+				continue
+			}
+			q := 1.0
+			if s, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+				if f, err := strconv.ParseFloat(s, 64); err == nil {
+					q = f
+				}
+			}
+			if q > quality || (q == quality && q > 0 && name == encodingZstd) {
+				encoding, quality = name, q
+			}
+		}
+	}
+
+	return encoding
+}
+
+// compressWriter holds back the response until it is known whether it
+// is large enough to be worth compressing.
+type compressWriter struct {
+	http.ResponseWriter
+
+	encoding string
+	minSize  int64
+	code     int
+	buf      bytes.Buffer
+	writer   io.Writer
+	cw       compressor
+}
+
+func (cw *compressWriter) WriteHeader(code int) {
+	if cw.code == 0 {
+		cw.code = code
+	}
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	cw.WriteHeader(http.StatusOK)
+
+	if cw.writer != nil {
+		return cw.writer.Write(b)
+	}
+
+	n, _ := cw.buf.Write(b)
+	if int64(cw.buf.Len()) >= cw.minSize {
+		if err := cw.commit(); err != nil {
+			return 0, err
+		}
+	}
+	return n, nil
+}
+
+// Flush sends what has been compressed so far, provided the response
+// has been committed.
+func (cw *compressWriter) Flush() {
+	if cw.writer == nil {
+		return
+	}
+	if cw.cw != nil {
+		if err := cw.cw.Flush(); err != nil {
+			return
+		}
+	}
+	_ = http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// commit sends the status code and what has been held back, which is
+// compressed if the response is large enough and has a body that has
+// not been encoded already.
+func (cw *compressWriter) commit() error {
+	header := cw.Header()
+
+	compress := cw.buf.Len() > 0 && int64(cw.buf.Len()) >= cw.minSize &&
+		header.Get(contentEncodingHeader) == "" &&
+		cw.code != http.StatusNoContent && cw.code != http.StatusNotModified
+
+	cw.writer = cw.ResponseWriter
+	if compress {
+		c := compressors[cw.encoding].Get().(compressor)
+		c.Reset(cw.ResponseWriter)
+
+		header.Set(contentEncodingHeader, cw.encoding)
+		header.Del(contentLengthHeader)
+		cw.cw, cw.writer = c, c
+	}
+
+	if cw.code != 0 {
+		cw.ResponseWriter.WriteHeader(cw.code)
+	}
+
+	_, err := cw.buf.WriteTo(cw.writer)
+	return err
+}
+
+// close completes the response once the handler has returned.
+func (cw *compressWriter) close() {
+	if cw.writer == nil {
+		_ = cw.commit()
+	}
+	if cw.cw != nil {
+		_ = cw.cw.Close()
+		cw.cw.Reset(nil)
+		compressors[cw.encoding].Put(cw.cw)
+	}
+}
@@ -0,0 +1,235 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	gorillahandlers "github.com/gorilla/handlers"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompression(t *testing.T) {
+	t.Parallel()
+
+	large := strings.Repeat(`["test"],`, 1024)
+
+	cases := []struct {
+		description string
+		accept      []string
+		handler     func(w http.ResponseWriter, r *http.Request)
+		code        int
+		encoding    string
+		body        string
+	}{
+		{
+			"response compressed using gzip",
+			[]string{"gzip"},
+			func(w http.ResponseWriter, r *http.Request) {
+				_, _ = io.WriteString(w, large)
+			},
+			200,
+			"gzip",
+			large,
+		},
+		{
+			"response compressed using zstd",
+			[]string{"zstd"},
+			func(w http.ResponseWriter, r *http.Request) {
+				_, _ = io.WriteString(w, large)
+			},
+			200,
+			"zstd",
+			large,
+		},
+		{
+			"response compressed using zstd when both are accepted",
+			[]string{"gzip, deflate, br, zstd"},
+			func(w http.ResponseWriter, r *http.Request) {
+				_, _ = io.WriteString(w, large)
+			},
+			200,
+			"zstd",
+			large,
+		},
+		{
+			"response compressed using encoding with higher quality value",
+			[]string{"zstd;q=0.5", "gzip;q=0.8"},
+			func(w http.ResponseWriter, r *http.Request) {
+				_, _ = io.WriteString(w, large)
+			},
+			200,
+			"gzip",
+			large,
+		},
+		{
+			"error response compressed",
+			[]string{"gzip"},
+			func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, large, http.StatusBadRequest)
+			},
+			400,
+			"gzip",
+			large,
+		},
+		{
+			"streamed response compressed",
+			[]string{"gzip"},
+			func(w http.ResponseWriter, r *http.Request) {
+				for i := 0; i < 1024; i++ {
+					_, _ = io.WriteString(w, `["test"],`)
+					_ = http.NewResponseController(w).Flush()
+				}
+			},
+			200,
+			"gzip",
+			large,
+		},
+		{
+			"small response not compressed",
+			[]string{"gzip"},
+			func(w http.ResponseWriter, r *http.Request) {
+				_, _ = io.WriteString(w, "test")
+				_ = http.NewResponseController(w).Flush()
+			},
+			200,
+			"",
+			"test",
+		},
+		{
+			"empty response not compressed",
+			[]string{"gzip"},
+			func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			},
+			204,
+			"",
+			"",
+		},
+		{
+			"response not compressed without supported encoding",
+			[]string{"br, deflate"},
+			func(w http.ResponseWriter, r *http.Request) {
+				_, _ = io.WriteString(w, large)
+			},
+			200,
+			"",
+			large,
+		},
+		{
+			"response not compressed with encoding that is not acceptable",
+			[]string{"gzip;q=0"},
+			func(w http.ResponseWriter, r *http.Request) {
+				_, _ = io.WriteString(w, large)
+			},
+			200,
+			"",
+			large,
+		},
+		{
+			"response not compressed when already encoded",
+			[]string{"gzip"},
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Encoding", "test")
+				_, _ = io.WriteString(w, large)
+			},
+			200,
+			"test",
+			large,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/", nil)
+			for _, accept := range tc.accept {
+				r.Header.Add("Accept-Encoding", accept)
+			}
+
+			Compression(1024)(http.HandlerFunc(tc.handler)).ServeHTTP(w, r)
+
+			actual := w.Result()
+			defer func() { _ = actual.Body.Close() }()
+
+			assert.Equal(t, tc.code, actual.StatusCode)
+			assert.Equal(t, tc.encoding, actual.Header.Get("Content-Encoding"))
+			assert.Equal(t, "Accept-Encoding", actual.Header.Get("Vary"))
+			assert.Equal(t, tc.body, strings.TrimSuffix(decompress(t, tc.encoding, actual.Body), "\n"))
+		})
+	}
+}
+
+func TestCompressionTrailers(t *testing.T) {
+	t.Parallel()
+
+	var output bytes.Buffer
+
+	large := strings.Repeat(`["test"],`, 1024)
+
+	h := Compression(0)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Trailer", "X-Gabi-Rows")
+		_, _ = io.WriteString(w, large)
+		_ = http.NewResponseController(w).Flush()
+		w.Header().Set("X-Gabi-Rows", "1024")
+	}))
+
+	s := httptest.NewServer(gorillahandlers.LoggingHandler(&output, h))
+	defer s.Close()
+
+	r, err := http.NewRequestWithContext(context.TODO(), http.MethodPost, s.URL, nil)
+	require.NoError(t, err)
+	r.Header.Set("Accept-Encoding", "gzip")
+
+	actual, err := http.DefaultTransport.RoundTrip(r)
+	require.NoError(t, err)
+	defer func() { _ = actual.Body.Close() }()
+
+	body := decompress(t, "gzip", actual.Body)
+
+	assert.Equal(t, 200, actual.StatusCode)
+	assert.Equal(t, "gzip", actual.Header.Get("Content-Encoding"))
+	assert.Equal(t, large, body)
+	assert.Equal(t, "1024", actual.Trailer.Get("X-Gabi-Rows"))
+
+	// The size of the compressed response is logged.
+	match := regexp.MustCompile(`" 200 (\d+)\n$`).FindStringSubmatch(output.String())
+	require.Len(t, match, 2)
+	assert.NotEqual(t, fmt.Sprint(len(large)), match[1])
+}
+
+func decompress(t *testing.T, encoding string, body io.Reader) string {
+	t.Helper()
+
+	var (
+		r   io.Reader
+		err error
+	)
+
+	switch encoding {
+	case "gzip":
+		r, err = gzip.NewReader(body)
+	case "zstd":
+		r, err = zstd.NewReader(body)
+	default:
+		r = body
+	}
+	require.NoError(t, err)
+
+	b, err := io.ReadAll(r)
+	require.NoError(t, err)
+
+	return string(b)
+}
@@ -0,0 +1,89 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/app-sre/gabi/internal/random"
+	gabi "github.com/app-sre/gabi/pkg"
+	"github.com/app-sre/gabi/pkg/audit"
+	"github.com/app-sre/gabi/pkg/models"
+)
+
+// AuditExplain audits a request for the plan of a query like the query
+// itself, marking it as such, as the query is executed when its plan
+// is to be analysed.
+func AuditExplain(cfg *gabi.Config) Middleware {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			now := time.Now()
+
+			var request models.ExplainRequest
+
+			user, b, ok := readAuditRequest(cfg, w, r)
+			if !ok {
+				return
+			}
+
+			err := json.Unmarshal(b, &request)
+			if err != nil {
+				cfg.Logger.Debugf("Unable to unmarshal request body: %s", err)
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			if base64DecodeQuery(r) {
+				bytes, err := cfg.Encoder.DecodeString(request.Query)
+				if err != nil {
+					l := "Unable to decode Base64-encoded query"
+					cfg.Logger.Errorf("%s: %s", l, err)
+					http.Error(w, l, http.StatusBadRequest)
+					return
+				}
+				request.Query = string(bytes)
+			}
+
+			id, err := random.ID()
+			if err != nil {
+				cfg.Logger.Errorf("Unable to audit request: %s", err)
+				http.Error(w, "An internal error has occurred", http.StatusInternalServerError)
+				return
+			}
+
+			query := &audit.QueryData{
+				Query:     request.Query,
+				Args:      auditArgs(request.Args),
+				Explain:   true,
+				Analyze:   request.Analyze,
+				User:      user,
+				Timestamp: now.Unix(),
+				RequestID: id,
+			}
+			if err := cfg.Audit.Write(ctx, query); err != nil {
+				cfg.Logger.Errorf("Unable to write audit: %s", err)
+				http.Error(w, "An internal error has occurred", http.StatusInternalServerError)
+				return
+			}
+
+			result := &audit.ResultData{}
+
+			ctx = context.WithValue(ctx, ContextKeyQuery, request.Query)
+			ctx = context.WithValue(ctx, ContextKeyResult, result)
+
+			deferred := newDeferredAudit(func(results []*audit.ResultData) {
+				executed := *query
+				executed.Timestamp = time.Now().Unix()
+				executed.Result = results[0]
+				writeResultAudit(ctx, cfg, &executed)
+			}, result)
+			ctx = context.WithValue(ctx, contextKeyDeferredAudit, deferred)
+
+			h.ServeHTTP(w, r.WithContext(ctx))
+
+			deferred.finish()
+		})
+	}
+}
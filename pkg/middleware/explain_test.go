@@ -0,0 +1,144 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/app-sre/gabi/internal/test"
+	gabi "github.com/app-sre/gabi/pkg"
+	"github.com/app-sre/gabi/pkg/audit"
+	"github.com/app-sre/gabi/pkg/env/splunk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditExplain(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		description string
+		parameters  func(*http.Request)
+		request     func() *bytes.Buffer
+		code        int
+		body        string
+		want        *regexp.Regexp
+		query       string
+	}{
+		{
+			"valid query plan audited",
+			func(r *http.Request) {
+				// No-op.
+			},
+			func() *bytes.Buffer {
+				return bytes.NewBufferString(`{"query": "select 1;"}`)
+			},
+			200,
+			``,
+			regexp.MustCompile(`AUDIT\s{"Query": "select 1;", "User": "test", "Timestamp": \d{10}, "RequestID": "[0-9a-f]{32}", "Explain": true, "Analyze": false}`),
+			`select 1;`,
+		},
+		{
+			"valid analysed query plan audited once executed",
+			func(r *http.Request) {
+				// No-op.
+			},
+			func() *bytes.Buffer {
+				return bytes.NewBufferString(`{"query": "delete from test;", "analyze": true}`)
+			},
+			200,
+			``,
+			regexp.MustCompile(`AUDIT\s{"Query": "delete from test;", "User": "test", "Timestamp": \d{10}, "RequestID": "[0-9a-f]{32}", "Explain": true, "Analyze": true, "Rows": 0, "Truncated": false, "RowLimit": 0, "ByteLimit": 0}`),
+			`delete from test;`,
+		},
+		{
+			"valid Base64-encoded query plan",
+			func(r *http.Request) {
+				q := r.URL.Query()
+				q.Add("base64_query", "true")
+				r.URL.RawQuery = q.Encode()
+			},
+			func() *bytes.Buffer {
+				return bytes.NewBufferString(`{"query": "c2VsZWN0IDE7"}`)
+			},
+			200,
+			``,
+			regexp.MustCompile(`AUDIT\s{"Query": "select 1;", "User": "test", "Timestamp": \d{10}, "RequestID": "[0-9a-f]{32}", "Explain": true, "Analyze": false}`),
+			`select 1;`,
+		},
+		{
+			"invalid query plan with malformed Base64-encoded value in the body",
+			func(r *http.Request) {
+				q := r.URL.Query()
+				q.Add("base64_query", "true")
+				r.URL.RawQuery = q.Encode()
+			},
+			func() *bytes.Buffer {
+				return bytes.NewBufferString(`{"query": "dGhpcyBpcyBhIHRlc3Q=="}`)
+			},
+			400,
+			`Unable to decode Base64-encoded query`,
+			regexp.MustCompile(`Unable to decode Base64-encoded query`),
+			``,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			var (
+				body, output bytes.Buffer
+				query        string
+			)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/", tc.request())
+			r.Header.Set("Content-Length", fmt.Sprint(tc.request().Len()))
+			r.Header.Set("X-Forwarded-User", "test")
+			tc.parameters(r)
+
+			s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprintln(w, `{"Code":0,"Text":""}`)
+			}))
+			defer s.Close()
+
+			logger := test.DummyLogger(&output).Sugar()
+			encoder := base64.StdEncoding
+
+			la := &audit.ConsoleAudit{Logger: logger}
+			sa := &audit.SplunkAudit{SplunkEnv: &splunk.Env{Endpoint: s.URL}}
+			sa.SetHTTPClient(http.DefaultClient)
+
+			pipeline := audit.NewPipeline([]audit.Sink{{Name: "console", Audit: la}, {Name: "splunk", Audit: sa, Required: true}}, 0, logger)
+			expected := &gabi.Config{Audit: pipeline, Logger: logger, Encoder: encoder}
+			AuditExplain(expected)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				query, _ = r.Context().Value(ContextKeyQuery).(string)
+			})).ServeHTTP(w, r.WithContext(context.TODO()))
+
+			actual := w.Result()
+			defer func() { _ = actual.Body.Close() }()
+
+			_, _ = io.Copy(&body, actual.Body)
+
+			assert.Equal(t, tc.code, actual.StatusCode)
+			assert.Contains(t, body.String(), tc.body)
+			assert.Regexp(t, tc.want, output.String())
+			assert.Equal(t, tc.query, query)
+
+			// Both events are audited for the same request.
+			ids := regexp.MustCompile(`"RequestID": "([0-9a-f]{32})"`).FindAllStringSubmatch(output.String(), -1)
+			if tc.code == 200 {
+				require.Len(t, ids, 2)
+				assert.Equal(t, ids[0][1], ids[1][1])
+			}
+		})
+	}
+}
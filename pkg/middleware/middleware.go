@@ -7,8 +7,14 @@ import (
 type ctxKey string
 
 const (
-	ContextKeyUser  ctxKey = "user"
-	ContextKeyQuery ctxKey = "query"
+	ContextKeyUser    ctxKey = "user"
+	ContextKeyQuery   ctxKey = "query"
+	ContextKeyResult  ctxKey = "result"
+	ContextKeyBatchID ctxKey = "batch_id"
+	ContextKeyQueries ctxKey = "queries"
+	ContextKeyResults ctxKey = "results"
+
+	contextKeyDeferredAudit ctxKey = "deferred_audit"
 )
 
 const (
@@ -0,0 +1,115 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	gabi "github.com/app-sre/gabi/pkg"
+	"github.com/app-sre/gabi/pkg/audit"
+)
+
+// The result of a query is audited in an event of its own, emitted once
+// the query has been executed, which carries the same request ID as the
+// event auditing the request.
+
+// Bounds the time spent auditing the result, as the request context
+// may have already expired by the time a query completes.
+const resultAuditTimeout = 30 * time.Second
+
+// deferredAudit writes the audit of the results once, unless the
+// handler has taken over doing so. The handler fills in the results, so
+// a handler that has been abandoned, as it did not return in time, has
+// its results replaced with the outcome of the request instead.
+type deferredAudit struct {
+	audit   func([]*audit.ResultData)
+	results []*audit.ResultData
+	started time.Time
+
+	mu       sync.Mutex
+	deferred bool
+	written  bool
+}
+
+func newDeferredAudit(write func([]*audit.ResultData), results ...*audit.ResultData) *deferredAudit {
+	return &deferredAudit{audit: write, results: results, started: time.Now()}
+}
+
+// DeferResultAudit lets a handler that carries on executing the query
+// once it has returned, such as when running it as a job, audit the
+// result itself. The returned function writes the audit, and has to be
+// called once the query has been executed.
+func DeferResultAudit(ctx context.Context) func() {
+	deferred, ok := ctx.Value(contextKeyDeferredAudit).(*deferredAudit)
+	if !ok {
+		return func() {}
+	}
+	deferred.mu.Lock()
+	deferred.deferred = true
+	deferred.mu.Unlock()
+	return deferred.write
+}
+
+// abandonResultAudit audits the results of a request whose handler has
+// been abandoned with the given status code. Whatever the handler goes
+// on to fill in is left out.
+func abandonResultAudit(ctx context.Context, code int) {
+	deferred, ok := ctx.Value(contextKeyDeferredAudit).(*deferredAudit)
+	if !ok {
+		return
+	}
+
+	status := audit.StatusTimeout
+	if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		status = audit.StatusCancelled
+	}
+
+	results := make([]*audit.ResultData, len(deferred.results))
+	for i := range results {
+		results[i] = &audit.ResultData{
+			Status:   status,
+			Code:     code,
+			Duration: time.Since(deferred.started),
+		}
+	}
+	deferred.writeResults(results)
+}
+
+// finish writes the audit once the handler has returned, unless it has
+// taken over doing so.
+func (d *deferredAudit) finish() {
+	d.mu.Lock()
+	deferred := d.deferred
+	d.mu.Unlock()
+
+	if !deferred {
+		d.write()
+	}
+}
+
+func (d *deferredAudit) write() {
+	d.writeResults(d.results)
+}
+
+func (d *deferredAudit) writeResults(results []*audit.ResultData) {
+	d.mu.Lock()
+	written := d.written
+	d.written = true
+	d.mu.Unlock()
+
+	if !written {
+		d.audit(results)
+	}
+}
+
+// The response has already been sent, so failing to audit the result
+// can only be logged.
+func writeResultAudit(ctx context.Context, cfg *gabi.Config, query *audit.QueryData) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), resultAuditTimeout)
+	defer cancel()
+
+	if err := cfg.Audit.Write(ctx, query); err != nil {
+		cfg.Logger.Errorf("Unable to write result audit: %s", err)
+	}
+}
@@ -1,12 +1,178 @@
 package middleware
 
 import (
+	"context"
+	"errors"
 	"net/http"
+	"sync"
 	"time"
 )
 
+// Handlers are given this long to return once the deadline has passed,
+// after which their response is abandoned.
+const timeoutGrace = 5 * time.Second
+
+// Timeout bounds the time a request can take using a context deadline.
+// Unlike http.TimeoutHandler, it does not buffer the response, which
+// allows handlers to stream large responses to the client. Handlers
+// that ignore the deadline are abandoned once the grace period passes.
 func Timeout(timeout time.Duration) Middleware {
+	return timeoutWithGrace(timeout, timeoutGrace)
+}
+
+func timeoutWithGrace(timeout, grace time.Duration) Middleware {
 	return func(h http.Handler) http.Handler {
-		return http.TimeoutHandler(h, timeout, "Request timed out")
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+
+			tw := &timeoutWriter{ResponseWriter: w, ctx: ctx}
+
+			// Panics are raised again once the handler has returned,
+			// so that they are recovered from as usual.
+			done := make(chan any, 1)
+			go func() {
+				defer func() { done <- recover() }()
+				h.ServeHTTP(tw, r.WithContext(ctx))
+			}()
+
+			var p any
+			select {
+			case p = <-done:
+			case <-ctx.Done():
+				timer := time.NewTimer(grace)
+				defer timer.Stop()
+
+				select {
+				case p = <-done:
+				case <-timer.C:
+					// The result is audited before the connection is
+					// closed, as the handler cannot be relied upon to.
+					code, committed := tw.abandon()
+					abandonResultAudit(ctx, code)
+					if committed {
+						panic(http.ErrAbortHandler)
+					}
+					return
+				}
+			}
+			if p != nil {
+				panic(p)
+			}
+
+			if !tw.wroteHeader && ctx.Err() != nil {
+				tw.timeout()
+			}
+		})
+	}
+}
+
+// timeoutWriter replaces the response with a timeout error when the
+// deadline passes before the handler has started writing its response.
+// Once the response has been committed, handlers are expected to signal
+// errors in-band.
+type timeoutWriter struct {
+	http.ResponseWriter
+
+	ctx         context.Context
+	mu          sync.Mutex
+	code        int
+	wroteHeader bool
+	timedOut    bool
+}
+
+func (tw *timeoutWriter) Header() http.Header {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	// Headers set once the response has been abandoned are discarded.
+	if tw.timedOut {
+		return make(http.Header)
+	}
+	return tw.ResponseWriter.Header()
+}
+
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	tw.writeHeader(code)
+}
+
+func (tw *timeoutWriter) Write(b []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	if !tw.wroteHeader {
+		tw.writeHeader(http.StatusOK)
+	}
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	return tw.ResponseWriter.Write(b)
+}
+
+func (tw *timeoutWriter) Flush() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	if tw.timedOut {
+		return
+	}
+	_ = http.NewResponseController(tw.ResponseWriter).Flush()
+}
+
+func (tw *timeoutWriter) Unwrap() http.ResponseWriter {
+	return tw.ResponseWriter
+}
+
+func (tw *timeoutWriter) writeHeader(code int) {
+	if tw.wroteHeader || tw.timedOut {
+		return
+	}
+	if tw.ctx.Err() != nil {
+		tw.timeout()
+		return
+	}
+	tw.wroteHeader = true
+	tw.code = code
+	tw.ResponseWriter.WriteHeader(code)
+}
+
+func (tw *timeoutWriter) timeout() {
+	tw.timedOut = true
+
+	if errors.Is(tw.ctx.Err(), context.DeadlineExceeded) {
+		tw.code = http.StatusGatewayTimeout
+		http.Error(tw.ResponseWriter, "Request timed out", tw.code)
+		return
+	}
+	tw.code = http.StatusServiceUnavailable
+	tw.ResponseWriter.WriteHeader(tw.code)
+}
+
+// abandon gives up on a handler that has not returned in time, which
+// can no longer write to the response. A response that has not been
+// started reports the timeout, otherwise the response is reported as
+// committed, so that the connection is closed and the client cannot
+// mistake what was sent for the whole response. It returns the status
+// code of the response.
+func (tw *timeoutWriter) abandon() (int, bool) {
+	if !tw.mu.TryLock() {
+		// The handler is blocked writing the response, which only a
+		// write deadline interrupts.
+		_ = http.NewResponseController(tw.ResponseWriter).SetWriteDeadline(time.Now())
+		tw.mu.Lock()
+	}
+	defer tw.mu.Unlock()
+
+	committed := tw.wroteHeader
+	if !tw.timedOut {
+		if committed {
+			tw.timedOut = true
+		} else {
+			tw.timeout()
+		}
 	}
+	return tw.code, committed
 }
@@ -3,13 +3,18 @@ package middleware
 import (
 	"bytes"
 	"context"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 	"time"
 
+	"github.com/app-sre/gabi/internal/test"
+	gabi "github.com/app-sre/gabi/pkg"
+	"github.com/app-sre/gabi/pkg/audit"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestTimeout(t *testing.T) {
@@ -56,9 +61,36 @@ func TestTimeout(t *testing.T) {
 			func() context.Context {
 				return context.Background()
 			},
-			503,
+			504,
+			`Request timed out`,
+		},
+		{
+			"timeout with HTTP response written after exceeding limit",
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				<-r.Context().Done()
+				http.Error(w, "test", http.StatusBadRequest)
+			}),
+			time.Duration(1 * time.Millisecond),
+			func() context.Context {
+				return context.Background()
+			},
+			504,
 			`Request timed out`,
 		},
+		{
+			"no timeout with HTTP response committed before exceeding limit",
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, _ = io.WriteString(w, "test")
+				<-r.Context().Done()
+				_, _ = io.WriteString(w, "test")
+			}),
+			time.Duration(1 * time.Millisecond),
+			func() context.Context {
+				return context.Background()
+			},
+			200,
+			`testtest`,
+		},
 		{
 			"no timeout with HTTP request error due to cancelled context",
 			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -97,3 +129,172 @@ func TestTimeout(t *testing.T) {
 		})
 	}
 }
+
+func TestTimeoutAbandoned(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		description string
+		given       func(http.ResponseWriter)
+		panics      bool
+		code        int
+		body        string
+	}{
+		{
+			"timeout with HTTP handler not returning before writing the response",
+			func(w http.ResponseWriter) {
+				// No-op.
+			},
+			false,
+			504,
+			`Request timed out`,
+		},
+		{
+			"connection closed with HTTP handler not returning after committing the response",
+			func(w http.ResponseWriter) {
+				_, _ = io.WriteString(w, "test")
+			},
+			true,
+			200,
+			`test`,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			release := make(chan struct{})
+			written := make(chan error, 1)
+
+			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				tc.given(w)
+				<-release
+				_, err := io.WriteString(w, "late")
+				written <- err
+			})
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", &bytes.Buffer{})
+
+			serve := func() {
+				timeoutWithGrace(time.Millisecond, time.Millisecond)(handler).ServeHTTP(w, r)
+			}
+			if tc.panics {
+				assert.PanicsWithValue(t, http.ErrAbortHandler, serve)
+			} else {
+				assert.NotPanics(t, serve)
+			}
+
+			// The handler can no longer write to the response.
+			close(release)
+			assert.ErrorIs(t, <-written, http.ErrHandlerTimeout)
+
+			assert.Equal(t, tc.code, w.Code)
+			assert.Contains(t, w.Body.String(), tc.body)
+			assert.NotContains(t, w.Body.String(), "late")
+		})
+	}
+}
+
+func TestTimeoutAbandonedAudit(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		description string
+		given       func(http.ResponseWriter)
+		panics      bool
+		want        string
+	}{
+		{
+			"timeout audited with HTTP handler not returning before writing the response",
+			func(w http.ResponseWriter) {
+				// No-op.
+			},
+			false,
+			`"Status": "timeout", "Code": 504`,
+		},
+		{
+			"timeout audited with HTTP handler not returning after committing the response",
+			func(w http.ResponseWriter) {
+				_, _ = io.WriteString(w, "test")
+			},
+			true,
+			`"Status": "timeout", "Code": 200`,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			var output bytes.Buffer
+
+			release := make(chan struct{})
+			returned := make(chan struct{})
+
+			// The handler keeps filling in the result past the grace
+			// period, which is left out of the audit.
+			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				defer close(returned)
+
+				result, _ := r.Context().Value(ContextKeyResult).(*audit.ResultData)
+				tc.given(w)
+				for {
+					select {
+					case <-release:
+						result.Status = audit.StatusSuccess
+						return
+					default:
+						result.Rows++
+						time.Sleep(time.Millisecond)
+					}
+				}
+			})
+
+			b := bytes.NewBufferString(`{"query": "select 1;"}`)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/", b)
+			r.Header.Set("Content-Length", fmt.Sprint(b.Len()))
+			r.Header.Set("X-Forwarded-User", "test")
+
+			logger := test.DummyLogger(&output).Sugar()
+			pipeline := audit.NewPipeline([]audit.Sink{{Name: "console", Audit: &audit.ConsoleAudit{Logger: logger}}}, 0, logger)
+			cfg := &gabi.Config{Audit: pipeline, Logger: logger}
+
+			serve := func() {
+				Audit(cfg)(timeoutWithGrace(time.Millisecond, 10*time.Millisecond)(handler)).ServeHTTP(w, r)
+			}
+			if tc.panics {
+				assert.PanicsWithValue(t, http.ErrAbortHandler, serve)
+			} else {
+				assert.NotPanics(t, serve)
+			}
+
+			close(release)
+			<-returned
+
+			require.Contains(t, output.String(), tc.want)
+			assert.Contains(t, output.String(), `"Rows": 0`)
+			assert.NotContains(t, output.String(), `"Status": "success"`)
+		})
+	}
+}
+
+func TestTimeoutPanic(t *testing.T) {
+	t.Parallel()
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("test")
+	})
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", &bytes.Buffer{})
+
+	assert.PanicsWithValue(t, "test", func() {
+		Timeout(time.Second)(handler).ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,225 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	gabi "github.com/app-sre/gabi/pkg"
+	"github.com/app-sre/gabi/pkg/env/query"
+	"github.com/app-sre/gabi/pkg/models"
+)
+
+const contentTypeHeader = "Content-Type"
+
+// Codes identifying why a request has been rejected.
+const (
+	errorCodeBodyTooLarge           = "body_too_large"
+	errorCodeEmptyBody              = "empty_body"
+	errorCodeUnsupportedContentType = "unsupported_content_type"
+	errorCodeInvalidJSON            = "invalid_json"
+	errorCodeInvalidField           = "invalid_field"
+	errorCodeUnknownField           = "unknown_field"
+	errorCodeEmptyQuery             = "empty_query"
+	errorCodeQueryTooLong           = "query_too_long"
+)
+
+// requestError is a request that has been rejected, along with the
+// status code it is rejected with.
+type requestError struct {
+	models.RequestError
+	status int
+}
+
+// Validation rejects query requests that are not well-formed before
+// anything else reads them. The body has to be JSON within the size
+// limit, made only of known attributes, with a query within the length
+// limit, unless the request fetches the next page of a result.
+func Validation(cfg *gabi.Config) Middleware {
+	return validation(cfg, validateQueryRequest)
+}
+
+// ValidationBatch rejects batch requests that are not well-formed, the
+// same way as query requests, where every statement has to be within
+// the length limit.
+func ValidationBatch(cfg *gabi.Config) Middleware {
+	return validation(cfg, validateBatchRequest)
+}
+
+// ValidationExplain rejects plan requests that are not well-formed, the
+// same way as query requests.
+func ValidationExplain(cfg *gabi.Config) Middleware {
+	return validation(cfg, validateExplainRequest)
+}
+
+func validation(cfg *gabi.Config, validate func([]byte, int64) *requestError) Middleware {
+	maxBytes, maxLength := int64(query.DefaultMaxRequestBytes), int64(query.DefaultMaxQueryLength)
+	if cfg.QueryEnv != nil {
+		maxBytes, maxLength = cfg.QueryEnv.MaxRequestBytes, cfg.QueryEnv.MaxQueryLength
+	}
+
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			b, rerr := readRequest(w, r, maxBytes)
+			if rerr == nil {
+				rerr = validate(b, maxLength)
+			}
+			if rerr != nil {
+				cfg.Logger.Debugf("Unable to validate request: %s (%s)", rerr.Error, rerr.Code)
+				writeRequestError(cfg, w, rerr)
+				return
+			}
+
+			r.Body = io.NopCloser(bytes.NewReader(b))
+
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// readRequest reads the body of the request, provided it is not larger
+// than allowed, which is known upfront when the client states its size.
+func readRequest(w http.ResponseWriter, r *http.Request, maxBytes int64) ([]byte, *requestError) {
+	if r.ContentLength > maxBytes {
+		return nil, newRequestError(http.StatusRequestEntityTooLarge, errorCodeBodyTooLarge, "",
+			fmt.Sprintf("Request body cannot be larger than %d bytes", maxBytes))
+	}
+
+	b, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBytes))
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			return nil, newRequestError(http.StatusRequestEntityTooLarge, errorCodeBodyTooLarge, "",
+				fmt.Sprintf("Request body cannot be larger than %d bytes", maxBytes))
+		}
+		return nil, newRequestError(http.StatusBadRequest, errorCodeInvalidJSON, "",
+			fmt.Sprintf("Unable to read request body: %s", err))
+	}
+	_ = r.Body.Close()
+
+	if len(bytes.TrimSpace(b)) == 0 {
+		return nil, newRequestError(http.StatusBadRequest, errorCodeEmptyBody, "", "Request body cannot be empty")
+	}
+
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get(contentTypeHeader))
+	if err != nil || (mediaType != "application/json" && !strings.HasSuffix(mediaType, "+json")) {
+		return nil, newRequestError(http.StatusUnsupportedMediaType, errorCodeUnsupportedContentType, "",
+			"Request body has to be sent as application/json")
+	}
+
+	return b, nil
+}
+
+func validateQueryRequest(b []byte, maxLength int64) *requestError {
+	var request models.QueryRequest
+
+	if rerr := decodeRequest(b, &request); rerr != nil {
+		return rerr
+	}
+	if request.Cursor != "" && strings.TrimSpace(request.Query) == "" {
+		return nil
+	}
+
+	return validateQuery(request.Query, "query", maxLength)
+}
+
+func validateBatchRequest(b []byte, maxLength int64) *requestError {
+	var request models.BatchRequest
+
+	if rerr := decodeRequest(b, &request); rerr != nil {
+		return rerr
+	}
+	if len(request.Statements) == 0 {
+		return newRequestError(http.StatusBadRequest, errorCodeEmptyQuery, "statements",
+			"Batch must contain at least one statement")
+	}
+
+	for i, statement := range request.Statements {
+		if rerr := validateQuery(statement.Query, fmt.Sprintf("statements.%d.query", i), maxLength); rerr != nil {
+			rerr.Error = fmt.Sprintf("Statement %d: %s", i+1, rerr.Error)
+			return rerr
+		}
+	}
+
+	return nil
+}
+
+func validateExplainRequest(b []byte, maxLength int64) *requestError {
+	var request models.ExplainRequest
+
+	if rerr := decodeRequest(b, &request); rerr != nil {
+		return rerr
+	}
+
+	return validateQuery(request.Query, "query", maxLength)
+}
+
+// decodeRequest decodes the body into the request, which has to be a
+// single JSON object made only of known attributes.
+func decodeRequest(b []byte, request any) *requestError {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(request); err != nil {
+		return decodeError(err)
+	}
+	if _, err := dec.Token(); !errors.Is(err, io.EOF) {
+		return newRequestError(http.StatusBadRequest, errorCodeInvalidJSON, "",
+			"Request body has to be a single JSON object")
+	}
+
+	return nil
+}
+
+func validateQuery(query, field string, maxLength int64) *requestError {
+	switch {
+	case strings.TrimSpace(query) == "":
+		return newRequestError(http.StatusBadRequest, errorCodeEmptyQuery, field, "Query cannot be empty")
+	case int64(len(query)) > maxLength:
+		return newRequestError(http.StatusBadRequest, errorCodeQueryTooLong, field,
+			fmt.Sprintf("Query cannot be longer than %d bytes", maxLength))
+	}
+
+	return nil
+}
+
+// decodeError tells apart a body that is not JSON from an attribute
+// that is either unknown, or not of the expected type.
+func decodeError(err error) *requestError {
+	var typeError *json.UnmarshalTypeError
+
+	switch {
+	case errors.As(err, &typeError):
+		return newRequestError(http.StatusBadRequest, errorCodeInvalidField, typeError.Field,
+			fmt.Sprintf("Request attribute has to be of type %s: %s", typeError.Type, typeError.Field))
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		return newRequestError(http.StatusBadRequest, errorCodeUnknownField, field,
+			fmt.Sprintf("Request attribute is not known: %s", field))
+	default:
+		return newRequestError(http.StatusBadRequest, errorCodeInvalidJSON, "",
+			fmt.Sprintf("Unable to decode request body: %s", err))
+	}
+}
+
+func newRequestError(status int, code, field, message string) *requestError {
+	return &requestError{
+		RequestError: models.RequestError{Error: message, Code: code, Field: field},
+		status:       status,
+	}
+}
+
+func writeRequestError(cfg *gabi.Config, w http.ResponseWriter, rerr *requestError) {
+	w.Header().Set(contentTypeHeader, "application/json; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(rerr.status)
+
+	if err := json.NewEncoder(w).Encode(&rerr.RequestError); err != nil {
+		cfg.Logger.Errorf("Unable to send response: %s", err)
+	}
+}
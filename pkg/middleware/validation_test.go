@@ -0,0 +1,348 @@
+package middleware
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/app-sre/gabi/internal/test"
+	gabi "github.com/app-sre/gabi/pkg"
+	"github.com/app-sre/gabi/pkg/env/query"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidation(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		description string
+		contentType string
+		length      int64
+		request     string
+		code        int
+		body        string
+	}{
+		{
+			"valid request",
+			"application/json",
+			-1,
+			`{"query": "select 1;", "args": [1], "row_limit": 10}`,
+			200,
+			`{"query": "select 1;", "args": [1], "row_limit": 10}`,
+		},
+		{
+			"valid request with content type parameters",
+			"application/json; charset=utf-8",
+			-1,
+			`{"query": "select 1;"}`,
+			200,
+			`{"query": "select 1;"}`,
+		},
+		{
+			"valid request for next page without query",
+			"application/json",
+			-1,
+			`{"cursor": "test"}`,
+			200,
+			`{"cursor": "test"}`,
+		},
+		{
+			"invalid request with body too large",
+			"application/json",
+			-1,
+			`{"query": "select '` + strings.Repeat("a", 64) + `';"}`,
+			413,
+			`{"error":"Request body cannot be larger than 64 bytes","code":"body_too_large"}`,
+		},
+		{
+			"invalid request with body too large without content length",
+			"application/json",
+			0,
+			`{"query": "select '` + strings.Repeat("a", 64) + `';"}`,
+			413,
+			`{"error":"Request body cannot be larger than 64 bytes","code":"body_too_large"}`,
+		},
+		{
+			"invalid request with empty body",
+			"application/json",
+			-1,
+			``,
+			400,
+			`{"error":"Request body cannot be empty","code":"empty_body"}`,
+		},
+		{
+			"invalid request without content type",
+			"",
+			-1,
+			`{"query": "select 1;"}`,
+			415,
+			`{"error":"Request body has to be sent as application/json","code":"unsupported_content_type"}`,
+		},
+		{
+			"invalid request with form content type",
+			"application/x-www-form-urlencoded",
+			-1,
+			`{"query": "select 1;"}`,
+			415,
+			`{"error":"Request body has to be sent as application/json","code":"unsupported_content_type"}`,
+		},
+		{
+			"invalid request with malformed body",
+			"application/json",
+			-1,
+			`{"query": "select 1;"`,
+			400,
+			`"code":"invalid_json"`,
+		},
+		{
+			"invalid request with more than one object",
+			"application/json",
+			-1,
+			`{"query": "select 1;"} {}`,
+			400,
+			`{"error":"Request body has to be a single JSON object","code":"invalid_json"}`,
+		},
+		{
+			"invalid request with unknown attribute",
+			"application/json",
+			-1,
+			`{"qeury": "select 1;"}`,
+			400,
+			`{"error":"Request attribute is not known: qeury","code":"unknown_field","field":"qeury"}`,
+		},
+		{
+			"invalid request with attribute of wrong type",
+			"application/json",
+			-1,
+			`{"query": "select 1;", "row_limit": "10"}`,
+			400,
+			`{"error":"Request attribute has to be of type int64: row_limit","code":"invalid_field","field":"row_limit"}`,
+		},
+		{
+			"invalid request with empty query",
+			"application/json",
+			-1,
+			`{"query": " "}`,
+			400,
+			`{"error":"Query cannot be empty","code":"empty_query","field":"query"}`,
+		},
+		{
+			"invalid request with query too long",
+			"application/json",
+			-1,
+			`{"query": "select '` + strings.Repeat("a", 8) + `';"}`,
+			400,
+			`{"error":"Query cannot be longer than 16 bytes","code":"query_too_long","field":"query"}`,
+		},
+	}
+
+	dummyHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(w, r.Body)
+	})
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			var output bytes.Buffer
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tc.request))
+			if tc.contentType != "" {
+				r.Header.Set("Content-Type", tc.contentType)
+			}
+			if tc.length >= 0 {
+				r.ContentLength = tc.length
+			}
+
+			cfg := &gabi.Config{
+				QueryEnv: &query.Env{MaxRequestBytes: 64, MaxQueryLength: 16},
+				Logger:   test.DummyLogger(&output).Sugar(),
+			}
+
+			Validation(cfg)(dummyHandler).ServeHTTP(w, r)
+
+			assert.Equal(t, tc.code, w.Code)
+			assert.Contains(t, w.Body.String(), tc.body)
+		})
+	}
+}
+
+func TestValidationBatch(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		description string
+		contentType string
+		request     string
+		code        int
+		body        string
+	}{
+		{
+			"valid request",
+			"application/json",
+			`{"statements": [{"query": "select 1;"}]}`,
+			200,
+			`{"statements": [{"query": "select 1;"}]}`,
+		},
+		{
+			"invalid request with body too large",
+			"application/json",
+			`{"statements": [{"query": "select '` + strings.Repeat("a", 64) + `';"}]}`,
+			413,
+			`{"error":"Request body cannot be larger than 64 bytes","code":"body_too_large"}`,
+		},
+		{
+			"invalid request without content type",
+			"",
+			`{"statements": [{"query": "select 1;"}]}`,
+			415,
+			`{"error":"Request body has to be sent as application/json","code":"unsupported_content_type"}`,
+		},
+		{
+			"invalid request with unknown attribute",
+			"application/json",
+			`{"statements": [{"qeury": "select 1;"}]}`,
+			400,
+			`"code":"unknown_field"`,
+		},
+		{
+			"invalid request without statements",
+			"application/json",
+			`{"statements": []}`,
+			400,
+			`{"error":"Batch must contain at least one statement","code":"empty_query","field":"statements"}`,
+		},
+		{
+			"invalid request with empty statement",
+			"application/json",
+			`{"statements": [{"query": "select 1;"}, {"query": ""}]}`,
+			400,
+			`{"error":"Statement 2: Query cannot be empty","code":"empty_query","field":"statements.1.query"}`,
+		},
+		{
+			"invalid request with statement too long",
+			"application/json",
+			`{"statements": [{"query": "select '` + strings.Repeat("a", 8) + `';"}]}`,
+			400,
+			`{"error":"Statement 1: Query cannot be longer than 16 bytes","code":"query_too_long","field":"statements.0.query"}`,
+		},
+	}
+
+	dummyHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(w, r.Body)
+	})
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			var output bytes.Buffer
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tc.request))
+			if tc.contentType != "" {
+				r.Header.Set("Content-Type", tc.contentType)
+			}
+
+			cfg := &gabi.Config{
+				QueryEnv: &query.Env{MaxRequestBytes: 64, MaxQueryLength: 16},
+				Logger:   test.DummyLogger(&output).Sugar(),
+			}
+
+			ValidationBatch(cfg)(dummyHandler).ServeHTTP(w, r)
+
+			assert.Equal(t, tc.code, w.Code)
+			assert.Contains(t, w.Body.String(), tc.body)
+		})
+	}
+}
+
+func TestValidationExplain(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		description string
+		contentType string
+		request     string
+		code        int
+		body        string
+	}{
+		{
+			"valid request",
+			"application/json",
+			`{"query": "select 1;", "analyze": true}`,
+			200,
+			`{"query": "select 1;", "analyze": true}`,
+		},
+		{
+			"invalid request with body too large",
+			"application/json",
+			`{"query": "select '` + strings.Repeat("a", 64) + `';"}`,
+			413,
+			`{"error":"Request body cannot be larger than 64 bytes","code":"body_too_large"}`,
+		},
+		{
+			"invalid request with form content type",
+			"application/x-www-form-urlencoded",
+			`{"query": "select 1;"}`,
+			415,
+			`{"error":"Request body has to be sent as application/json","code":"unsupported_content_type"}`,
+		},
+		{
+			"invalid request with unknown attribute",
+			"application/json",
+			`{"query": "select 1;", "cursor": "test"}`,
+			400,
+			`{"error":"Request attribute is not known: cursor","code":"unknown_field","field":"cursor"}`,
+		},
+		{
+			"invalid request with empty query",
+			"application/json",
+			`{"query": ""}`,
+			400,
+			`{"error":"Query cannot be empty","code":"empty_query","field":"query"}`,
+		},
+		{
+			"invalid request with query too long",
+			"application/json",
+			`{"query": "select '` + strings.Repeat("a", 8) + `';"}`,
+			400,
+			`{"error":"Query cannot be longer than 16 bytes","code":"query_too_long","field":"query"}`,
+		},
+	}
+
+	dummyHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(w, r.Body)
+	})
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.description, func(t *testing.T) {
+			t.Parallel()
+
+			var output bytes.Buffer
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tc.request))
+			if tc.contentType != "" {
+				r.Header.Set("Content-Type", tc.contentType)
+			}
+
+			cfg := &gabi.Config{
+				QueryEnv: &query.Env{MaxRequestBytes: 64, MaxQueryLength: 16},
+				Logger:   test.DummyLogger(&output).Sugar(),
+			}
+
+			ValidationExplain(cfg)(dummyHandler).ServeHTTP(w, r)
+
+			assert.Equal(t, tc.code, w.Code)
+			assert.Contains(t, w.Body.String(), tc.body)
+		})
+	}
+}
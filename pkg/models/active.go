@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+type ActiveQuery struct {
+	ID        string    `json:"id"`
+	User      string    `json:"user"`
+	Database  string    `json:"database"`
+	Query     string    `json:"query"`
+	Truncated bool      `json:"truncated,omitempty"`
+	Started   time.Time `json:"started"`
+	Backend   *int64    `json:"backend,omitempty"`
+}
+
+type ActiveQueriesResponse struct {
+	Queries []ActiveQuery `json:"queries"`
+}
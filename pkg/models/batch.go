@@ -0,0 +1,34 @@
+package models
+
+type BatchRequest struct {
+	Statements []BatchStatement `json:"statements"`
+	RowLimit   int64            `json:"row_limit,omitempty"`
+	ByteLimit  int64            `json:"byte_limit,omitempty"`
+	Timeout    string           `json:"timeout,omitempty"`
+}
+
+type BatchStatement struct {
+	Query string     `json:"query"`
+	Args  []QueryArg `json:"args,omitempty"`
+	Exec  bool       `json:"exec,omitempty"`
+}
+
+type BatchResponse struct {
+	BatchID       string         `json:"batch_id,omitempty"`
+	Results       []BatchResult  `json:"results"`
+	Error         string         `json:"error"`
+	DatabaseError *DatabaseError `json:"database_error,omitempty"`
+}
+
+// BatchResult holds either the rows returned by a statement, or the
+// number of rows it affected when it has been executed as such.
+type BatchResult struct {
+	Result         [][]string `json:"result,omitempty"`
+	Truncated      bool       `json:"truncated,omitempty"`
+	Rows           int64      `json:"rows,omitempty"`
+	RowsAffected   *int64     `json:"rows_affected,omitempty"`
+	LastInsertID   *int64     `json:"last_insert_id,omitempty"`
+	EncodedColumns []int      `json:"encoded_columns,omitempty"`
+	EncodedCells   [][2]int64 `json:"encoded_cells,omitempty"`
+	EncodedFrom    [][2]int64 `json:"encoded_from,omitempty"`
+}
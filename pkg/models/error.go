@@ -0,0 +1,23 @@
+package models
+
+// DatabaseError describes an error reported by the database. The code
+// is the SQLSTATE for PostgreSQL, and the error number for MySQL, with
+// the rest of the attributes only set when the database reports them.
+type DatabaseError struct {
+	Code     string `json:"code"`
+	SQLState string `json:"sqlstate,omitempty"`
+	Severity string `json:"severity,omitempty"`
+	Message  string `json:"message"`
+	Detail   string `json:"detail,omitempty"`
+	Hint     string `json:"hint,omitempty"`
+	Position int32  `json:"position,omitempty"`
+}
+
+// RequestError describes why a request has been rejected before being
+// processed, with a code that does not change along with the message,
+// and the attribute of the request at fault, where there is one.
+type RequestError struct {
+	Error string `json:"error"`
+	Code  string `json:"code"`
+	Field string `json:"field,omitempty"`
+}
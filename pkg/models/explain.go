@@ -0,0 +1,18 @@
+package models
+
+import "encoding/json"
+
+type ExplainRequest struct {
+	Query   string     `json:"query"`
+	Args    []QueryArg `json:"args,omitempty"`
+	Analyze bool       `json:"analyze,omitempty"`
+	Timeout string     `json:"timeout,omitempty"`
+}
+
+// ExplainResponse carries the plan as reported by the database, which
+// is a JSON document unless the database only reports it as text.
+type ExplainResponse struct {
+	Plan    json.RawMessage `json:"plan"`
+	Analyze bool            `json:"analyze,omitempty"`
+	Error   string          `json:"error"`
+}
@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+type JobResponse struct {
+	ID       string     `json:"id"`
+	Status   string     `json:"status"`
+	QueryID  string     `json:"query_id,omitempty"`
+	Created  time.Time  `json:"created"`
+	Started  *time.Time `json:"started,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+	Error    string     `json:"error"`
+}
@@ -1,10 +1,81 @@
 package models
 
+import (
+	"bytes"
+	"encoding/json"
+)
+
 type QueryRequest struct {
-	Query string `json:"query"`
+	Query     string     `json:"query"`
+	Args      []QueryArg `json:"args,omitempty"`
+	Exec      bool       `json:"exec,omitempty"`
+	DryRun    bool       `json:"dry_run,omitempty"`
+	Timeout   string     `json:"timeout,omitempty"`
+	RowLimit  int64      `json:"row_limit,omitempty"`
+	ByteLimit int64      `json:"byte_limit,omitempty"`
+	PageSize  int64      `json:"page_size,omitempty"`
+	Cursor    string     `json:"cursor,omitempty"`
+}
+
+// QueryArg is a value bound to a placeholder in the query. It is given
+// either as a plain JSON value, or as an object carrying the value and
+// a hint of the type it should be bound as.
+type QueryArg struct {
+	Value json.RawMessage `json:"value"`
+	Type  string          `json:"type,omitempty"`
+}
+
+func (a *QueryArg) UnmarshalJSON(b []byte) error {
+	if b = bytes.TrimSpace(b); len(b) > 0 && b[0] == '{' {
+		type arg QueryArg
+		return json.Unmarshal(b, (*arg)(a))
+	}
+	a.Value = append(json.RawMessage(nil), b...)
+	a.Type = ""
+	return nil
 }
 
 type QueryResponse struct {
-	Result [][]string `json:"result"`
-	Error  string     `json:"error"`
+	Result         [][]string     `json:"result"`
+	Error          string         `json:"error"`
+	DatabaseError  *DatabaseError `json:"database_error,omitempty"`
+	Truncated      bool           `json:"truncated,omitempty"`
+	Rows           int64          `json:"rows,omitempty"`
+	Cursor         string         `json:"cursor,omitempty"`
+	DryRun         bool           `json:"dry_run,omitempty"`
+	EncodedColumns []int          `json:"encoded_columns,omitempty"`
+	EncodedCells   [][2]int64     `json:"encoded_cells,omitempty"`
+	EncodedFrom    [][2]int64     `json:"encoded_from,omitempty"`
+}
+
+// ExecResponse describes the outcome of a statement that has been run
+// without returning any rows. Not every driver reports the ID of the
+// last inserted row.
+type ExecResponse struct {
+	RowsAffected int64  `json:"rows_affected"`
+	LastInsertID *int64 `json:"last_insert_id,omitempty"`
+	DryRun       bool   `json:"dry_run,omitempty"`
+	Error        string `json:"error"`
+}
+
+type Column struct {
+	Name      string `json:"name"`
+	Type      string `json:"type"`
+	Nullable  *bool  `json:"nullable,omitempty"`
+	Precision *int64 `json:"precision,omitempty"`
+	Scale     *int64 `json:"scale,omitempty"`
+	Encoding  string `json:"encoding,omitempty"`
+}
+
+type TypedQueryResponse struct {
+	Columns        []Column   `json:"columns"`
+	Result         [][]any    `json:"result"`
+	Error          string     `json:"error"`
+	Truncated      bool       `json:"truncated,omitempty"`
+	Rows           int64      `json:"rows,omitempty"`
+	Cursor         string     `json:"cursor,omitempty"`
+	DryRun         bool       `json:"dry_run,omitempty"`
+	EncodedColumns []int      `json:"encoded_columns,omitempty"`
+	EncodedCells   [][2]int64 `json:"encoded_cells,omitempty"`
+	EncodedFrom    [][2]int64 `json:"encoded_from,omitempty"`
 }
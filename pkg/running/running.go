@@ -0,0 +1,146 @@
+package running
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/app-sre/gabi/internal/random"
+)
+
+var ErrNotFound = errors.New("query not found")
+
+type ctxKey struct{}
+
+// Query is a query being run on behalf of a user, which can be
+// cancelled until it has finished.
+type Query struct {
+	ID       string
+	User     string
+	Database string
+	Query    string
+	Start    time.Time
+
+	registry *Registry
+	cancel   context.CancelFunc
+	backend  int64
+	mu       sync.Mutex
+}
+
+type Registry struct {
+	queries map[string]*Query
+	mu      sync.Mutex
+}
+
+// NewRegistry returns a registry for the queries that are running.
+func NewRegistry() *Registry {
+	return &Registry{
+		queries: make(map[string]*Query),
+	}
+}
+
+// Start registers the query as running until finished, and returns a
+// context that is cancelled should the query be cancelled, from which
+// the query can also be retrieved.
+func (r *Registry) Start(ctx context.Context, user, database, query string) (context.Context, *Query, error) {
+	id, err := random.ID()
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to generate query ID: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	q := &Query{
+		ID:       id,
+		User:     user,
+		Database: database,
+		Query:    query,
+		Start:    time.Now(),
+		registry: r,
+		cancel:   cancel,
+	}
+
+	r.mu.Lock()
+	r.queries[id] = q
+	r.mu.Unlock()
+
+	return context.WithValue(ctx, ctxKey{}, q), q, nil
+}
+
+// Get returns the running query with the given ID.
+func (r *Registry) Get(id string) (*Query, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	q, ok := r.queries[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return q, nil
+}
+
+// List returns the queries that are running, oldest first.
+func (r *Registry) List() []*Query {
+	r.mu.Lock()
+	queries := make([]*Query, 0, len(r.queries))
+	for _, q := range r.queries {
+		queries = append(queries, q)
+	}
+	r.mu.Unlock()
+
+	sort.Slice(queries, func(i, j int) bool {
+		return queries[i].Start.Before(queries[j].Start)
+	})
+	return queries
+}
+
+// FromContext returns the running query the context belongs to, if any.
+func FromContext(ctx context.Context) *Query {
+	q, _ := ctx.Value(ctxKey{}).(*Query)
+	return q
+}
+
+// SetBackend records the ID the database uses for the connection the
+// query runs on, such as the process ID of a PostgreSQL backend.
+func (q *Query) SetBackend(id int64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.backend = id
+}
+
+// Backend returns the ID of the database connection the query runs on,
+// or zero when unknown.
+func (q *Query) Backend() int64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.backend
+}
+
+// Stop asks the database to stop the query using kill, provided that
+// the connection it runs on is known, and then cancels its context. The
+// connection cannot be forgotten meanwhile, so that it is not returned
+// to the pool, and running another query, by the time it is killed.
+func (q *Query) Stop(kill func(backend int64) error) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var err error
+	if q.backend != 0 {
+		err = kill(q.backend)
+	}
+	q.cancel()
+
+	return err
+}
+
+// Finish removes the query from the registry once it has finished.
+func (q *Query) Finish() {
+	q.registry.mu.Lock()
+	delete(q.registry.queries, q.ID)
+	q.registry.mu.Unlock()
+
+	q.cancel()
+}
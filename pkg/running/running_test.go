@@ -0,0 +1,115 @@
+package running
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewRegistry(t *testing.T) {
+	t.Parallel()
+
+	actual := NewRegistry()
+
+	require.NotNil(t, actual)
+	assert.IsType(t, &Registry{}, actual)
+	assert.Empty(t, actual.queries)
+}
+
+func TestStart(t *testing.T) {
+	t.Parallel()
+
+	r := NewRegistry()
+
+	ctx, q, err := r.Start(context.TODO(), "test", "test", "select 1;")
+	require.NoError(t, err)
+
+	assert.Len(t, q.ID, 32)
+	assert.Equal(t, "test", q.User)
+	assert.Equal(t, "select 1;", q.Query)
+	assert.False(t, q.Start.IsZero())
+	assert.Same(t, q, FromContext(ctx))
+
+	actual, err := r.Get(q.ID)
+	require.NoError(t, err)
+	assert.Same(t, q, actual)
+
+	_, err = r.Get("unknown")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	assert.Nil(t, FromContext(context.TODO()))
+}
+
+func TestBackend(t *testing.T) {
+	t.Parallel()
+
+	_, q, err := NewRegistry().Start(context.TODO(), "test", "test", "select 1;")
+	require.NoError(t, err)
+
+	assert.Equal(t, int64(0), q.Backend())
+
+	q.SetBackend(42)
+	assert.Equal(t, int64(42), q.Backend())
+}
+
+func TestStop(t *testing.T) {
+	t.Parallel()
+
+	r := NewRegistry()
+
+	ctx, q, err := r.Start(context.TODO(), "test", "test", "select 1;")
+	require.NoError(t, err)
+
+	q.SetBackend(42)
+
+	var killed int64
+	err = q.Stop(func(backend int64) error {
+		// Killed before its context has been cancelled.
+		assert.NoError(t, ctx.Err())
+		killed = backend
+		return errors.New("test")
+	})
+	assert.EqualError(t, err, "test")
+	assert.Equal(t, int64(42), killed)
+	assert.ErrorIs(t, ctx.Err(), context.Canceled)
+
+	// Still running until finished.
+	_, err = r.Get(q.ID)
+	assert.NoError(t, err)
+}
+
+func TestFinish(t *testing.T) {
+	t.Parallel()
+
+	r := NewRegistry()
+
+	ctx, q, err := r.Start(context.TODO(), "test", "test", "select 1;")
+	require.NoError(t, err)
+
+	q.Finish()
+	assert.ErrorIs(t, ctx.Err(), context.Canceled)
+
+	_, err = r.Get(q.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestList(t *testing.T) {
+	t.Parallel()
+
+	r := NewRegistry()
+
+	assert.Empty(t, r.List())
+
+	_, first, err := r.Start(context.TODO(), "test", "test", "select 1;")
+	require.NoError(t, err)
+	_, second, err := r.Start(context.TODO(), "other", "test", "select 2;")
+	require.NoError(t, err)
+
+	assert.Equal(t, []*Query{first, second}, r.List())
+
+	first.Finish()
+	assert.Equal(t, []*Query{second}, r.List())
+}
@@ -123,6 +123,7 @@ func TestQueryWithMissingHeader(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	req.Header.Set("Content-Type", "application/json")
 
 	resp, err := client.Do(req)
 	if err != nil {
@@ -167,6 +168,7 @@ func TestQueryWithMalformedBase64EncodedQuery(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("X-Forwarded-User", "test")
 
 	q := req.URL.Query()
@@ -217,6 +219,7 @@ func TestQueryWithMissingBody(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("X-Forwarded-User", "test")
 
 	resp, err := client.Do(req)
@@ -264,6 +267,7 @@ func TestQueryWithExpiredInstance(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("X-Forwarded-User", "test")
 
 	resp, err := client.Do(req)
@@ -310,6 +314,7 @@ func TestQueryWithUnauthorizedAccess(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("X-Forwarded-User", "test")
 
 	resp, err := client.Do(req)
@@ -355,6 +360,7 @@ func TestQueryWithForbiddenUserAccess(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("X-Forwarded-User", "user-without-access-permissions")
 
 	resp, err := client.Do(req)
@@ -402,6 +408,7 @@ func TestQueryWithAccessUsingEnvironment(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("X-Forwarded-User", "test")
 
 	resp, err := client.Do(req)
@@ -451,6 +458,7 @@ func TestQueryWithRequestTimedOut(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("X-Forwarded-User", "test")
 
 	resp, err := client.Do(req)
@@ -504,6 +512,7 @@ func TestQueryWithSplunkWrite(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("X-Forwarded-User", "test")
 
 	resp, err := client.Do(req)
@@ -557,6 +566,7 @@ func TestQueryWithSplunkWriteFailure(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("X-Forwarded-User", "test")
 
 	resp, err := client.Do(req)
@@ -613,6 +623,7 @@ func TestQueryWithDatabaseWriteAccess(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("X-Forwarded-User", "test")
 
 	resp, err := client.Do(req)
@@ -669,6 +680,7 @@ func TestQueryWithDatabaseWriteAccessFailure(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("X-Forwarded-User", "test")
 
 	resp, err := client.Do(req)
@@ -725,6 +737,7 @@ func TestQueryWithBase64EncodedQuery(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("X-Forwarded-User", "test")
 
 	q := req.URL.Query()
@@ -786,6 +799,7 @@ func TestQueryWithBase64EncodedResults(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("X-Forwarded-User", "test")
 
 	q := req.URL.Query()